@@ -0,0 +1,201 @@
+package gosqlite
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+const (
+	// statName is the table ANALYZE stores the statistics in, a row is the
+	// table, the index or NULL for a table without indexes, and the stat.
+	statName = "sqlite_stat1"
+	statSQL  = "CREATE TABLE sqlite_stat1(tbl,idx,stat)"
+)
+
+// parseStat returns the numbers of a stat of sqlite_stat1: the rows of
+// the table, then the average rows with the same values in the first 1,
+// 2, ... columns of the index. Words after the numbers are ignored.
+func parseStat(text string) []int64 {
+	stat := make([]int64, 0)
+	for _, field := range strings.Fields(text) {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || n < 0 {
+			break
+		}
+		stat = append(stat, n)
+	}
+	if len(stat) == 0 {
+		return nil
+	}
+	return stat
+}
+
+// readStats sets the stats of the defs of tables and indexes from the
+// rows of sqlite_stat1 visible to trx, stat is its def. A table has the
+// stat of its row without an index or else of any of its indexes.
+func (db *DB) readStats(trx *Trx, stat indexDef, tables []indexDef, indexes map[string][]indexDef) error {
+	info, err := db.parseTable(stat, nil)
+	if err != nil {
+		return err
+	}
+	tableStats := make(map[string]string)
+	indexStats := make(map[string]string)
+	err = db.scanTable(trx, info, func(rowid int64, values []interface{}) error {
+		tbl, _ := values[0].(string)
+		text, _ := values[2].(string)
+		tbl = strings.ToLower(tbl)
+		if idx, ok := values[1].(string); ok {
+			indexStats[tbl+"\x00"+strings.ToLower(idx)] = text
+		}
+		if _, ok := tableStats[tbl]; !ok || values[1] == nil {
+			tableStats[tbl] = text
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range tables {
+		tbl := strings.ToLower(tables[i].name)
+		tables[i].stat = tableStats[tbl]
+		for j, def := range indexes[tbl] {
+			indexes[tbl][j].stat = indexStats[tbl+"\x00"+strings.ToLower(def.name)]
+		}
+	}
+	return nil
+}
+
+// analyze gathers the stats of the tables ANALYZE names, all the tables
+// when it names none, the table of an index for an index. sqlite_stat1
+// is created by the first ANALYZE.
+func (b *planner) analyze(stmt *sql.AnalyzeStmt) (*plan, error) {
+	targets := make([]*tableInfo, 0)
+	if stmt.Name == "" {
+		for name, info := range b.tables {
+			if !strings.HasPrefix(name, "sqlite_") {
+				targets = append(targets, info)
+			}
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].id < targets[j].id })
+	} else if info := b.tables[strings.ToLower(stmt.Name)]; info != nil {
+		if !strings.HasPrefix(strings.ToLower(info.name), "sqlite_") {
+			targets = append(targets, info)
+		}
+	} else if info, _ := b.index(stmt.Name); info != nil {
+		targets = append(targets, info)
+	} else {
+		return nil, fmt.Errorf("no such table or index: %s", stmt.Name)
+	}
+
+	op := &command{fn: func() error {
+		stat := b.tables[statName]
+		if stat == nil {
+			id, err := b.insertMaster("table", statName, statName, statSQL)
+			if err != nil {
+				return err
+			}
+			if stat, err = b.env.db.parseTable(indexDef{id: id, name: statName, sql: statSQL}, nil); err != nil {
+				return err
+			}
+		}
+		for _, info := range targets {
+			if err := b.analyzeTable(stat, info); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	return &plan{op: op, columns: []string{}}, nil
+}
+
+// analyzeTable to replace the rows of table in sqlite_stat1 with its
+// stats, an empty table has none.
+func (b *planner) analyzeTable(stat *tableInfo, info *tableInfo) error {
+	if err := b.clearStats(0, info.name); err != nil {
+		return err
+	}
+
+	// the distinct values of the first 1, 2, ... columns of each index
+	var n int64
+	distinct := make([][]map[string]bool, len(info.indexes))
+	for i, idx := range info.indexes {
+		for range idx.columns {
+			distinct[i] = append(distinct[i], make(map[string]bool))
+		}
+	}
+	scan := &tableScan{env: b.env, table: info}
+	if err := scan.open(); err != nil {
+		return err
+	}
+	for {
+		row, ok, err := scan.next()
+		if err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+		n++
+		for i, idx := range info.indexes {
+			var key []byte
+			for j, c := range idx.columns {
+				key = appendKeyValue(key, row[c+1], idx.colls[j], false)
+				distinct[i][j][string(key)] = true
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0)
+	if len(info.indexes) == 0 {
+		rows = append(rows, []interface{}{info.name, nil, strconv.FormatInt(n, 10)})
+	}
+	for i, idx := range info.indexes {
+		fields := []string{strconv.FormatInt(n, 10)}
+		for _, values := range distinct[i] {
+			d := int64(len(values))
+			fields = append(fields, strconv.FormatInt((n+d-1)/d, 10))
+		}
+		rows = append(rows, []interface{}{info.name, idx.name, strings.Join(fields, " ")})
+	}
+	for _, row := range rows {
+		rowid, err := b.env.newRowID(stat)
+		if err != nil {
+			return err
+		}
+		if err = b.env.insertRow(stat, rowid, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearStats to delete the rows of sqlite_stat1 with name in column, 0
+// for a table and 1 for an index
+func (b *planner) clearStats(column int, name string) error {
+	stat := b.tables[statName]
+	if stat == nil {
+		return nil
+	}
+	scan := &tableScan{env: b.env, table: stat}
+	if err := scan.open(); err != nil {
+		return err
+	}
+	for {
+		row, ok, err := scan.next()
+		if err != nil || !ok {
+			return err
+		}
+		if row[column+1] == name {
+			if err = b.env.writeRow(stat, row[0].(int64), nil, ChangeDelete); err != nil {
+				return err
+			}
+		}
+	}
+}
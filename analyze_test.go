@@ -0,0 +1,91 @@
+package gosqlite_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gosqlite"
+)
+
+// createSkewed creates t with an index on a column of two values
+func createSkewed(conn *gosqlite.Conn) error {
+	values := make([]string, 0)
+	for i := 1; i <= 50; i++ {
+		values = append(values, fmt.Sprintf("(%d, %d, %d)", i, i%2, i))
+	}
+	_, err := conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, a, b); CREATE INDEX t_a ON t (a); INSERT INTO t VALUES " +
+		strings.Join(values, ", "))
+	return err
+}
+
+func TestAnalyzeReplans(t *testing.T) {
+	conn := gosqlite.CreateDB().Conn()
+	if err := createSkewed(conn); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := conn.Prepare("EXPLAIN QUERY PLAN SELECT * FROM t WHERE a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	detail := func() string {
+		rows, err := stmt.Query(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatal("no plan")
+		}
+		return rows.Values()[3].(string)
+	}
+
+	if got := detail(); got != "SEARCH t USING INDEX t_a (a=?)" {
+		t.Fatalf("before ANALYZE: %s", got)
+	}
+	// the stats of a trx rolled back are gone with it
+	conn.Begin(context.Background(), false)
+	if _, err = conn.Exec("ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+	if got := detail(); got != "SCAN t" {
+		t.Fatalf("after ANALYZE: %s", got)
+	}
+	conn.Rollback()
+	if got := detail(); got != "SEARCH t USING INDEX t_a (a=?)" {
+		t.Fatalf("after ROLLBACK: %s", got)
+	}
+}
+
+func TestAnalyzeReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db, err := gosqlite.OpenDB(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := db.Conn()
+	if err = createSkewed(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec("ANALYZE t"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = gosqlite.OpenDB(fileName); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn = db.Conn()
+	rows, err := queryRows(conn, "SELECT * FROM sqlite_stat1")
+	if err != nil || fmt.Sprint(rows) != "[t t_a 50 25]" {
+		t.Fatalf("stats %v %v", rows, err)
+	}
+	rows, err = queryRows(conn, "EXPLAIN QUERY PLAN SELECT * FROM t WHERE a = 0")
+	if err != nil || fmt.Sprint(rows) != "[2 0 0 SCAN t]" {
+		t.Fatalf("plan %v %v", rows, err)
+	}
+}
@@ -1,11 +1,13 @@
 package gosqlite
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math"
+	"sort"
+	"strconv"
 )
 
 const (
@@ -14,6 +16,7 @@ const (
 
 	nodeTypeInternal byte = 0x01
 	nodeTypeLeaf     byte = 0x02
+	nodeTypeOverflow byte = 0x03
 
 	nodeUsed   byte = 0x01
 	nodeUnused byte = 0x00
@@ -28,17 +31,35 @@ const (
 	offsetPayload      = pageSize - 8
 	offsetNext         = pageSize - 8
 	offsetOverflowPage = pageSize - 4
+
+	// payloads too large for a leaf cell are kept in a chain of overflow
+	// pages, the cell then holds the first overflow page and the length
+	// has overflowFlag set.
+	overflowFlag uint32 = 0x80000000
+	maxOrder            = 16
+
+	// pageRoom is the room of a page for the key slots and the cells, a
+	// slot is a key and a cell pointer
+	pageRoom = offsetPayload - offsetKey
+	slotSize = 12
+	// maxLocal is the largest payload stored inside a leaf cell, four
+	// cells of that size fit a page
+	maxLocal = pageRoom/4 - slotSize - 8
 )
 
-// BPlusTree b+ tree
+// BPlusTree b+ tree, pages are kept in data which grows as pages are allocated.
+// The keys of a keyed tree are byte strings, see btree_key.go.
 type BPlusTree struct {
 	data  []byte
 	leaf  uint32
 	order int
+	free  []uint32
+	keyed bool
 }
 
-func ceil(n int64) int {
-	return int(math.Ceil(float64(n) / 2))
+type entry struct {
+	key  uint64
+	cell []byte
 }
 
 func blockCopy(src []byte, srcOffset int, dst []byte, dstOffset, count int) (bool, error) {
@@ -195,26 +216,13 @@ func (b *BPlusTree) getUsablePtr(page uint32) uint32 {
 	return getInt32(data, offsetUsablePtr)
 }
 
-func (b *BPlusTree) getKeyIndex(page uint32, key uint64) int {
-	numberOfKey := int(b.getNumberOfKey(page))
-	for i := 0; i < numberOfKey; i++ {
-		ikey := b.getKey(page, i)
-		if ikey == key {
-			return i
-		}
-	}
-
-	return -1
-}
-
-func (b *BPlusTree) getChildByIndex(page uint32, index int) uint32 {
-	key := b.getKey(page, index)
-	return b.getChildByKey(page, key)
+func (b *BPlusTree) numberOfPage() uint32 {
+	return uint32(len(b.data) / pageSize)
 }
 
-func (b *BPlusTree) getChildByKey(page uint32, key uint64) uint32 {
-	cell := b.getKeyCell(page, key)
-	return binary.BigEndian.Uint32(cell)
+func (b *BPlusTree) getChild(page uint32, index int) uint32 {
+	data := b.getPageData(page)
+	return getInt32(data, int(b.getCellPtr(page, index)))
 }
 
 func (b *BPlusTree) marshal(child uint32, payload []byte) []byte {
@@ -231,130 +239,119 @@ func (b *BPlusTree) marshal(child uint32, payload []byte) []byte {
 	return cell
 }
 
-func lshift(data []byte, src int, len int, shiftSize int) {
-	for i := 0; i < len; i++ {
-		data[src-shiftSize+i] = data[src+i]
+// leafCell to build the cell of payload, large payloads go to overflow pages
+func (b *BPlusTree) leafCell(payload []byte) []byte {
+	if len(payload) <= maxLocal {
+		return b.marshal(0, payload)
 	}
-}
 
-func rshift(data []byte, src int, len int, shiftSize int) {
-	for i := len - 1; i >= 0; i-- {
-		data[src+shiftSize+i] = data[src+i]
-	}
+	cell := make([]byte, 12)
+	binary.BigEndian.PutUint32(cell[4:], uint32(len(payload))|overflowFlag)
+	binary.BigEndian.PutUint32(cell[8:], b.writeOverflow(payload))
+	return cell
 }
 
-// shift data[src] of length len to data[dst]
-func shift(data []byte, src int, len int, shiftSize int) {
-	if shiftSize > 0 {
-		rshift(data, src, len, shiftSize)
-	} else if shiftSize < 0 {
-		lshift(data, src, len, -shiftSize)
+func (b *BPlusTree) writeOverflow(payload []byte) uint32 {
+	first := uint32(0)
+	prev := uint32(0)
+	for len(payload) > 0 {
+		page := b.allocte()
+		b.setNodeType(page, nodeTypeOverflow)
+		data := b.getPageData(page)
+		n := copy(data[offsetKey:offsetOverflowPage], payload)
+		payload = payload[n:]
+		if prev == 0 {
+			first = page
+		} else {
+			b.setPageInt32(prev, offsetOverflowPage, page)
+		}
+		prev = page
 	}
+
+	return first
 }
 
-func (b *BPlusTree) shiftCellPtr(page uint32, cellptr uint32, shiftSize int) {
-	numberOfKey := int(b.getNumberOfKey(page))
-	for i := 0; i < numberOfKey; i++ {
-		icellPtr := b.getCellPtr(page, i)
-		if icellPtr > 0 && icellPtr < cellptr {
-			b.setCellPtr(page, i, uint32(int(icellPtr)+shiftSize))
+func (b *BPlusTree) readOverflow(page uint32, size int) []byte {
+	payload := make([]byte, 0, size)
+	for ; page != 0 && len(payload) < size; page = b.getPageInt32(page, offsetOverflowPage) {
+		data := b.getPageData(page)
+		n := size - len(payload)
+		if n > offsetOverflowPage-offsetKey {
+			n = offsetOverflowPage - offsetKey
 		}
+		payload = append(payload, data[offsetKey:offsetKey+n]...)
 	}
+
+	return payload
 }
 
-func (b *BPlusTree) deleteCell(page uint32, index int) {
-	cellptr := b.getCellPtr(page, index)
-	if cellptr == 0 {
+// freeCell releases the overflow pages of a leaf cell
+func (b *BPlusTree) freeCell(cell []byte) {
+	if len(cell) < 12 || binary.BigEndian.Uint32(cell[4:])&overflowFlag == 0 {
 		return
 	}
 
-	data := b.getPageData(page)
-	cell := b.getKeyCell(page, b.getKey(page, index))
-	usablePtr := b.getUsablePtr(page)
-	shiftSize := len(cell)
-	length := int(cellptr - usablePtr)
-	if length > 0 {
-		shift(data, int(usablePtr), length, shiftSize)
-		b.shiftCellPtr(page, cellptr, shiftSize)
+	page := binary.BigEndian.Uint32(cell[8:])
+	for page != 0 {
+		next := b.getPageInt32(page, offsetOverflowPage)
+		b.release(page)
+		page = next
 	}
-	b.setUsablePtr(page, uint32(int(usablePtr)+shiftSize))
-	b.setCellPtr(page, index, 0)
 }
 
-func (b *BPlusTree) insertOrUpdateCell(page uint32, index int, cell []byte) {
-	cellPtr := b.getCellPtr(page, index)
-	data := b.getPageData(page)
-	oldCell := b.getKeyCell(page, b.getKey(page, index))
-	shiftSize := 0
-	// insert cell
-	if cellPtr == 0 {
-		cellPtr = b.getUsablePtr(page) - uint32(len(cell))
-		b.setCellPtr(page, index, uint32(cellPtr))
-		b.setUsablePtr(page, cellPtr)
-	} else if oldCell != nil { // update cell
-		shiftSize = len(oldCell) - len(cell)
-		usablePtr := b.getUsablePtr(page)
-		len := int(cellPtr - usablePtr)
-		if len > 0 {
-			shift(data, int(usablePtr), len, shiftSize)
-			b.shiftCellPtr(page, cellPtr, shiftSize)
-			b.setUsablePtr(page, uint32(int(usablePtr)+shiftSize))
-			b.setCellPtr(page, index, uint32(int(cellPtr)+shiftSize))
-		}
+func (b *BPlusTree) cellPayload(cell []byte) []byte {
+	size := binary.BigEndian.Uint32(cell[4:])
+	if size&overflowFlag != 0 {
+		return b.readOverflow(binary.BigEndian.Uint32(cell[8:]), int(size&^overflowFlag))
 	}
-	blockCopy(cell, 0, data, int(cellPtr)+shiftSize, len(cell))
+	return cell[8:]
 }
 
-func (b *BPlusTree) getKeyCell(page uint32, key uint64) []byte {
-	index := b.getKeyIndex(page, key)
-	if index == -1 {
-		return nil
-	}
-	offset := b.getCellPtr(page, index)
-
-	var cell []byte
-	if b.getNodeType(page) == nodeTypeLeaf {
-		data := b.getPageData(page)
-		payloadSize := binary.BigEndian.Uint32(data[offset+4:])
-		cell = make([]byte, 8+payloadSize)
-
-		blockCopy(data, int(offset), cell, 0, len(cell))
-	} else {
-		cell = make([]byte, 4)
+func (b *BPlusTree) getCell(page uint32, index int) []byte {
+	data := b.getPageData(page)
+	offset := int(b.getCellPtr(page, index))
 
-		data := b.getPageData(page)
-		blockCopy(data, int(offset), cell, 0, len(cell))
+	size := 4
+	if b.getNodeType(page) == nodeTypeLeaf || b.keyed {
+		payloadSize := getInt32(data, offset+4)
+		if payloadSize&overflowFlag != 0 {
+			size = 12
+		} else {
+			size = 8 + int(payloadSize)
+		}
 	}
+
+	cell := make([]byte, size)
+	blockCopy(data, offset, cell, 0, size)
 	return cell
 }
 
-func (b *BPlusTree) getKeyPayload(page uint32, key uint64) []byte {
-	cell := b.getKeyCell(page, key)
-	if b.getNodeType(page) == nodeTypeLeaf {
-		return cell[8:]
+func (b *BPlusTree) getEntries(page uint32) []entry {
+	numberOfKey := int(b.getNumberOfKey(page))
+	entries := make([]entry, numberOfKey, numberOfKey+1)
+	for i := 0; i < numberOfKey; i++ {
+		entries[i] = entry{b.getKey(page, i), b.getCell(page, i)}
 	}
-	return nil
+	return entries
 }
 
-func (b *BPlusTree) setChild(page uint32, index int, child uint32, payload []byte) {
-	if payload == nil {
-		cell := make([]byte, 4)
-		binary.BigEndian.PutUint32(cell, child)
-		b.insertOrUpdateCell(page, index, cell)
-	} else {
-		cell := b.marshal(child, payload)
-		b.insertOrUpdateCell(page, index, cell)
+// setEntries rewrites the keys and cells of page, cells are packed from
+// the end of the page towards the key slots.
+func (b *BPlusTree) setEntries(page uint32, entries []entry) {
+	data := b.getPageData(page)
+	ptr := offsetPayload
+	for i, e := range entries {
+		ptr -= len(e.cell)
+		blockCopy(e.cell, 0, data, ptr, len(e.cell))
+		b.setKey(page, i, e.key)
+		b.setCellPtr(page, i, uint32(ptr))
 	}
-}
-
-func (b *BPlusTree) updateChild(page uint32, index int, child uint32) {
-	cell := make([]byte, 4)
-	binary.BigEndian.PutUint32(cell, child)
-	b.insertOrUpdateCell(page, index, cell)
-}
+	b.setUsablePtr(page, uint32(ptr))
+	b.setNumberOfKey(page, uint32(len(entries)))
 
-func (b *BPlusTree) getChild(page uint32, index int) uint32 {
-	return b.getChildByIndex(page, index)
+	if b.getNodeType(page) == nodeTypeInternal {
+		b.setChildParent(page)
+	}
 }
 
 func (b *BPlusTree) setChildParent(page uint32) {
@@ -365,23 +362,47 @@ func (b *BPlusTree) setChildParent(page uint32) {
 	}
 }
 
-func (b *BPlusTree) allocte() uint32 {
-	for i := 2; i < 32; i++ {
-		if !b.isUsed(uint32(i)) {
-			b.setUsed(uint32(i), nodeUsed)
-			return uint32(i)
+// childIndex returns the index of child in the internal page
+func (b *BPlusTree) childIndex(page uint32, child uint32) int {
+	numberOfKey := int(b.getNumberOfKey(page))
+	for i := 0; i < numberOfKey; i++ {
+		if b.getChild(page, i) == child {
+			return i
 		}
 	}
 
-	return 0
+	return -1
 }
 
-func (b *BPlusTree) copy(src uint32, dst uint32) {
-	srcData := b.getPageData(src)
-	dstData := b.getPageData(dst)
+func (b *BPlusTree) initPage(page uint32) {
+	data := b.getPageData(page)
+	for i := range data {
+		data[i] = 0
+	}
+	b.setPageNo(page, page)
+	b.setUsablePtr(page, offsetPayload)
+}
+
+// allocte to allocate a free page, the tree grows when every page is used
+func (b *BPlusTree) allocte() uint32 {
+	var page uint32
+	if n := len(b.free); n > 0 {
+		page = b.free[n-1]
+		b.free = b.free[:n-1]
+	} else {
+		page = b.numberOfPage()
+		b.data = append(b.data, make([]byte, pageSize)...)
+	}
+
+	b.initPage(page)
+	b.setUsed(page, nodeUsed)
+	return page
+}
 
-	copy(dstData, srcData)
-	b.setPageNo(dst, dst)
+func (b *BPlusTree) release(page uint32) {
+	b.initPage(page)
+	b.setUsed(page, nodeUnused)
+	b.free = append(b.free, page)
 }
 
 func (b *BPlusTree) search(key uint64) uint32 {
@@ -394,31 +415,27 @@ func (b *BPlusTree) search(key uint64) uint32 {
 
 // RangeSearch to search key from key1 to key2
 func (b *BPlusTree) RangeSearch(key1 uint64, key2 uint64) {
-	startPage := b.search(key1)
-	endPage := b.search(key2)
-
-	page := startPage
-	for {
-		numberOfKey := int(b.getNumberOfKey(page))
-		for i := 0; i < numberOfKey; i++ {
-			ikey := b.getKey(page, i)
-			if ikey >= key1 && ikey <= key2 {
-				fmt.Printf("%d ", ikey)
-			}
-		}
-		if page == endPage {
-			break
-		} else {
-			page = b.getNext(page)
-		}
+	for c := b.Seek(key1); c.Valid() && c.Key() <= key2; c.Next() {
+		fmt.Printf("%d ", c.Key())
 	}
 }
 
-// Write to write b+ tree to file
-func (b *BPlusTree) Write(fileName string) {
+// bytes returns the pages of the tree with order, first leaf and whether
+// it is keyed in page 0
+func (b *BPlusTree) bytes() []byte {
 	setInt32(b.data, 0, uint32(b.order))
 	setInt32(b.data, 4, b.leaf)
-	ioutil.WriteFile(fileName, b.data, 777)
+	keyed := uint32(0)
+	if b.keyed {
+		keyed = 1
+	}
+	setInt32(b.data, 8, keyed)
+	return b.data
+}
+
+// Write to write b+ tree to file
+func (b *BPlusTree) Write(fileName string) error {
+	return ioutil.WriteFile(fileName, b.bytes(), 0644)
 }
 
 func (b *BPlusTree) searchInternalNode(pageNo uint32, key uint64) uint32 {
@@ -439,168 +456,285 @@ func (b *BPlusTree) searchInternalNode(pageNo uint32, key uint64) uint32 {
 	return b.searchInternalNode(child, key)
 }
 
-func (b *BPlusTree) updateKey(pageNo uint32, oldKey uint64, newKey uint64) {
-	numberOfKey := int(b.getNumberOfKey(pageNo))
-	for i := 0; i < numberOfKey; i++ {
-		if b.getKey(pageNo, i) == oldKey {
-			b.setKey(pageNo, i, newKey)
-		}
+// updateMaxKey raises the keys of the ancestors of pageNo, only the last
+// child of a node can receive keys above its key in the parent. A keyed
+// tree leaves them, search takes the last child for the keys above all.
+func (b *BPlusTree) updateMaxKey(pageNo uint32, key uint64) {
+	if b.keyed {
+		return
 	}
-}
-
-func (b *BPlusTree) insertAndNotSplit(pageNo uint32, key uint64, child uint32, payload []byte) {
-	numberOfKey := int(b.getNumberOfKey(pageNo))
-	oldMaxKey := b.getKey(pageNo, numberOfKey-1)
-	k := numberOfKey
-	for i := numberOfKey - 1; i >= 0; i-- {
-		ikey := b.getKey(pageNo, i)
-		icellptr := b.getCellPtr(pageNo, i)
-		if ikey < key {
-			k = i + 1
-			break
-		} else {
-			b.setKey(pageNo, i+1, ikey)
-			b.setCellPtr(pageNo, i+1, icellptr)
-			b.setCellPtr(pageNo, i, 0)
-			k = i
+	for parent := b.getParent(pageNo); parent != 0; parent = b.getParent(pageNo) {
+		i := b.childIndex(parent, pageNo)
+		if i < 0 || b.getKey(parent, i) >= key {
+			return
 		}
+		b.setKey(parent, i, key)
+		pageNo = parent
 	}
-	b.setKey(pageNo, k, key)
-	b.setChild(pageNo, k, child, payload)
+}
 
-	newMaxKey := b.getKey(pageNo, numberOfKey)
-	parent := b.getParent(pageNo)
-	if parent != 0 && oldMaxKey != newMaxKey {
-		b.updateKey(parent, oldMaxKey, newMaxKey)
+// entriesSize returns the room entries take in a page
+func entriesSize(entries []entry) int {
+	size := 0
+	for _, e := range entries {
+		size += slotSize + len(e.cell)
 	}
-	b.setNumberOfKey(pageNo, uint32(numberOfKey+1))
+	return size
 }
 
-func (b *BPlusTree) insertAndSplitRoot(root uint32, leftPage uint32, rightPage uint32) {
-	// set root node
-	leftMaxKey := b.getMaxKey(leftPage)
-	rightMaxKey := b.getMaxKey(rightPage)
-	b.setNodeType(root, nodeTypeInternal)
-	b.setUsablePtr(root, offsetPayload)
-
-	b.setKey(root, 0, leftMaxKey)
-	b.setCellPtr(root, 0, 0)
-	b.setChild(root, 0, leftPage, nil)
+// putEntries to store entries in pageNo, splitting it when there are more
+// than order or they do not fit the page
+func (b *BPlusTree) putEntries(pageNo uint32, entries []entry) {
+	if len(entries) <= b.order && entriesSize(entries) <= pageRoom {
+		b.setEntries(pageNo, entries)
+		if len(entries) > 0 {
+			b.updateMaxKey(pageNo, entries[len(entries)-1].key)
+		}
+		return
+	}
 
-	b.setKey(root, 1, rightMaxKey)
-	b.setCellPtr(root, 1, 0)
-	b.setChild(root, 1, rightPage, nil)
-	b.setNumberOfKey(root, 2)
+	b.insertAndsplit(pageNo, entries)
+}
 
+func (b *BPlusTree) insertAndSplitRoot(root uint32, left []entry, right []entry) {
+	nodeType := b.getNodeType(root)
+	leftPage := b.allocte()
+	rightPage := b.allocte()
+	b.setNodeType(leftPage, nodeType)
+	b.setNodeType(rightPage, nodeType)
+	b.setEntries(leftPage, left)
+	b.setEntries(rightPage, right)
 	b.setParent(leftPage, root)
 	b.setParent(rightPage, root)
-
-	if b.getNodeType(leftPage) == nodeTypeLeaf {
+	if nodeType == nodeTypeLeaf {
+		b.setNext(leftPage, rightPage)
 		b.leaf = leftPage
 	}
+
+	// the root node cannot be changed, it becomes the parent of left and right
+	b.initPage(root)
+	b.setUsed(root, nodeUsed)
+	b.setNodeType(root, nodeTypeInternal)
+	b.setEntries(root, []entry{
+		b.separator(leftPage, left[len(left)-1]),
+		b.separator(rightPage, right[len(right)-1]),
+	})
 }
 
-func (b *BPlusTree) insertAndSplitKey(pageNo uint32, key uint64, child uint32, payload []byte) uint32 {
-	rightPageNo := b.allocte()
-	leftNumberOfKey := ceil(int64(b.order))
-	rightNumberOfKey := b.order - leftNumberOfKey + 1
+// splitPoint returns the count of entries kept on the left of a split,
+// each side takes about half the room. entries are at most one more
+// than order, so each side has at most order.
+func splitPoint(entries []entry) int {
+	n, half, size := 1, entriesSize(entries)/2, slotSize+len(entries[0].cell)
+	for n < len(entries)-1 && size < half {
+		size += slotSize + len(entries[n].cell)
+		n++
+	}
+	return n
+}
 
-	l, r, k := leftNumberOfKey, rightNumberOfKey, key
-	for i := b.order - 1; i >= 0; i-- {
-		ikey := b.getKey(pageNo, i)
-		ichild := b.getChild(pageNo, i)
-		ipayload := b.getKeyPayload(pageNo, ikey)
-		icellptr := b.getCellPtr(pageNo, i)
-		if k > ikey {
-			if r > 0 {
-				r = r - 1
-				b.setKey(rightPageNo, r, key)
-				b.setChild(rightPageNo, r, child, payload)
-			} else {
-				l = l - 1
-				b.setKey(pageNo, l, key)
-				b.setChild(pageNo, l, child, payload)
-			}
-			k = 0
-		}
+func (b *BPlusTree) insertAndsplit(pageNo uint32, entries []entry) {
+	leftNumberOfKey := splitPoint(entries)
+	left := entries[:leftNumberOfKey]
+	right := entries[leftNumberOfKey:]
 
-		if r > 0 {
-			r = r - 1
-			b.setKey(rightPageNo, r, ikey)
-			b.setChild(rightPageNo, r, ichild, ipayload)
-			b.setKey(pageNo, i, 0)
-			b.deleteCell(pageNo, i)
-		} else {
-			l = l - 1
-			b.setKey(pageNo, l, ikey)
-			b.setCellPtr(pageNo, l, icellptr)
-		}
-	}
-	if k != 0 {
-		b.setKey(pageNo, 0, key)
-		b.setChild(pageNo, 0, child, payload)
+	parent := b.getParent(pageNo)
+	if parent == 0 {
+		b.insertAndSplitRoot(pageNo, left, right)
+		return
 	}
-	b.setNumberOfKey(rightPageNo, uint32(rightNumberOfKey))
-	b.setNumberOfKey(pageNo, uint32(leftNumberOfKey))
-	b.setNext(pageNo, rightPageNo)
 
-	return rightPageNo
+	nodeType := b.getNodeType(pageNo)
+	rightPageNo := b.allocte()
+	b.setNodeType(rightPageNo, nodeType)
+	b.setParent(rightPageNo, parent)
+	b.setEntries(pageNo, left)
+	b.setEntries(rightPageNo, right)
+	if nodeType == nodeTypeLeaf {
+		b.setNext(rightPageNo, b.getNext(pageNo))
+		b.setNext(pageNo, rightPageNo)
+	}
+
+	// the right node takes over the key of pageNo in parent,
+	// pageNo is inserted before it with its new max key.
+	parentEntries := b.getEntries(parent)
+	i := b.childIndex(parent, pageNo)
+	binary.BigEndian.PutUint32(parentEntries[i].cell, rightPageNo)
+	parentEntries = append(parentEntries, entry{})
+	copy(parentEntries[i+1:], parentEntries[i:])
+	parentEntries[i] = b.separator(pageNo, left[len(left)-1])
+	b.putEntries(parent, parentEntries)
+	b.updateMaxKey(rightPageNo, right[len(right)-1].key)
+}
+
+// separator returns the entry of child in its parent, last is the last
+// entry of child.
+func (b *BPlusTree) separator(child uint32, last entry) entry {
+	if b.keyed {
+		cell := b.leafCell(b.cellPayload(last.cell))
+		binary.BigEndian.PutUint32(cell, child)
+		return entry{last.key, cell}
+	}
+	return entry{last.key, b.marshal(child, nil)}
 }
 
-// TODO add child parameter,
-func (b *BPlusTree) insertAndsplit(pageNo uint32, key uint64, child uint32, payload []byte) {
-	oldLeftMaxKey := b.getMaxKey(pageNo)
-
-	rightPageNo := b.insertAndSplitKey(pageNo, key, child, payload)
-	splitNodeType := b.getNodeType(pageNo)
-	b.setNodeType(rightPageNo, splitNodeType)
+// sortKey returns key i of page as bytes which compare like the keys
+func (b *BPlusTree) sortKey(page uint32, i int) []byte {
+	if b.keyed {
+		return b.cellKey(page, i)
+	}
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], b.getKey(page, i))
+	return key[:]
+}
 
-	parent := b.getParent(pageNo)
-	if parent == 0 {
-		// parent is root, The root node cannot be changed
-		// 1. create left node and copy parent's data to left
-		// 2. insert left and right to parent
-		newLeftPage := b.allocte()
-		b.copy(pageNo, newLeftPage)
-		b.setNodeType(newLeftPage, splitNodeType)
-		b.insertAndSplitRoot(pageNo, newLeftPage, rightPageNo)
-		b.setChildParent(newLeftPage)
-		b.setChildParent(rightPageNo)
-	} else {
-		// change parent node after split
-		newLeftMaxKey := b.getMaxKey(pageNo)
-		rightMaxKey := b.getMaxKey(rightPageNo)
-		b.setParent(rightPageNo, parent)
-		b.setChildParent(rightPageNo)
-		// update parent's left key
-		b.updateKey(parent, oldLeftMaxKey, newLeftMaxKey)
-		// insert right node to parent
-		b.insertKey(parent, rightMaxKey, rightPageNo, nil)
+// keyText returns key i of page as check reports it
+func (b *BPlusTree) keyText(page uint32, i int) string {
+	if b.keyed {
+		return fmt.Sprintf("%x", b.cellKey(page, i))
 	}
+	return strconv.FormatUint(b.getKey(page, i), 10)
+}
+
+func searchEntries(entries []entry, key uint64) int {
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].key >= key
+	})
 }
 
-// Insert to insert payload to b+ tree
+// Insert to insert payload to b+ tree, the payload of an existing key is replaced
 func (b *BPlusTree) Insert(key uint64, payload []byte) {
 	// search leaf node
 	pageNo := b.search(key)
-	b.insertKey(pageNo, key, 0, payload)
+	entries := b.getEntries(pageNo)
+	cell := b.leafCell(payload)
+
+	i := searchEntries(entries, key)
+	if i < len(entries) && entries[i].key == key {
+		b.freeCell(entries[i].cell)
+		entries[i].cell = cell
+	} else {
+		entries = append(entries, entry{})
+		copy(entries[i+1:], entries[i:])
+		entries[i] = entry{key, cell}
+	}
+	b.putEntries(pageNo, entries)
 }
 
 // Get to get payload from b+ tree
 func (b *BPlusTree) Get(key uint64) []byte {
-	page := b.search(key)
-	return b.getKeyPayload(page, key)
+	pageNo := b.search(key)
+	entries := b.getEntries(pageNo)
+	i := searchEntries(entries, key)
+	if i < len(entries) && entries[i].key == key {
+		return b.cellPayload(entries[i].cell)
+	}
+	return nil
 }
 
-func (b *BPlusTree) insertKey(pageNo uint32, key uint64, child uint32, payload []byte) {
-	numberOfKey := b.getNumberOfKey(pageNo)
-	if numberOfKey != uint32(b.order) {
-		b.insertAndNotSplit(pageNo, key, child, payload)
-	} else {
-		b.insertAndsplit(pageNo, key, child, payload)
+// Delete to delete key from b+ tree, pages are not merged so a leaf may
+// become empty and keys in internal nodes stay as upper bounds.
+func (b *BPlusTree) Delete(key uint64) bool {
+	pageNo := b.search(key)
+	entries := b.getEntries(pageNo)
+	i := searchEntries(entries, key)
+	if i == len(entries) || entries[i].key != key {
+		return false
+	}
+
+	b.freeCell(entries[i].cell)
+	entries = append(entries[:i], entries[i+1:]...)
+	b.setEntries(pageNo, entries)
+	return true
+}
+
+// Cursor walks the leaf keys of a b+ tree in order, it is invalid after
+// the tree is changed.
+type Cursor struct {
+	tree  *BPlusTree
+	page  uint32
+	index int
+}
+
+// First returns a cursor on the smallest key
+func (b *BPlusTree) First() *Cursor {
+	c := &Cursor{tree: b, page: b.leaf}
+	c.skip()
+	return c
+}
+
+// Seek returns a cursor on the smallest key not less than key
+func (b *BPlusTree) Seek(key uint64) *Cursor {
+	pageNo := b.search(key)
+	numberOfKey := int(b.getNumberOfKey(pageNo))
+	i := sort.Search(numberOfKey, func(i int) bool {
+		return b.getKey(pageNo, i) >= key
+	})
+
+	c := &Cursor{tree: b, page: pageNo, index: i}
+	c.skip()
+	return c
+}
+
+// lastBelow returns the largest key less than key, ok is false when
+// there is none. A child left of the one key falls in is tried when the
+// keys of that one are not less, or it is an empty leaf.
+func (b *BPlusTree) lastBelow(key uint64) (uint64, bool) {
+	return b.lastBelowIn(rootPageNo, key)
+}
+
+func (b *BPlusTree) lastBelowIn(pageNo uint32, key uint64) (uint64, bool) {
+	numberOfKey := int(b.getNumberOfKey(pageNo))
+	i := sort.Search(numberOfKey, func(i int) bool {
+		return b.getKey(pageNo, i) >= key
+	})
+	if b.getNodeType(pageNo) == nodeTypeLeaf {
+		if i == 0 {
+			return 0, false
+		}
+		return b.getKey(pageNo, i-1), true
+	}
+
+	if i == numberOfKey {
+		i = numberOfKey - 1
+	}
+	for ; i >= 0; i-- {
+		if k, ok := b.lastBelowIn(b.getChild(pageNo, i), key); ok {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// skip moves past the end of a leaf to the next non empty leaf
+func (c *Cursor) skip() {
+	for c.page != 0 && c.index >= int(c.tree.getNumberOfKey(c.page)) {
+		c.page = c.tree.getNext(c.page)
+		c.index = 0
 	}
 }
 
+// Valid returns whether the cursor is on a key
+func (c *Cursor) Valid() bool {
+	return c.page != 0
+}
+
+// Next to move the cursor to the next key
+func (c *Cursor) Next() {
+	c.index++
+	c.skip()
+}
+
+// Key returns the key under the cursor
+func (c *Cursor) Key() uint64 {
+	return c.tree.getKey(c.page, c.index)
+}
+
+// Payload returns the payload under the cursor
+func (c *Cursor) Payload() []byte {
+	return c.tree.cellPayload(c.tree.getCell(c.page, c.index))
+}
+
 func (b *BPlusTree) printKey(pageNo uint32) {
 	numberOfKey := int(b.getNumberOfKey(pageNo))
 	parent := b.getParent(pageNo)
@@ -609,9 +743,13 @@ func (b *BPlusTree) printKey(pageNo uint32) {
 	fmt.Printf("[%d:P%d:N%d] -> ", pageNo, parent, next)
 	for i := 0; i < numberOfKey; i++ {
 		ikey := b.getKey(pageNo, i)
-		ichild := b.getChild(pageNo, i)
 		icellPtr := b.getCellPtr(pageNo, i)
-		fmt.Printf("%d:C%d*[%d]:ptr[%d] |  ", i, ichild, ikey, icellPtr)
+		if b.getNodeType(pageNo) == nodeTypeInternal {
+			ichild := b.getChild(pageNo, i)
+			fmt.Printf("%d:C%d*[%d]:ptr[%d] |  ", i, ichild, ikey, icellPtr)
+		} else {
+			fmt.Printf("%d:[%d]:ptr[%d] |  ", i, ikey, icellPtr)
+		}
 	}
 
 	fmt.Println()
@@ -644,31 +782,123 @@ func (b *BPlusTree) Print() {
 	}
 }
 
+// check returns the problems of the structure of the tree: pages out of
+// use or with the wrong parent, keys out of order or out of the range of
+// the key in the parent and leaves missing from the chain of leaves.
+func (b *BPlusTree) check() []string {
+	problems := make([]string, 0)
+	leaves := make([]uint32, 0)
+	// the keys of pageNo are above lo unless it is in the first subtree
+	// and at most hi unless hi is nil, for the root and the last children
+	// of a keyed tree
+	var walk func(pageNo, parent uint32, lo, hi []byte, first bool)
+	walk = func(pageNo, parent uint32, lo, hi []byte, first bool) {
+		if pageNo < rootPageNo || pageNo >= b.numberOfPage() || !b.isUsed(pageNo) {
+			problems = append(problems, fmt.Sprintf("page %d is not in use", pageNo))
+			return
+		}
+		if p := b.getParent(pageNo); p != parent {
+			problems = append(problems, fmt.Sprintf("page %d has parent %d instead of %d", pageNo, p, parent))
+		}
+
+		leaf := b.getNodeType(pageNo) == nodeTypeLeaf
+		numberOfKey := int(b.getNumberOfKey(pageNo))
+		for i := 0; i < numberOfKey; i++ {
+			if b.keyed && !leaf && hi == nil && i == numberOfKey-1 {
+				// the last key of the right edge is not a bound
+				break
+			}
+			key := b.sortKey(pageNo, i)
+			if i > 0 {
+				if c := bytes.Compare(key, b.sortKey(pageNo, i-1)); c < 0 || leaf && c == 0 {
+					problems = append(problems, fmt.Sprintf("keys of page %d are out of order", pageNo))
+				}
+			}
+			if parent != 0 && (!first && bytes.Compare(key, lo) <= 0 || hi != nil && bytes.Compare(key, hi) > 0) {
+				problems = append(problems, fmt.Sprintf("key %s of page %d is out of the range of its parent", b.keyText(pageNo, i), pageNo))
+			}
+		}
+		if leaf {
+			leaves = append(leaves, pageNo)
+			return
+		}
+		for i := 0; i < numberOfKey; i++ {
+			if i > 0 {
+				lo, first = b.sortKey(pageNo, i-1), false
+			}
+			childHi := b.sortKey(pageNo, i)
+			if b.keyed && hi == nil && i == numberOfKey-1 {
+				childHi = nil
+			}
+			walk(b.getChild(pageNo, i), pageNo, lo, childHi, first)
+		}
+	}
+	walk(rootPageNo, 0, nil, nil, true)
+
+	pageNo := b.leaf
+	for _, leaf := range leaves {
+		if pageNo != leaf {
+			problems = append(problems, fmt.Sprintf("leaf %d is missing from the chain of leaves", leaf))
+			return problems
+		}
+		pageNo = b.getNext(pageNo)
+	}
+	if pageNo != 0 {
+		problems = append(problems, fmt.Sprintf("page %d in the chain of leaves is not a leaf of the tree", pageNo))
+	}
+	return problems
+}
+
 // LoadBtree load data to bplustree
 func LoadBtree(fileName string) *BPlusTree {
-	tree := new(BPlusTree)
-
-	data, err := ioutil.ReadFile("db0.log")
+	tree, err := OpenBtree(fileName)
 	if err != nil {
 		return nil
 	}
+	return tree
+}
+
+// OpenBtree to load b+ tree from file
+func OpenBtree(fileName string) (*BPlusTree, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return loadTree(data)
+}
+
+func loadTree(data []byte) (*BPlusTree, error) {
+	if len(data) < 2*pageSize || len(data)%pageSize != 0 {
+		return nil, errors.New("The b+ tree file is corrupt")
+	}
+
+	tree := new(BPlusTree)
 	tree.data = data
 	tree.order = int(getInt32(data, 0))
 	tree.leaf = getInt32(data, 4)
+	tree.keyed = getInt32(data, 8) == 1
+	for i := uint32(2); i < tree.numberOfPage(); i++ {
+		if !tree.isUsed(i) {
+			tree.free = append(tree.free, i)
+		}
+	}
 
-	return tree
+	return tree, nil
 }
 
 // CreateTree to create b+ tree with order
 func CreateTree(order int) *BPlusTree {
+	if order < 3 {
+		order = 3
+	} else if order > maxOrder {
+		order = maxOrder
+	}
+
 	tree := new(BPlusTree)
 	tree.order = order
-	tree.data = make([]byte, pageSize*32)
-	for i := 0; i < 32; i++ {
-		tree.setPageNo(uint32(i), uint32(i))
-		tree.setUsed(uint32(i), nodeUnused)
-		tree.setUsablePtr(uint32(i), offsetPayload)
-	}
+	tree.data = make([]byte, pageSize*2)
+	tree.initPage(0)
+	tree.initPage(rootPageNo)
 	tree.leaf = rootPageNo
 	tree.setNodeType(rootPageNo, nodeTypeLeaf)
 	tree.setUsed(rootPageNo, nodeUsed)
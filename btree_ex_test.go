@@ -1,15 +1,32 @@
 package gosqlite_test
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"gosqlite"
 )
 
 func TestLoadFile(t *testing.T) {
-	tree := gosqlite.LoadBtree("db0.log")
+	fileName := filepath.Join(t.TempDir(), "db0.log")
+	tree := gosqlite.CreateTree(5)
+	for i := uint64(1); i <= 100; i++ {
+		tree.Insert(i, []byte(fmt.Sprintf("val-%d", i)))
+	}
+	if err := tree.Write(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = gosqlite.LoadBtree(fileName)
 	tree.Print()
+	if b := tree.Get(42); string(b) != "val-42" {
+		t.Fatalf("payload is [%s]", b)
+	}
 }
 
 func TestBtree(t *testing.T) {
@@ -32,3 +49,110 @@ func TestBtree(t *testing.T) {
 
 	tree.RangeSearch(4, 15)
 }
+
+func TestBtreeRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tree := gosqlite.CreateTree(5)
+	model := make(map[uint64][]byte)
+
+	for i := 0; i < 20000; i++ {
+		key := uint64(rnd.Intn(3000))
+		switch rnd.Intn(4) {
+		case 0:
+			tree.Delete(key)
+			delete(model, key)
+		default:
+			payload := bytes.Repeat([]byte{byte(i)}, rnd.Intn(1200))
+			tree.Insert(key, payload)
+			model[key] = payload
+		}
+	}
+
+	fileName := filepath.Join(t.TempDir(), "random.db")
+	if err := tree.Write(fileName); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gosqlite.OpenBtree(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]uint64, 0, len(model))
+	for key, payload := range model {
+		keys = append(keys, key)
+		if got := tree.Get(key); !bytes.Equal(got, payload) {
+			t.Fatalf("key %d has %d bytes, want %d", key, len(got), len(payload))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	i := 0
+	for c := tree.First(); c.Valid(); c.Next() {
+		if i >= len(keys) || c.Key() != keys[i] {
+			t.Fatalf("cursor at %d is key %d", i, c.Key())
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("cursor visited %d keys, want %d", i, len(keys))
+	}
+
+	c := tree.Seek(1500)
+	j := sort.Search(len(keys), func(i int) bool { return keys[i] >= 1500 })
+	if !c.Valid() || c.Key() != keys[j] || !bytes.Equal(c.Payload(), model[keys[j]]) {
+		t.Fatalf("seek 1500")
+	}
+}
+
+// rows of 9 to 97 bytes are kept in the leaves without overflow pages,
+// whatever the order
+func TestBtreeLocalPayload(t *testing.T) {
+	for _, order := range []int{5, 16} {
+		tree := gosqlite.CreateTree(order)
+		payload := func(key uint64) []byte {
+			return bytes.Repeat([]byte{byte(key)}, 9+int(key%89))
+		}
+		for key := uint64(1); key <= 2000; key++ {
+			tree.Insert(key, payload(key))
+		}
+
+		fileName := filepath.Join(t.TempDir(), "local.db")
+		if err := tree.Write(fileName); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an overflow page for each row would take more pages than rows
+		if pages := info.Size() / 512; pages >= 2000 {
+			t.Fatalf("order %d takes %d pages for 2000 rows", order, pages)
+		}
+		for key := uint64(1); key <= 2000; key++ {
+			if got := tree.Get(key); !bytes.Equal(got, payload(key)) {
+				t.Fatalf("order %d key %d has %d bytes", order, key, len(got))
+			}
+		}
+	}
+}
+
+// a payload growing past the room of its leaf splits the leaf
+func TestBtreeGrowPayload(t *testing.T) {
+	tree := gosqlite.CreateTree(16)
+	for key := uint64(1); key <= 16; key++ {
+		tree.Insert(key, []byte("v"))
+	}
+	for key := uint64(1); key <= 16; key++ {
+		tree.Insert(key, bytes.Repeat([]byte{byte(key)}, 90))
+	}
+	i := uint64(0)
+	for c := tree.First(); c.Valid(); c.Next() {
+		i++
+		if c.Key() != i || !bytes.Equal(c.Payload(), bytes.Repeat([]byte{byte(i)}, 90)) {
+			t.Fatalf("cursor at %d is key %d with %d bytes", i, c.Key(), len(c.Payload()))
+		}
+	}
+	if i != 16 {
+		t.Fatalf("cursor visited %d keys", i)
+	}
+}
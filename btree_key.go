@@ -0,0 +1,119 @@
+package gosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// A keyed b+ tree orders byte string keys, which have no payload. A leaf
+// cell holds its key as the payload, an internal cell holds its child
+// and the largest key of the child when it was split off, which is not
+// kept up to date for the last child on the right edge. The key slot of
+// a cell holds the first 8 bytes of the key, so most comparisons do not
+// read the cell.
+
+// createKeyTree to create a keyed b+ tree with order
+func createKeyTree(order int) *BPlusTree {
+	tree := CreateTree(order)
+	tree.keyed = true
+	return tree
+}
+
+// keyPrefix returns the key slot of key, its first 8 bytes
+func keyPrefix(key []byte) uint64 {
+	var b [8]byte
+	copy(b[:], key)
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// cellKey returns the key of cell i of page, it is not a copy unless it
+// is in overflow pages.
+func (b *BPlusTree) cellKey(page uint32, i int) []byte {
+	data := b.getPageData(page)
+	offset := int(b.getCellPtr(page, i))
+	size := getInt32(data, offset+4)
+	if size&overflowFlag != 0 {
+		return b.readOverflow(getInt32(data, offset+8), int(size&^overflowFlag))
+	}
+	return data[offset+8 : offset+8+int(size)]
+}
+
+// compareKey compares key i of page with key
+func (b *BPlusTree) compareKey(page uint32, i int, key []byte) int {
+	switch p, q := b.getKey(page, i), keyPrefix(key); {
+	case p < q:
+		return -1
+	case p > q:
+		return 1
+	}
+	return bytes.Compare(b.cellKey(page, i), key)
+}
+
+// findKey returns the index of the first key of page not below key
+func (b *BPlusTree) findKey(page uint32, key []byte) int {
+	return sort.Search(int(b.getNumberOfKey(page)), func(i int) bool {
+		return b.compareKey(page, i, key) >= 0
+	})
+}
+
+// searchKey returns the leaf key is in or belongs in
+func (b *BPlusTree) searchKey(key []byte) uint32 {
+	page := rootPageNo
+	for b.getNodeType(page) == nodeTypeInternal {
+		// the last child takes the keys above the others, its key is not
+		// a bound on the right edge of the tree
+		i := sort.Search(int(b.getNumberOfKey(page))-1, func(i int) bool {
+			return b.compareKey(page, i, key) >= 0
+		})
+		page = b.getChild(page, i)
+	}
+	return page
+}
+
+// hasKey returns whether key is in the tree
+func (b *BPlusTree) hasKey(key []byte) bool {
+	page := b.searchKey(key)
+	i := b.findKey(page, key)
+	return i < int(b.getNumberOfKey(page)) && b.compareKey(page, i, key) == 0
+}
+
+// insertKey to insert key, returns false when it is in the tree
+func (b *BPlusTree) insertKey(key []byte) bool {
+	page := b.searchKey(key)
+	i := b.findKey(page, key)
+	if i < int(b.getNumberOfKey(page)) && b.compareKey(page, i, key) == 0 {
+		return false
+	}
+
+	cell := b.leafCell(key)
+	entries := b.getEntries(page)
+	entries = append(entries, entry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry{keyPrefix(key), cell}
+	b.putEntries(page, entries)
+	return true
+}
+
+// deleteKey to delete key, returns false when it is not in the tree
+func (b *BPlusTree) deleteKey(key []byte) bool {
+	page := b.searchKey(key)
+	i := b.findKey(page, key)
+	if i == int(b.getNumberOfKey(page)) || b.compareKey(page, i, key) != 0 {
+		return false
+	}
+
+	entries := b.getEntries(page)
+	b.freeCell(entries[i].cell)
+	b.setEntries(page, append(entries[:i], entries[i+1:]...))
+	return true
+}
+
+// seekKey returns a cursor on the smallest key not below key, Payload
+// returns the key under the cursor.
+func (b *BPlusTree) seekKey(key []byte) *Cursor {
+	page := b.searchKey(key)
+	c := &Cursor{tree: b, page: page, index: b.findKey(page, key)}
+	c.skip()
+	return c
+}
@@ -0,0 +1,243 @@
+package gosqlite
+
+import (
+	"errors"
+	"sync"
+)
+
+// ChangeOp is the kind of a row change
+type ChangeOp int8
+
+const (
+	ChangeInsert ChangeOp = 1
+	ChangeUpdate ChangeOp = 2
+	ChangeDelete ChangeOp = 3
+
+	defaultChangeRetention = 1 << 16
+)
+
+var (
+	// ErrChangesTruncated is returned when the changes after a position are no longer kept.
+	ErrChangesTruncated = errors.New("changes are truncated")
+	// ErrLSNOutOfRange is returned when a position is after the last change.
+	ErrLSNOutOfRange = errors.New("lsn is out of range")
+)
+
+// Change is a committed row change, Before is nil for an insert and
+// After is nil for a delete. LSN is the position of the change in the
+// feed of the context, it keeps growing when the context is reopened.
+// CommitTS orders the trx by commit.
+type Change struct {
+	LSN      uint64
+	TrxID    int64
+	CommitTS int64
+	RowID    int64
+	Op       ChangeOp
+	Before   []byte
+	After    []byte
+}
+
+// changeFeed keeps the recent changes in commit order, changes[i] has
+// lsn first+i. The changes are kept in memory, the next lsn is stored at
+// checkpoint and recovered from the change counts of the commit records.
+type changeFeed struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	changes []Change
+	first   uint64
+	retain  int
+	closed  bool
+}
+
+func newChangeFeed() *changeFeed {
+	f := &changeFeed{first: 1, retain: defaultChangeRetention}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// next returns the lsn the next change gets
+func (f *changeFeed) next() uint64 {
+	return f.first + uint64(len(f.changes))
+}
+
+// changeLSN returns the lsn of the next change, counting the changes of
+// the trx committing whose commit records were logged. The caller holds
+// the lock of the context.
+func (context *TrxContext) changeLSN() uint64 {
+	context.feed.mu.Lock()
+	lsn := context.feed.next()
+	context.feed.mu.Unlock()
+
+	for _, t := range context.trxIDs {
+		if t.status == uncommit && t.committing {
+			lsn += uint64(len(t.writes))
+		}
+	}
+	return lsn
+}
+
+// publish to append the changes of a committed trx and wake the subscribers
+func (f *changeFeed) publish(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range changes {
+		changes[i].LSN = f.next()
+		f.changes = append(f.changes, changes[i])
+	}
+	if n := len(f.changes); n > 2*f.retain {
+		f.first += uint64(n - f.retain)
+		f.changes = append(f.changes[:0], f.changes[n-f.retain:]...)
+	}
+	f.cond.Broadcast()
+}
+
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+// changes returns the changes trx wrote in write order. The versions trx
+// wrote of a row are the newest ones of its undo chain, each one follows
+// the version it replaced.
+func (t *Trx) changes(commitTS int64) []Change {
+	count := make(map[*record]int)
+	for _, r := range t.writes {
+		count[r]++
+	}
+
+	changes := make([]Change, 0, len(t.writes))
+	for _, r := range t.writes {
+		count[r]--
+		after := r
+		for i := 0; i < count[r]; i++ {
+			after = after.rollPtr
+		}
+
+		c := Change{TrxID: t.trxID, CommitTS: commitTS, RowID: r.rowID, Op: ChangeUpdate}
+		if before := after.rollPtr; before == nil || before.deleted {
+			c.Op = ChangeInsert
+		} else {
+			c.Before = append([]byte(nil), before.data...)
+		}
+		if after.deleted {
+			c.Op = ChangeDelete
+		} else {
+			c.After = append([]byte(nil), after.data...)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// Subscription delivers the committed changes on C in commit order, C is
+// closed when the subscription or the context is closed or the
+// subscriber fell behind the kept changes.
+type Subscription struct {
+	C    <-chan Change
+	feed *changeFeed
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Subscribe to receive the changes after position fromLSN, 0 is the
+// first change kept. A subscriber resumes with the LSN of the last change
+// it handled, it gets ErrChangesTruncated when changes after it are no
+// longer kept, as after the context was reopened, and ErrLSNOutOfRange
+// when it is after the last change.
+func (context *TrxContext) Subscribe(fromLSN uint64) (*Subscription, error) {
+	f := context.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fromLSN == 0 {
+		fromLSN = f.first - 1
+	}
+	if fromLSN+1 < f.first {
+		return nil, ErrChangesTruncated
+	}
+	if fromLSN >= f.next() {
+		return nil, ErrLSNOutOfRange
+	}
+
+	c := make(chan Change)
+	s := &Subscription{C: c, feed: f, done: make(chan struct{})}
+	go s.run(c, fromLSN)
+	return s, nil
+}
+
+func (s *Subscription) run(c chan<- Change, pos uint64) {
+	defer close(c)
+	f := s.feed
+
+	for {
+		f.mu.Lock()
+		for pos+1 >= f.next() && !f.closed && !s.isDone() {
+			f.cond.Wait()
+		}
+		if s.isDone() || pos+1 >= f.next() {
+			f.mu.Unlock()
+			return
+		}
+		if pos+1 < f.first {
+			s.err = ErrChangesTruncated
+			f.mu.Unlock()
+			return
+		}
+		batch := append([]Change(nil), f.changes[pos+1-f.first:]...)
+		f.mu.Unlock()
+
+		for _, change := range batch {
+			select {
+			case c <- change:
+				pos = change.LSN
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *Subscription) isDone() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close to stop the subscription, C is closed
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.feed.mu.Lock()
+		s.feed.cond.Broadcast()
+		s.feed.mu.Unlock()
+	})
+}
+
+// Err returns ErrChangesTruncated when C was closed because the
+// subscriber fell behind, it is valid after C is closed.
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	return s.err
+}
+
+// SetChangeRetention to keep at least n changes for subscribers that resume
+func (context *TrxContext) SetChangeRetention(n int) {
+	context.feed.mu.Lock()
+	defer context.feed.mu.Unlock()
+
+	context.feed.retain = n
+}
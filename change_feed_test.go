@@ -0,0 +1,175 @@
+package gosqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+func receive(t *testing.T, s *gosqlite.Subscription, n int) []gosqlite.Change {
+	changes := make([]gosqlite.Change, 0, n)
+	for len(changes) < n {
+		select {
+		case c, ok := <-s.C:
+			if !ok {
+				t.Fatalf("closed after %d changes", len(changes))
+			}
+			changes = append(changes, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout after %d changes", len(changes))
+		}
+	}
+	return changes
+}
+
+func TestSubscribe(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	s, err := context.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	trx.Insert(context, "b")
+	trx.Commit()
+
+	// trx2 begins first but commits after trx3
+	trx2 := beginTrx(context)
+	trx2.Update(context, 1, "a2")
+	trx2.Savepoint("sp")
+	trx2.Update(context, 1, "a3")
+	trx2.RollbackTo("sp")
+	trx2.Update(context, 1, "a4")
+	trx3 := beginTrx(context)
+	trx3.Delete(context, 2)
+	trx3.Commit()
+	aborted := beginTrx(context)
+	aborted.Insert(context, "c")
+	aborted.Rollback()
+	trx2.Commit()
+
+	want := []gosqlite.Change{
+		{LSN: 1, TrxID: 1, RowID: 1, Op: gosqlite.ChangeInsert, After: []byte("a")},
+		{LSN: 2, TrxID: 1, RowID: 2, Op: gosqlite.ChangeInsert, After: []byte("b")},
+		{LSN: 3, TrxID: 3, RowID: 2, Op: gosqlite.ChangeDelete, Before: []byte("b")},
+		{LSN: 4, TrxID: 2, RowID: 1, Op: gosqlite.ChangeUpdate, Before: []byte("a"), After: []byte("a2")},
+		{LSN: 5, TrxID: 2, RowID: 1, Op: gosqlite.ChangeUpdate, Before: []byte("a2"), After: []byte("a4")},
+	}
+	got := receive(t, s, len(want))
+	for i, c := range got {
+		w := want[i]
+		if c.LSN != w.LSN || c.TrxID != w.TrxID || c.RowID != w.RowID || c.Op != w.Op ||
+			string(c.Before) != string(w.Before) || string(c.After) != string(w.After) {
+			t.Fatalf("change %d is %+v, want %+v", i, c, w)
+		}
+		if i > 0 && c.CommitTS < got[i-1].CommitTS {
+			t.Fatalf("change %d is out of commit order", i)
+		}
+	}
+
+	// resume after the third change
+	resumed, err := context.Subscribe(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, resumed, 2); got[0].LSN != 4 || got[1].LSN != 5 {
+		t.Fatalf("resumed at %d", got[0].LSN)
+	}
+	resumed.Close()
+	if _, ok := <-resumed.C; ok {
+		t.Fatal("change after close")
+	}
+}
+
+func TestSubscribeTruncated(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetChangeRetention(2)
+	for i := 0; i < 5; i++ {
+		trx := beginTrx(context)
+		trx.Insert(context, "a")
+		trx.Commit()
+	}
+
+	if _, err := context.Subscribe(1); err != gosqlite.ErrChangesTruncated {
+		t.Fatalf("subscribe to truncated changes %v", err)
+	}
+	s, err := context.Subscribe(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, s, 2); got[0].LSN != 4 || got[1].LSN != 5 {
+		t.Fatalf("got %+v", got)
+	}
+
+	// a subscriber falling behind the kept changes is closed
+	for i := 0; i < 10; i++ {
+		trx := beginTrx(context)
+		trx.Insert(context, "b")
+		trx.Commit()
+	}
+	for range s.C {
+	}
+	if s.Err() != gosqlite.ErrChangesTruncated {
+		t.Fatalf("lagging subscriber %v", s.Err())
+	}
+}
+
+func TestSubscribeReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(n int) {
+		trx := beginTrx(context)
+		for i := 0; i < n; i++ {
+			trx.Insert(context, "a")
+		}
+		if err := trx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(2)
+	if err = context.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	commit(3)
+	if _, err = context.Subscribe(6); err != gosqlite.ErrLSNOutOfRange {
+		t.Fatalf("subscribe after the last change %v", err)
+	}
+
+	// crash: the lsn of the store and the commits in the log are kept
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = context.Subscribe(4); err != gosqlite.ErrChangesTruncated {
+		t.Fatalf("subscribe to changes before the reopen %v", err)
+	}
+	s, err := context.Subscribe(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	commit(1)
+	if got := receive(t, s, 1); got[0].LSN != 6 {
+		t.Fatalf("change after the reopen at %d", got[0].LSN)
+	}
+
+	context.Close()
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer context.Close()
+	if _, err = context.Subscribe(6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = context.Subscribe(7); err != gosqlite.ErrLSNOutOfRange {
+		t.Fatalf("subscribe after the last change %v", err)
+	}
+}
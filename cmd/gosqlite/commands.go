@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gosqlite"
+)
+
+// dotCommand is a dot-command, run gets the words after its name
+type dotCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(s *shell, args []string) error
+}
+
+var dotCommands []dotCommand
+
+func init() {
+	dotCommands = []dotCommand{
+		{"bail", "on|off", "Stop after hitting an error", setFlag(func(s *shell) *bool { return &s.bail })},
+		{"check", "", "Run the integrity checks of the database", (*shell).check},
+		{"dbinfo", "", "Show the header of the database file", (*shell).dbinfo},
+		{"dump", "?TABLE?", "Render the database, or TABLE, as SQL text", (*shell).dump},
+		{"exit", "?CODE?", "Exit this program with return-code CODE", (*shell).exit},
+		{"explain", "on|off", "Show EXPLAIN as a program and EXPLAIN QUERY PLAN as a tree", setFlag(func(s *shell) *bool { return &s.explain })},
+		{"headers", "on|off", "Turn display of headers on or off", setFlag(func(s *shell) *bool { return &s.headers })},
+		{"help", "", "Show this message", (*shell).help},
+		{"history", "", "Show the statements and commands entered", (*shell).showHistory},
+		{"import", "?--csv? ?--skip N? FILE TABLE", "Import CSV data from FILE into TABLE", (*shell).importCSV},
+		{"mode", strings.Join(modes, "|"), "Set the output mode", (*shell).setMode},
+		{"open", "?FILE?", "Close the database and open FILE, in memory without it", (*shell).reopen},
+		{"quit", "", "Exit this program", (*shell).exit},
+		{"read", "FILE", "Read input from FILE", (*shell).read},
+		{"schema", "?PATTERN?", "Show the CREATE statements matching PATTERN", (*shell).schema},
+		{"tables", "?PATTERN?", "List the names of the tables matching PATTERN", (*shell).tables},
+		{"timer", "on|off", "Turn the timer of statements on or off", setFlag(func(s *shell) *bool { return &s.timer })},
+		{"vm", "on|off", "Run the statements on the bytecode VM or the operators", (*shell).setVM},
+	}
+}
+
+// command to run the dot-command of line, its name may be shortened to
+// a prefix of one command
+func (s *shell) command(line string) error {
+	args, err := splitArgs(line[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("unknown command: .")
+	}
+	var found *dotCommand
+	for i, c := range dotCommands {
+		if c.name == args[0] {
+			found = &dotCommands[i]
+			break
+		}
+		if strings.HasPrefix(c.name, args[0]) {
+			if found != nil {
+				return fmt.Errorf("ambiguous command: .%s", args[0])
+			}
+			found = &dotCommands[i]
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("unknown command: .%s, enter \".help\" for help", args[0])
+	}
+	if err = found.run(s, args[1:]); err == errUsage {
+		return fmt.Errorf("usage: .%s %s", found.name, found.usage)
+	}
+	return err
+}
+
+var (
+	// errUsage is returned by a dot-command given the wrong arguments
+	errUsage = errors.New("usage")
+	// errNoFile is returned by the dot-commands on the file of a database
+	// in memory
+	errNoFile = errors.New("the database is in memory, it has no file")
+)
+
+// splitArgs returns the words of line, a word in single or double quotes
+// may have spaces
+func splitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		if q := line[0]; q == '\'' || q == '"' {
+			end := strings.IndexByte(line[1:], q)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %s", line)
+			}
+			args = append(args, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+}
+
+// setFlag returns the run of a dot-command setting the flag of a shell
+func setFlag(flag func(s *shell) *bool) func(s *shell, args []string) error {
+	return func(s *shell, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		on, err := parseOnOff(args[0])
+		if err != nil {
+			return err
+		}
+		*flag(s) = on
+		return nil
+	}
+}
+
+func parseOnOff(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean value: %s", arg)
+}
+
+func (s *shell) help(args []string) error {
+	for _, c := range dotCommands {
+		fmt.Fprintf(s.out, "%-30s %s\n", strings.TrimSpace("."+c.name+" "+c.usage), c.help)
+	}
+	return nil
+}
+
+func (s *shell) exit(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	if len(args) == 1 {
+		code, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errUsage
+		}
+		s.exitCode = code
+	}
+	s.quit = true
+	return nil
+}
+
+func (s *shell) setMode(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	for _, m := range modes {
+		if strings.EqualFold(m, args[0]) {
+			s.mode = m
+			return nil
+		}
+	}
+	return errUsage
+}
+
+func (s *shell) setVM(args []string) error {
+	if err := setFlag(func(s *shell) *bool { return &s.vm })(s, args); err != nil {
+		return err
+	}
+	s.conn.UseVM(s.vm)
+	return nil
+}
+
+func (s *shell) showHistory(args []string) error {
+	for i, text := range s.history {
+		fmt.Fprintf(s.out, "%5d  %s\n", i+1, text)
+	}
+	return nil
+}
+
+func (s *shell) reopen(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	return s.open(name)
+}
+
+func (s *shell) read(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s.run(f, args[0])
+	return nil
+}
+
+// firstColumn returns the first column of the rows of query as text
+func (s *shell) firstColumn(query string, args ...interface{}) ([]string, error) {
+	stmt, err := s.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0)
+	for rows.Next() {
+		values = append(values, formatValue(rows.Values()[0]))
+	}
+	return values, rows.Close()
+}
+
+// pattern returns the LIKE pattern of the optional argument of a
+// dot-command, % matches all
+func pattern(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return "%", nil
+	case 1:
+		return args[0], nil
+	}
+	return "", errUsage
+}
+
+func (s *shell) tables(args []string) error {
+	like, err := pattern(args)
+	if err != nil {
+		return err
+	}
+	names, err := s.firstColumn("SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE ? ORDER BY name", like)
+	if err != nil {
+		return err
+	}
+	width := 0
+	shown := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+			shown = append(shown, name)
+			if n := utf8.RuneCountInString(name); n > width {
+				width = n
+			}
+		}
+	}
+	if len(shown) == 0 {
+		return nil
+	}
+
+	// the names go down the columns, as many as fit 80 characters
+	width += 2
+	columns := 80 / width
+	if columns < 1 {
+		columns = 1
+	}
+	lines := (len(shown) + columns - 1) / columns
+	for i := 0; i < lines; i++ {
+		var b strings.Builder
+		for j := i; j < len(shown); j += lines {
+			b.WriteString(shown[j] + strings.Repeat(" ", width-utf8.RuneCountInString(shown[j])))
+		}
+		fmt.Fprintln(s.out, strings.TrimRight(b.String(), " "))
+	}
+	return nil
+}
+
+func (s *shell) schema(args []string) error {
+	like, err := pattern(args)
+	if err != nil {
+		return err
+	}
+	texts, err := s.firstColumn("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND tbl_name LIKE ? ORDER BY rowid", like)
+	if err != nil {
+		return err
+	}
+	for _, text := range texts {
+		fmt.Fprintln(s.out, text+";")
+	}
+	return nil
+}
+
+func (s *shell) check(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	problems, err := s.conn.IntegrityCheck(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(s.out, "ok")
+	}
+	for _, p := range problems {
+		fmt.Fprintln(s.out, p)
+	}
+	return nil
+}
+
+// dbinfo to write the header of the store file after a checkpoint, the
+// sizes of the files and the size of the schema
+func (s *shell) dbinfo(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if s.fileName == "" {
+		return errNoFile
+	}
+	if err := s.db.TrxContext().Flush(); err != nil {
+		return err
+	}
+	info, err := gosqlite.ReadStoreInfo(s.fileName)
+	if err != nil {
+		return err
+	}
+	fileSize := func(name string) int64 {
+		if fi, err := os.Stat(name); err == nil {
+			return fi.Size()
+		}
+		return 0
+	}
+	types, err := s.firstColumn("SELECT type FROM sqlite_master")
+	if err != nil {
+		return err
+	}
+	texts, err := s.firstColumn("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	count := func(typ string) int {
+		n := 0
+		for _, t := range types {
+			if t == typ {
+				n++
+			}
+		}
+		return n
+	}
+	schemaSize := 0
+	for _, text := range texts {
+		schemaSize += len(text)
+	}
+
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"database page size", info.PageSize},
+		{"row tree pages", info.RowPages},
+		{"undo tree pages", info.UndoPages},
+		{"index tree pages", info.IndexPages},
+		{"commit tree pages", info.CommitPages},
+		{"checkpoint lsn", info.CheckpointLSN},
+		{"max trx id", info.MaxTrxID},
+		{"row counter", info.RowCounter},
+		{"store file size", fileSize(s.fileName)},
+		{"redo log size", fileSize(s.fileName + "-wal")},
+		{"number of tables", count("table")},
+		{"number of indexes", count("index")},
+		{"schema size", schemaSize},
+	} {
+		fmt.Fprintf(s.out, "%-20s %v\n", field.name+":", field.value)
+	}
+	return nil
+}
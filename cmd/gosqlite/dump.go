@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+// dump to write the tables matching the pattern, their rows and their
+// indexes as SQL text which recreates them, read in one trx. The stats
+// of ANALYZE are gathered again by an ANALYZE.
+func (s *shell) dump(args []string) error {
+	like, err := pattern(args)
+	if err != nil {
+		return err
+	}
+	if !s.conn.InTrx() {
+		if err = s.conn.Begin(context.Background(), true); err != nil {
+			return err
+		}
+		defer s.conn.Commit()
+	}
+
+	stmt, err := s.conn.Prepare("SELECT type, name, sql FROM sqlite_master WHERE tbl_name LIKE ? ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(like)
+	if err != nil {
+		return err
+	}
+	schema := make([][]interface{}, 0)
+	for rows.Next() {
+		schema = append(schema, rows.Values())
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(s.out, "BEGIN TRANSACTION;")
+	analyzed := false
+	for _, row := range schema {
+		name := formatValue(row[1])
+		if row[0] != "table" {
+			continue
+		} else if strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+			analyzed = true
+			continue
+		}
+		fmt.Fprintln(s.out, formatValue(row[2])+";")
+		if err = s.dumpRows(name); err != nil {
+			return err
+		}
+	}
+	for _, row := range schema {
+		if row[0] == "index" && row[2] != nil {
+			fmt.Fprintln(s.out, formatValue(row[2])+";")
+		}
+	}
+	if analyzed {
+		fmt.Fprintln(s.out, "ANALYZE;")
+	}
+	fmt.Fprintln(s.out, "COMMIT;")
+	return nil
+}
+
+// dumpRows to write an INSERT for each row of table
+func (s *shell) dumpRows(table string) error {
+	rows, err := s.conn.Query("SELECT * FROM " + sql.QuoteIdent(table))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]string, 0, len(rows.Values()))
+		for _, v := range rows.Values() {
+			values = append(values, literal(v))
+		}
+		fmt.Fprintf(s.out, "INSERT INTO %s VALUES(%s);\n", sql.QuoteIdent(table), strings.Join(values, ","))
+	}
+	return rows.Close()
+}
+
+// literal returns the SQL literal of v, a real keeps all its digits
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NULL"
+		case math.IsInf(v, 0):
+			// a literal out of range is an error, a product is not
+			return fmt.Sprintf("%.0fe308*10", math.Copysign(1, v))
+		}
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		return text
+	case []byte:
+		return fmt.Sprintf("X'%x'", v)
+	}
+	return "'" + strings.Replace(fmt.Sprint(v), "'", "''", -1) + "'"
+}
+
+// importCSV to insert the records of a CSV file in a table, in one trx.
+// A table that does not exist is created with TEXT columns named by the
+// first record. Missing fields are NULL and extra fields are ignored.
+func (s *shell) importCSV(args []string) error {
+	skip := 0
+	for len(args) > 2 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--csv":
+			// CSV is the only mode, as in sqlite3 the option changes nothing
+			args = args[1:]
+		case "--skip":
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return errUsage
+			}
+			skip, args = n, args[2:]
+		default:
+			return errUsage
+		}
+	}
+	if len(args) != 2 {
+		return errUsage
+	}
+	fileName, table := args[0], args[1]
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for i := 0; i < skip; i++ {
+		if _, err = r.Read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if !s.conn.InTrx() {
+		if err = s.conn.Begin(context.Background(), false); err != nil {
+			return err
+		}
+		defer func() {
+			if s.conn.InTrx() {
+				s.conn.Rollback()
+			}
+		}()
+		if err = s.insertCSV(r, fileName, table); err != nil {
+			return err
+		}
+		return s.conn.Commit()
+	}
+	return s.insertCSV(r, fileName, table)
+}
+
+// insertCSV to insert the records r reads from fileName in table, which
+// is created if it does not exist
+func (s *shell) insertCSV(r *csv.Reader, fileName string, table string) error {
+	names, err := s.firstColumn("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, name := range names {
+		exists = exists || strings.EqualFold(name, table)
+	}
+
+	columns := 0
+	if exists {
+		rows, err := s.conn.Query("SELECT * FROM " + sql.QuoteIdent(table) + " LIMIT 0")
+		if err != nil {
+			return err
+		}
+		columns = len(rows.Columns())
+		if err = rows.Close(); err != nil {
+			return err
+		}
+	} else {
+		header, err := r.Read()
+		if err == io.EOF {
+			return fmt.Errorf("%s: empty file", fileName)
+		} else if err != nil {
+			return err
+		}
+		defs := make([]string, len(header))
+		for i, name := range header {
+			defs[i] = sql.QuoteIdent(name) + " TEXT"
+		}
+		if _, err = s.conn.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", sql.QuoteIdent(table), strings.Join(defs, ", "))); err != nil {
+			return err
+		}
+		columns = len(header)
+	}
+
+	params := strings.TrimSuffix(strings.Repeat("?, ", columns), ", ")
+	stmt, err := s.conn.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", sql.QuoteIdent(table), params))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line, _ := r.FieldPos(0)
+		switch {
+		case len(record) < columns:
+			fmt.Fprintf(s.errOut, "%s:%d: expected %d columns but found %d - filling the rest with NULL\n", fileName, line, columns, len(record))
+		case len(record) > columns:
+			fmt.Fprintf(s.errOut, "%s:%d: expected %d columns but found %d - extras ignored\n", fileName, line, columns, len(record))
+		}
+		values := make([]interface{}, columns)
+		for i := range values {
+			if i < len(record) {
+				values[i] = record[i]
+			}
+		}
+		if _, err = stmt.Exec(values...); err != nil {
+			return fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+	}
+}
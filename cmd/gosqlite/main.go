@@ -0,0 +1,101 @@
+// Command gosqlite is a shell for gosqlite databases like the sqlite3
+// shell: it runs SQL and dot-commands typed at the prompt, read from a
+// script on stdin or given on the command line.
+//
+//	gosqlite [OPTIONS] [FILENAME [SQL...]]
+//
+// FILENAME is opened or created, without it or with ":memory:" the
+// database is in memory. Enter ".help" for the dot-commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] [FILENAME [SQL...]]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	bail := flag.Bool("bail", false, "stop after the first error")
+	cmd := flag.String("cmd", "", "run `COMMAND` before reading stdin")
+	csv := flag.Bool("csv", false, "set the output mode to csv")
+	header := flag.Bool("header", false, "show the column names")
+	json := flag.Bool("json", false, "set the output mode to json")
+	list := flag.Bool("list", false, "set the output mode to list")
+	table := flag.Bool("table", false, "set the output mode to table")
+	vm := flag.Bool("vm", false, "run the statements on the bytecode VM")
+	flag.Parse()
+
+	s := newShell(os.Stdout, os.Stderr)
+	s.bail, s.headers, s.vm = *bail, *header, *vm
+	switch {
+	case *csv:
+		s.mode = "csv"
+	case *json:
+		s.mode = "json"
+	case *table:
+		s.mode = "table"
+	case *list:
+		s.mode = "list"
+	}
+
+	fileName := flag.Arg(0)
+	if err := s.open(fileName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: unable to open database %q: %v\n", fileName, err)
+		os.Exit(1)
+	}
+	if *cmd != "" && !s.runLine(*cmd) && s.bail {
+		s.close()
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 1 {
+		for _, text := range flag.Args()[1:] {
+			if !s.runLine(text) && s.bail {
+				break
+			}
+		}
+	} else if interactive() {
+		s.interactive = true
+		s.loadHistory(historyFile())
+		fmt.Fprintln(s.out, `gosqlite shell, enter ".help" for usage hints.`)
+		if fileName == "" || fileName == memoryName {
+			fmt.Fprintln(s.out, "Connected to a transient in-memory database.")
+			fmt.Fprintln(s.out, `Use ".open FILENAME" to reopen on a persistent database.`)
+		}
+		s.interruptible()
+		s.run(os.Stdin, "")
+	} else {
+		s.run(os.Stdin, "")
+	}
+
+	code := s.exitCode
+	if code == 0 && s.failed && !s.interactive {
+		code = 1
+	}
+	s.close()
+	os.Exit(code)
+}
+
+// interactive returns whether stdin is a terminal
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// historyFile returns the file the history is kept in, $GOSQLITE_HISTORY
+// or ~/.gosqlite_history, "" for none
+func historyFile() string {
+	if name := os.Getenv("GOSQLITE_HISTORY"); name != "" {
+		return name
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gosqlite_history")
+}
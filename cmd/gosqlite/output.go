@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// modes are the output modes of .mode
+var modes = []string{"list", "csv", "json", "table"}
+
+// formatValue returns v as the shell shows it, NULL is empty and a real
+// has 15 digits like the sqlite3 shell
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "Inf"
+		case math.IsInf(v, -1):
+			return "-Inf"
+		}
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', 15, 64)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// write to write the rows of a query by the mode of the shell
+func (s *shell) write(columns []string, rows [][]interface{}) {
+	switch s.mode {
+	case "csv":
+		writeCSV(s.out, columns, rows, s.headers)
+	case "json":
+		writeJSON(s.out, columns, rows)
+	case "table":
+		writeTable(s.out, columns, rows)
+	default:
+		writeList(s.out, columns, rows, s.headers)
+	}
+}
+
+func writeList(w io.Writer, columns []string, rows [][]interface{}, headers bool) {
+	if headers {
+		fmt.Fprintln(w, strings.Join(columns, "|"))
+	}
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			fields[i] = formatValue(v)
+		}
+		fmt.Fprintln(w, strings.Join(fields, "|"))
+	}
+}
+
+func writeCSV(w io.Writer, columns []string, rows [][]interface{}, headers bool) {
+	cw := csv.NewWriter(w)
+	if headers {
+		cw.Write(columns)
+	}
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			fields[i] = formatValue(v)
+		}
+		cw.Write(fields)
+	}
+	cw.Flush()
+}
+
+// writeJSON to write the rows as an array of objects, a row a line
+func writeJSON(w io.Writer, columns []string, rows [][]interface{}) {
+	if len(rows) == 0 {
+		return
+	}
+	for i, row := range rows {
+		var b strings.Builder
+		if i == 0 {
+			b.WriteByte('[')
+		}
+		b.WriteByte('{')
+		for j, v := range row {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(jsonString(columns[j]) + ":" + jsonValue(v))
+		}
+		b.WriteByte('}')
+		if i < len(rows)-1 {
+			b.WriteByte(',')
+		} else {
+			b.WriteByte(']')
+		}
+		fmt.Fprintln(w, b.String())
+	}
+}
+
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "null"
+		}
+		return formatValue(v)
+	case []byte:
+		return jsonString(string(v))
+	}
+	return jsonString(fmt.Sprint(v))
+}
+
+func jsonString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// writeTable to write the rows in a box with the column names on top,
+// numbers are aligned to the right
+func writeTable(w io.Writer, columns []string, rows [][]interface{}) {
+	widths := make([]int, len(columns))
+	for i, c := range columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	texts := make([][]string, len(rows))
+	for i, row := range rows {
+		texts[i] = make([]string, len(row))
+		for j, v := range row {
+			texts[i][j] = formatValue(v)
+			if n := utf8.RuneCountInString(texts[i][j]); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	var rule strings.Builder
+	rule.WriteByte('+')
+	for _, width := range widths {
+		rule.WriteString(strings.Repeat("-", width+2) + "+")
+	}
+	line := func(fields []string, right func(j int) bool) {
+		var b strings.Builder
+		b.WriteByte('|')
+		for j, f := range fields {
+			pad := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(f))
+			if right(j) {
+				b.WriteString(" " + pad + f + " |")
+			} else {
+				b.WriteString(" " + f + pad + " |")
+			}
+		}
+		fmt.Fprintln(w, b.String())
+	}
+
+	fmt.Fprintln(w, rule.String())
+	line(columns, func(int) bool { return false })
+	fmt.Fprintln(w, rule.String())
+	for i, row := range rows {
+		line(texts[i], func(j int) bool {
+			switch row[j].(type) {
+			case int64, float64:
+				return true
+			}
+			return false
+		})
+	}
+	if len(rows) > 0 {
+		fmt.Fprintln(w, rule.String())
+	}
+}
+
+// programWidths are the widths of the columns of an EXPLAIN program
+var programWidths = []int{4, 13, 4, 4, 4, 13, 2, 13}
+
+// writeProgram to write the program of EXPLAIN in aligned columns
+func writeProgram(w io.Writer, columns []string, rows [][]interface{}) {
+	line := func(fields []string) {
+		var b strings.Builder
+		for i, f := range fields {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(f)
+			if i < len(programWidths) {
+				if n := programWidths[i] - utf8.RuneCountInString(f); n > 0 {
+					b.WriteString(strings.Repeat(" ", n))
+				}
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+
+	line(columns)
+	rules := make([]string, len(columns))
+	for i := range columns {
+		width := 13
+		if i < len(programWidths) {
+			width = programWidths[i]
+		}
+		rules[i] = strings.Repeat("-", width)
+	}
+	line(rules)
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			fields[i] = formatValue(v)
+		}
+		line(fields)
+	}
+}
+
+// writeQueryPlan to write the rows of EXPLAIN QUERY PLAN, id, parent,
+// notused and detail, as a tree
+func writeQueryPlan(w io.Writer, rows [][]interface{}) {
+	children := make(map[int64][]int)
+	for i, row := range rows {
+		children[toInt64(row[1])] = append(children[toInt64(row[1])], i)
+	}
+
+	fmt.Fprintln(w, "QUERY PLAN")
+	var walk func(parent int64, prefix string)
+	walk = func(parent int64, prefix string) {
+		for n, i := range children[parent] {
+			branch, indent := "|--", "|  "
+			if n == len(children[parent])-1 {
+				branch, indent = "`--", "   "
+			}
+			fmt.Fprintln(w, prefix+branch+formatValue(rows[i][3]))
+			if id := toInt64(rows[i][0]); id != parent {
+				walk(id, prefix+indent)
+			}
+		}
+	}
+	walk(0, "")
+}
+
+func toInt64(v interface{}) int64 {
+	n, _ := v.(int64)
+	return n
+}
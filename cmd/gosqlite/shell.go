@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"gosqlite"
+	"gosqlite/sql"
+)
+
+const (
+	memoryName     = ":memory:"
+	mainPrompt     = "gosqlite> "
+	continuePrompt = "   ...> "
+	maxHistory     = 1000
+)
+
+// shell runs SQL and dot-commands on a database, the output of queries
+// is written by mode and the errors to errOut.
+type shell struct {
+	db       *gosqlite.DB
+	conn     *gosqlite.Conn
+	fileName string
+	out      io.Writer
+	errOut   io.Writer
+
+	mode    string
+	headers bool
+	timer   bool
+	explain bool
+	bail    bool
+	vm      bool
+	// interactive is set when the user types the input at a prompt
+	interactive bool
+
+	history     []string
+	historyFile string
+
+	mu sync.Mutex
+	// cancel interrupts the running statement, nil if none
+	cancel context.CancelFunc
+
+	// failed is set by an error, quit by .quit or an error with bail on
+	failed   bool
+	quit     bool
+	exitCode int
+}
+
+func newShell(out, errOut io.Writer) *shell {
+	return &shell{out: out, errOut: errOut, mode: "list", explain: true}
+}
+
+// open to open the database fileName in place of the open one, "" or
+// ":memory:" is a database in memory
+func (s *shell) open(fileName string) error {
+	var db *gosqlite.DB
+	if fileName == "" || fileName == memoryName {
+		db, fileName = gosqlite.CreateDB(), ""
+	} else {
+		var err error
+		if db, err = gosqlite.OpenDB(fileName); err != nil {
+			return err
+		}
+	}
+	s.close()
+	s.db, s.conn, s.fileName = db, db.Conn(), fileName
+	s.conn.UseVM(s.vm)
+	return nil
+}
+
+// close to roll back the open trx and close the database
+func (s *shell) close() {
+	if s.db == nil {
+		return
+	}
+	s.conn.Close()
+	if err := s.db.Close(); err != nil {
+		fmt.Fprintf(s.errOut, "Error: %v\n", err)
+	}
+	s.db, s.conn = nil, nil
+}
+
+// run to run the SQL and the dot-commands read from r until its end, a
+// .quit or an error when bail is on. name is the file r reads, "" for
+// stdin. A statement may span lines and ends with a semicolon, a
+// dot-command is a line of its own.
+func (s *shell) run(r io.Reader, name string) {
+	prompt := s.interactive && name == ""
+	in := bufio.NewReader(r)
+	var text strings.Builder
+	start := 0
+	for lineNo := 1; !s.quit; lineNo++ {
+		if prompt {
+			if text.Len() == 0 {
+				fmt.Fprint(s.out, mainPrompt)
+			} else {
+				fmt.Fprint(s.out, continuePrompt)
+			}
+		}
+		line, err := in.ReadString('\n')
+		if line == "" && err != nil {
+			if prompt {
+				fmt.Fprintln(s.out)
+			}
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if text.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			start = lineNo
+			if trimmed[0] == '.' {
+				if prompt {
+					s.addHistory(trimmed)
+				}
+				s.report(s.command(trimmed), name, start)
+				continue
+			}
+		}
+		text.WriteString(line)
+		text.WriteByte('\n')
+		if sql.Complete(text.String()) {
+			if prompt {
+				s.addHistory(text.String())
+			}
+			s.report(s.exec(text.String()), name, start)
+			text.Reset()
+		}
+	}
+	// a last statement without a semicolon
+	if rest := strings.TrimSpace(text.String()); rest != "" && !s.quit {
+		s.report(s.exec(rest), name, start)
+	}
+}
+
+// runLine to run text as one line of input, returns false on an error
+func (s *shell) runLine(text string) bool {
+	var err error
+	if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, ".") {
+		err = s.command(trimmed)
+	} else {
+		err = s.exec(text)
+	}
+	s.report(err, "", 0)
+	return err == nil
+}
+
+// report to write err, with the line of the input it was found near
+// unless the user typed it, and to stop when bail is on
+func (s *shell) report(err error, name string, line int) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("interrupted")
+	}
+	s.failed = true
+	switch {
+	case line == 0 || s.interactive && name == "":
+		fmt.Fprintf(s.errOut, "Error: %v\n", err)
+	case name != "":
+		fmt.Fprintf(s.errOut, "Error: %s near line %d: %v\n", name, line, err)
+	default:
+		fmt.Fprintf(s.errOut, "Error: near line %d: %v\n", line, err)
+	}
+	if s.bail {
+		s.quit = true
+		s.exitCode = 1
+	}
+}
+
+// exec to run the statements of text and write their rows
+func (s *shell) exec(text string) error {
+	texts, err := sql.Split(text)
+	if err != nil {
+		return err
+	}
+	for _, text := range texts {
+		if err = s.query(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query to run the statement text and write its rows, the rows read
+// before an error are written too
+func (s *shell) query(text string) error {
+	var explain *sql.ExplainStmt
+	if stmt, err := sql.ParseStmt(text); err == nil {
+		explain, _ = stmt.(*sql.ExplainStmt)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	started := time.Now()
+	rows, err := s.conn.QueryContext(ctx, text)
+	if err != nil {
+		return err
+	}
+	values := make([][]interface{}, 0)
+	for rows.Next() {
+		values = append(values, rows.Values())
+	}
+	err = rows.Close()
+	elapsed := time.Since(started)
+
+	if len(rows.Columns()) > 0 {
+		switch {
+		case explain != nil && s.explain && explain.QueryPlan:
+			writeQueryPlan(s.out, values)
+		case explain != nil && s.explain:
+			writeProgram(s.out, rows.Columns(), values)
+		default:
+			s.write(rows.Columns(), values)
+		}
+	}
+	if s.timer {
+		fmt.Fprintf(s.out, "Run Time: real %.3f\n", elapsed.Seconds())
+	}
+	return err
+}
+
+// interruptible to interrupt the running statement on an interrupt
+// signal instead of exiting
+func (s *shell) interruptible() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		for range ch {
+			s.mu.Lock()
+			if s.cancel != nil {
+				s.cancel()
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// loadHistory to read the history kept in fileName, the entries typed
+// from now on are added to it
+func (s *shell) loadHistory(fileName string) {
+	s.historyFile = fileName
+	if fileName == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+	if n := len(s.history); n > maxHistory {
+		s.history = s.history[n-maxHistory:]
+	}
+}
+
+// addHistory to add an entry the user typed to the history, a statement
+// of several lines is kept as one line
+func (s *shell) addHistory(text string) {
+	text = strings.TrimSpace(strings.Join(strings.Split(strings.Replace(text, "\r", "", -1), "\n"), " "))
+	if text == "" || len(s.history) > 0 && s.history[len(s.history)-1] == text {
+		return
+	}
+	s.history = append(s.history, text)
+	if len(s.history) > maxHistory {
+		s.history = s.history[1:]
+	}
+	if s.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(s.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, text)
+	f.Close()
+}
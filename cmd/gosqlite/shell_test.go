@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestShell returns a shell on a database in memory writing to out
+// and errOut
+func newTestShell(t *testing.T) (s *shell, out, errOut *bytes.Buffer) {
+	out, errOut = new(bytes.Buffer), new(bytes.Buffer)
+	s = newShell(out, errOut)
+	if err := s.open(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.close)
+	return s, out, errOut
+}
+
+// expect checks that running input writes want, and nothing to errOut
+func expect(t *testing.T, s *shell, out, errOut *bytes.Buffer, input, want string) {
+	t.Helper()
+	out.Reset()
+	s.run(strings.NewReader(input), "")
+	if errOut.Len() > 0 {
+		t.Fatalf("%s: errors\n%s", input, errOut)
+	}
+	if got := out.String(); got != want {
+		t.Fatalf("%s: got\n%s\nwant\n%s", input, got, want)
+	}
+}
+
+func TestShellModes(t *testing.T) {
+	s, out, errOut := newTestShell(t)
+	expect(t, s, out, errOut, `CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score REAL);
+INSERT INTO t (name, score) VALUES ('a', 1.5), ('b, "c"',
+  2), (NULL, NULL);
+SELECT * FROM t;`, `1|a|1.5
+2|b, "c"|2.0
+3||
+`)
+	expect(t, s, out, errOut, ".headers on\n.mode csv\nSELECT * FROM t WHERE id < 3;\n", `id,name,score
+1,a,1.5
+2,"b, ""c""",2.0
+`)
+	expect(t, s, out, errOut, ".mode json\nSELECT * FROM t;\nSELECT * FROM t WHERE 0;\n", `[{"id":1,"name":"a","score":1.5},
+{"id":2,"name":"b, \"c\"","score":2.0},
+{"id":3,"name":null,"score":null}]
+`)
+	expect(t, s, out, errOut, ".mode table\nSELECT id, name AS n FROM t;\n", `+----+--------+
+| id | n      |
++----+--------+
+|  1 | a      |
+|  2 | b, "c" |
+|  3 |        |
++----+--------+
+`)
+	expect(t, s, out, errOut, ".mode list\n.headers off\nSELECT 1; SELECT 2 -- two\n;\n", "1\n2\n")
+}
+
+func TestShellExplain(t *testing.T) {
+	s, out, errOut := newTestShell(t)
+	expect(t, s, out, errOut, `CREATE TABLE t (a, b); CREATE TABLE u (c);
+EXPLAIN QUERY PLAN SELECT * FROM t, u WHERE a = 1 AND c = b;
+EXPLAIN SELECT 1;`, "QUERY PLAN\n"+
+		"|--SCAN t\n"+
+		"`--SCAN u\n"+
+		`addr  opcode         p1    p2    p3    p4             p5  comment
+----  -------------  ----  ----  ----  -------------  --  -------------
+0     Init           0     1     0                    0
+1     Integer        1     1     0                    0
+2     ResultRow      1     1     0                    0
+3     Halt           0     0     0                    0
+`)
+	expect(t, s, out, errOut, ".explain off\nEXPLAIN QUERY PLAN SELECT * FROM t;\n", "2|0|0|SCAN t\n")
+}
+
+func TestShellErrors(t *testing.T) {
+	s, out, errOut := newTestShell(t)
+	s.run(strings.NewReader("SELECT 1;\n\nSELECT x;\n.mode xml\n.bogus\n.t\n.e\nSELECT 'a\n;b';\nSELECT 2"), "")
+	if out.String() != "1\na\n;b\n2\n" {
+		t.Fatalf("output\n%s", out)
+	}
+	want := `Error: near line 3: no such column: x
+Error: near line 4: usage: .mode list|csv|json|table
+Error: near line 5: unknown command: .bogus, enter ".help" for help
+Error: near line 6: ambiguous command: .t
+Error: near line 7: ambiguous command: .e
+`
+	if errOut.String() != want || !s.failed || s.quit {
+		t.Fatalf("errors\n%s", errOut)
+	}
+
+	// bail stops at the first error
+	out.Reset()
+	errOut.Reset()
+	s.bail = true
+	s.run(strings.NewReader("SELECT 1;\nSELECT x;\nSELECT 2;\n"), "")
+	if out.String() != "1\n" || errOut.String() != "Error: near line 2: no such column: x\n" || !s.quit || s.exitCode != 1 {
+		t.Fatalf("bail: %q %q", out, errOut)
+	}
+}
+
+func TestShellDump(t *testing.T) {
+	s, out, errOut := newTestShell(t)
+	expect(t, s, out, errOut, `CREATE TABLE "my t" (id INTEGER PRIMARY KEY, v, "order" TEXT UNIQUE);
+INSERT INTO "my t" (v, "order") VALUES (1.0, 'it''s'), (X'00ff', NULL), (1e308 * 10, 'x'), (-2, '');
+CREATE INDEX i ON "my t" (v DESC);
+CREATE TABLE u (a);
+ANALYZE;
+.dump
+`, `BEGIN TRANSACTION;
+CREATE TABLE "my t" (id INTEGER PRIMARY KEY, v, "order" TEXT UNIQUE);
+INSERT INTO "my t" VALUES(1,1.0,'it''s');
+INSERT INTO "my t" VALUES(2,X'00ff',NULL);
+INSERT INTO "my t" VALUES(3,1e308*10,'x');
+INSERT INTO "my t" VALUES(4,-2,'');
+CREATE TABLE u (a);
+CREATE INDEX i ON "my t" (v DESC);
+ANALYZE;
+COMMIT;
+`)
+	dump := out.String()
+	expect(t, s, out, errOut, ".dump u\n", "BEGIN TRANSACTION;\nCREATE TABLE u (a);\nCOMMIT;\n")
+
+	// the dump recreates the database
+	r, out2, errOut2 := newTestShell(t)
+	expect(t, r, out2, errOut2, dump+".dump\n", dump)
+	expect(t, r, out2, errOut2, "SELECT * FROM sqlite_stat1;\n.check\n", "my t|sqlite_autoindex_my t_1|4 1\nmy t|i|4 1\nok\n")
+}
+
+func TestShellImport(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "p.csv")
+	if err := ioutil.WriteFile(fileName, []byte("id,name,\"the score\"\n1,ann,1.5\n2,\"bob, jr\",\n3,cid\n4,dan,2,x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, out, errOut := newTestShell(t)
+	s.run(strings.NewReader(`CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score REAL);
+.import --skip 1 `+fileName+` t
+.import --csv `+fileName+" \"new t\"\n"), "")
+	if s.failed {
+		t.Fatalf("errors\n%s", errOut)
+	}
+	errOut.Reset()
+	expect(t, s, out, errOut, ".headers on\nSELECT id, name, score, typeof(score) FROM t;\n.schema \"new%\"\nSELECT * FROM \"new t\";\n", `id|name|score|typeof(score)
+1|ann|1.5|real
+2|bob, jr||text
+3|cid||null
+4|dan|2.0|real
+CREATE TABLE "new t" (id TEXT, name TEXT, "the score" TEXT);
+id|name|the score
+1|ann|1.5
+2|bob, jr|
+3|cid|
+4|dan|2
+`)
+}
+
+func TestShellImportWarnings(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "p.csv")
+	if err := ioutil.WriteFile(fileName, []byte("a,b\n1\n2,3,4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, _, errOut := newTestShell(t)
+	s.run(strings.NewReader(".import "+fileName+" t\n"), "")
+	want := fileName + ":2: expected 2 columns but found 1 - filling the rest with NULL\n" +
+		fileName + ":3: expected 2 columns but found 3 - extras ignored\n"
+	if errOut.String() != want {
+		t.Fatalf("warnings\n%s", errOut)
+	}
+
+	// a failed import is rolled back
+	errOut.Reset()
+	s.run(strings.NewReader("CREATE TABLE u (a, b NOT NULL);\n.import "+fileName+" u\n"), "")
+	if !strings.HasSuffix(errOut.String(), "Error: near line 2: "+fileName+":2: NOT NULL constraint failed: u.b\n") {
+		t.Fatalf("errors\n%s", errOut)
+	}
+	if s.conn.InTrx() {
+		t.Fatal("the trx of the import is open")
+	}
+}
+
+func TestShellTables(t *testing.T) {
+	s, out, errOut := newTestShell(t)
+	names := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		names = append(names, "CREATE TABLE table_"+strings.Repeat("x", i)+" (a)")
+	}
+	expect(t, s, out, errOut, strings.Join(names, ";\n")+";\nANALYZE;\n.tables\n.tables %xxxxxxx%\n.tab nope\n",
+		`table_           table_xxx        table_xxxxxx     table_xxxxxxxxx
+table_x          table_xxxx       table_xxxxxxx
+table_xx         table_xxxxx      table_xxxxxxxx
+table_xxxxxxx    table_xxxxxxxx   table_xxxxxxxxx
+`)
+	expect(t, s, out, errOut, ".schema table_xx\n", "CREATE TABLE table_xx (a);\n")
+}
+
+func TestShellFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "shell.db")
+	s, out, errOut := newTestShell(t)
+	s.run(strings.NewReader(".dbinfo\n"), "")
+	if errOut.String() != "Error: near line 1: "+errNoFile.Error()+"\n" {
+		t.Fatalf("dbinfo in memory: %s", errOut)
+	}
+	errOut.Reset()
+
+	expect(t, s, out, errOut, ".open "+fileName+"\nCREATE TABLE t (a UNIQUE); CREATE INDEX t_a ON t (a); INSERT INTO t VALUES (1), (2);\n.check\n", "ok\n")
+	out.Reset()
+	s.run(strings.NewReader(".dbinfo\n"), "")
+	for _, want := range []string{"database page size:  512\n", "number of tables:    1\n", "number of indexes:   2\n", "schema size:         50\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("dbinfo misses %q\n%s", want, out)
+		}
+	}
+
+	// the rows are there once the file is opened again
+	expect(t, s, out, errOut, ".open\nSELECT count(*) FROM sqlite_master;\n.open "+fileName+"\nSELECT * FROM t;\n", "0\n1\n2\n")
+}
+
+func TestShellRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "script.sql")
+	if err := ioutil.WriteFile(fileName, []byte("CREATE TABLE t (a);\nINSERT INTO t VALUES (1);\nSELECT b FROM t;\n.timer on\nSELECT a\nFROM t;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, out, errOut := newTestShell(t)
+	s.run(strings.NewReader(".read "+fileName+"\n.timer off\nSELECT 2;\n"), "")
+	if errOut.String() != "Error: "+fileName+" near line 3: no such column: b\n" {
+		t.Fatalf("errors\n%s", errOut)
+	}
+	if !strings.HasPrefix(out.String(), "1\nRun Time: real ") || !strings.HasSuffix(out.String(), "\n2\n") {
+		t.Fatalf("output\n%s", out)
+	}
+}
+
+func TestShellHistory(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "history")
+	if err := ioutil.WriteFile(fileName, []byte("SELECT 0;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, out, errOut := newTestShell(t)
+	s.interactive = true
+	s.loadHistory(fileName)
+	s.run(strings.NewReader("SELECT 1,\n  2;\n.mode csv\n.mode csv\n.history\n"), "")
+	want := mainPrompt + continuePrompt + "1|2\n" + mainPrompt + mainPrompt + mainPrompt +
+		"    1  SELECT 0;\n    2  SELECT 1,   2;\n    3  .mode csv\n    4  .history\n" + mainPrompt + "\n"
+	if out.String() != want || errOut.Len() > 0 {
+		t.Fatalf("output\n%s\n%s", out, errOut)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil || string(data) != "SELECT 0;\nSELECT 1,   2;\n.mode csv\n.history\n" {
+		t.Fatalf("history file %q %v", data, err)
+	}
+}
@@ -0,0 +1,571 @@
+package gosqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gosqlite/sql"
+)
+
+const (
+	// the rows of table id are stored in the trx context with rowIDs
+	// id<<tableShift | rowid, rowids are in [1, maxTableRowID].
+	tableShift    = 40
+	maxTableRowID = 1<<tableShift - 1
+
+	masterName = "sqlite_master"
+)
+
+var (
+	// ErrNoTrx is returned by COMMIT or ROLLBACK without BEGIN.
+	ErrNoTrx = errors.New("no transaction is active")
+	// ErrTrxActive is returned by BEGIN within a transaction.
+	ErrTrxActive = errors.New("cannot start a transaction within a transaction")
+)
+
+// DB is a database of tables, the rows of every table are versions in
+// one trx context so a trx reads and writes all tables. The schema is
+// stored in the table sqlite_master so DDL is transactional too.
+type DB struct {
+	ctx     *TrxContext
+	indexes *indexSet
+
+	mu sync.Mutex
+	// tables caches the parsed CREATE TABLE statements by their sql
+	tables map[string]*tableInfo
+
+	// schemaVersion counts the commits that changed the schema, schemaTS
+	// is the commit timestamp of the last one and schemaWriters are the
+	// trx with schema changes not committed yet. statID is the id of
+	// sqlite_stat1, whose rows are part of the schema, 0 if none.
+	schemaVersion int64
+	schemaTS      int64
+	schemaWriters map[int64]bool
+	statID        int64
+}
+
+// columnInfo is a column of a table, aff is the affinity of its type
+// and coll its collation
+type columnInfo struct {
+	name    string
+	typ     string
+	aff     affinity
+	coll    *collation
+	notNull bool
+	unique  bool
+	dflt    sql.Expr
+}
+
+// tableInfo is a table of the schema, rowidColumn is the INTEGER
+// PRIMARY KEY column which is stored as the rowid, -1 if none. stat is
+// the count of rows ANALYZE found, nil if not analyzed.
+type tableInfo struct {
+	id          int64
+	name        string
+	sql         string
+	columns     []columnInfo
+	rowidColumn int
+	indexes     []*indexInfo
+	stat        []int64
+}
+
+// masterTable is sqlite_master, its rowids are the ids of the tables
+var masterTable = &tableInfo{
+	id:   0,
+	name: masterName,
+	columns: []columnInfo{
+		{name: "type", typ: "TEXT", aff: affinityText, coll: binaryCollation},
+		{name: "name", typ: "TEXT", aff: affinityText, coll: binaryCollation},
+		{name: "tbl_name", typ: "TEXT", aff: affinityText, coll: binaryCollation},
+		{name: "rootpage", typ: "INTEGER", aff: affinityInteger, coll: binaryCollation},
+		{name: "sql", typ: "TEXT", aff: affinityText, coll: binaryCollation},
+	},
+	rowidColumn: -1,
+}
+
+// rowRange returns the rowIDs of the table in the trx context
+func (info *tableInfo) rowRange() (int64, int64) {
+	return info.id<<tableShift + 1, info.id<<tableShift + maxTableRowID + 1
+}
+
+func (info *tableInfo) rowID(rowid int64) int64 {
+	return info.id<<tableShift | rowid
+}
+
+// column returns the index of column name, -1 if none
+func (info *tableInfo) column(name string) int {
+	for i, c := range info.columns {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// uniqueColumns returns the UNIQUE and PRIMARY KEY columns other than
+// the rowid, each has an automatic index.
+func (info *tableInfo) uniqueColumns() []int {
+	columns := make([]int, 0)
+	for i, c := range info.columns {
+		if c.unique {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+// CreateDB to create a database in memory
+func CreateDB() *DB {
+	return newDB(CreateTrxContext())
+}
+
+// OpenDB to open a database stored in fileName
+func OpenDB(fileName string) (*DB, error) {
+	ctx, err := OpenTrxContext(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newDB(ctx), nil
+}
+
+func newDB(ctx *TrxContext) *DB {
+	db := &DB{ctx: ctx, indexes: newIndexSet(ctx), tables: make(map[string]*tableInfo), schemaWriters: make(map[int64]bool)}
+	ctx.OnUpdate(db.schemaWritten)
+	ctx.OnAfterCommit(func(info TxInfo) { db.schemaEnded(info.TrxID, info.CommitTS) })
+	ctx.OnRollback(func(info TxInfo) { db.schemaEnded(info.TrxID, 0) })
+	return db
+}
+
+// schemaWritten to note that trx trxID changed the schema when rowID is
+// a row of sqlite_master or sqlite_stat1
+func (db *DB) schemaWritten(trxID int64, op ChangeOp, rowID int64) {
+	id := rowID >> tableShift
+	if id != masterTable.id && id != atomic.LoadInt64(&db.statID) {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.schemaWriters[trxID] = true
+}
+
+// schemaEnded to count the schema changes of trx trxID once it committed
+// at commitTS, 0 when it rolled back.
+func (db *DB) schemaEnded(trxID int64, commitTS int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.schemaWriters[trxID] {
+		return
+	}
+	delete(db.schemaWriters, trxID)
+	if commitTS != 0 {
+		db.schemaVersion++
+		db.schemaTS = commitTS
+	}
+}
+
+// schemaCookie returns the version of the schema trx sees when it is the
+// latest committed one, -1 when trx may see another schema.
+func (db *DB) schemaCookie(trx *Trx) int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.schemaWriters) > 0 || trx.view.snapshotTS < db.schemaTS {
+		return -1
+	}
+	return db.schemaVersion
+}
+
+// TrxContext returns the trx context the database is stored in
+func (db *DB) TrxContext() *TrxContext {
+	return db.ctx
+}
+
+// Close to close the database, the connections must be closed
+func (db *DB) Close() error {
+	return db.ctx.Close()
+}
+
+// parseTable returns the table of def, created by a CREATE TABLE text,
+// with the indexes and their stats, the parsed tables are cached by the
+// texts.
+func (db *DB) parseTable(def indexDef, indexes []indexDef) (*tableInfo, error) {
+	key := fmt.Sprintf("%d:%s:%s", def.id, def.sql, def.stat)
+	for _, def := range indexes {
+		key += fmt.Sprintf("\x00%d:%s:%s:%s", def.id, def.name, def.sql, def.stat)
+	}
+	db.mu.Lock()
+	info := db.tables[key]
+	db.mu.Unlock()
+	if info != nil {
+		return info, nil
+	}
+
+	stmt, err := sql.ParseStmt(def.sql)
+	if err != nil {
+		return nil, err
+	}
+	create, ok := stmt.(*sql.CreateTableStmt)
+	if !ok {
+		return nil, fmt.Errorf("malformed schema: %s", def.sql)
+	}
+	if info, err = newTableInfo(def.id, def.sql, create); err != nil {
+		return nil, err
+	}
+	if stat := parseStat(def.stat); stat != nil {
+		info.stat = stat[:1]
+	}
+	for _, def := range indexes {
+		idx, err := parseIndex(info, def)
+		if err != nil {
+			return nil, err
+		}
+		idx.stat = parseStat(def.stat)
+		info.indexes = append(info.indexes, idx)
+	}
+
+	db.mu.Lock()
+	db.tables[key] = info
+	db.mu.Unlock()
+	return info, nil
+}
+
+func newTableInfo(id int64, text string, create *sql.CreateTableStmt) (*tableInfo, error) {
+	info := &tableInfo{id: id, name: create.Name, sql: text, rowidColumn: -1}
+	for i, c := range create.Columns {
+		if info.column(c.Name) >= 0 {
+			return nil, fmt.Errorf("duplicate column name: %s", c.Name)
+		}
+		if c.PrimaryKey && strings.EqualFold(c.Type, "INTEGER") && !c.Desc {
+			info.rowidColumn = i
+		}
+		unique := c.Unique || c.PrimaryKey && i != info.rowidColumn
+		coll, err := lookupCollation(c.Collate)
+		if err != nil {
+			return nil, err
+		}
+		info.columns = append(info.columns, columnInfo{name: c.Name, typ: c.Type, aff: typeAffinity(c.Type), coll: coll,
+			notNull: c.NotNull, unique: unique, dflt: c.Default})
+	}
+	return info, nil
+}
+
+// schema returns the tables visible to trx by lower case name
+func (db *DB) schema(trx *Trx) (map[string]*tableInfo, error) {
+	defs := make([]indexDef, 0)
+	indexes := make(map[string][]indexDef)
+	err := db.scanMaster(trx, func(rowid int64, values []interface{}) error {
+		name, _ := values[2].(string)
+		text, _ := values[4].(string)
+		switch values[0] {
+		case "table":
+			defs = append(defs, indexDef{id: rowid, name: name, sql: text})
+		case "index":
+			name = strings.ToLower(name)
+			indexes[name] = append(indexes[name], indexDef{id: rowid, name: values[1].(string), sql: text})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range defs {
+		if strings.EqualFold(def.name, statName) {
+			atomic.StoreInt64(&db.statID, def.id)
+			if err = db.readStats(trx, def, defs, indexes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tables := map[string]*tableInfo{masterName: masterTable}
+	for _, def := range defs {
+		info, err := db.parseTable(def, indexes[strings.ToLower(def.name)])
+		if err != nil {
+			return nil, err
+		}
+		tables[strings.ToLower(info.name)] = info
+	}
+	return tables, nil
+}
+
+// scanMaster calls fn on the rows of sqlite_master visible to trx
+func (db *DB) scanMaster(trx *Trx, fn func(rowid int64, values []interface{}) error) error {
+	return db.scanTable(trx, masterTable, fn)
+}
+
+// scanTable calls fn on the rows of table visible to trx
+func (db *DB) scanTable(trx *Trx, info *tableInfo, fn func(rowid int64, values []interface{}) error) error {
+	from, to := info.rowRange()
+	rows := trx.cursor(db.ctx, from, to)
+	for {
+		row, ok, err := rows.next()
+		if err != nil || !ok {
+			return err
+		}
+		values, err := DecodeRecord(row.Data)
+		if err != nil {
+			return err
+		}
+		if len(values) < len(info.columns) {
+			return ErrRecordCorrupt
+		}
+		if err = fn(row.RowID-info.id<<tableShift, values); err != nil {
+			return err
+		}
+	}
+}
+
+// Result is the outcome of a statement that changes rows
+type Result struct {
+	RowsAffected int64
+	LastInsertID int64
+}
+
+// Conn runs statements on a DB, a statement outside BEGIN and COMMIT
+// runs in a trx of its own.
+type Conn struct {
+	db    *DB
+	trx   *Trx
+	vm    bool
+	cache *stmtCache
+	// lastInsertID is the rowid of the row last inserted on the conn
+	lastInsertID int64
+}
+
+// Conn to open a connection
+func (db *DB) Conn() *Conn {
+	return &Conn{db: db, cache: newStmtCache(defaultStmtCacheSize)}
+}
+
+// UseVM to run the statements of the connection on the bytecode VM
+// instead of the operators, EXPLAIN shows the program in both cases.
+func (c *Conn) UseVM(on bool) {
+	c.vm = on
+}
+
+// InTrx returns whether a trx begun by BEGIN is open
+func (c *Conn) InTrx() bool {
+	return c.trx != nil
+}
+
+// Close to roll back the open trx of the connection
+func (c *Conn) Close() error {
+	if c.trx != nil {
+		c.trx.Rollback()
+		c.trx = nil
+	}
+	return nil
+}
+
+// Begin to begin a trx as BEGIN does, the trx is rolled back when ctx is
+// done and a read-only trx rejects writes.
+func (c *Conn) Begin(ctx context.Context, readOnly bool) error {
+	if c.trx != nil {
+		return ErrTrxActive
+	}
+	c.trx = c.db.ctx.AllocteTrx()
+	if readOnly {
+		c.trx.BeginReadOnlyContext(ctx, c.db.ctx)
+	} else {
+		c.trx.BeginContext(ctx, c.db.ctx)
+	}
+	return nil
+}
+
+// Commit to commit the trx begun by Begin or BEGIN
+func (c *Conn) Commit() error {
+	trx := c.trx
+	if trx == nil {
+		return ErrNoTrx
+	}
+	c.trx = nil
+	return trx.Commit()
+}
+
+// Rollback to roll back the trx begun by Begin or BEGIN, it returns
+// ErrTrxNotActive when the trx was already aborted.
+func (c *Conn) Rollback() error {
+	trx := c.trx
+	if trx == nil {
+		return ErrNoTrx
+	}
+	c.trx = nil
+	if !trx.active() {
+		return ErrTrxNotActive
+	}
+	trx.Rollback()
+	return nil
+}
+
+// Exec to run the statements of src, returns the result of the last one
+func (c *Conn) Exec(src string) (Result, error) {
+	return c.ExecContext(context.Background(), src)
+}
+
+// ExecContext to run the statements of src until ctx is done
+func (c *Conn) ExecContext(ctx context.Context, src string) (Result, error) {
+	texts, err := sql.Split(src)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	for _, text := range texts {
+		rows, err := c.query(ctx, text)
+		if err != nil {
+			return Result{}, err
+		}
+		for rows.Next() {
+		}
+		if err = rows.Close(); err != nil {
+			return Result{}, err
+		}
+		res = rows.result
+	}
+	return res, nil
+}
+
+// Query to run the statements of src, the rows of the last one are
+// returned and must be closed.
+func (c *Conn) Query(src string) (*Rows, error) {
+	return c.QueryContext(context.Background(), src)
+}
+
+// QueryContext to run the statements of src, the rows of the last one
+// end with the error of ctx once it is done.
+func (c *Conn) QueryContext(ctx context.Context, src string) (*Rows, error) {
+	texts, err := sql.Split(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return nil, errors.New("no statement to run")
+	}
+
+	last := len(texts) - 1
+	if _, err = c.ExecContext(ctx, strings.Join(texts[:last], ";")); err != nil {
+		return nil, err
+	}
+	return c.query(ctx, texts[last])
+}
+
+// query runs the statement text by its cached stmt, DML and DDL are run
+// to the end while the rows of a SELECT are read by the caller.
+func (c *Conn) query(ctx context.Context, text string) (*Rows, error) {
+	s, err := c.Prepare(text)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.QueryContext(ctx)
+}
+
+// begin returns the trx a statement runs in and the func finishing it,
+// a statement in an explicit trx is undone alone when it fails.
+func (c *Conn) begin(ctx context.Context, readOnly bool) (*Trx, func(error) error) {
+	if trx := c.trx; trx != nil {
+		if err := trx.Savepoint("stmt"); err != nil {
+			return trx, func(error) error { return err }
+		}
+		return trx, func(err error) error {
+			if err != nil {
+				if trx.RollbackTo("stmt") == ErrTrxNotActive {
+					// the trx was aborted, by a deadlock or a timeout
+					c.trx = nil
+				}
+			}
+			trx.Release("stmt")
+			return err
+		}
+	}
+
+	trx := c.db.ctx.AllocteTrx()
+	if readOnly {
+		trx.BeginReadOnlyContext(ctx, c.db.ctx)
+	} else {
+		trx.BeginContext(ctx, c.db.ctx)
+	}
+	return trx, func(err error) error {
+		if err != nil {
+			trx.Rollback()
+			return err
+		}
+		return trx.Commit()
+	}
+}
+
+// Rows is the result of a query
+type Rows struct {
+	ctx     context.Context
+	columns []string
+	types   []string
+	op      operator
+	row     []interface{}
+	err     error
+	done    func(error) error
+	result  Result
+}
+
+func emptyRows() *Rows {
+	return &Rows{columns: []string{}, types: []string{}}
+}
+
+// Columns returns the names of the columns
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// ColumnTypes returns the declared types of the columns, empty for the
+// columns that are not columns of a table.
+func (r *Rows) ColumnTypes() []string {
+	return r.types
+}
+
+// Next to move to the next row, returns false after the last row or an error
+func (r *Rows) Next() bool {
+	if r.op == nil || r.err != nil {
+		return false
+	}
+	if r.err = r.ctx.Err(); r.err != nil {
+		return false
+	}
+
+	row, ok, err := r.op.next()
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.row = row
+	return ok
+}
+
+// Values returns the values of the current row
+func (r *Rows) Values() []interface{} {
+	return r.row
+}
+
+// Err returns the error that ended Next
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close to close the rows and finish the trx of the statement, an error
+// of the rows is returned again.
+func (r *Rows) Close() error {
+	if r.op == nil {
+		return r.err
+	}
+
+	r.op.close()
+	r.op = nil
+	if err := r.done(r.err); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
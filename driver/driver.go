@@ -0,0 +1,303 @@
+// Package driver registers the database/sql driver "gosqlite", the data
+// source name is the file the database is stored in, an empty name or
+// ":memory:" is a database in memory.
+//
+//	import _ "gosqlite/driver"
+//
+//	db, err := sql.Open("gosqlite", "file.db")
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gosqlite"
+)
+
+func init() {
+	sql.Register("gosqlite", &Driver{})
+}
+
+// Driver is the database/sql driver of gosqlite
+type Driver struct{}
+
+// Open to open the database name for a single conn, the conns of a
+// sql.DB share the database opened by OpenConnector instead.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector to open the database name, it is closed with the sql.DB
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	if name == "" || name == ":memory:" {
+		return &connector{driver: d, db: gosqlite.CreateDB()}, nil
+	}
+	db, err := gosqlite.OpenDB(name)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{driver: d, db: db}, nil
+}
+
+// connector opens the conns of a database
+type connector struct {
+	driver *Driver
+	db     *gosqlite.DB
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &conn{c: c.db.Conn()}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *connector) Close() error {
+	return c.db.Close()
+}
+
+// conn is a connection of the database, database/sql uses a conn from
+// one goroutine at a time.
+type conn struct {
+	c *gosqlite.Conn
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext to prepare the single statement query
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s: s}, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins a trx of snapshot isolation, which also serves the
+// weaker levels.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted,
+		sql.LevelRepeatableRead, sql.LevelSnapshot:
+	default:
+		return nil, fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+	if err := c.c.Begin(ctx, opts.ReadOnly); err != nil {
+		return nil, err
+	}
+	return &tx{c: c.c}, nil
+}
+
+// ExecContext runs the statements of query, a query with arguments is a
+// single statement.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) == 0 {
+		res, err := c.c.ExecContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return result{res}, nil
+	}
+
+	s, err := c.c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return (&stmt{s: s}).ExecContext(ctx, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) == 0 {
+		r, err := c.c.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return &rows{r: r}, nil
+	}
+
+	s, err := c.c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return (&stmt{s: s}).QueryContext(ctx, args)
+}
+
+// CheckNamedValue converts an argument to a value of a column, that is
+// nil, int64, float64, string or []byte. A bool is 0 or 1 and a time is
+// its text.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			nv.Value = int64(1)
+		} else {
+			nv.Value = int64(0)
+		}
+	case time.Time:
+		nv.Value = v.Format("2006-01-02 15:04:05.999999999-07:00")
+	default:
+		nv.Value = v
+	}
+	return nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// args returns the arguments of a stmt, a named value is a NamedArg
+func args(named []driver.NamedValue) []interface{} {
+	args := make([]interface{}, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+		if nv.Name != "" {
+			args[i] = gosqlite.Named(nv.Name, nv.Value)
+		}
+	}
+	return args
+}
+
+// stmt is a statement prepared on a conn
+type stmt struct {
+	s *gosqlite.Stmt
+}
+
+func (s *stmt) Close() error {
+	return s.s.Close()
+}
+
+func (s *stmt) NumInput() int {
+	return s.s.NumParams()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, named []driver.NamedValue) (driver.Result, error) {
+	res, err := s.s.ExecContext(ctx, args(named)...)
+	if err != nil {
+		return nil, err
+	}
+	return result{res}, nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, named []driver.NamedValue) (driver.Rows, error) {
+	r, err := s.s.QueryContext(ctx, args(named)...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r: r}, nil
+}
+
+// tx is the trx begun on a conn
+type tx struct {
+	c *gosqlite.Conn
+}
+
+func (t *tx) Commit() error {
+	return t.c.Commit()
+}
+
+func (t *tx) Rollback() error {
+	return t.c.Rollback()
+}
+
+// result is the result of a statement changing rows
+type result struct {
+	res gosqlite.Result
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return r.res.LastInsertID, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.res.RowsAffected, nil
+}
+
+// rows are the rows of a query
+type rows struct {
+	r *gosqlite.Rows
+}
+
+func (r *rows) Columns() []string {
+	return r.r.Columns()
+}
+
+func (r *rows) Close() error {
+	return r.r.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.r.Next() {
+		if err := r.r.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.r.Values() {
+		dest[i] = v
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the declared type of column i
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	return strings.ToUpper(r.r.ColumnTypes()[i])
+}
+
+// ColumnTypeScanType returns the type to scan column i into by the
+// affinity of its declared type, a column may hold NULL.
+func (r *rows) ColumnTypeScanType(i int) reflect.Type {
+	typ := strings.ToUpper(r.r.ColumnTypes()[i])
+	switch {
+	case strings.Contains(typ, "INT"):
+		return reflect.TypeOf(sql.NullInt64{})
+	case strings.Contains(typ, "CHAR") || strings.Contains(typ, "CLOB") || strings.Contains(typ, "TEXT"):
+		return reflect.TypeOf(sql.NullString{})
+	case strings.Contains(typ, "BLOB"):
+		return reflect.TypeOf([]byte(nil))
+	case strings.Contains(typ, "REAL") || strings.Contains(typ, "FLOA") || strings.Contains(typ, "DOUB"):
+		return reflect.TypeOf(sql.NullFloat64{})
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
@@ -0,0 +1,192 @@
+package driver_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "gosqlite/driver"
+)
+
+func open(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("gosqlite", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDriverQuery(t *testing.T) {
+	db := open(t, "")
+	res, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score REAL); INSERT INTO t (name, score) VALUES ('a', 1.5), ('b', NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("rows affected %d", n)
+	}
+	if id, _ := res.LastInsertId(); id != 2 {
+		t.Fatalf("last insert id %d", id)
+	}
+
+	rows, err := db.Query("SELECT id, name, score, score * 2 AS double FROM t ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, typ := range types {
+		names = append(names, typ.Name()+" "+typ.DatabaseTypeName())
+	}
+	if got := strings.Join(names, ","); got != "id INTEGER,name TEXT,score REAL,double " {
+		t.Fatalf("column types %q", got)
+	}
+	if types[0].ScanType() != reflect.TypeOf(sql.NullInt64{}) {
+		t.Fatalf("scan type %v", types[0].ScanType())
+	}
+
+	got := make([]string, 0)
+	for rows.Next() {
+		var id int64
+		var name string
+		var score, double sql.NullFloat64
+		if err = rows.Scan(&id, &name, &score, &double); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+		if id == 1 && (score.Float64 != 1.5 || double.Float64 != 3) || id == 2 && score.Valid {
+			t.Fatalf("row %d: %v %v", id, score, double)
+		}
+	}
+	if err = rows.Err(); err != nil || len(got) != 2 {
+		t.Fatalf("rows %v: %v", got, err)
+	}
+
+	if _, err = db.Exec("SELECT * FROM t", 1); err == nil {
+		t.Fatal("an argument without placeholders is accepted")
+	}
+	if _, err = db.Exec("SELECT * FROM t WHERE id = ?", struct{}{}); err == nil {
+		t.Fatal("an argument of an unsupported type is accepted")
+	}
+	if _, err = db.Prepare("SELECT FROM"); err == nil {
+		t.Fatal("a syntax error is not found by Prepare")
+	}
+}
+
+func TestDriverParams(t *testing.T) {
+	db := open(t, "")
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, ok INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare("INSERT INTO t (name, ok) VALUES (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err = stmt.Exec(name, name != "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = stmt.Exec("d"); err == nil {
+		t.Fatal("a missing argument is accepted")
+	}
+	stmt.Close()
+
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM t WHERE ok = :ok AND name >= $from", sql.Named("from", "b"), sql.Named("ok", true)).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("named: %d %v", n, err)
+	}
+	var name string
+	if err = db.QueryRow("SELECT name FROM t WHERE id = ?2 - ?1", 1, 3).Scan(&name); err != nil || name != "b" {
+		t.Fatalf("numbered: %q %v", name, err)
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+	db := open(t, ":memory:")
+	if _, err := db.Exec("CREATE TABLE t (v INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	count := func() (n int) {
+		if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("uncommitted insert is visible: %d", n)
+	}
+	if err = tx.Commit(); err != nil || count() != 1 {
+		t.Fatalf("commit: %v", err)
+	}
+
+	tx, _ = db.Begin()
+	tx.Exec("INSERT INTO t VALUES (2)")
+	if err = tx.Rollback(); err != nil || count() != 1 {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("INSERT INTO t VALUES (3)"); err == nil {
+		t.Fatal("a read-only tx writes")
+	}
+	tx.Rollback()
+
+	if _, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable}); err == nil {
+		t.Fatal("serializable isolation is accepted")
+	}
+
+	// a canceled context rolls the tx back
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("INSERT INTO t VALUES (4)")
+	cancel()
+	if err = tx.Commit(); err == nil {
+		t.Fatal("commit after cancel succeeds")
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("canceled insert is visible: %d", n)
+	}
+	if _, err = db.QueryContext(ctx, "SELECT * FROM t"); err == nil {
+		t.Fatal("query with a canceled context succeeds")
+	}
+}
+
+func TestDriverFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db := open(t, fileName)
+	if _, err := db.Exec("CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('kept')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var v string
+	if err := open(t, fileName).QueryRow("SELECT v FROM t").Scan(&v); err != nil || v != "kept" {
+		t.Fatalf("reopened: %q %v", v, err)
+	}
+}
@@ -0,0 +1,1081 @@
+package gosqlite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+// operator is an iterator of the Volcano model, open prepares it, next
+// returns the rows one by one until it returns false and close releases
+// it. Operators read and write through the trx of their plan.
+type operator interface {
+	open() error
+	next() ([]interface{}, bool, error)
+	close()
+}
+
+// execEnv is what the operators of a plan share, trx and params are set
+// for every run of the plan.
+type execEnv struct {
+	db     *DB
+	trx    *Trx
+	params []interface{}
+}
+
+// decodeTableRow returns the row of a table as [rowid, columns...]
+func decodeTableRow(info *tableInfo, rowid int64, data []byte) ([]interface{}, error) {
+	values, err := DecodeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, len(info.columns)+1)
+	row[0] = rowid
+	copy(row[1:], values)
+	if info.rowidColumn >= 0 {
+		row[info.rowidColumn+1] = rowid
+	}
+	return row, nil
+}
+
+// encodeTableRow returns the record stored for the columns of a table row
+// converted by the affinities of the columns, the INTEGER PRIMARY KEY
+// column is the rowid and stored as NULL.
+func encodeTableRow(info *tableInfo, columns []interface{}) ([]byte, error) {
+	values := make([]interface{}, len(columns))
+	for i, v := range columns {
+		values[i] = info.columns[i].aff.apply(v)
+	}
+	if info.rowidColumn >= 0 {
+		values[info.rowidColumn] = nil
+	}
+	return EncodeRecord(values)
+}
+
+// tableScan returns the rows of a table with rowids in [lo, hi] visible
+// to the trx in rowid order.
+type tableScan struct {
+	env    *execEnv
+	table  *tableInfo
+	lo, hi evalFunc
+	rows   *rowCursor
+}
+
+func (s *tableScan) open() error {
+	pos, end := int64(1), int64(maxTableRowID)
+	if s.lo != nil {
+		v, err := s.lo(nil)
+		if err != nil {
+			return err
+		}
+		pos = clampRowID(v, 1)
+	}
+	if s.hi != nil {
+		v, err := s.hi(nil)
+		if err != nil {
+			return err
+		}
+		end = clampRowID(v, maxTableRowID)
+	}
+	s.rows = nil
+	if pos <= end {
+		s.rows = s.env.trx.cursor(s.env.db.ctx, s.table.rowID(pos), s.table.rowID(end)+1)
+	}
+	return nil
+}
+
+// clampRowID returns v as a rowid in [0, maxTableRowID+1], NULL or text is dflt
+func clampRowID(v interface{}, dflt int64) int64 {
+	var f float64
+	switch n := v.(type) {
+	case int64:
+		f = float64(n)
+		if n >= 0 && n <= maxTableRowID {
+			return n
+		}
+	case float64:
+		f = n
+	default:
+		return dflt
+	}
+	if f < 0 {
+		return 0
+	} else if f > maxTableRowID {
+		return maxTableRowID + 1
+	}
+	return int64(f)
+}
+
+func (s *tableScan) next() ([]interface{}, bool, error) {
+	if s.rows == nil {
+		return nil, false, nil
+	}
+	row, ok, err := s.rows.next()
+	if err != nil || !ok {
+		s.rows = nil
+		return nil, false, err
+	}
+
+	rowid := row.RowID - s.table.id<<tableShift
+	values, err := decodeTableRow(s.table, rowid, row.Data)
+	return values, err == nil, err
+}
+
+func (s *tableScan) close() {
+	s.rows = nil
+}
+
+// indexSeek returns the rows of a table with the rowids the keys
+// evaluate to, keys that are not integers match no row.
+type indexSeek struct {
+	env   *execEnv
+	table *tableInfo
+	keys  []evalFunc
+	rowid []int64
+}
+
+func (s *indexSeek) open() error {
+	s.rowid = s.rowid[:0]
+	seen := make(map[int64]bool)
+	for _, key := range s.keys {
+		v, err := key(nil)
+		if err != nil {
+			return err
+		}
+		if n, ok := toRowID(v); ok && !seen[n] {
+			seen[n] = true
+			s.rowid = append(s.rowid, n)
+		}
+	}
+	sort.Slice(s.rowid, func(i, j int) bool { return s.rowid[i] < s.rowid[j] })
+	return nil
+}
+
+// toRowID returns v as a rowid of a table if it is one
+func toRowID(v interface{}) (int64, bool) {
+	switch n := affinityNumeric.apply(v).(type) {
+	case int64:
+		return n, n >= 1 && n <= maxTableRowID
+	case float64:
+		return int64(n), n == float64(int64(n)) && n >= 1 && n <= maxTableRowID
+	}
+	return 0, false
+}
+
+func (s *indexSeek) next() ([]interface{}, bool, error) {
+	for len(s.rowid) > 0 {
+		rowid := s.rowid[0]
+		s.rowid = s.rowid[1:]
+		row, ok, err := s.env.trx.Get(s.env.db.ctx, s.table.rowID(rowid))
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			values, err := decodeTableRow(s.table, rowid, row.Data)
+			return values, err == nil, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *indexSeek) close() {}
+
+// valueList returns one row for each list of expressions
+type valueList struct {
+	rows [][]evalFunc
+	pos  int
+}
+
+func (v *valueList) open() error {
+	v.pos = 0
+	return nil
+}
+
+func (v *valueList) next() ([]interface{}, bool, error) {
+	if v.pos >= len(v.rows) {
+		return nil, false, nil
+	}
+
+	exprs := v.rows[v.pos]
+	v.pos++
+	row := make([]interface{}, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if row[i], err = e(nil); err != nil {
+			return nil, false, err
+		}
+	}
+	return row, true, nil
+}
+
+func (v *valueList) close() {}
+
+// filter returns the rows of child for which cond is true
+type filter struct {
+	child operator
+	cond  evalFunc
+}
+
+func (f *filter) open() error {
+	return f.child.open()
+}
+
+func (f *filter) next() ([]interface{}, bool, error) {
+	for {
+		row, ok, err := f.child.next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		v, err := f.cond(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if truth(v) {
+			return row, true, nil
+		}
+	}
+}
+
+func (f *filter) close() {
+	f.child.close()
+}
+
+// project returns the exprs evaluated on the rows of child
+type project struct {
+	child operator
+	exprs []evalFunc
+}
+
+func (p *project) open() error {
+	return p.child.open()
+}
+
+func (p *project) next() ([]interface{}, bool, error) {
+	row, ok, err := p.child.next()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	out := make([]interface{}, len(p.exprs))
+	for i, e := range p.exprs {
+		if out[i], err = e(row); err != nil {
+			return nil, false, err
+		}
+	}
+	return out, true, nil
+}
+
+func (p *project) close() {
+	p.child.close()
+}
+
+// sortKey is a column of the rows sort orders by, text by coll
+type sortKey struct {
+	column int
+	desc   bool
+	coll   *collation
+}
+
+// sorter reads all rows of child and returns them ordered by keys, rows
+// with equal keys keep their order.
+type sorter struct {
+	child operator
+	keys  []sortKey
+	rows  [][]interface{}
+}
+
+func (s *sorter) open() error {
+	if err := s.child.open(); err != nil {
+		return err
+	}
+
+	rows, err := drain(s.child)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j], s.keys) < 0
+	})
+	s.rows = rows
+	return nil
+}
+
+// drain returns the remaining rows of op
+func drain(op operator) ([][]interface{}, error) {
+	rows := make([][]interface{}, 0)
+	for {
+		row, ok, err := op.next()
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (s *sorter) next() ([]interface{}, bool, error) {
+	if len(s.rows) == 0 {
+		return nil, false, nil
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, true, nil
+}
+
+func (s *sorter) close() {
+	s.rows = nil
+	s.child.close()
+}
+
+// limit skips offset rows of child and returns up to limit rows, a
+// negative limit has no bound.
+type limit struct {
+	child  operator
+	limit  evalFunc
+	offset evalFunc
+	left   int64
+}
+
+func (l *limit) open() error {
+	if err := l.child.open(); err != nil {
+		return err
+	}
+
+	n, err := l.limit(nil)
+	if err != nil {
+		return err
+	}
+	if l.left, err = mustBeInt(n); err != nil || l.offset == nil {
+		return err
+	}
+	if n, err = l.offset(nil); err != nil {
+		return err
+	}
+	skip, err := mustBeInt(n)
+	if err != nil {
+		return err
+	}
+	for ; skip > 0 && l.left != 0; skip-- {
+		if _, ok, err := l.child.next(); err != nil || !ok {
+			l.left = 0
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *limit) next() ([]interface{}, bool, error) {
+	if l.left == 0 {
+		return nil, false, nil
+	}
+	l.left--
+	return l.child.next()
+}
+
+func (l *limit) close() {
+	l.child.close()
+}
+
+// distinct returns the rows of child whose first width columns were not
+// returned before, text is compared by colls.
+type distinct struct {
+	child operator
+	width int
+	colls []*collation
+	seen  map[string]bool
+}
+
+func (d *distinct) open() error {
+	d.seen = make(map[string]bool)
+	return d.child.open()
+}
+
+func (d *distinct) next() ([]interface{}, bool, error) {
+	for {
+		row, ok, err := d.child.next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		key, err := groupKey(collate(row[:d.width], d.colls))
+		if err != nil {
+			return nil, false, err
+		}
+		if !d.seen[key] {
+			d.seen[key] = true
+			return row, true, nil
+		}
+	}
+}
+
+func (d *distinct) close() {
+	d.seen = nil
+	d.child.close()
+}
+
+// groupKey returns a key equal for values that compare equal, integral
+// floats are keyed as integers.
+func groupKey(values []interface{}) (string, error) {
+	key := make([]interface{}, len(values))
+	for i, v := range values {
+		if f, ok := v.(float64); ok && f == float64(int64(f)) {
+			v = int64(f)
+		}
+		key[i] = v
+	}
+	b, err := EncodeRecord(key)
+	return string(b), err
+}
+
+// aggregate is an aggregate function call of a query, coll is the
+// collation of its argument for min, max and DISTINCT.
+type aggregate struct {
+	name     string
+	star     bool
+	distinct bool
+	coll     *collation
+	arg      evalFunc
+}
+
+// aggState accumulates the values of an aggregate in a group
+type aggState struct {
+	agg   *aggregate
+	seen  map[string]bool
+	count int64
+	sum   interface{}
+	value interface{}
+}
+
+var aggregateNames = map[string]bool{"count": true, "sum": true, "total": true, "avg": true, "min": true, "max": true}
+
+// isAggregate returns whether e calls an aggregate, min and max with
+// several arguments are scalar.
+func isAggregate(e *sql.FuncCall) bool {
+	name := strings.ToLower(e.Name)
+	return aggregateNames[name] && (len(e.Args) == 1 || name == "count" && e.Star)
+}
+
+// hasAggregate returns whether e contains an aggregate call
+func hasAggregate(e sql.Expr) bool {
+	found := false
+	walkExpr(e, func(e sql.Expr) {
+		if f, ok := e.(*sql.FuncCall); ok && isAggregate(f) {
+			found = true
+		}
+	})
+	return found
+}
+
+// newAggregate returns the aggregate e calls on the rows of s, its
+// argument is not compiled
+func newAggregate(e *sql.FuncCall, s *scope) (*aggregate, error) {
+	if e.Star && strings.ToLower(e.Name) != "count" || len(e.Args) > 1 {
+		return nil, fmt.Errorf("wrong number of arguments to function %s()", e.Name)
+	}
+	agg := &aggregate{name: strings.ToLower(e.Name), star: e.Star, distinct: e.Distinct}
+	if !e.Star {
+		var err error
+		if agg.coll, _, err = exprCollation(e.Args[0], s); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+// aggregate compiles an aggregate call, its argument is evaluated on the
+// input rows and the result is read from the aggregated row.
+func (c *compiler) aggregate(e *sql.FuncCall) (evalFunc, error) {
+	if c.aggs == nil {
+		return nil, fmt.Errorf("misuse of aggregate: %s()", e.Name)
+	}
+	agg, err := newAggregate(e, c.scope)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Star {
+		inner := &compiler{scope: c.scope, env: c.env, b: c.b}
+		if agg.arg, err = inner.compile(e.Args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	*c.aggs = append(*c.aggs, agg)
+	i := len(c.scope.columns) + len(*c.aggs) - 1
+	return func(row []interface{}) (interface{}, error) { return row[i], nil }, nil
+}
+
+func newAggState(agg *aggregate) *aggState {
+	return &aggState{agg: agg, seen: make(map[string]bool)}
+}
+
+// add adds a row to the group
+func (s *aggState) add(row []interface{}) error {
+	if s.agg.star {
+		s.count++
+		return nil
+	}
+
+	v, err := s.agg.arg(row)
+	if err != nil {
+		return err
+	}
+	return s.step(v)
+}
+
+// step adds the argument value v of a row, NULL is skipped
+func (s *aggState) step(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if s.agg.distinct {
+		key, err := groupKey(collate([]interface{}{v}, []*collation{s.agg.coll}))
+		if err != nil || s.seen[key] {
+			return err
+		}
+		s.seen[key] = true
+	}
+
+	s.count++
+	switch s.agg.name {
+	case "sum", "total", "avg":
+		if s.sum == nil {
+			s.sum = int64(0)
+		}
+		var err error
+		if s.sum, err = arithmetic("+", s.sum, toNumeric(v)); err != nil {
+			return err
+		}
+	case "min":
+		if s.value == nil || s.agg.coll.compare(v, s.value) < 0 {
+			s.value = v
+		}
+	case "max":
+		if s.value == nil || s.agg.coll.compare(v, s.value) > 0 {
+			s.value = v
+		}
+	}
+	return nil
+}
+
+func (s *aggState) result() interface{} {
+	switch s.agg.name {
+	case "count":
+		return s.count
+	case "sum":
+		return s.sum
+	case "total":
+		return toFloat(s.sum)
+	case "avg":
+		if s.count == 0 {
+			return nil
+		}
+		return toFloat(s.sum) / float64(s.count)
+	}
+	return s.value
+}
+
+// hashAggregate groups the rows of child by groupBy, text compared by
+// colls, and returns for each group its last row followed by the results
+// of aggs. Without groupBy an empty input is one group whose row is all
+// NULL.
+type hashAggregate struct {
+	child   operator
+	groupBy []evalFunc
+	colls   []*collation
+	aggs    []*aggregate
+	width   int
+	rows    [][]interface{}
+}
+
+type aggGroup struct {
+	row    []interface{}
+	states []*aggState
+}
+
+func (h *hashAggregate) newGroup() *aggGroup {
+	g := &aggGroup{row: make([]interface{}, h.width)}
+	for _, agg := range h.aggs {
+		g.states = append(g.states, newAggState(agg))
+	}
+	return g
+}
+
+func (h *hashAggregate) open() error {
+	if err := h.child.open(); err != nil {
+		return err
+	}
+
+	groups := make(map[string]*aggGroup)
+	order := make([]*aggGroup, 0)
+	for {
+		row, ok, err := h.child.next()
+		if err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+
+		keys := make([]interface{}, len(h.groupBy))
+		for i, e := range h.groupBy {
+			if keys[i], err = e(row); err != nil {
+				return err
+			}
+		}
+		key, err := groupKey(collate(keys, h.colls))
+		if err != nil {
+			return err
+		}
+		g := groups[key]
+		if g == nil {
+			g = h.newGroup()
+			groups[key] = g
+			order = append(order, g)
+		}
+		g.row = row
+		for _, s := range g.states {
+			if err = s.add(row); err != nil {
+				return err
+			}
+		}
+	}
+	if len(order) == 0 && len(h.groupBy) == 0 {
+		order = append(order, h.newGroup())
+	}
+
+	h.rows = make([][]interface{}, len(order))
+	for i, g := range order {
+		row := append(make([]interface{}, 0, h.width+len(g.states)), g.row...)
+		for _, s := range g.states {
+			row = append(row, s.result())
+		}
+		h.rows[i] = row
+	}
+	return nil
+}
+
+func (h *hashAggregate) next() ([]interface{}, bool, error) {
+	if len(h.rows) == 0 {
+		return nil, false, nil
+	}
+	row := h.rows[0]
+	h.rows = h.rows[1:]
+	return row, true, nil
+}
+
+func (h *hashAggregate) close() {
+	h.rows = nil
+	h.child.close()
+}
+
+// nestedLoopJoin returns the rows of left joined with the rows of right
+// that cond is true for, the right rows are read once and kept. An outer
+// join returns a left row without match padded with NULL.
+type nestedLoopJoin struct {
+	left, right operator
+	cond        evalFunc
+	outer       bool
+	rightWidth  int
+
+	rightRows [][]interface{}
+	row       []interface{}
+	pos       int
+	matched   bool
+}
+
+func (j *nestedLoopJoin) open() error {
+	if err := j.right.open(); err != nil {
+		return err
+	}
+	rows, err := drain(j.right)
+	if err != nil {
+		return err
+	}
+	j.rightRows = rows
+	j.row = nil
+	return j.left.open()
+}
+
+func (j *nestedLoopJoin) next() ([]interface{}, bool, error) {
+	for {
+		if j.row == nil {
+			row, ok, err := j.left.next()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			j.row, j.pos, j.matched = row, 0, false
+		}
+
+		for j.pos < len(j.rightRows) {
+			row := append(append(make([]interface{}, 0, len(j.row)+j.rightWidth), j.row...), j.rightRows[j.pos]...)
+			j.pos++
+			ok, err := joinMatch(j.cond, row)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				j.matched = true
+				return row, true, nil
+			}
+		}
+
+		left := j.row
+		j.row = nil
+		if j.outer && !j.matched {
+			return append(left, make([]interface{}, j.rightWidth)...), true, nil
+		}
+	}
+}
+
+func joinMatch(cond evalFunc, row []interface{}) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	v, err := cond(row)
+	return truth(v), err
+}
+
+func (j *nestedLoopJoin) close() {
+	j.rightRows = nil
+	j.left.close()
+	j.right.close()
+}
+
+// hashJoin joins the rows of left with the rows of right whose rightKeys
+// equal the leftKeys of the left row, a hash table of the right rows is
+// built at open. cond is checked on the joined rows.
+type hashJoin struct {
+	left, right operator
+	leftKeys    []evalFunc
+	rightKeys   []evalFunc
+	cond        evalFunc
+	outer       bool
+	rightWidth  int
+
+	table   map[string][][]interface{}
+	row     []interface{}
+	bucket  [][]interface{}
+	matched bool
+}
+
+// joinKey returns the key of row, ok is false when a key is NULL
+func joinKey(keys []evalFunc, row []interface{}) (string, bool, error) {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		v, err := k(row)
+		if err != nil || v == nil {
+			return "", false, err
+		}
+		values[i] = v
+	}
+	key, err := groupKey(values)
+	return key, err == nil, err
+}
+
+func (j *hashJoin) open() error {
+	if err := j.right.open(); err != nil {
+		return err
+	}
+
+	j.table = make(map[string][][]interface{})
+	for {
+		row, ok, err := j.right.next()
+		if err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+		key, ok, err := joinKey(j.rightKeys, row)
+		if err != nil {
+			return err
+		}
+		if ok {
+			j.table[key] = append(j.table[key], row)
+		}
+	}
+	j.row = nil
+	return j.left.open()
+}
+
+func (j *hashJoin) next() ([]interface{}, bool, error) {
+	for {
+		if j.row == nil {
+			row, ok, err := j.left.next()
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			key, ok, err := joinKey(j.leftKeys, row)
+			if err != nil {
+				return nil, false, err
+			}
+			j.row, j.bucket, j.matched = row, nil, false
+			if ok {
+				j.bucket = j.table[key]
+			}
+		}
+
+		for len(j.bucket) > 0 {
+			row := append(append(make([]interface{}, 0, len(j.row)+j.rightWidth), j.row...), j.bucket[0]...)
+			j.bucket = j.bucket[1:]
+			ok, err := joinMatch(j.cond, row)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				j.matched = true
+				return row, true, nil
+			}
+		}
+
+		left := j.row
+		j.row = nil
+		if j.outer && !j.matched {
+			return append(left, make([]interface{}, j.rightWidth)...), true, nil
+		}
+	}
+}
+
+func (j *hashJoin) close() {
+	j.table = nil
+	j.left.close()
+	j.right.close()
+}
+
+// constraintError reports a violated constraint of a table column
+func constraintError(kind string, table *tableInfo, column string) error {
+	return fmt.Errorf("%s constraint failed: %s.%s", kind, table.name, column)
+}
+
+// checkRow checks the NOT NULL columns of a table row
+func checkRow(table *tableInfo, columns []interface{}) error {
+	for i, c := range table.columns {
+		if c.notNull && columns[i] == nil && i != table.rowidColumn {
+			return constraintError("NOT NULL", table, c.name)
+		}
+	}
+	return nil
+}
+
+// insertRow stores a row of table with rowid
+func (env *execEnv) insertRow(table *tableInfo, rowid int64, columns []interface{}) error {
+	data, err := encodeTableRow(table, columns)
+	if err != nil {
+		return err
+	}
+	return env.insertRecord(table, rowid, data)
+}
+
+// insertRecord stores the record of a row of table with rowid, the error
+// of an existing rowid names the INTEGER PRIMARY KEY column.
+func (env *execEnv) insertRecord(table *tableInfo, rowid int64, data []byte) error {
+	if rowid < 1 || rowid > maxTableRowID {
+		return fmt.Errorf("rowid out of range: %d", rowid)
+	}
+	err := env.writeRow(table, rowid, data, ChangeInsert)
+	if err == ErrDuplicateRowID {
+		column := "rowid"
+		if table.rowidColumn >= 0 {
+			column = table.columns[table.rowidColumn].name
+		}
+		return constraintError("UNIQUE", table, column)
+	}
+	return err
+}
+
+// writeRow to write data as the row rowid of table by op, nil for a
+// delete, the indexes of the table are changed with the row.
+func (env *execEnv) writeRow(table *tableInfo, rowid int64, data []byte, op ChangeOp) error {
+	ctx, trx, rowID := env.db.ctx, env.trx, table.rowID(rowid)
+	err := env.db.indexes.write(trx, table, rowid, data, func() error {
+		switch op {
+		case ChangeInsert:
+			return trx.insertVersionAt(ctx, rowID, data)
+		case ChangeUpdate:
+			return trx.updateVersion(ctx, rowID, data)
+		}
+		return trx.delete(ctx, rowID)
+	})
+	if err == nil {
+		ctx.rowHooks(trx.trxID, op, rowID)
+	}
+	return err
+}
+
+// newRowID returns the rowid of a row inserted without one, the largest
+// rowid of any version of the table plus one.
+func (env *execEnv) newRowID(table *tableInfo) (int64, error) {
+	from, to := table.rowRange()
+	rowid := env.db.ctx.maxRowID(from, to) - table.id<<tableShift + 1
+	if rowid > maxTableRowID {
+		return 0, errors.New("database or disk is full")
+	}
+	return rowid, nil
+}
+
+// insertOp adds the rows of child to table, a row is the values of the
+// columns, a NULL INTEGER PRIMARY KEY is given a new rowid. It returns
+// the count of rows inserted.
+type insertOp struct {
+	env    *execEnv
+	child  operator
+	table  *tableInfo
+	result *Result
+	done   bool
+}
+
+func (ins *insertOp) open() error {
+	ins.done = false
+	return ins.child.open()
+}
+
+func (ins *insertOp) next() ([]interface{}, bool, error) {
+	if ins.done {
+		return nil, false, nil
+	}
+	ins.done = true
+
+	for {
+		row, ok, err := ins.child.next()
+		if err != nil {
+			return nil, false, err
+		} else if !ok {
+			break
+		}
+
+		var rowid int64
+		if k := ins.table.rowidColumn; k >= 0 && row[k] != nil {
+			if rowid, err = mustBeInt(row[k]); err != nil {
+				return nil, false, err
+			}
+		} else if rowid, err = ins.env.newRowID(ins.table); err != nil {
+			return nil, false, err
+		}
+		if k := ins.table.rowidColumn; k >= 0 {
+			row[k] = rowid
+		}
+
+		if err = checkRow(ins.table, row); err != nil {
+			return nil, false, err
+		}
+		if err = ins.env.insertRow(ins.table, rowid, row); err != nil {
+			return nil, false, err
+		}
+		ins.result.RowsAffected++
+		ins.result.LastInsertID = rowid
+	}
+	return []interface{}{ins.result.RowsAffected}, true, nil
+}
+
+func (ins *insertOp) close() {
+	ins.child.close()
+}
+
+// updateOp sets the columns of the table rows of child, the rows are read
+// before the first is changed. A row of child is [rowid, columns...]
+// followed by the new values of columns.
+type updateOp struct {
+	env     *execEnv
+	child   operator
+	table   *tableInfo
+	columns []int
+	result  *Result
+	done    bool
+}
+
+func (u *updateOp) open() error {
+	u.done = false
+	return u.child.open()
+}
+
+func (u *updateOp) next() ([]interface{}, bool, error) {
+	if u.done {
+		return nil, false, nil
+	}
+	u.done = true
+
+	rows, err := drain(u.child)
+	if err != nil {
+		return nil, false, err
+	}
+
+	width := len(u.table.columns) + 1
+	for _, row := range rows {
+		rowid := row[0].(int64)
+		columns := append([]interface{}(nil), row[1:width]...)
+		for i, c := range u.columns {
+			columns[c] = row[width+i]
+		}
+
+		newRowID := rowid
+		if k := u.table.rowidColumn; k >= 0 {
+			if newRowID, err = mustBeInt(columns[k]); err != nil {
+				return nil, false, err
+			}
+			columns[k] = newRowID
+		}
+		if err = checkRow(u.table, columns); err != nil {
+			return nil, false, err
+		}
+
+		if newRowID != rowid {
+			if err = u.env.writeRow(u.table, rowid, nil, ChangeDelete); err == nil {
+				err = u.env.insertRow(u.table, newRowID, columns)
+			}
+		} else {
+			var data []byte
+			if data, err = encodeTableRow(u.table, columns); err == nil {
+				err = u.env.writeRow(u.table, rowid, data, ChangeUpdate)
+			}
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		u.result.RowsAffected++
+	}
+	return []interface{}{u.result.RowsAffected}, true, nil
+}
+
+func (u *updateOp) close() {
+	u.child.close()
+}
+
+// deleteOp removes the table rows of child, the rows are read before the
+// first is removed.
+type deleteOp struct {
+	env    *execEnv
+	child  operator
+	table  *tableInfo
+	result *Result
+	done   bool
+}
+
+func (d *deleteOp) open() error {
+	d.done = false
+	return d.child.open()
+}
+
+func (d *deleteOp) next() ([]interface{}, bool, error) {
+	if d.done {
+		return nil, false, nil
+	}
+	d.done = true
+
+	rows, err := drain(d.child)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, row := range rows {
+		if err = d.env.writeRow(d.table, row[0].(int64), nil, ChangeDelete); err != nil {
+			return nil, false, err
+		}
+		d.result.RowsAffected++
+	}
+	return []interface{}{d.result.RowsAffected}, true, nil
+}
+
+func (d *deleteOp) close() {
+	d.child.close()
+}
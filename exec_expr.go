@@ -0,0 +1,934 @@
+package gosqlite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+// evalFunc evaluates a compiled expression on a row, values are nil,
+// int64, float64, string and []byte.
+type evalFunc func(row []interface{}) (interface{}, error)
+
+// scopeColumn is a column a row of an operator carries, the rowid of a
+// table is hidden from *. aff and coll are the affinity and collation of
+// the column.
+type scopeColumn struct {
+	table  string
+	name   string
+	typ    string
+	aff    affinity
+	coll   *collation
+	hidden bool
+}
+
+// scope names the columns of the rows an expression is evaluated on
+type scope struct {
+	columns []scopeColumn
+}
+
+func (s *scope) concat(other *scope) *scope {
+	columns := append(append([]scopeColumn(nil), s.columns...), other.columns...)
+	return &scope{columns}
+}
+
+func isRowIDName(name string) bool {
+	switch strings.ToLower(name) {
+	case "rowid", "oid", "_rowid_":
+		return true
+	}
+	return false
+}
+
+// resolve returns the index of column ref, a hidden rowid is found by
+// its names unless a column has the name.
+func (s *scope) resolve(ref *sql.ColumnRef) (int, error) {
+	found := -1
+	for _, hidden := range []bool{false, true} {
+		for i, c := range s.columns {
+			if c.hidden != hidden || ref.Table != "" && !strings.EqualFold(ref.Table, c.table) {
+				continue
+			}
+			if hidden && !isRowIDName(ref.Name) || !hidden && !strings.EqualFold(ref.Name, c.name) {
+				continue
+			}
+			if found >= 0 {
+				return 0, fmt.Errorf("ambiguous column name: %s", sql.FormatExpr(ref))
+			}
+			found = i
+		}
+		if found >= 0 {
+			return found, nil
+		}
+	}
+	return 0, fmt.Errorf("no such column: %s", sql.FormatExpr(ref))
+}
+
+// has returns whether every column e refers to is in the scope
+func (s *scope) has(e sql.Expr) bool {
+	ok := true
+	walkExpr(e, func(e sql.Expr) {
+		if ref, isRef := e.(*sql.ColumnRef); isRef {
+			if _, err := s.resolve(ref); err != nil {
+				ok = false
+			}
+		}
+	})
+	return ok
+}
+
+// walkExpr calls fn on e and its subexpressions
+func walkExpr(e sql.Expr, fn func(sql.Expr)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	switch e := e.(type) {
+	case *sql.UnaryExpr:
+		walkExpr(e.X, fn)
+	case *sql.BinaryExpr:
+		walkExpr(e.X, fn)
+		walkExpr(e.Y, fn)
+	case *sql.IsNullExpr:
+		walkExpr(e.X, fn)
+	case *sql.InExpr:
+		walkExpr(e.X, fn)
+		for _, x := range e.List {
+			walkExpr(x, fn)
+		}
+	case *sql.BetweenExpr:
+		walkExpr(e.X, fn)
+		walkExpr(e.Low, fn)
+		walkExpr(e.High, fn)
+	case *sql.CollateExpr:
+		walkExpr(e.X, fn)
+	case *sql.CastExpr:
+		walkExpr(e.X, fn)
+	case *sql.FuncCall:
+		for _, x := range e.Args {
+			walkExpr(x, fn)
+		}
+	}
+}
+
+// compiler turns expressions into evalFuncs over the rows of scope, the
+// aggregate calls are collected into aggs when aggs is not nil. The
+// parameters are read from env when the evalFuncs run, b plans the
+// subqueries.
+type compiler struct {
+	scope *scope
+	env   *execEnv
+	aggs  *[]*aggregate
+	b     *planner
+}
+
+func (c *compiler) compile(e sql.Expr) (evalFunc, error) {
+	switch e := e.(type) {
+	case *sql.Literal:
+		v, err := literalValue(e)
+		return func([]interface{}) (interface{}, error) { return v, nil }, err
+	case *sql.Param:
+		env, i := c.env, e.Index-1
+		return func([]interface{}) (interface{}, error) { return env.params[i], nil }, nil
+	case *sql.ColumnRef:
+		i, err := c.scope.resolve(e)
+		return func(row []interface{}) (interface{}, error) { return row[i], nil }, err
+	case *sql.UnaryExpr:
+		return c.unary(e)
+	case *sql.BinaryExpr:
+		return c.binary(e)
+	case *sql.IsNullExpr:
+		x, err := c.compile(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) (interface{}, error) {
+			v, err := x(row)
+			return boolValue((v == nil) != e.Not), err
+		}, nil
+	case *sql.InExpr:
+		return c.in(e)
+	case *sql.BetweenExpr:
+		return c.compile(betweenExpr(e))
+	case *sql.CollateExpr:
+		if _, err := lookupCollation(e.Collation); err != nil {
+			return nil, err
+		}
+		return c.compile(e.X)
+	case *sql.CastExpr:
+		x, err := c.compile(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) (interface{}, error) {
+			v, err := x(row)
+			return castValue(v, e.Type), err
+		}, nil
+	case *sql.SubqueryExpr:
+		return c.subquery(e.Select, false)
+	case *sql.ExistsExpr:
+		return c.subquery(e.Select, true)
+	case *sql.FuncCall:
+		if isAggregate(e) {
+			return c.aggregate(e)
+		}
+		return c.call(e)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+// subquery compiles a subquery, run to its first row each time it is
+// evaluated. Its value is whether it returns a row when exists is set,
+// else the single column of the row or NULL. The subquery reads its own
+// tables only, a column of the outer query is not found.
+func (c *compiler) subquery(stmt *sql.SelectStmt, exists bool) (evalFunc, error) {
+	p, err := c.b.selectPlan(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && len(p.columns) != 1 {
+		return nil, fmt.Errorf("sub-select returns %d columns - expected 1", len(p.columns))
+	}
+
+	return func([]interface{}) (interface{}, error) {
+		if err := p.op.open(); err != nil {
+			return nil, err
+		}
+		defer p.op.close()
+		row, ok, err := p.op.next()
+		switch {
+		case err != nil:
+			return nil, err
+		case exists:
+			return boolValue(ok), nil
+		case !ok:
+			return nil, nil
+		}
+		return row[0], nil
+	}, nil
+}
+
+// betweenExpr returns X BETWEEN Low AND High as X >= Low AND X <= High
+func betweenExpr(e *sql.BetweenExpr) sql.Expr {
+	low := &sql.BinaryExpr{Span: e.Span, Op: ">=", X: e.X, Y: e.Low}
+	high := &sql.BinaryExpr{Span: e.Span, Op: "<=", X: e.X, Y: e.High}
+	var and sql.Expr = &sql.BinaryExpr{Span: e.Span, Op: "AND", X: low, Y: high}
+	if e.Not {
+		and = &sql.UnaryExpr{Span: e.Span, Op: "NOT", X: and}
+	}
+	return and
+}
+
+func literalValue(e *sql.Literal) (interface{}, error) {
+	switch e.Kind {
+	case sql.Integer:
+		if strings.HasPrefix(e.Value, "0x") || strings.HasPrefix(e.Value, "0X") {
+			v, err := strconv.ParseUint(e.Value[2:], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("hex literal too big: %s", e.Value)
+			}
+			return int64(v), nil
+		}
+		if v, err := strconv.ParseInt(e.Value, 10, 64); err == nil {
+			return v, nil
+		}
+		v, err := strconv.ParseFloat(e.Value, 64)
+		return v, err
+	case sql.Float:
+		return strconv.ParseFloat(e.Value, 64)
+	case sql.String:
+		return e.Value, nil
+	case sql.Blob:
+		b := make([]byte, len(e.Value)/2)
+		for i := range b {
+			v, _ := strconv.ParseUint(e.Value[2*i:2*i+2], 16, 8)
+			b[i] = byte(v)
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+func boolValue(b bool) interface{} {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// truth returns whether v is true, NULL is not
+func truth(v interface{}) bool {
+	switch n := toNumeric(v).(type) {
+	case int64:
+		return n != 0
+	case float64:
+		return n != 0
+	}
+	return false
+}
+
+// toNumeric converts text and blobs to the number they start with like
+// SQLite does in arithmetic, NULL stays NULL.
+func toNumeric(v interface{}) interface{} {
+	var s string
+	switch v := v.(type) {
+	case nil, int64, float64:
+		return v
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	}
+
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || s[end] == '-' || s[end] == '+' ||
+		s[end] == 'e' || s[end] == 'E') {
+		end++
+	}
+	for ; end > 0; end-- {
+		if i, err := strconv.ParseInt(s[:end], 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+	}
+	return int64(0)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func toInt(v interface{}) int64 {
+	switch n := toNumeric(v).(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// toText converts v to text, NULL stays NULL
+func toText(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+func formatFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatFloat(f, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', 15, 64)
+}
+
+// typeRank orders the values of different types: NULL, numbers, text, blob
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// compareValues returns -1, 0 or 1 comparing a and b in SQLite order
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case int64:
+		if b, ok := b.(int64); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+
+	fa, fb := toFloat(a), toFloat(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	// an integer is apart from the real it rounds to by the rounding
+	d := int64(0)
+	if n, ok := a.(int64); ok {
+		d = intRounding(n, fa)
+	} else if n, ok := b.(int64); ok {
+		d = -intRounding(n, fb)
+	}
+	switch {
+	case d < 0:
+		return -1
+	case d > 0:
+		return 1
+	}
+	return 0
+}
+
+// intRounding returns n less its float64 f, which is within 2^10 of n
+func intRounding(n int64, f float64) int64 {
+	if f >= 1<<63 {
+		// f is out of the range of int64
+		return n + math.MinInt64
+	}
+	return n - int64(f)
+}
+
+func (c *compiler) unary(e *sql.UnaryExpr) (evalFunc, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []interface{}) (interface{}, error) {
+		v, err := x(row)
+		if err != nil {
+			return nil, err
+		}
+		return unaryValue(e.Op, v), nil
+	}, nil
+}
+
+// unaryValue returns op v, op is NOT, ~, - or +
+func unaryValue(op string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch op {
+	case "NOT":
+		return boolValue(!truth(v))
+	case "~":
+		return ^toInt(v)
+	case "-":
+		switch n := toNumeric(v).(type) {
+		case int64:
+			if n == math.MinInt64 {
+				return -float64(n)
+			}
+			return -n
+		case float64:
+			return -n
+		}
+	}
+	// + only stops the use of an index and of affinity
+	return v
+}
+
+func (c *compiler) binary(e *sql.BinaryExpr) (evalFunc, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := c.compile(e.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "AND", "OR":
+		and := e.Op == "AND"
+		return func(row []interface{}) (interface{}, error) {
+			a, err := x(row)
+			if err != nil {
+				return nil, err
+			}
+			if a != nil && truth(a) != and {
+				return boolValue(!and), nil
+			}
+			b, err := y(row)
+			if err != nil {
+				return nil, err
+			}
+			return logicValue(and, a, b), nil
+		}, nil
+	}
+
+	op := binaryOp(e.Op)
+	if compareTests[e.Op] != nil {
+		aff, coll, err := comparison(e.X, e.Y, c.scope)
+		if err != nil {
+			return nil, err
+		}
+		op = func(a, b interface{}) (interface{}, error) {
+			return compareValue(e.Op, a, b, aff, coll), nil
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("unsupported operator %s", e.Op)
+	}
+	return func(row []interface{}) (interface{}, error) {
+		a, err := x(row)
+		if err != nil {
+			return nil, err
+		}
+		b, err := y(row)
+		if err != nil {
+			return nil, err
+		}
+		return op(a, b)
+	}, nil
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+// compareTests are the comparison operators, a test of the result of
+// comparing the sides
+var compareTests = map[string]func(c int) bool{
+	"=":      func(c int) bool { return c == 0 },
+	"!=":     func(c int) bool { return c != 0 },
+	"<":      func(c int) bool { return c < 0 },
+	"<=":     func(c int) bool { return c <= 0 },
+	">":      func(c int) bool { return c > 0 },
+	">=":     func(c int) bool { return c >= 0 },
+	"IS":     func(c int) bool { return c == 0 },
+	"IS NOT": func(c int) bool { return c != 0 },
+}
+
+// comparison returns the affinity and the collation x and y compare by
+func comparison(x, y sql.Expr, s *scope) (affinity, *collation, error) {
+	coll, err := comparisonCollation(x, y, s)
+	return compareAffinity(exprAffinity(x, s), exprAffinity(y, s)), coll, err
+}
+
+// compareValue returns a op b for a comparison op, aff is applied to a
+// and b and text compares by coll. It is NULL when a side is NULL but
+// for IS and IS NOT.
+func compareValue(op string, a, b interface{}, aff affinity, coll *collation) interface{} {
+	if (a == nil || b == nil) && op != "IS" && op != "IS NOT" {
+		return nil
+	}
+	return boolValue(compareTests[op](coll.compare(aff.apply(a), aff.apply(b))))
+}
+
+// logicValue returns a AND b or a OR b, NULL is unknown: false AND NULL
+// is false and true OR NULL is true.
+func logicValue(and bool, a, b interface{}) interface{} {
+	if a != nil && truth(a) != and || b != nil && truth(b) != and {
+		return boolValue(!and)
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return boolValue(and)
+}
+
+func binaryOp(op string) func(a, b interface{}) (interface{}, error) {
+	if compareTests[op] != nil {
+		return func(a, b interface{}) (interface{}, error) {
+			return compareValue(op, a, b, affinityNone, nil), nil
+		}
+	}
+
+	switch op {
+	case "LIKE", "NOT LIKE":
+		like := op == "LIKE"
+		return func(a, b interface{}) (interface{}, error) {
+			if a == nil || b == nil {
+				return nil, nil
+			}
+			return boolValue(matchLike(toText(b).(string), toText(a).(string)) == like), nil
+		}
+	case "||":
+		return func(a, b interface{}) (interface{}, error) {
+			if a == nil || b == nil {
+				return nil, nil
+			}
+			return toText(a).(string) + toText(b).(string), nil
+		}
+	case "+", "-", "*", "/", "%":
+		return func(a, b interface{}) (interface{}, error) {
+			return arithmetic(op, a, b)
+		}
+	case "&", "|", "<<", ">>":
+		return func(a, b interface{}) (interface{}, error) {
+			if a == nil || b == nil {
+				return nil, nil
+			}
+			x, y := toInt(a), toInt(b)
+			switch op {
+			case "&":
+				return x & y, nil
+			case "|":
+				return x | y, nil
+			case "<<":
+				return shift(x, y), nil
+			}
+			return shift(x, -y), nil
+		}
+	}
+	return nil
+}
+
+func shift(x, n int64) int64 {
+	switch {
+	case n >= 64:
+		return 0
+	case n <= -64:
+		if x < 0 {
+			return -1
+		}
+		return 0
+	case n >= 0:
+		return x << uint(n)
+	}
+	return x >> uint(-n)
+}
+
+// arithmetic on integers falls back to float when it overflows, division
+// by zero gives NULL.
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+
+	x, y := toNumeric(a), toNumeric(b)
+	if i, ok := x.(int64); ok {
+		if j, ok := y.(int64); ok {
+			switch op {
+			case "+":
+				if r := i + j; (r > i) == (j > 0) {
+					return r, nil
+				}
+			case "-":
+				if r := i - j; (r < i) == (j > 0) {
+					return r, nil
+				}
+			case "*":
+				if i == 0 || j == 0 {
+					return int64(0), nil
+				}
+				if r := i * j; r/j == i && !(i == -1 && j == math.MinInt64) && !(j == -1 && i == math.MinInt64) {
+					return r, nil
+				}
+			case "/":
+				if j == 0 {
+					return nil, nil
+				}
+				if !(i == math.MinInt64 && j == -1) {
+					return i / j, nil
+				}
+			case "%":
+				if j == 0 {
+					return nil, nil
+				}
+				if j == -1 {
+					return int64(0), nil
+				}
+				return i % j, nil
+			}
+		}
+	}
+
+	f, g := toFloat(x), toFloat(y)
+	switch op {
+	case "+":
+		return f + g, nil
+	case "-":
+		return f - g, nil
+	case "*":
+		return f * g, nil
+	case "/":
+		if g == 0 {
+			return nil, nil
+		}
+		return f / g, nil
+	}
+	if int64(g) == 0 {
+		return nil, nil
+	}
+	return float64(int64(f) % int64(g)), nil
+}
+
+// matchLike matches s against a LIKE pattern, ASCII letters match
+// regardless of case.
+func matchLike(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(s); i++ {
+			if matchLike(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return s != "" && matchLike(pattern[1:], s[1:])
+	}
+	return s != "" && lowerASCII(s[0]) == lowerASCII(pattern[0]) && matchLike(pattern[1:], s[1:])
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func (c *compiler) in(e *sql.InExpr) (evalFunc, error) {
+	x, err := c.compile(e.X)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]evalFunc, len(e.List))
+	for i, item := range e.List {
+		if list[i], err = c.compile(item); err != nil {
+			return nil, err
+		}
+	}
+	aff, coll, err := inComparison(e, c.scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []interface{}) (interface{}, error) {
+		v, err := x(row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(list))
+		for i, item := range list {
+			if values[i], err = item(row); err != nil {
+				return nil, err
+			}
+		}
+		return inValue(v, values, e.Not, aff, coll), nil
+	}, nil
+}
+
+// inComparison returns the affinity and the collation of X of e, which
+// its values compare by
+func inComparison(e *sql.InExpr, s *scope) (affinity, *collation, error) {
+	coll, _, err := exprCollation(e.X, s)
+	return exprAffinity(e.X, s), coll, err
+}
+
+// inValue returns v [NOT] IN values compared by aff and coll, NULL when
+// v is not found and a value is NULL
+func inValue(v interface{}, values []interface{}, not bool, aff affinity, coll *collation) interface{} {
+	if v == nil {
+		return nil
+	}
+	v = aff.apply(v)
+	null := false
+	for _, w := range values {
+		if w == nil {
+			null = true
+		} else if coll.compare(v, aff.apply(w)) == 0 {
+			return boolValue(!not)
+		}
+	}
+	if null {
+		return nil
+	}
+	return boolValue(not)
+}
+
+// lookupFunc returns the scalar function e calls
+func lookupFunc(e *sql.FuncCall) (scalarFunc, error) {
+	fn, ok := scalarFuncs[strings.ToLower(e.Name)]
+	if !ok {
+		return fn, fmt.Errorf("no such function: %s", e.Name)
+	}
+	if e.Star || e.Distinct || len(e.Args) < fn.minArgs || fn.maxArgs >= 0 && len(e.Args) > fn.maxArgs {
+		return fn, fmt.Errorf("wrong number of arguments to function %s()", e.Name)
+	}
+	return fn, nil
+}
+
+// call compiles a scalar function
+func (c *compiler) call(e *sql.FuncCall) (evalFunc, error) {
+	fn, err := lookupFunc(e)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]evalFunc, len(e.Args))
+	for i, arg := range e.Args {
+		if args[i], err = c.compile(arg); err != nil {
+			return nil, err
+		}
+	}
+	return func(row []interface{}) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(row)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return fn.call(values)
+	}, nil
+}
+
+type scalarFunc struct {
+	minArgs int
+	maxArgs int
+	call    func(args []interface{}) (interface{}, error)
+}
+
+var scalarFuncs = map[string]scalarFunc{
+	"abs": {1, 1, func(args []interface{}) (interface{}, error) {
+		switch n := toNumeric(args[0]).(type) {
+		case int64:
+			if n == math.MinInt64 {
+				return nil, errors.New("integer overflow")
+			}
+			if n < 0 {
+				return -n, nil
+			}
+			return n, nil
+		case float64:
+			return math.Abs(n), nil
+		}
+		return nil, nil
+	}},
+	"coalesce": {2, -1, firstNotNull},
+	"ifnull":   {2, 2, firstNotNull},
+	"nullif": {2, 2, func(args []interface{}) (interface{}, error) {
+		if compareValues(args[0], args[1]) == 0 {
+			return nil, nil
+		}
+		return args[0], nil
+	}},
+	"length": {1, 1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case []byte:
+			return int64(len(v)), nil
+		}
+		return int64(len([]rune(toText(args[0]).(string)))), nil
+	}},
+	"lower": {1, 1, func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ToLower(toText(args[0]).(string)), nil
+	}},
+	"upper": {1, 1, func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ToUpper(toText(args[0]).(string)), nil
+	}},
+	"typeof": {1, 1, func(args []interface{}) (interface{}, error) {
+		return typeName(args[0]), nil
+	}},
+	"substr": {2, 3, func(args []interface{}) (interface{}, error) {
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		s := []rune(toText(args[0]).(string))
+		start, n := toInt(args[1]), int64(len(s))
+		if len(args) == 3 {
+			if args[2] == nil {
+				return nil, nil
+			}
+			n = toInt(args[2])
+		}
+		if start > 0 {
+			start--
+		} else if start < 0 {
+			start += int64(len(s))
+			if start < 0 {
+				n += start
+				start = 0
+			}
+		}
+		if start > int64(len(s)) || n <= 0 {
+			return "", nil
+		}
+		if start+n > int64(len(s)) {
+			n = int64(len(s)) - start
+		}
+		return string(s[start : start+n]), nil
+	}},
+	"min": {2, -1, func(args []interface{}) (interface{}, error) {
+		return extreme(args, -1), nil
+	}},
+	"max": {2, -1, func(args []interface{}) (interface{}, error) {
+		return extreme(args, 1), nil
+	}},
+}
+
+func firstNotNull(args []interface{}) (interface{}, error) {
+	for _, v := range args {
+		if v != nil {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// extreme returns the smallest (sign -1) or largest argument, NULL if one is NULL
+func extreme(args []interface{}, sign int) interface{} {
+	best := args[0]
+	for _, v := range args {
+		if v == nil {
+			return nil
+		}
+		if compareValues(v, best)*sign > 0 {
+			best = v
+		}
+	}
+	return best
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return "integer"
+	case float64:
+		return "real"
+	case string:
+		return "text"
+	}
+	return "blob"
+}
@@ -0,0 +1,916 @@
+package gosqlite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+// plan is a statement prepared to run in a trx, result is set for the
+// statements that change rows. types are the declared types of the
+// columns. The plan runs again in another trx of env while the schema
+// is still tables.
+type plan struct {
+	op      operator
+	columns []string
+	types   []string
+	result  *Result
+	env     *execEnv
+	tables  map[string]*tableInfo
+	vm      bool
+	// cookie is the schema version the plan was built on, -1 if unknown
+	cookie int64
+}
+
+// planner builds the operators of a statement on the schema of a trx
+type planner struct {
+	env    *execEnv
+	tables map[string]*tableInfo
+}
+
+// prepare to build the plan of stmt to run in trx, text is the text of
+// stmt which CREATE TABLE stores in the schema. The plan runs on the
+// bytecode VM when vm is set and on the operators otherwise.
+func (db *DB) prepare(trx *Trx, stmt sql.Stmt, text string, vm bool) (*plan, error) {
+	tables, err := db.schema(trx)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &planner{env: &execEnv{db: db, trx: trx}, tables: tables}
+	p, err := b.plan(stmt, text, vm)
+	if err != nil {
+		return nil, err
+	}
+	p.env, p.tables, p.vm = b.env, tables, vm
+	return p, nil
+}
+
+// reuse returns whether p can run in a trx with the schema tables, the
+// tables are the same as long as the schema is not changed.
+func (p *plan) reuse(tables map[string]*tableInfo, vm bool) bool {
+	if p.vm != vm || len(p.tables) != len(tables) {
+		return false
+	}
+	for name, info := range tables {
+		if p.tables[name] != info {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *planner) plan(stmt sql.Stmt, text string, vm bool) (*plan, error) {
+	switch stmt := stmt.(type) {
+	case *sql.ExplainStmt:
+		prog, err := b.compile(stmt.Stmt, text)
+		if err != nil {
+			return nil, err
+		}
+		if stmt.QueryPlan {
+			return &plan{op: constantRows(prog.queryPlan()), columns: queryPlanColumns}, nil
+		}
+		return &plan{op: constantRows(prog.explain()), columns: explainColumns}, nil
+	case *sql.AnalyzeStmt:
+		return b.analyze(stmt)
+	}
+	if vm {
+		return b.vmPlan(stmt, text)
+	}
+
+	switch stmt := stmt.(type) {
+	case *sql.SelectStmt:
+		return b.selectPlan(stmt)
+	case *sql.InsertStmt:
+		return b.insertPlan(stmt)
+	case *sql.UpdateStmt:
+		return b.updatePlan(stmt)
+	case *sql.DeleteStmt:
+		return b.deletePlan(stmt)
+	case *sql.CreateTableStmt:
+		return b.createTable(stmt, text)
+	case *sql.DropStmt:
+		if stmt.Index {
+			return b.dropIndex(stmt)
+		}
+		return b.dropTable(stmt)
+	case *sql.CreateIndexStmt:
+		return b.createIndex(stmt, text)
+	}
+	return nil, fmt.Errorf("unsupported statement %T", stmt)
+}
+
+// vmPlan returns the plan running the program of stmt
+func (b *planner) vmPlan(stmt sql.Stmt, text string) (*plan, error) {
+	prog, err := b.compile(stmt, text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &plan{columns: prog.columns, types: prog.types}
+	switch stmt.(type) {
+	case *sql.InsertStmt, *sql.UpdateStmt, *sql.DeleteStmt:
+		p.result = new(Result)
+	}
+	p.op = &vm{env: b.env, prog: prog, result: p.result}
+	return p, nil
+}
+
+// constantRows returns an operator returning rows
+func constantRows(rows [][]interface{}) operator {
+	exprs := make([][]evalFunc, len(rows))
+	for i, row := range rows {
+		for _, v := range row {
+			v := v
+			exprs[i] = append(exprs[i], func([]interface{}) (interface{}, error) { return v, nil })
+		}
+	}
+	return &valueList{rows: exprs}
+}
+
+// compiler returns a compiler of the expressions over the rows of s
+func (b *planner) compiler(s *scope) *compiler {
+	return &compiler{scope: s, env: b.env, b: b}
+}
+
+// table returns the table called name
+func (b *planner) table(name string) (*tableInfo, error) {
+	info := b.tables[strings.ToLower(name)]
+	if info == nil {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	return info, nil
+}
+
+// writableTable returns the table called name which a statement changes
+func (b *planner) writableTable(name string) (*tableInfo, error) {
+	info, err := b.table(name)
+	if err == nil && info == masterTable {
+		err = fmt.Errorf("table %s may not be modified", masterName)
+	}
+	return info, err
+}
+
+// tableScope returns the scope of the rows of a table scan
+func tableScope(info *tableInfo, alias string) *scope {
+	if alias == "" {
+		alias = info.name
+	}
+	s := &scope{columns: []scopeColumn{{table: alias, name: "rowid", aff: affinityInteger, hidden: true}}}
+	for _, c := range info.columns {
+		s.columns = append(s.columns, scopeColumn{table: alias, name: c.name, typ: c.typ, aff: c.aff, coll: c.coll})
+	}
+	return s
+}
+
+// conjuncts splits e into the expressions joined by AND
+func conjuncts(e sql.Expr) []sql.Expr {
+	if e == nil {
+		return nil
+	}
+	if and, ok := e.(*sql.BinaryExpr); ok && and.Op == "AND" {
+		return append(conjuncts(and.X), conjuncts(and.Y)...)
+	}
+	return []sql.Expr{e}
+}
+
+// constant returns whether e refers to no column
+func constant(e sql.Expr) bool {
+	return (&scope{}).has(e)
+}
+
+// isRowIDRef returns whether e is the rowid of the table of the scope
+func isRowIDRef(e sql.Expr, s *scope, info *tableInfo) bool {
+	ref, ok := e.(*sql.ColumnRef)
+	if !ok {
+		return false
+	}
+	i, err := s.resolve(ref)
+	return err == nil && (i == 0 || i == info.rowidColumn+1)
+}
+
+var swapped = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// access returns the operator reading the rows of a table that where
+// may be true for by the cheapest path, used tells which columns of s
+// the statement reads. where is still to be checked on the rows.
+func (b *planner) access(info *tableInfo, s *scope, where sql.Expr, used []bool) (operator, error) {
+	constants := b.compiler(&scope{})
+	path := cheapest(accessPaths(info, s, conjuncts(where), constant, used))
+	switch {
+	case path.keys != nil:
+		seek := &indexSeek{env: b.env, table: info}
+		for _, key := range path.keys {
+			k, err := constants.compile(key)
+			if err != nil {
+				return nil, err
+			}
+			seek.keys = append(seek.keys, k)
+		}
+		return seek, nil
+	case path.index != nil:
+		op := &indexScan{env: b.env, table: info, rng: path.index}
+		for _, e := range path.values {
+			value, err := constants.compile(e)
+			if err != nil {
+				return nil, err
+			}
+			op.values = append(op.values, value)
+		}
+		return op, nil
+	}
+
+	scan := &tableScan{env: b.env, table: info}
+	if path.lo != nil {
+		lo, err := constants.compile(path.lo)
+		if err != nil {
+			return nil, err
+		}
+		scan.lo = rowIDBound(lo, path.loOp)
+	}
+	if path.hi != nil {
+		hi, err := constants.compile(path.hi)
+		if err != nil {
+			return nil, err
+		}
+		scan.hi = rowIDBound(hi, path.hiOp)
+	}
+	return scan, nil
+}
+
+// rowIDBound returns the smallest (op > or >=) or largest rowid the
+// bound allows.
+func rowIDBound(bound evalFunc, op string) evalFunc {
+	return func(row []interface{}) (interface{}, error) {
+		v, err := bound(row)
+		if v = affinityNumeric.apply(v); err != nil || typeRank(v) != 1 {
+			return nil, err
+		}
+		f := toFloat(v)
+		switch op {
+		case ">":
+			return math.Floor(f) + 1, nil
+		case ">=":
+			return math.Ceil(f), nil
+		case "<":
+			return math.Ceil(f) - 1, nil
+		}
+		return math.Floor(f), nil
+	}
+}
+
+// source returns the operator reading a FROM source and the scope of its
+// rows, the WHERE of stmt chooses how a table is read.
+func (b *planner) source(src sql.Source, stmt *sql.SelectStmt) (operator, *scope, error) {
+	switch src := src.(type) {
+	case nil:
+		return &valueList{rows: [][]evalFunc{{}}}, &scope{}, nil
+	case *sql.TableRef:
+		info, err := b.table(src.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := tableScope(info, src.Alias)
+		if stmt == nil {
+			op, err := b.access(info, s, nil, nil)
+			return op, s, err
+		}
+		op, err := b.access(info, s, stmt.Where, usedColumns(stmt, s))
+		return op, s, err
+	case *sql.Join:
+		return b.join(src)
+	}
+	return nil, nil, fmt.Errorf("unsupported source %T", src)
+}
+
+// join returns a hash join when ON has equalities between columns of the
+// left and the right rows, a nested loop join otherwise.
+func (b *planner) join(j *sql.Join) (operator, *scope, error) {
+	left, ls, err := b.source(j.Left, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, rs, err := b.source(j.Right, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := ls.concat(rs)
+	outer := j.Kind == sql.LeftJoin
+	width := len(rs.columns)
+	if j.On == nil {
+		return &nestedLoopJoin{left: left, right: right, outer: outer, rightWidth: width}, s, nil
+	}
+	all := b.compiler(s)
+	if _, err = all.compile(j.On); err != nil {
+		return nil, nil, err
+	}
+
+	hj := &hashJoin{left: left, right: right, outer: outer, rightWidth: width}
+	var residual sql.Expr
+	for _, e := range conjuncts(j.On) {
+		if eq, ok := e.(*sql.BinaryExpr); ok && eq.Op == "=" {
+			x, y := eq.X, eq.Y
+			if !ls.has(x) || !rs.has(y) {
+				x, y = y, x
+			}
+			if ls.has(x) && rs.has(y) && !constant(x) && !constant(y) {
+				lk, err := b.compiler(ls).compile(x)
+				if err != nil {
+					return nil, nil, err
+				}
+				rk, err := b.compiler(rs).compile(y)
+				if err != nil {
+					return nil, nil, err
+				}
+				hj.leftKeys = append(hj.leftKeys, lk)
+				hj.rightKeys = append(hj.rightKeys, rk)
+				continue
+			}
+		}
+		if residual == nil {
+			residual = e
+		} else {
+			residual = &sql.BinaryExpr{Span: sql.Span{Start: e.Pos()}, Op: "AND", X: residual, Y: e}
+		}
+	}
+
+	var cond evalFunc
+	if residual != nil {
+		if cond, err = all.compile(residual); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(hj.leftKeys) == 0 {
+		return &nestedLoopJoin{left: left, right: right, cond: cond, outer: outer, rightWidth: width}, s, nil
+	}
+	hj.cond = cond
+	return hj, s, nil
+}
+
+// columnName returns the name of a result column
+func columnName(rc *sql.ResultColumn) string {
+	if rc.Alias != "" {
+		return rc.Alias
+	}
+	if ref, ok := rc.Expr.(*sql.ColumnRef); ok {
+		return ref.Name
+	}
+	return sql.FormatExpr(rc.Expr)
+}
+
+// columnType returns the declared type of a result column, empty unless
+// it is a column of a table.
+func columnType(s *scope, rc *sql.ResultColumn) string {
+	if ref, ok := rc.Expr.(*sql.ColumnRef); ok {
+		if i, err := s.resolve(ref); err == nil {
+			return s.columns[i].typ
+		}
+	}
+	return ""
+}
+
+func (b *planner) selectPlan(stmt *sql.SelectStmt) (*plan, error) {
+	op, s, err := b.source(stmt.From, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Where != nil {
+		cond, err := b.compiler(s).compile(stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		op = &filter{child: op, cond: cond}
+	}
+
+	grouped := len(stmt.GroupBy) > 0 || hasAggregate(stmt.Having)
+	for _, rc := range stmt.Columns {
+		grouped = grouped || hasAggregate(rc.Expr)
+	}
+	for _, term := range stmt.OrderBy {
+		grouped = grouped || hasAggregate(term.Expr)
+	}
+
+	// after grouping the rows are the last row of a group and the aggregates
+	c := b.compiler(s)
+	var aggs []*aggregate
+	if grouped {
+		c.aggs = &aggs
+	}
+
+	p := &plan{columns: make([]string, 0), types: make([]string, 0)}
+	exprs := make([]evalFunc, 0)
+	colls := make([]*collation, 0)
+	for _, rc := range stmt.Columns {
+		if !rc.Star {
+			e, err := c.compile(rc.Expr)
+			if err != nil {
+				return nil, err
+			}
+			coll, _, err := exprCollation(rc.Expr, s)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e)
+			colls = append(colls, coll)
+			p.columns = append(p.columns, columnName(rc))
+			p.types = append(p.types, columnType(s, rc))
+			continue
+		}
+
+		n := len(exprs)
+		for i, col := range s.columns {
+			if !col.hidden && (rc.Table == "" || strings.EqualFold(rc.Table, col.table)) {
+				i := i
+				exprs = append(exprs, func(row []interface{}) (interface{}, error) { return row[i], nil })
+				colls = append(colls, col.coll)
+				p.columns = append(p.columns, col.name)
+				p.types = append(p.types, col.typ)
+			}
+		}
+		if n == len(exprs) {
+			if rc.Table != "" {
+				return nil, fmt.Errorf("no such table: %s", rc.Table)
+			}
+			return nil, errors.New("no tables specified")
+		}
+	}
+	width := len(exprs)
+
+	var having evalFunc
+	if stmt.Having != nil {
+		if having, err = c.compile(stmt.Having); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]sortKey, 0)
+	for _, term := range stmt.OrderBy {
+		column, err := orderColumn(stmt, term.Expr, width)
+		if err != nil {
+			return nil, err
+		}
+		coll, err := orderCollation(term.Expr, s, colls, column)
+		if err != nil {
+			return nil, err
+		}
+		if column < 0 {
+			e, err := c.compile(term.Expr)
+			if err != nil {
+				return nil, err
+			}
+			column = len(exprs)
+			exprs = append(exprs, e)
+		}
+		keys = append(keys, sortKey{column, term.Desc, coll})
+	}
+
+	if grouped {
+		groupBy := make([]evalFunc, len(stmt.GroupBy))
+		groupColls := make([]*collation, len(stmt.GroupBy))
+		for i, e := range stmt.GroupBy {
+			if groupBy[i], err = b.compiler(s).compile(e); err != nil {
+				return nil, err
+			}
+			if groupColls[i], _, err = exprCollation(e, s); err != nil {
+				return nil, err
+			}
+		}
+		op = &hashAggregate{child: op, groupBy: groupBy, colls: groupColls, aggs: aggs, width: len(s.columns)}
+		if having != nil {
+			op = &filter{child: op, cond: having}
+		}
+	}
+
+	op = &project{child: op, exprs: exprs}
+	if stmt.Distinct {
+		op = &distinct{child: op, width: width, colls: colls}
+	}
+	if len(keys) > 0 {
+		op = &sorter{child: op, keys: keys}
+	}
+	if stmt.Limit != nil {
+		constants := b.compiler(&scope{})
+		l := &limit{child: op}
+		if l.limit, err = constants.compile(stmt.Limit); err != nil {
+			return nil, err
+		}
+		if stmt.Offset != nil {
+			if l.offset, err = constants.compile(stmt.Offset); err != nil {
+				return nil, err
+			}
+		}
+		op = l
+	}
+	if len(exprs) > width {
+		trim := make([]evalFunc, width)
+		for i := range trim {
+			i := i
+			trim[i] = func(row []interface{}) (interface{}, error) { return row[i], nil }
+		}
+		op = &project{child: op, exprs: trim}
+	}
+
+	p.op = op
+	return p, nil
+}
+
+// orderColumn returns the result column an ORDER BY term names by its
+// position or alias, -1 if the term is an expression.
+func orderColumn(stmt *sql.SelectStmt, e sql.Expr, width int) (int, error) {
+	switch e := e.(type) {
+	case *sql.CollateExpr:
+		return orderColumn(stmt, e.X, width)
+	case *sql.Literal:
+		if e.Kind != sql.Integer {
+			break
+		}
+		v, err := literalValue(e)
+		if n, ok := v.(int64); err != nil || !ok || n < 1 || n > int64(width) {
+			return 0, fmt.Errorf("ORDER BY term out of range - should be between 1 and %d", width)
+		}
+		return int(v.(int64)) - 1, nil
+	case *sql.ColumnRef:
+		if e.Table != "" {
+			break
+		}
+		i := 0
+		for _, rc := range stmt.Columns {
+			if rc.Star {
+				return -1, nil
+			}
+			if strings.EqualFold(rc.Alias, e.Name) {
+				return i, nil
+			}
+			i++
+		}
+	}
+	return -1, nil
+}
+
+// orderCollation returns the collation of the ORDER BY term e over s:
+// that of its COLLATE, else of the result column it is, else of e. colls
+// are those of the result columns.
+func orderCollation(e sql.Expr, s *scope, colls []*collation, column int) (*collation, error) {
+	coll, explicit, err := exprCollation(e, s)
+	if explicit || column < 0 {
+		return coll, err
+	}
+	return colls[column], nil
+}
+
+func (b *planner) insertPlan(stmt *sql.InsertStmt) (*plan, error) {
+	info, err := b.writableTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]int, 0)
+	if len(stmt.Columns) == 0 {
+		for i := range info.columns {
+			columns = append(columns, i)
+		}
+	}
+	for _, name := range stmt.Columns {
+		i := info.column(name)
+		if i < 0 {
+			return nil, fmt.Errorf("table %s has no column named %s", info.name, name)
+		}
+		columns = append(columns, i)
+	}
+
+	constants := b.compiler(&scope{})
+	rows := make([][]evalFunc, len(stmt.Rows))
+	for r, values := range stmt.Rows {
+		if len(values) != len(columns) {
+			if len(stmt.Columns) == 0 {
+				return nil, fmt.Errorf("table %s has %d columns but %d values were supplied", info.name, len(columns), len(values))
+			}
+			return nil, fmt.Errorf("%d values for %d columns", len(values), len(columns))
+		}
+
+		row := make([]evalFunc, len(info.columns))
+		for i, c := range info.columns {
+			dflt := sql.Expr(&sql.Literal{Kind: sql.Null})
+			if c.dflt != nil {
+				dflt = c.dflt
+			}
+			if row[i], err = constants.compile(dflt); err != nil {
+				return nil, err
+			}
+		}
+		for i, e := range values {
+			if row[columns[i]], err = constants.compile(e); err != nil {
+				return nil, err
+			}
+		}
+		rows[r] = row
+	}
+
+	result := new(Result)
+	op := &insertOp{env: b.env, child: &valueList{rows: rows}, table: info, result: result}
+	return &plan{op: op, columns: []string{}, result: result}, nil
+}
+
+// targetRows returns the operator reading the rows of a table where is true for
+func (b *planner) targetRows(info *tableInfo, where sql.Expr) (operator, *scope, error) {
+	s := tableScope(info, "")
+	op, err := b.access(info, s, where, nil)
+	if err != nil || where == nil {
+		return op, s, err
+	}
+	cond, err := b.compiler(s).compile(where)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &filter{child: op, cond: cond}, s, nil
+}
+
+func (b *planner) updatePlan(stmt *sql.UpdateStmt) (*plan, error) {
+	info, err := b.writableTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	op, s, err := b.targetRows(info, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := make([]evalFunc, len(s.columns))
+	for i := range exprs {
+		i := i
+		exprs[i] = func(row []interface{}) (interface{}, error) { return row[i], nil }
+	}
+	columns := make([]int, 0, len(stmt.Set))
+	c := b.compiler(s)
+	for _, set := range stmt.Set {
+		i := info.column(set.Column)
+		if i < 0 {
+			return nil, fmt.Errorf("no such column: %s", set.Column)
+		}
+		e, err := c.compile(set.Value)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, i)
+		exprs = append(exprs, e)
+	}
+
+	result := new(Result)
+	op = &updateOp{env: b.env, child: &project{child: op, exprs: exprs}, table: info, columns: columns, result: result}
+	return &plan{op: op, columns: []string{}, result: result}, nil
+}
+
+func (b *planner) deletePlan(stmt *sql.DeleteStmt) (*plan, error) {
+	info, err := b.writableTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	op, _, err := b.targetRows(info, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(Result)
+	op = &deleteOp{env: b.env, child: op, table: info, result: result}
+	return &plan{op: op, columns: []string{}, result: result}, nil
+}
+
+// command runs fn when its single row is read, it is the operator of DDL
+type command struct {
+	fn   func() error
+	done bool
+}
+
+func (c *command) open() error {
+	c.done = false
+	return nil
+}
+
+func (c *command) next() ([]interface{}, bool, error) {
+	if c.done {
+		return nil, false, nil
+	}
+	c.done = true
+	return nil, true, c.fn()
+}
+
+func (c *command) close() {}
+
+// index returns the index called name and its table, nil if none
+func (b *planner) index(name string) (*tableInfo, *indexInfo) {
+	for _, info := range b.tables {
+		for _, idx := range info.indexes {
+			if strings.EqualFold(idx.name, name) {
+				return info, idx
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkName checks that a table or an index may be called name
+func (b *planner) checkName(name string) error {
+	if strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+		return fmt.Errorf("object name reserved for internal use: %s", name)
+	} else if b.tables[strings.ToLower(name)] != nil {
+		return fmt.Errorf("there is already a table named %s", name)
+	} else if _, idx := b.index(name); idx != nil {
+		return fmt.Errorf("there is already an index named %s", name)
+	}
+	return nil
+}
+
+// insertMaster to add the row of a table or an index to sqlite_master,
+// returns its id
+func (b *planner) insertMaster(typ, name, table string, text interface{}) (int64, error) {
+	id, err := b.env.newRowID(masterTable)
+	if err != nil {
+		return 0, err
+	}
+	return id, b.env.insertRow(masterTable, id, []interface{}{typ, name, table, id, text})
+}
+
+func (b *planner) createTable(stmt *sql.CreateTableStmt, text string) (*plan, error) {
+	info, err := newTableInfo(0, text, stmt)
+	if err != nil {
+		return nil, err
+	}
+	exists := b.tables[strings.ToLower(stmt.Name)] != nil
+	if exists && !stmt.IfNotExists {
+		return nil, fmt.Errorf("table %s already exists", stmt.Name)
+	} else if !exists {
+		if err = b.checkName(stmt.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	op := &command{fn: func() error {
+		if exists {
+			return nil
+		}
+		if _, err := b.insertMaster("table", stmt.Name, stmt.Name, text); err != nil {
+			return err
+		}
+		for n := range info.uniqueColumns() {
+			if _, err := b.insertMaster("index", autoIndexName(stmt.Name, n+1), stmt.Name, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	return &plan{op: op, columns: []string{}}, nil
+}
+
+// checkCreateIndex checks CREATE INDEX, the returned index is nil when it
+// exists and IF NOT EXISTS is given.
+func (b *planner) checkCreateIndex(stmt *sql.CreateIndexStmt, text string) (*tableInfo, *indexInfo, error) {
+	if _, idx := b.index(stmt.Name); idx != nil && stmt.IfNotExists {
+		return nil, nil, nil
+	} else if idx != nil {
+		return nil, nil, fmt.Errorf("index %s already exists", stmt.Name)
+	}
+	if err := b.checkName(stmt.Name); err != nil {
+		return nil, nil, err
+	}
+	info, err := b.table(stmt.Table)
+	if err == nil && info == masterTable {
+		err = fmt.Errorf("table %s may not be indexed", masterName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := newIndexInfo(info, 0, text, stmt)
+	return info, idx, err
+}
+
+func (b *planner) createIndex(stmt *sql.CreateIndexStmt, text string) (*plan, error) {
+	info, idx, err := b.checkCreateIndex(stmt, text)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &command{fn: func() error {
+		if idx == nil {
+			return nil
+		}
+		if idx.unique {
+			if err := b.checkUnique(info, idx); err != nil {
+				return err
+			}
+		}
+		_, err := b.insertMaster("index", stmt.Name, info.name, text)
+		return err
+	}}
+	return &plan{op: op, columns: []string{}}, nil
+}
+
+// checkUnique checks that the rows of table have distinct values in the
+// columns of the new UNIQUE index idx, rows with a NULL in them excepted.
+func (b *planner) checkUnique(info *tableInfo, idx *indexInfo) error {
+	seen := make(map[string]bool)
+	scan := &tableScan{env: b.env, table: info}
+	if err := scan.open(); err != nil {
+		return err
+	}
+	for {
+		row, ok, err := scan.next()
+		if err != nil || !ok {
+			return err
+		}
+		values := make([]interface{}, 0, len(idx.columns))
+		for _, c := range idx.columns {
+			values = append(values, row[c+1])
+		}
+		key := string(idx.key(row))
+		if seen[key] && !hasNull(values) {
+			return uniqueError(info, idx)
+		}
+		seen[key] = true
+	}
+}
+
+// hasNull returns whether a value is NULL
+func hasNull(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDropIndex returns the index DROP INDEX drops, nil when it does
+// not exist and IF EXISTS is given.
+func (b *planner) checkDropIndex(stmt *sql.DropStmt) (*indexInfo, error) {
+	_, idx := b.index(stmt.Name)
+	switch {
+	case idx == nil && !stmt.IfExists:
+		return nil, fmt.Errorf("no such index: %s", stmt.Name)
+	case idx != nil && idx.sql == "":
+		return nil, errors.New("index associated with UNIQUE or PRIMARY KEY constraint cannot be dropped")
+	}
+	return idx, nil
+}
+
+func (b *planner) dropIndex(stmt *sql.DropStmt) (*plan, error) {
+	idx, err := b.checkDropIndex(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &command{fn: func() error {
+		if idx == nil {
+			return nil
+		}
+		if err := b.clearStats(1, idx.name); err != nil {
+			return err
+		}
+		return b.env.trx.Delete(b.env.db.ctx, masterTable.rowID(idx.id))
+	}}
+	return &plan{op: op, columns: []string{}}, nil
+}
+
+func (b *planner) dropTable(stmt *sql.DropStmt) (*plan, error) {
+	info := b.tables[strings.ToLower(stmt.Name)]
+	if info == nil && !stmt.IfExists {
+		return nil, fmt.Errorf("no such table: %s", stmt.Name)
+	} else if info == masterTable {
+		return nil, fmt.Errorf("table %s may not be dropped", masterName)
+	}
+
+	op := &command{fn: func() error {
+		if info == nil {
+			return nil
+		}
+		ctx, trx := b.env.db.ctx, b.env.trx
+		from, to := info.rowRange()
+		rows := trx.cursor(ctx, from, to)
+		for {
+			row, ok, err := rows.next()
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+			if err = trx.Delete(ctx, row.RowID); err != nil {
+				return err
+			}
+		}
+		for _, idx := range info.indexes {
+			if err := trx.Delete(ctx, masterTable.rowID(idx.id)); err != nil {
+				return err
+			}
+		}
+		if info.name != statName {
+			if err := b.clearStats(0, info.name); err != nil {
+				return err
+			}
+		}
+		return trx.Delete(ctx, masterTable.rowID(info.id))
+	}}
+	return &plan{op: op, columns: []string{}}, nil
+}
@@ -0,0 +1,271 @@
+package gosqlite_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+// formatValue returns a value as the scripts write it
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		s := fmt.Sprint(v)
+		if !strings.ContainsAny(s, ".eN") {
+			s += ".0"
+		}
+		return s
+	case []byte:
+		return fmt.Sprintf("x'%x'", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func queryRows(conn *gosqlite.Conn, src string) ([]string, error) {
+	rows, err := conn.Query(src)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0)
+	for rows.Next() {
+		fields := make([]string, 0)
+		for _, v := range rows.Values() {
+			fields = append(fields, formatValue(v))
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return lines, rows.Close()
+}
+
+// runScript runs a script of blocks separated by blank lines on the VM
+// when vm is set, a block is "statement ok" or "statement error <text>"
+// followed by SQL, or "query" followed by SQL, a "----" line and the
+// expected rows.
+func runScript(t *testing.T, fileName string, vm bool) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := gosqlite.CreateDB()
+	conn := db.Conn()
+	defer conn.Close()
+	conn.UseVM(vm)
+
+	line := 1
+	for _, block := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n\n") {
+		start := line
+		line += strings.Count(block, "\n") + 2
+		lines := make([]string, 0)
+		for _, l := range strings.Split(block, "\n") {
+			if l != "" && !strings.HasPrefix(l, "#") {
+				lines = append(lines, l)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		head, body := lines[0], lines[1:]
+		switch {
+		case head == "statement ok":
+			if _, err := conn.Exec(strings.Join(body, "\n")); err != nil {
+				t.Errorf("%s:%d: %v", fileName, start, err)
+			}
+		case strings.HasPrefix(head, "statement error"):
+			want := strings.TrimSpace(strings.TrimPrefix(head, "statement error"))
+			if _, err := conn.Exec(strings.Join(body, "\n")); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s:%d: error %v, want %q", fileName, start, err, want)
+			}
+		case head == "query":
+			sep := len(body)
+			for i, l := range body {
+				if l == "----" {
+					sep = i
+				}
+			}
+			got, err := queryRows(conn, strings.Join(body[:sep], "\n"))
+			if err != nil {
+				t.Errorf("%s:%d: %v", fileName, start, err)
+				continue
+			}
+			want := []string{}
+			if sep < len(body) {
+				want = body[sep+1:]
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("%s:%d: got\n%s\nwant\n%s", fileName, start, strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		default:
+			t.Fatalf("%s:%d: unknown block %q", fileName, start, head)
+		}
+	}
+}
+
+func TestExecScripts(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.test")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no scripts: %v", err)
+	}
+	for _, fileName := range files {
+		fileName := fileName
+		t.Run(filepath.Base(fileName), func(t *testing.T) {
+			t.Run("ops", func(t *testing.T) { runScript(t, fileName, false) })
+			t.Run("vm", func(t *testing.T) { runScript(t, fileName, true) })
+		})
+	}
+}
+
+func TestExecTrx(t *testing.T) {
+	db := gosqlite.CreateDB()
+	c1, c2 := db.Conn(), db.Conn()
+	defer c1.Close()
+	defer c2.Close()
+
+	if _, err := c1.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT); INSERT INTO t (v) VALUES ('a')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c1.Exec("BEGIN; INSERT INTO t VALUES (2, 'b')"); err != nil || !c1.InTrx() {
+		t.Fatal(err)
+	}
+	if rows, _ := queryRows(c2, "SELECT count(*) FROM t"); len(rows) != 1 || rows[0] != "1" {
+		t.Fatalf("uncommitted insert is visible: %v", rows)
+	}
+
+	// a failed statement is undone alone and the trx goes on
+	if _, err := c1.Exec("INSERT INTO t VALUES (3, 'c'), (1, 'dup')"); err == nil {
+		t.Fatal("duplicate id is inserted")
+	}
+	if rows, _ := queryRows(c1, "SELECT id FROM t"); strings.Join(rows, ",") != "1,2" {
+		t.Fatalf("rows after failed statement: %v", rows)
+	}
+	if _, err := c1.Exec("COMMIT"); err != nil || c1.InTrx() {
+		t.Fatal(err)
+	}
+	if rows, _ := queryRows(c2, "SELECT v FROM t ORDER BY id DESC"); strings.Join(rows, ",") != "b,a" {
+		t.Fatalf("rows after commit: %v", rows)
+	}
+
+	res, err := c2.Exec("BEGIN; CREATE TABLE u (x); INSERT INTO u VALUES (1), (2)")
+	if err != nil || res.RowsAffected != 2 || res.LastInsertID != 2 {
+		t.Fatalf("insert result %+v %v", res, err)
+	}
+	if _, err = c2.Exec("ROLLBACK"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c1.Exec("SELECT * FROM u"); err == nil || err.Error() != "no such table: u" {
+		t.Fatalf("rolled back table: %v", err)
+	}
+	if _, err = c1.Exec("COMMIT"); err != gosqlite.ErrNoTrx {
+		t.Fatalf("commit without trx: %v", err)
+	}
+}
+
+func TestExecScanWrites(t *testing.T) {
+	for _, vm := range []bool{false, true} {
+		db := gosqlite.CreateDB()
+		reader, writer := db.Conn(), db.Conn()
+		reader.UseVM(vm)
+		values, evens := "", ""
+		for i := 1; i <= 400; i += 2 {
+			values += fmt.Sprintf(", (%d)", i)
+			evens += fmt.Sprintf(", (%d)", i+1)
+		}
+		if _, err := writer.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY); INSERT INTO t VALUES " + values[2:]); err != nil {
+			t.Fatal(err)
+		}
+
+		// the row tree splits and shrinks under the scan, which goes on
+		// after the last row it read with the rows its trx sees
+		rows, err := reader.Query("SELECT id FROM t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0)
+		for rows.Next() {
+			got = append(got, fmt.Sprint(rows.Values()[0]))
+			if len(got) == 50 {
+				if _, err = writer.Exec("INSERT INTO t VALUES " + evens[2:] + "; DELETE FROM t WHERE id % 4 = 1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err = rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := make([]string, 0)
+		for i := 1; i <= 400; i += 2 {
+			want = append(want, fmt.Sprint(i))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("vm %v: scanned %v", vm, got)
+		}
+		reader.Close()
+		writer.Close()
+	}
+}
+
+func TestExecTrxTimeout(t *testing.T) {
+	db := gosqlite.CreateDB()
+	c1, c2 := db.Conn(), db.Conn()
+	defer c1.Close()
+	defer c2.Close()
+
+	if _, err := c1.Exec("CREATE TABLE t (v)"); err != nil {
+		t.Fatal(err)
+	}
+	db.TrxContext().SetTrxTimeout(20*time.Millisecond, 0)
+	if _, err := c1.Exec("BEGIN; INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	for len(db.TrxContext().ActiveTrx()) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	db.TrxContext().SetTrxTimeout(0, 0)
+
+	// the trx begun now must not be rolled back by the stale one of c1
+	if _, err := c2.Exec("BEGIN; INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c1.Exec("ROLLBACK"); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("rollback of timed out trx: %v", err)
+	}
+	if _, err := c2.Exec("COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := queryRows(c1, "SELECT v FROM t"); strings.Join(rows, ",") != "2" {
+		t.Fatalf("rows: %v", rows)
+	}
+}
+
+func TestExecReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "exec.db")
+	db, err := gosqlite.OpenDB(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := db.Conn()
+	if _, err = conn.Exec("CREATE TABLE t (a, b); INSERT INTO t VALUES (1, 'one'), (2, x'02')"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = gosqlite.OpenDB(fileName); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := queryRows(db.Conn(), "SELECT rowid, a, b FROM t")
+	if err != nil || strings.Join(rows, ",") != "1 1 one,2 2 x'02'" {
+		t.Fatalf("rows after reopen: %v %v", rows, err)
+	}
+}
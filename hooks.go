@@ -0,0 +1,63 @@
+package gosqlite
+
+// TxInfo describes a trx passed to the commit and rollback hooks, Writes
+// is the number of row changes and CommitTS is set after a commit that
+// wrote rows.
+type TxInfo struct {
+	TrxID    int64
+	Writes   int
+	CommitTS int64
+}
+
+// hooks are only appended to, so a copy taken under the lock can be
+// called after it is released.
+type hooks struct {
+	commit      []func(TxInfo) error
+	afterCommit []func(TxInfo)
+	rollback    []func(TxInfo)
+	row         []func(trxID int64, op ChangeOp, rowID int64)
+}
+
+// OnCommit to add a hook called before a trx commits, an error vetoes the
+// commit and the trx is rolled back.
+func (context *TrxContext) OnCommit(fn func(TxInfo) error) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.hooks.commit = append(context.hooks.commit, fn)
+}
+
+// OnAfterCommit to add a hook called after a trx committed
+func (context *TrxContext) OnAfterCommit(fn func(TxInfo)) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.hooks.afterCommit = append(context.hooks.afterCommit, fn)
+}
+
+// OnRollback to add a hook called after a trx rolled back
+func (context *TrxContext) OnRollback(fn func(TxInfo)) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.hooks.rollback = append(context.hooks.rollback, fn)
+}
+
+// OnUpdate to add a hook called after a trx inserted, updated or deleted
+// a row, like sqlite3_update_hook.
+func (context *TrxContext) OnUpdate(fn func(trxID int64, op ChangeOp, rowID int64)) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.hooks.row = append(context.hooks.row, fn)
+}
+
+func (context *TrxContext) rowHooks(trxID int64, op ChangeOp, rowID int64) {
+	context.mu.RLock()
+	row := context.hooks.row
+	context.mu.RUnlock()
+
+	for _, fn := range row {
+		fn(trxID, op, rowID)
+	}
+}
@@ -0,0 +1,62 @@
+package gosqlite_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestTrxHooks(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	events := make([]string, 0)
+	veto := errors.New("veto")
+	context.OnCommit(func(info gosqlite.TxInfo) error {
+		events = append(events, fmt.Sprintf("commit %d %d", info.TrxID, info.Writes))
+		if info.Writes > 1 {
+			return veto
+		}
+		return nil
+	})
+	context.OnAfterCommit(func(info gosqlite.TxInfo) {
+		events = append(events, fmt.Sprintf("committed %d %d", info.TrxID, info.CommitTS))
+	})
+	context.OnRollback(func(info gosqlite.TxInfo) {
+		events = append(events, fmt.Sprintf("rollback %d %d", info.TrxID, info.Writes))
+	})
+	context.OnUpdate(func(trxID int64, op gosqlite.ChangeOp, rowID int64) {
+		events = append(events, fmt.Sprintf("row %d %d %d", trxID, op, rowID))
+	})
+
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	if err := trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	trx = beginTrx(context)
+	trx.Update(context, 1, "b")
+	trx.Delete(context, 1)
+	if err := trx.Commit(); err != veto {
+		t.Fatalf("vetoed commit %v", err)
+	}
+	if got := snapshot(t, context); got[1] != "a" {
+		t.Fatalf("vetoed commit is visible %v", got)
+	}
+
+	trx = beginTrx(context)
+	trx.Update(context, 1, "c")
+	trx.Rollback()
+	trx.Rollback()
+
+	want := []string{
+		"row 1 1 1", "commit 1 1", "committed 1 2",
+		"row 2 2 1", "row 2 3 1", "commit 2 2", "rollback 2 2",
+		"commit 3 0", "committed 3 0",
+		"row 4 2 1", "rollback 4 1",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Fatalf("events %v\nwant %v", events, want)
+	}
+}
@@ -0,0 +1,924 @@
+package gosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gosqlite/sql"
+)
+
+const (
+	// the order of the keyed b+ tree of the index entries
+	indexOrder = maxOrder
+
+	autoIndexPrefix = "sqlite_autoindex_"
+)
+
+// the tags of the values in an index key, they order the values like
+// compareValues does
+const (
+	keyNull   byte = 1
+	keyNumber byte = 2
+	keyText   byte = 3
+	keyBlob   byte = 4
+
+	// keyFormat is the version of the key encoding in the marker of an
+	// index, the entries of another version are built again
+	keyFormat byte = 2
+)
+
+// indexInfo is an index of a table, columns are the table columns it
+// keys and colls their collations. The automatic index of a UNIQUE or
+// PRIMARY KEY column has no sql.
+type indexInfo struct {
+	id      int64
+	name    string
+	sql     string
+	unique  bool
+	columns []int
+	colls   []*collation
+	desc    []bool
+	stat    []int64
+}
+
+// indexDef is an index row of sqlite_master, or a table row, with its
+// stat in sqlite_stat1
+type indexDef struct {
+	id   int64
+	name string
+	sql  string
+	stat string
+}
+
+// autoIndexName returns the name of the automatic index n of table,
+// counting from 1.
+func autoIndexName(table string, n int) string {
+	return fmt.Sprintf("%s%s_%d", autoIndexPrefix, table, n)
+}
+
+// newIndexInfo returns the index of table the CREATE INDEX statement
+// creates, the columns must be columns of table. A column is keyed by
+// its COLLATE in the statement or else by its collation in the table.
+func newIndexInfo(table *tableInfo, id int64, text string, create *sql.CreateIndexStmt) (*indexInfo, error) {
+	idx := &indexInfo{id: id, name: create.Name, sql: text, unique: create.Unique}
+	for _, c := range create.Columns {
+		i := table.column(c.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("no such column: %s", c.Name)
+		}
+		coll := table.columns[i].coll
+		if c.Collate != "" {
+			var err error
+			if coll, err = lookupCollation(c.Collate); err != nil {
+				return nil, err
+			}
+		}
+		idx.columns = append(idx.columns, i)
+		idx.colls = append(idx.colls, coll)
+		idx.desc = append(idx.desc, c.Desc)
+	}
+	return idx, nil
+}
+
+// parseIndex returns the index of table stored as def in sqlite_master
+func parseIndex(table *tableInfo, def indexDef) (*indexInfo, error) {
+	if def.sql == "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(def.name, autoIndexPrefix+table.name+"_"))
+		columns := table.uniqueColumns()
+		if err != nil || n < 1 || n > len(columns) {
+			return nil, fmt.Errorf("malformed schema: %s", def.name)
+		}
+		c := columns[n-1]
+		return &indexInfo{id: def.id, name: def.name, unique: true, columns: []int{c},
+			colls: []*collation{table.columns[c].coll}, desc: []bool{false}}, nil
+	}
+
+	stmt, err := sql.ParseStmt(def.sql)
+	if err != nil {
+		return nil, err
+	}
+	create, ok := stmt.(*sql.CreateIndexStmt)
+	if !ok {
+		return nil, fmt.Errorf("malformed schema: %s", def.sql)
+	}
+	return newIndexInfo(table, def.id, def.sql, create)
+}
+
+// key returns the key of a table row [rowid, columns...] in the index,
+// the keys of rows compare as bytes like the values of the columns do.
+func (idx *indexInfo) key(row []interface{}) []byte {
+	var key []byte
+	for i, c := range idx.columns {
+		key = appendKeyValue(key, row[c+1], idx.colls[i], idx.desc[i])
+	}
+	return key
+}
+
+// appendKeyValue appends v to an index key, a number is its float64 and
+// the difference of an integer from it, so integers the float64 rounds
+// to one key stay apart. Text, keyed by coll, and blobs end with 0x00
+// 0x01 after 0x00 is escaped as 0x00 0xFF. The bytes of a descending
+// column are inverted.
+func appendKeyValue(key []byte, v interface{}, coll *collation, desc bool) []byte {
+	start := len(key)
+	switch v := v.(type) {
+	case nil:
+		key = append(key, keyNull)
+	case int64, float64:
+		f := toFloat(v)
+		if f == 0 {
+			// -0 is 0
+			f = 0
+		}
+		bits := math.Float64bits(f)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:], bits)
+		if n, ok := v.(int64); ok {
+			binary.BigEndian.PutUint64(b[8:], uint64(intRounding(n, f))^1<<63)
+		} else {
+			binary.BigEndian.PutUint64(b[8:], 1<<63)
+		}
+		key = append(append(key, keyNumber), b[:]...)
+	case string:
+		key = appendKeyBytes(append(key, keyText), []byte(coll.text(v)))
+	case []byte:
+		key = appendKeyBytes(append(key, keyBlob), v)
+	}
+	if desc {
+		for i := start; i < len(key); i++ {
+			key[i] = ^key[i]
+		}
+	}
+	return key
+}
+
+func appendKeyBytes(key []byte, b []byte) []byte {
+	for _, c := range b {
+		if c == 0 {
+			key = append(key, 0, 0xFF)
+		} else {
+			key = append(key, c)
+		}
+	}
+	return append(key, 0, 1)
+}
+
+// keySuccessor returns the smallest key above every key starting with
+// key, nil when there is none.
+func keySuccessor(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		if key[i] != 0xFF {
+			succ := append([]byte(nil), key[:i+1]...)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
+
+// indexEntry returns the entry of a row in an index, its key followed by
+// its rowid.
+func indexEntry(key []byte, rowid int64) []byte {
+	entry := make([]byte, len(key)+8)
+	copy(entry, key)
+	binary.BigEndian.PutUint64(entry[len(key):], uint64(rowid))
+	return entry
+}
+
+func entryRowID(entry []byte) int64 {
+	return int64(binary.BigEndian.Uint64(entry[len(entry)-8:]))
+}
+
+func entryKey(entry []byte) []byte {
+	return entry[:len(entry)-8]
+}
+
+// indexTree is the view of an index in the index tree of the trx context,
+// where its entries start with prefix, the uvarint of the index id. The
+// marker entry, prefix, 0x00 and the key format followed by the
+// definition of the index, tells the entries were built for it. The
+// entries which may be stale are kept too, after 0x00, so they are
+// checked again after a reopen.
+type indexTree struct {
+	ctx    *TrxContext
+	table  *tableInfo
+	index  *indexInfo
+	prefix []byte
+}
+
+func (t *indexTree) key(entry []byte) []byte {
+	return append(append([]byte(nil), t.prefix...), entry...)
+}
+
+func (t *indexTree) staleKey(entry []byte) []byte {
+	return append(append([]byte{0}, t.prefix...), entry...)
+}
+
+// marker returns the marker entry of the index
+func (t *indexTree) marker() []byte {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(t.table.id))
+	marker := append(append(t.key([]byte{0, keyFormat}), id[:]...), t.index.name...)
+	return append(append(marker, 0), t.index.sql...)
+}
+
+// add to add entry, returns false when it is in the tree
+func (t *indexTree) add(entry []byte) bool {
+	return t.ctx.addIndexEntry(t.key(entry))
+}
+
+// remove to remove entry from the tree
+func (t *indexTree) remove(entry []byte) {
+	t.ctx.removeIndexEntry(t.key(entry))
+}
+
+// has returns whether entry is in the tree
+func (t *indexTree) has(entry []byte) bool {
+	return t.ctx.hasIndexEntry(t.key(entry))
+}
+
+// entries returns the entries in [from, to) in order, to is nil for no
+// bound.
+func (t *indexTree) entries(from, to []byte) [][]byte {
+	if len(from) == 0 {
+		// above the marker
+		from = []byte{keyNull}
+	}
+	end := keySuccessor(t.prefix)
+	if to != nil {
+		end = t.key(to)
+	}
+	entries := t.ctx.indexEntries(t.key(from), end)
+	for i, e := range entries {
+		entries[i] = e[len(t.prefix):]
+	}
+	return entries
+}
+
+// keep to keep entry to check after a reopen, release when it is checked
+func (t *indexTree) keep(entry []byte) {
+	t.ctx.addIndexEntry(t.staleKey(entry))
+}
+
+func (t *indexTree) release(entry []byte) {
+	t.ctx.removeIndexEntry(t.staleKey(entry))
+}
+
+// dropIndexEntries to remove the entries of the index with prefix
+func dropIndexEntries(ctx *TrxContext, prefix []byte) {
+	ctx.removeIndexEntries(prefix, keySuccessor(prefix))
+	stale := append([]byte{0}, prefix...)
+	ctx.removeIndexEntries(stale, keySuccessor(stale))
+}
+
+// addIndexEntry to add key to the index tree, returns false when it is
+// in the tree
+func (context *TrxContext) addIndexEntry(key []byte) bool {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	return context.indexTree.insertKey(key)
+}
+
+// removeIndexEntry to remove key from the index tree
+func (context *TrxContext) removeIndexEntry(key []byte) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.indexTree.deleteKey(key)
+}
+
+// hasIndexEntry returns whether key is in the index tree
+func (context *TrxContext) hasIndexEntry(key []byte) bool {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	return context.indexTree.hasKey(key)
+}
+
+// indexEntries returns copies of the keys in [from, to) of the index
+// tree in order, to is nil for no bound.
+func (context *TrxContext) indexEntries(from, to []byte) [][]byte {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	keys := make([][]byte, 0)
+	for c := context.indexTree.seekKey(from); c.Valid(); c.Next() {
+		key := c.Payload()
+		if to != nil && bytes.Compare(key, to) >= 0 {
+			break
+		}
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	return keys
+}
+
+// removeIndexEntries to remove the keys in [from, to) of the index tree,
+// to is nil for no bound.
+func (context *TrxContext) removeIndexEntries(from, to []byte) {
+	keys := context.indexEntries(from, to)
+
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	for _, key := range keys {
+		context.indexTree.deleteKey(key)
+	}
+}
+
+// indexIDs returns the ids of the indexes with entries in the index tree
+// and the stale entries of each
+func (context *TrxContext) indexIDs() (map[int64]bool, map[int64][][]byte) {
+	ids := make(map[int64]bool)
+	stale := make(map[int64][][]byte)
+	for _, key := range context.indexEntries([]byte{0}, []byte{1}) {
+		id, n := binary.Uvarint(key[1:])
+		if n > 0 {
+			stale[int64(id)] = append(stale[int64(id)], key[1+n:])
+		}
+	}
+
+	from := []byte{1}
+	for {
+		keys := context.indexFirst(from)
+		if keys == nil {
+			return ids, stale
+		}
+		id, n := binary.Uvarint(keys)
+		if n <= 0 {
+			return ids, stale
+		}
+		ids[int64(id)] = true
+		if from = keySuccessor(keys[:n]); from == nil {
+			return ids, stale
+		}
+	}
+}
+
+// indexFirst returns a copy of the smallest key of the index tree not
+// below from, nil if none
+func (context *TrxContext) indexFirst(from []byte) []byte {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	if c := context.indexTree.seekKey(from); c.Valid() {
+		return append([]byte(nil), c.Payload()...)
+	}
+	return nil
+}
+
+// staleEntry is an entry which may no longer have a row version with its key
+type staleEntry struct {
+	tree  *indexTree
+	entry []byte
+}
+
+// indexSet holds the indexes of a DB. The entries of an index are built
+// from the row versions when it is first used and every write to a table
+// goes through write, so the index has the entries of all versions.
+// Readers check an entry against the version they see. The entries the
+// versions lost are removed once the trx writing them ended.
+type indexSet struct {
+	mu    sync.Mutex
+	ctx   *TrxContext
+	trees map[int64]*indexTree
+	// written are the entries a trx added or took the version of, they
+	// are checked when the trx ends
+	written map[int64][]staleEntry
+	stale   []staleEntry
+	// pending are the entries kept to check in the file, by index id,
+	// until the index is used
+	pending map[int64][][]byte
+}
+
+// newIndexSet returns the indexes of ctx, the entries of the indexes
+// dropped since the file was written are removed.
+func newIndexSet(ctx *TrxContext) *indexSet {
+	s := &indexSet{ctx: ctx, trees: make(map[int64]*indexTree), written: make(map[int64][]staleEntry)}
+	ids, pending := ctx.indexIDs()
+	for id := range pending {
+		ids[id] = true
+	}
+	for id := range ids {
+		if versions, _ := ctx.versions(nil, masterTable.rowID(id)); len(versions) == 0 {
+			dropIndexEntries(ctx, binary.AppendUvarint(nil, uint64(id)))
+			delete(pending, id)
+		}
+	}
+	s.pending = pending
+	ctx.OnAfterCommit(func(info TxInfo) { s.ended(info.TrxID) })
+	ctx.OnRollback(func(info TxInfo) { s.ended(info.TrxID) })
+	return s
+}
+
+// tree returns the tree of index idx of table, whose entries are built
+// from the versions of the table rows unless the file has them.
+func (s *indexSet) tree(table *tableInfo, idx *indexInfo) *indexTree {
+	if t := s.trees[idx.id]; t != nil && t.table.id == table.id && t.index.name == idx.name && t.index.sql == idx.sql {
+		return t
+	}
+
+	t := &indexTree{ctx: s.ctx, table: table, index: idx, prefix: binary.AppendUvarint(nil, uint64(idx.id))}
+	marker := t.marker()
+	if s.ctx.hasIndexEntry(marker) {
+		for _, entry := range s.pending[idx.id] {
+			s.stale = append(s.stale, staleEntry{t, entry})
+		}
+	} else {
+		dropIndexEntries(s.ctx, t.prefix)
+		type version struct {
+			entry []byte
+			head  bool
+		}
+		versions := make([]version, 0)
+		from, to := table.rowRange()
+		s.ctx.eachVersion(from, to, func(rowID int64, data []byte, head bool) {
+			rowid := rowID - table.id<<tableShift
+			row, err := decodeTableRow(table, rowid, data)
+			if err != nil {
+				return
+			}
+			versions = append(versions, version{indexEntry(idx.key(row), rowid), head})
+		})
+		for _, v := range versions {
+			if t.add(v.entry) && !v.head {
+				t.keep(v.entry)
+				s.stale = append(s.stale, staleEntry{t, v.entry})
+			}
+		}
+		s.ctx.addIndexEntry(marker)
+	}
+	delete(s.pending, idx.id)
+	s.trees[idx.id] = t
+	return t
+}
+
+// has returns whether the tree of index idx of table has entry
+func (s *indexSet) has(table *tableInfo, idx *indexInfo, entry []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tree(table, idx).has(entry)
+}
+
+// tableTrees returns the trees of the indexes of the table with id,
+// including the indexes other trx created which table does not have.
+func (s *indexSet) tableTrees(table *tableInfo) []*indexTree {
+	for _, idx := range table.indexes {
+		s.tree(table, idx)
+	}
+	trees := make([]*indexTree, 0)
+	for _, t := range s.trees {
+		if t.table.id == table.id {
+			trees = append(trees, t)
+		}
+	}
+	return trees
+}
+
+// write runs fn which writes data as the version of row rowid of table
+// in trx, nil for a delete, and adds the entries of the version to the
+// trees of the table. The UNIQUE indexes of table are checked first.
+func (s *indexSet) write(trx *Trx, table *tableInfo, rowid int64, data []byte, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trees := s.tableTrees(table)
+	if len(trees) == 0 {
+		return fn()
+	}
+
+	var row, old []interface{}
+	var err error
+	if data != nil {
+		if row, err = decodeTableRow(table, rowid, data); err != nil {
+			return err
+		}
+		for _, idx := range table.indexes {
+			if idx.unique {
+				if err = s.unique(trx, s.trees[idx.id], rowid, row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if versions, _ := s.ctx.versions(nil, table.rowID(rowid)); len(versions) > 0 && versions[0] != nil {
+		if old, err = decodeTableRow(table, rowid, versions[0]); err != nil {
+			return err
+		}
+	}
+	if err = fn(); err != nil {
+		return err
+	}
+
+	written := s.written[trx.trxID]
+	for _, t := range trees {
+		var entry []byte
+		if row != nil {
+			entry = indexEntry(t.index.key(row), rowid)
+			if t.add(entry) {
+				t.keep(entry)
+				written = append(written, staleEntry{t, entry})
+			}
+		}
+		if old != nil {
+			if e := indexEntry(t.index.key(old), rowid); !bytes.Equal(e, entry) {
+				t.keep(e)
+				written = append(written, staleEntry{t, e})
+			}
+		}
+	}
+	s.written[trx.trxID] = written
+	return nil
+}
+
+// unique checks that no other row has the values of row in the columns
+// of the UNIQUE index of t, NULL values are distinct. A row whose newest
+// version trx can not see is a write conflict when a version has them.
+func (s *indexSet) unique(trx *Trx, t *indexTree, rowid int64, row []interface{}) error {
+	idx := t.index
+	for _, c := range idx.columns {
+		if row[c+1] == nil {
+			return nil
+		}
+	}
+
+	key := idx.key(row)
+	for _, entry := range t.entries(key, keySuccessor(key)) {
+		other := entryRowID(entry)
+		if other == rowid {
+			continue
+		}
+		versions, visible := s.ctx.versions(trx, t.table.rowID(other))
+		for i, data := range versions {
+			if visible && i > 0 {
+				break
+			}
+			if data == nil {
+				continue
+			}
+			values, err := decodeTableRow(t.table, other, data)
+			if err != nil {
+				return err
+			}
+			if sameKey(idx, row, values) {
+				if visible {
+					return uniqueError(t.table, idx)
+				}
+				return ErrWriteConflict
+			}
+		}
+	}
+	return nil
+}
+
+// sameKey returns whether the rows a and b have equal values in the
+// columns of idx
+func sameKey(idx *indexInfo, a, b []interface{}) bool {
+	for i, c := range idx.columns {
+		if idx.colls[i].compare(a[c+1], b[c+1]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueError reports a violated UNIQUE index of table
+func uniqueError(table *tableInfo, idx *indexInfo) error {
+	names := make([]string, len(idx.columns))
+	for i, c := range idx.columns {
+		names[i] = table.name + "." + table.columns[c].name
+	}
+	return fmt.Errorf("UNIQUE constraint failed: %s", strings.Join(names, ", "))
+}
+
+// entries returns the entries in [from, to) of index idx of table
+func (s *indexSet) entries(table *tableInfo, idx *indexInfo, from, to []byte) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tree(table, idx).entries(from, to)
+}
+
+// ended to check the entries written by trx once it committed or rolled back
+func (s *indexSet) ended(trxID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.written[trxID] {
+		// a sweep since the write may have released it
+		e.tree.keep(e.entry)
+		s.stale = append(s.stale, e)
+	}
+	delete(s.written, trxID)
+	s.sweep()
+}
+
+// sweep removes the stale entries no row version has the key of, an
+// entry of the newest version is not stale. The entries of the indexes
+// without a row in sqlite_master are dropped.
+func (s *indexSet) sweep() {
+	for id, t := range s.trees {
+		if versions, _ := s.ctx.versions(nil, masterTable.rowID(id)); len(versions) == 0 {
+			dropIndexEntries(s.ctx, t.prefix)
+			delete(s.trees, id)
+		}
+	}
+
+	stale := s.stale[:0]
+	for _, e := range s.stale {
+		t := e.tree
+		if s.trees[t.index.id] != t {
+			continue
+		}
+		rowid := entryRowID(e.entry)
+		versions, _ := s.ctx.versions(nil, t.table.rowID(rowid))
+		used := -1
+		for i, data := range versions {
+			if data == nil {
+				continue
+			}
+			row, err := decodeTableRow(t.table, rowid, data)
+			if err == nil && bytes.Equal(t.index.key(row), entryKey(e.entry)) {
+				used = i
+				break
+			}
+		}
+		if used > 0 {
+			// an older version may be read until it is purged
+			stale = append(stale, e)
+			continue
+		}
+		if used < 0 {
+			t.remove(e.entry)
+		}
+		t.release(e.entry)
+	}
+	for i := len(stale); i < len(s.stale); i++ {
+		s.stale[i] = staleEntry{}
+	}
+	s.stale = stale
+}
+
+// indexRange is the range of an index a scan reads, the keys start with
+// eq values and the next column is bounded by loOp and hiOp when set.
+// affs are the affinities the values are compared by.
+type indexRange struct {
+	index      *indexInfo
+	eq         int
+	loOp, hiOp string
+	affs       []affinity
+}
+
+// bounds returns the entries [from, to) with the values of the range,
+// the eq values followed by the lo and hi bounds that are set. ok is
+// false when a value is NULL, which no comparison is true for.
+func (r *indexRange) bounds(values []interface{}) (from, to []byte, ok bool) {
+	converted := make([]interface{}, len(values))
+	for i, v := range values {
+		if v == nil {
+			return nil, nil, false
+		}
+		converted[i] = r.affs[i].apply(v)
+	}
+	values = converted
+
+	prefix := make([]byte, 0)
+	for i, v := range values[:r.eq] {
+		prefix = appendKeyValue(prefix, v, r.index.colls[i], r.index.desc[i])
+	}
+	from, to = prefix, keySuccessor(prefix)
+	if r.loOp == "" && r.hiOp == "" {
+		return from, to, true
+	}
+
+	coll, desc := r.index.colls[r.eq], r.index.desc[r.eq]
+	bound := func(v interface{}, op string) {
+		key := appendKeyValue(append([]byte(nil), prefix...), v, coll, desc)
+		// a lower bound of the values is an upper bound of the keys of a
+		// descending column
+		switch lower := strings.HasPrefix(op, ">") != desc; {
+		case lower && (op == ">=" || op == "<="):
+			from = key
+		case lower:
+			from = keySuccessor(key)
+		case op == ">=" || op == "<=":
+			to = keySuccessor(key)
+		default:
+			to = key
+		}
+	}
+	values = values[r.eq:]
+	if r.loOp != "" {
+		bound(values[0], r.loOp)
+		values = values[1:]
+	} else {
+		// NULL is below every bound
+		null := appendKeyValue(append([]byte(nil), prefix...), nil, coll, desc)
+		if desc {
+			to = null
+		} else {
+			from = keySuccessor(null)
+		}
+	}
+	if r.hiOp != "" {
+		bound(values[0], r.hiOp)
+	}
+	return from, to, true
+}
+
+// indexCursor reads the rows of a table through the entries of an index
+// in their order, a row is read when the version the trx sees still has
+// the key of the entry.
+type indexCursor struct {
+	env     *execEnv
+	table   *tableInfo
+	index   *indexInfo
+	entries [][]byte
+}
+
+// seekIndex returns a cursor on the rows of table in the range r of an
+// index with values.
+func (env *execEnv) seekIndex(table *tableInfo, r *indexRange, values []interface{}) *indexCursor {
+	c := &indexCursor{env: env, table: table, index: r.index}
+	if from, to, ok := r.bounds(values); ok {
+		c.entries = env.db.indexes.entries(table, r.index, from, to)
+	}
+	return c
+}
+
+func (c *indexCursor) next() ([]interface{}, bool, error) {
+	for len(c.entries) > 0 {
+		entry := c.entries[0]
+		c.entries = c.entries[1:]
+		rowid := entryRowID(entry)
+		row, ok, err := c.env.trx.Get(c.env.db.ctx, c.table.rowID(rowid))
+		if err != nil {
+			return nil, false, err
+		} else if !ok {
+			continue
+		}
+		values, err := decodeTableRow(c.table, rowid, row.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		if bytes.Equal(c.index.key(values), entryKey(entry)) {
+			return values, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// indexScan returns the rows of a table in a range of an index, the
+// values of the range are evaluated at open.
+type indexScan struct {
+	env    *execEnv
+	table  *tableInfo
+	rng    *indexRange
+	values []evalFunc
+	cursor *indexCursor
+}
+
+func (s *indexScan) open() error {
+	values := make([]interface{}, len(s.values))
+	for i, value := range s.values {
+		v, err := value(nil)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	s.cursor = s.env.seekIndex(s.table, s.rng, values)
+	return nil
+}
+
+func (s *indexScan) next() ([]interface{}, bool, error) {
+	return s.cursor.next()
+}
+
+func (s *indexScan) close() {
+	s.cursor = nil
+}
+
+// columnOf returns the column of the table of scope s that e refers to,
+// -1 if e is not a column or is the rowid. A COLLATE of a column is the
+// column.
+func columnOf(e sql.Expr, s *scope) int {
+	if c, ok := e.(*sql.CollateExpr); ok {
+		e = c.X
+	}
+	ref, ok := e.(*sql.ColumnRef)
+	if !ok {
+		return -1
+	}
+	i, err := s.resolve(ref)
+	if err != nil {
+		return -1
+	}
+	return i - 1
+}
+
+// matchIndex returns the range of idx with the most columns equal to
+// the terms and then a bound on the next column, the exprs of the values
+// of the range and the count of the terms it applies. usable tells
+// whether an expr can be evaluated before the scan.
+func matchIndex(idx *indexInfo, s *scope, terms []sql.Expr, usable func(sql.Expr) bool) (*indexRange, []sql.Expr, int) {
+	r := &indexRange{index: idx}
+	exprs := make([]sql.Expr, 0)
+	for r.eq < len(idx.columns) {
+		e, aff := findTerm(s, terms, idx, r.eq, "=", usable)
+		if e == nil {
+			break
+		}
+		exprs = append(exprs, e)
+		r.affs = append(r.affs, aff)
+		r.eq++
+	}
+	used := r.eq
+	if r.eq < len(idx.columns) {
+		var lo, hi sql.Expr
+		var loAff, hiAff affinity
+		for _, op := range []string{">", ">="} {
+			if lo, loAff = findTerm(s, terms, idx, r.eq, op, usable); lo != nil {
+				r.loOp = op
+				used++
+				break
+			}
+		}
+		for _, op := range []string{"<", "<="} {
+			if hi, hiAff = findTerm(s, terms, idx, r.eq, op, usable); hi != nil {
+				r.hiOp = op
+				used++
+				break
+			}
+		}
+		for _, t := range terms {
+			b, ok := t.(*sql.BetweenExpr)
+			if !ok || lo != nil || hi != nil || b.Not || columnOf(b.X, s) != idx.columns[r.eq] ||
+				!usable(b.Low) || !usable(b.High) {
+				continue
+			}
+			low, lowOK := indexable(s, idx, r.eq, b.X, b.Low, b.Low)
+			high, highOK := indexable(s, idx, r.eq, b.X, b.High, b.High)
+			if lowOK && highOK {
+				lo, hi, r.loOp, r.hiOp, loAff, hiAff = b.Low, b.High, ">=", "<=", low, high
+				used++
+			}
+		}
+		if lo != nil {
+			exprs = append(exprs, lo)
+			r.affs = append(r.affs, loAff)
+		}
+		if hi != nil {
+			exprs = append(exprs, hi)
+			r.affs = append(r.affs, hiAff)
+		}
+	}
+	return r, exprs, used
+}
+
+// findTerm returns the usable expr a term compares column i of idx with
+// by op, as column op expr or expr op column, and the affinity of the
+// comparison.
+func findTerm(s *scope, terms []sql.Expr, idx *indexInfo, i int, op string, usable func(sql.Expr) bool) (sql.Expr, affinity) {
+	column := idx.columns[i]
+	for _, t := range terms {
+		e, ok := t.(*sql.BinaryExpr)
+		if !ok {
+			continue
+		}
+		var other sql.Expr
+		switch {
+		case e.Op == op && columnOf(e.X, s) == column && usable(e.Y):
+			other = e.Y
+		case swapped[e.Op] == op && columnOf(e.Y, s) == column && usable(e.X):
+			other = e.X
+		default:
+			continue
+		}
+		if aff, ok := indexable(s, idx, i, e.X, e.Y, other); ok {
+			return other, aff
+		}
+	}
+	return nil, affinityNone
+}
+
+// indexable returns the affinity of the comparison x op y of column i of
+// idx with other, ok is false when the comparison converts the values of
+// the column or compares text by another collation than the index.
+func indexable(s *scope, idx *indexInfo, i int, x, y, other sql.Expr) (aff affinity, ok bool) {
+	column := s.columns[idx.columns[i]+1].aff
+	aff = compareAffinity(column, exprAffinity(other, s))
+	coll, err := comparisonCollation(x, y, s)
+	return aff, err == nil && coll == idx.colls[i] && (column.numeric() || !aff.numeric())
+}
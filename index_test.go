@@ -0,0 +1,273 @@
+package gosqlite_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+func TestIndexConflict(t *testing.T) {
+	db := gosqlite.CreateDB()
+	a, b := db.Conn(), db.Conn()
+	if _, err := a.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, code TEXT UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Begin(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Exec("INSERT INTO t (code) VALUES ('x')"); err != nil {
+		t.Fatal(err)
+	}
+	// the uncommitted value is a conflict until the trx ends
+	if _, err := b.Exec("INSERT INTO t (code) VALUES ('x')"); err != gosqlite.ErrWriteConflict {
+		t.Fatalf("insert of an uncommitted value: %v", err)
+	}
+	if err := a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Exec("INSERT INTO t (code) VALUES ('x')"); err == nil || err.Error() != "UNIQUE constraint failed: t.code" {
+		t.Fatalf("insert of a committed value: %v", err)
+	}
+
+	// a value rolled back is free again
+	a.Begin(context.Background(), false)
+	if _, err := a.Exec("UPDATE t SET code = 'y'"); err != nil {
+		t.Fatal(err)
+	}
+	a.Rollback()
+	if _, err := b.Exec("INSERT INTO t (code) VALUES ('y')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryRows(b, "SELECT id, code FROM t WHERE code >= 'x' ORDER BY code")
+	if err != nil || fmt.Sprint(rows) != "[1 x 2 y]" {
+		t.Fatalf("%v %v", rows, err)
+	}
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	for _, vm := range []bool{false, true} {
+		db := gosqlite.CreateDB()
+		a, b := db.Conn(), db.Conn()
+		a.UseVM(vm)
+		if _, err := a.Exec("CREATE TABLE t (v); CREATE INDEX t_v ON t (v); INSERT INTO t VALUES (1), (2)"); err != nil {
+			t.Fatal(err)
+		}
+
+		a.Begin(context.Background(), true)
+		if _, err := b.Exec("UPDATE t SET v = v + 10; INSERT INTO t VALUES (1)"); err != nil {
+			t.Fatal(err)
+		}
+		// the snapshot reads the old versions through their entries
+		for query, want := range map[string]string{
+			"SELECT rowid FROM t WHERE v = 1":  "[1]",
+			"SELECT rowid FROM t WHERE v > 10": "[]",
+		} {
+			if rows, err := queryRows(a, query); err != nil || fmt.Sprint(rows) != want {
+				t.Fatalf("vm %v: %s: %v %v", vm, query, rows, err)
+			}
+		}
+		a.Commit()
+
+		for query, want := range map[string]string{
+			"SELECT rowid FROM t WHERE v = 1":  "[3]",
+			"SELECT rowid FROM t WHERE v > 10": "[1 2]",
+		} {
+			if rows, err := queryRows(a, query); err != nil || fmt.Sprint(rows) != want {
+				t.Fatalf("vm %v: %s: %v %v", vm, query, rows, err)
+			}
+		}
+	}
+}
+
+func TestIndexUpdates(t *testing.T) {
+	conn := gosqlite.CreateDB().Conn()
+	if _, err := conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v UNIQUE); INSERT INTO t VALUES (1, 0), (2, -1)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 300; i++ {
+		if _, err := conn.Exec(fmt.Sprintf("UPDATE t SET v = %d WHERE id = 1", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := queryRows(conn, "SELECT count(*) FROM t WHERE v >= 0")
+	if err != nil || fmt.Sprint(rows) != "[1]" {
+		t.Fatalf("%v %v", rows, err)
+	}
+	if _, err = conn.Exec("UPDATE t SET v = 300 WHERE id = 2"); err == nil {
+		t.Fatal("a duplicate of the last value is accepted")
+	}
+	if _, err = conn.Exec("UPDATE t SET v = 299 WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db, err := gosqlite.OpenDB(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Conn().Exec("CREATE TABLE t (a, b); CREATE UNIQUE INDEX t_ab ON t (a, b); INSERT INTO t VALUES (1, 'x'), (1, 'y'), (2, 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// the entries are read from the file
+	if db, err = gosqlite.OpenDB(fileName); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn := db.Conn()
+	rows, err := queryRows(conn, "SELECT b FROM t WHERE a = 1")
+	if err != nil || fmt.Sprint(rows) != "[x y]" {
+		t.Fatalf("%v %v", rows, err)
+	}
+	if _, err = conn.Exec("INSERT INTO t VALUES (2, 'x')"); err == nil || err.Error() != "UNIQUE constraint failed: t.a, t.b" {
+		t.Fatalf("duplicate after reopen: %v", err)
+	}
+}
+
+func TestIndexStored(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db, err := gosqlite.OpenDB(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := db.Conn()
+	if _, err = conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, a); CREATE INDEX t_a ON t (a); INSERT INTO t VALUES (1, 'x'), (2, 'y')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryRows(conn, "SELECT rowid FROM sqlite_master WHERE name = 't'")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("%v %v", rows, err)
+	}
+	var id int64
+	fmt.Sscan(rows[0], &id)
+	db.Close()
+	if info, err := gosqlite.ReadStoreInfo(fileName); err != nil || info.IndexPages == 0 {
+		t.Fatalf("store info %+v %v", info, err)
+	}
+
+	// the entries are read from the file, so a version written below the
+	// SQL layer has none
+	if db, err = gosqlite.OpenDB(fileName); err != nil {
+		t.Fatal(err)
+	}
+	conn = db.Conn()
+	data, err := gosqlite.EncodeRecord([]interface{}{nil, "z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := db.TrxContext()
+	trx := ctx.AllocteTrx()
+	trx.Begin(ctx)
+	if err = trx.Update(ctx, id<<40|1, string(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := conn.IntegrityCheck(context.Background())
+	if err != nil || fmt.Sprint(problems) != "[row 1 missing from index t_a]" {
+		t.Fatalf("stored index: %v %v", problems, err)
+	}
+
+	// crash: the rows recovered from the log are indexed again
+	if db, err = gosqlite.OpenDB(fileName); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn = db.Conn()
+	if problems, err = conn.IntegrityCheck(context.Background()); err != nil || len(problems) != 0 {
+		t.Fatalf("recovered index: %v %v", problems, err)
+	}
+	if rows, err = queryRows(conn, "SELECT id FROM t WHERE a = 'z'"); err != nil || fmt.Sprint(rows) != "[1]" {
+		t.Fatalf("%v %v", rows, err)
+	}
+}
+
+func TestIndexLongKeys(t *testing.T) {
+	db, err := gosqlite.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn := db.Conn()
+	if _, err = conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, a); CREATE INDEX t_a ON t (a)"); err != nil {
+		t.Fatal(err)
+	}
+	// keys share their first bytes and some go to overflow pages
+	r := rand.New(rand.NewSource(1))
+	want := make(map[int]string)
+	for i := 0; i < 800; i++ {
+		id := 1 + r.Intn(200)
+		if _, ok := want[id]; ok && r.Intn(3) == 0 {
+			if _, err = conn.Exec(fmt.Sprintf("DELETE FROM t WHERE id = %d", id)); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, id)
+			continue
+		}
+		a := fmt.Sprintf("customer-%s%06d", strings.Repeat("x", r.Intn(2)*r.Intn(400)), r.Intn(1000))
+		text := fmt.Sprintf("INSERT INTO t VALUES (%d, '%s')", id, a)
+		if _, ok := want[id]; ok {
+			text = fmt.Sprintf("UPDATE t SET a = '%s' WHERE id = %d", a, id)
+		}
+		if _, err = conn.Exec(text); err != nil {
+			t.Fatal(err)
+		}
+		want[id] = a
+	}
+	if err = db.TrxContext().Flush(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := conn.IntegrityCheck(context.Background())
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("%v %v", problems, err)
+	}
+
+	values := make([]string, 0, len(want))
+	for _, a := range want {
+		values = append(values, a)
+	}
+	sort.Strings(values)
+	rows, err := queryRows(conn, "SELECT a FROM t WHERE a > 'customer-' ORDER BY a")
+	if err != nil || fmt.Sprint(rows) != fmt.Sprint(values) {
+		t.Fatalf("%d rows, want %d: %v", len(rows), len(values), err)
+	}
+}
+
+func TestIndexSharedPrefix(t *testing.T) {
+	insert := func(n int) time.Duration {
+		conn := gosqlite.CreateDB().Conn()
+		if _, err := conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name); CREATE INDEX t_name ON t (name)"); err != nil {
+			t.Fatal(err)
+		}
+		stmt, err := conn.Prepare("INSERT INTO t (name) VALUES (?)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+		started := time.Now()
+		for i := 0; i < n; i++ {
+			if _, err = stmt.Exec(fmt.Sprintf("customer-%06d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return time.Since(started)
+	}
+
+	// an insert takes about the same time in a larger index
+	small, large := insert(1000), insert(8000)
+	if large > 24*small {
+		t.Fatalf("1000 rows in %v, 8000 rows in %v", small, large)
+	}
+}
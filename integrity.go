@@ -0,0 +1,106 @@
+package gosqlite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// IntegrityCheck returns the problems found in the database, worded as
+// PRAGMA integrity_check of SQLite words them, none when it is sound. It
+// checks the trees of the store file and, in the trx of the connection
+// or else a read-only one, the schema, the NOT NULL columns and that the
+// indexes have an entry for every row.
+func (c *Conn) IntegrityCheck(ctx context.Context) ([]string, error) {
+	problems := c.db.ctx.checkTrees()
+	trx, done := c.begin(ctx, true)
+	found, err := c.db.check(trx)
+	if err = done(err); err != nil {
+		return nil, err
+	}
+	return append(problems, found...), nil
+}
+
+// checkTrees returns the problems of the row, undo and index trees, none
+// for a trx context in memory
+func (context *TrxContext) checkTrees() []string {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	problems := make([]string, 0)
+	if context.fileName == "" {
+		return problems
+	}
+	for _, p := range context.rowTree.check() {
+		problems = append(problems, "row tree: "+p)
+	}
+	for _, p := range context.undoTree.check() {
+		problems = append(problems, "undo tree: "+p)
+	}
+	for _, p := range context.indexTree.check() {
+		problems = append(problems, "index tree: "+p)
+	}
+	return problems
+}
+
+// check returns the problems of the tables visible to trx, a schema
+// that does not parse is one
+func (db *DB) check(trx *Trx) ([]string, error) {
+	problems := make([]string, 0)
+	tables, err := db.schema(trx)
+	if err != nil {
+		return append(problems, err.Error()), nil
+	}
+	infos := make([]*tableInfo, 0, len(tables))
+	for _, info := range tables {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].id < infos[j].id })
+
+	for _, info := range infos {
+		// the keys of the UNIQUE indexes seen so far
+		keys := make([]map[string]bool, len(info.indexes))
+		for i := range keys {
+			keys[i] = make(map[string]bool)
+		}
+		from, to := info.rowRange()
+		rows := trx.cursor(db.ctx, from, to)
+		for {
+			r, ok, err := rows.next()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+
+			rowid := r.RowID - info.id<<tableShift
+			row, err := decodeTableRow(info, rowid, r.Data)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("row %d of %s: %v", rowid, info.name, err))
+				continue
+			}
+			for i, c := range info.columns {
+				if c.notNull && row[i+1] == nil {
+					problems = append(problems, fmt.Sprintf("NULL value in %s.%s", info.name, c.name))
+				}
+			}
+			for i, idx := range info.indexes {
+				key := idx.key(row)
+				if !db.indexes.has(info, idx, indexEntry(key, rowid)) {
+					problems = append(problems, fmt.Sprintf("row %d missing from index %s", rowid, idx.name))
+				}
+				values := make([]interface{}, 0, len(idx.columns))
+				for _, c := range idx.columns {
+					values = append(values, row[c+1])
+				}
+				if idx.unique && !hasNull(values) {
+					if keys[i][string(key)] {
+						problems = append(problems, fmt.Sprintf("non-unique entry in index %s", idx.name))
+					}
+					keys[i][string(key)] = true
+				}
+			}
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,70 @@
+package gosqlite_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "check.db")
+	db, err := gosqlite.OpenDB(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn := db.Conn()
+	values := ""
+	for i := 1; i <= 200; i++ {
+		values += fmt.Sprintf(", (%d, 'n%d')", i, i)
+	}
+	if _, err = conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, a NOT NULL, b TEXT UNIQUE); CREATE INDEX t_a ON t (a)" +
+		"; INSERT INTO t (a, b) VALUES " + values[2:] + "; DELETE FROM t WHERE id % 3 = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.TrxContext().Flush(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := conn.IntegrityCheck(context.Background())
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("sound database: %v %v", problems, err)
+	}
+
+	info, err := gosqlite.ReadStoreInfo(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PageSize != 512 || info.RowPages == 0 || info.UndoPages == 0 || info.MaxTrxID == 0 {
+		t.Fatalf("store info %+v", info)
+	}
+
+	// a version written below the SQL layer skips the constraints and the
+	// indexes
+	rows, err := queryRows(conn, "SELECT rowid FROM sqlite_master WHERE name = 't'")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("%v %v", rows, err)
+	}
+	var id int64
+	fmt.Sscan(rows[0], &id)
+	data, err := gosqlite.EncodeRecord([]interface{}{nil, nil, "n2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := db.TrxContext()
+	trx := ctx.AllocteTrx()
+	trx.Begin(ctx)
+	if err = trx.Update(ctx, id<<40|1, string(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err = conn.IntegrityCheck(context.Background())
+	want := "[NULL value in t.a row 1 missing from index sqlite_autoindex_t_1 row 1 missing from index t_a non-unique entry in index sqlite_autoindex_t_1]"
+	if err != nil || fmt.Sprint(problems) != want {
+		t.Fatalf("corrupt database: %v %v", problems, err)
+	}
+}
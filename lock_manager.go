@@ -0,0 +1,298 @@
+package gosqlite
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	lockShared    int8 = 1
+	lockExclusive int8 = 2
+
+	defaultLockTimeout = 50 * time.Second
+)
+
+var (
+	// ErrLockTimeout is returned when a row lock is not granted in time.
+	ErrLockTimeout = errors.New("lock wait timeout")
+	// ErrDeadlock is returned to the trx chosen as deadlock victim, it is rolled back.
+	ErrDeadlock = errors.New("deadlock, trx rolled back")
+)
+
+// lockRequest is a granted or waiting row lock of a trx
+type lockRequest struct {
+	trxID   int64
+	rowID   int64
+	mode    int8
+	granted bool
+	done    chan error
+}
+
+// rowLock holds the granted modes by trx and the requests waiting in order
+type rowLock struct {
+	holders map[int64]int8
+	queue   []*lockRequest
+}
+
+// lockManager grants shared and exclusive row locks, waiting requests form
+// a wait-for graph which is checked for cycles whenever a trx starts waiting.
+type lockManager struct {
+	mu      sync.Mutex
+	locks   map[int64]*rowLock
+	waiting map[int64]*lockRequest
+	held    map[int64]map[int64]bool
+	timeout time.Duration
+}
+
+func newLockManager() *lockManager {
+	lm := new(lockManager)
+	lm.locks = make(map[int64]*rowLock)
+	lm.waiting = make(map[int64]*lockRequest)
+	lm.held = make(map[int64]map[int64]bool)
+	lm.timeout = defaultLockTimeout
+	return lm
+}
+
+func compatible(held int8, mode int8) bool {
+	return held == lockShared && mode == lockShared
+}
+
+// conflicts returns whether a holder other than req's trx blocks req
+func (l *rowLock) conflicts(req *lockRequest) bool {
+	for id, held := range l.holders {
+		if id != req.trxID && !compatible(held, req.mode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (lm *lockManager) grant(l *rowLock, req *lockRequest) {
+	if l.holders[req.trxID] < req.mode {
+		l.holders[req.trxID] = req.mode
+	}
+	if lm.held[req.trxID] == nil {
+		lm.held[req.trxID] = make(map[int64]bool)
+	}
+	lm.held[req.trxID][req.rowID] = true
+	req.granted = true
+}
+
+// grantWaiters grants the requests at the head of the queue that no longer conflict
+func (lm *lockManager) grantWaiters(l *rowLock) {
+	for len(l.queue) > 0 && !l.conflicts(l.queue[0]) {
+		req := l.queue[0]
+		l.queue = l.queue[1:]
+		delete(lm.waiting, req.trxID)
+		lm.grant(l, req)
+		req.done <- nil
+	}
+}
+
+// dequeue removes a waiting request and lets the requests behind it proceed
+func (lm *lockManager) dequeue(req *lockRequest) {
+	l := lm.locks[req.rowID]
+	for i, q := range l.queue {
+		if q == req {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+	delete(lm.waiting, req.trxID)
+	lm.grantWaiters(l)
+}
+
+// waitsFor returns the trx that the waiting trx trxID waits for
+func (lm *lockManager) waitsFor(trxID int64) []int64 {
+	req := lm.waiting[trxID]
+	if req == nil {
+		return nil
+	}
+
+	ids := make([]int64, 0)
+	l := lm.locks[req.rowID]
+	for id, held := range l.holders {
+		if id != trxID && !compatible(held, req.mode) {
+			ids = append(ids, id)
+		}
+	}
+	for _, q := range l.queue {
+		if q == req {
+			break
+		}
+		if q.trxID != trxID && !compatible(q.mode, req.mode) {
+			ids = append(ids, q.trxID)
+		}
+	}
+	return ids
+}
+
+// findCycle returns the trx on a wait-for cycle through start
+func (lm *lockManager) findCycle(start int64) []int64 {
+	path := make([]int64, 0)
+	visited := make(map[int64]bool)
+
+	var dfs func(id int64) []int64
+	dfs = func(id int64) []int64 {
+		visited[id] = true
+		path = append(path, id)
+		for _, next := range lm.waitsFor(id) {
+			if next == start {
+				return append([]int64(nil), path...)
+			}
+			if !visited[next] {
+				if cycle := dfs(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return nil
+	}
+
+	return dfs(start)
+}
+
+// lock to lock rowID for trxID, waits until the lock is granted, the
+// timeout elapses or trxID is chosen as deadlock victim.
+func (lm *lockManager) lock(trxID int64, rowID int64, mode int8) error {
+	lm.mu.Lock()
+	l := lm.locks[rowID]
+	if l == nil {
+		l = &rowLock{holders: make(map[int64]int8)}
+		lm.locks[rowID] = l
+	}
+
+	held := l.holders[trxID]
+	if held >= mode {
+		lm.mu.Unlock()
+		return nil
+	}
+
+	req := &lockRequest{trxID: trxID, rowID: rowID, mode: mode, done: make(chan error, 1)}
+	upgrade := held == lockShared
+	if !l.conflicts(req) && (upgrade || len(l.queue) == 0) {
+		lm.grant(l, req)
+		lm.mu.Unlock()
+		return nil
+	}
+
+	// an upgrade waits before the requests that queued behind its shared lock
+	if upgrade {
+		l.queue = append([]*lockRequest{req}, l.queue...)
+	} else {
+		l.queue = append(l.queue, req)
+	}
+	lm.waiting[trxID] = req
+
+	// the youngest trx of a deadlock is the victim
+	if cycle := lm.findCycle(trxID); cycle != nil {
+		victim := cycle[0]
+		for _, id := range cycle {
+			if id > victim {
+				victim = id
+			}
+		}
+
+		v := lm.waiting[victim]
+		lm.dequeue(v)
+		v.done <- ErrDeadlock
+	}
+	timeout := lm.timeout
+	lm.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-timer.C:
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if req.granted {
+		return nil
+	}
+	select {
+	case err := <-req.done:
+		return err
+	default:
+	}
+	lm.dequeue(req)
+	return ErrLockTimeout
+}
+
+// releaseAll to release the locks of trxID and grant the waiting requests
+func (lm *lockManager) releaseAll(trxID int64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for rowID := range lm.held[trxID] {
+		l := lm.locks[rowID]
+		delete(l.holders, trxID)
+		lm.grantWaiters(l)
+		if len(l.holders) == 0 && len(l.queue) == 0 {
+			delete(lm.locks, rowID)
+		}
+	}
+	delete(lm.held, trxID)
+}
+
+// cancel to end the lock wait of trxID with err
+func (lm *lockManager) cancel(trxID int64, err error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if req := lm.waiting[trxID]; req != nil {
+		lm.dequeue(req)
+		req.done <- err
+	}
+}
+
+// SetLockTimeout to set how long a trx waits for a row lock
+func (context *TrxContext) SetLockTimeout(timeout time.Duration) {
+	context.locks.mu.Lock()
+	defer context.locks.mu.Unlock()
+
+	context.locks.timeout = timeout
+}
+
+func (t *Trx) lock(rowID int64, mode int8) error {
+	if t.ctx == nil {
+		return ErrTrxNotActive
+	}
+
+	t.ctx.mu.RLock()
+	active := t.status == uncommit && !t.committing
+	trxID := t.trxID
+	readOnly := t.readOnly
+	t.ctx.mu.RUnlock()
+	if !active {
+		return ErrTrxNotActive
+	} else if readOnly {
+		return ErrTrxReadOnly
+	}
+
+	err := t.ctx.locks.lock(trxID, rowID, mode)
+	if err == ErrDeadlock {
+		// the caller still holds trx, it is retired like an aborted trx
+		t.rollback(t.id, true)
+	}
+	return err
+}
+
+// LockShared to lock rowID in shared mode until trx finishes
+func (t *Trx) LockShared(rowID int64) error {
+	return t.lock(rowID, lockShared)
+}
+
+// LockExclusive to lock rowID in exclusive mode until trx finishes,
+// a shared lock held by trx is upgraded.
+func (t *Trx) LockExclusive(rowID int64) error {
+	return t.lock(rowID, lockExclusive)
+}
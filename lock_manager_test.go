@@ -0,0 +1,134 @@
+package gosqlite_test
+
+import (
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+func beginTrx(context *gosqlite.TrxContext) *gosqlite.Trx {
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	return trx
+}
+
+func TestLockShared(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetLockTimeout(50 * time.Millisecond)
+	trx1 := beginTrx(context)
+	trx2 := beginTrx(context)
+
+	if err := trx1.LockShared(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := trx2.LockShared(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := trx2.LockExclusive(1); err != gosqlite.ErrLockTimeout {
+		t.Fatalf("upgrade with another reader %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- trx2.LockExclusive(1) }()
+	time.Sleep(10 * time.Millisecond)
+	trx1.Commit()
+	if err := <-done; err != nil {
+		t.Fatalf("upgrade after release %v", err)
+	}
+
+	trx3 := beginTrx(context)
+	if err := trx3.LockShared(1); err != gosqlite.ErrLockTimeout {
+		t.Fatalf("shared lock on exclusive row %v", err)
+	}
+	trx2.Rollback()
+	if err := trx3.LockShared(1); err != nil {
+		t.Fatal(err)
+	}
+	trx3.Commit()
+}
+
+func TestLockWaitQueue(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx1 := beginTrx(context)
+	trx1.LockExclusive(1)
+
+	order := make(chan int, 2)
+	trx2 := beginTrx(context)
+	trx3 := beginTrx(context)
+	go func() {
+		trx2.LockExclusive(1)
+		order <- 2
+		trx2.Commit()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		trx3.LockShared(1)
+		order <- 3
+		trx3.Commit()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	trx1.Commit()
+	if first, second := <-order, <-order; first != 2 || second != 3 {
+		t.Fatalf("granted %d before %d", first, second)
+	}
+}
+
+func TestLockDeadlock(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	trx.Insert(context, "b")
+	trx.Commit()
+
+	trx1 := beginTrx(context)
+	trx2 := beginTrx(context)
+	trx1.LockExclusive(1)
+	trx2.LockExclusive(2)
+	trx2.Update(context, 2, "b2")
+
+	done := make(chan error)
+	go func() { done <- trx1.LockExclusive(2) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// trx2 is younger and closes the cycle, it is the victim
+	if err := trx2.LockExclusive(1); err != gosqlite.ErrDeadlock {
+		t.Fatalf("deadlock %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("trx1 after victim rolled back %v", err)
+	}
+	if err := trx2.Update(context, 1, "x"); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("victim still active %v", err)
+	}
+	if err := trx1.Update(context, 2, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	trx1.Commit()
+
+	if got := snapshot(t, context); got[2] != "b1" {
+		t.Fatalf("rows %v", got)
+	}
+}
+
+func TestLockDeadlockWaitingVictim(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx1 := beginTrx(context)
+	trx2 := beginTrx(context)
+	trx1.LockShared(1)
+	trx2.LockShared(2)
+
+	// trx2 waits first, trx1 closes the cycle, trx2 is younger
+	done := make(chan error)
+	go func() { done <- trx2.LockExclusive(1) }()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := trx1.LockExclusive(2); err != nil {
+		t.Fatalf("trx1 %v", err)
+	}
+	if err := <-done; err != gosqlite.ErrDeadlock {
+		t.Fatalf("waiting victim %v", err)
+	}
+	trx1.Commit()
+}
@@ -1,8 +1,10 @@
 package gosqlite
 
 import (
-	"fmt"
+	"errors"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -12,220 +14,753 @@ const (
 	rollback int8 = 3
 )
 
-// TrxContext context
+// TrxContext context, all state is guarded by mu so trx can run
+// concurrently from different goroutines.
 type TrxContext struct {
-	trxIDs   []Trx
-	dataPool []record
-	undo     []record
+	mu sync.RWMutex
+
+	trxIDs   []*Trx
+	dataPool map[int64]*record
+	undo     []*record
+
+	freeTrx  []*Trx
+	freeData []*record
+	freeUndo []*record
+	purgeAt  int
+
+	// rowTree orders the rows by rowID, with their newest version when
+	// the context is opened from a file. undoTree persists the older
+	// versions and log is the redo log, they are nil in memory.
+	fileName string
+	rowTree  *BPlusTree
+	undoTree *BPlusTree
+	log      *redoLog
+	// rowChanges counts the changes of rowTree, a row cursor seeks again
+	// when it changed
+	rowChanges uint64
+	// indexTree is the keyed tree of the index entries of the tables, it
+	// is stored with the rows but not logged.
+	indexTree *BPlusTree
+	// checkpointSize is the size of the redo log a commit checkpoints at
+	checkpointSize int64
+
+	locks *lockManager
+
+	// committed maps the trx committed since the last purge to their
+	// commit timestamp, versions of trx up to frozenID stored without
+	// one were committed before the context was opened.
+	committed     map[int64]int64
+	commitCounter int64
+	frozenID      int64
+
+	feed  *changeFeed
+	hooks hooks
+
+	snapshots       map[SnapshotID]exportedSnapshot
+	snapshotCounter int64
+
+	beginCounter int64
+	maxDuration  time.Duration
+	idleTimeout  time.Duration
+
+	// commits in commit order, kept for time-travel reads until purged and
+	// stored with the rows at checkpoint
+	commits     []commitInfo
+	history     commitInfo
+	retention   time.Duration
+	purgedLimit int64
 
 	trxCounter int64
 	rowCounter int64
 }
 
+var (
+	// ErrTrxNotActive is returned when a trx is used before Begin or after Commit/Rollback.
+	ErrTrxNotActive = errors.New("trx is not active")
+	// ErrRowNotFound is returned when a row is not visible to the trx.
+	ErrRowNotFound = errors.New("row not found")
+	// ErrWriteConflict is returned when a row was changed by a trx the read view can not see.
+	ErrWriteConflict = errors.New("write conflict")
+	// ErrTrxReadOnly is returned when a read-only trx writes.
+	ErrTrxReadOnly = errors.New("trx is read-only")
+)
+
+// Row is a row version visible to a trx.
+type Row struct {
+	RowID int64
+	TrxID int64
+	Data  []byte
+}
+
 // Trx be
 type Trx struct {
 	trxID  int64
 	status int8
 	view   *readView
+	ctx    *TrxContext
+	writes []*record
+
+	// committing is set once the commit record is in the redo log
+	committing bool
+	savepoints []savepoint
+	readOnly   bool
+
+	// id numbers every begin, the timeouts check it so they do not abort
+	// a later trx reusing the slot.
+	id       int64
+	started  time.Time
+	idle     time.Duration
+	lastUsed int64
+	stops    []func() bool
+	// retired is set when trx was aborted by a timeout, a cancel or Kill,
+	// the caller may still hold it so the slot is not reused.
+	retired bool
 }
 
+// readView is the snapshot of a trx, the versions committed at or before
+// snapshotTS are visible together with the versions of the creator.
 type readView struct {
-	lowLimitID int64
-	upLimitID  int64
-	trxIDs     []Trx
+	snapshotTS int64
+	creatorID  int64
 }
 
+// record is a row version, commitTS is filled in from the commit
+// timestamp of trxID the first time it is looked up after the commit.
 type record struct {
-	rowID   int64
-	trxID   int64
-	rollPtr *record
-	data    []byte
+	rowID    int64
+	trxID    int64
+	commitTS int64
+	undoNo   int64
+	deleted  bool
+	rollPtr  *record
+	data     []byte
 }
 
+const (
+	minPurgeAt = 1024
+	// frozenTS is the commit timestamp of the versions the context started with
+	frozenTS = 1
+)
+
 // CreateTrxContext to create trx context
 func CreateTrxContext() *TrxContext {
 	context := new(TrxContext)
-	context.trxIDs = make([]Trx, 1024)
-	context.dataPool = make([]record, 1024)
-	context.undo = make([]record, 1024)
-	for i := 0; i < len(context.trxIDs); i++ {
-		context.trxIDs[i].trxID = 0
-		context.trxIDs[i].status = unused
-	}
+	context.trxIDs = make([]*Trx, 0)
+	context.dataPool = make(map[int64]*record)
+	context.rowTree = CreateTree(storeOrder)
+	context.undo = make([]*record, 0)
+	context.purgeAt = minPurgeAt
+	context.locks = newLockManager()
+	context.committed = make(map[int64]int64)
+	context.commitCounter = frozenTS
+	context.feed = newChangeFeed()
+	context.snapshots = make(map[SnapshotID]exportedSnapshot)
+	context.indexTree = createKeyTree(indexOrder)
+	context.resetHistory()
 
 	return context
 }
 
-// AllocteTrx to allocate trx from pool.
+// AllocteTrx to allocate trx from pool, finished trx slots are reused.
 func (context *TrxContext) AllocteTrx() *Trx {
-	for i := 0; i < len(context.trxIDs); i++ {
-		if context.trxIDs[i].status == unused {
-			return &context.trxIDs[i]
-		}
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	return context.allocteTrx()
+}
+
+func (context *TrxContext) allocteTrx() *Trx {
+	if n := len(context.freeTrx); n > 0 {
+		t := context.freeTrx[n-1]
+		context.freeTrx = context.freeTrx[:n-1]
+		*t = Trx{}
+		return t
 	}
 
-	return nil
+	t := new(Trx)
+	context.trxIDs = append(context.trxIDs, t)
+	return t
+}
+
+// releaseTrx returns a finished trx slot to the pool.
+func (context *TrxContext) releaseTrx(t *Trx) {
+	t.stopTimers()
+	if len(context.snapshots) > 0 {
+		context.dropSnapshots(t)
+	}
+	context.freeTrx = append(context.freeTrx, t)
+}
+
+// retireTrx drops an aborted trx from the context without returning its
+// slot to the pool, every op on it returns ErrTrxNotActive.
+func (context *TrxContext) retireTrx(t *Trx) {
+	t.stopTimers()
+	if len(context.snapshots) > 0 {
+		context.dropSnapshots(t)
+	}
+	for i, p := range context.trxIDs {
+		if p == t {
+			context.trxIDs = append(context.trxIDs[:i], context.trxIDs[i+1:]...)
+			break
+		}
+	}
+	t.retired = true
 }
 
-// AllocteRecord to allocate trx from pool.
+// allocteUndo to allocate undo record from pool.
 func (context *TrxContext) allocteUndo() *record {
-	for i := 0; i < len(context.trxIDs); i++ {
-		if context.undo[i].rowID == 0 {
-			return &context.undo[i]
+	if len(context.freeUndo) == 0 && len(context.undo) >= context.purgeAt {
+		context.purge()
+		context.purgeAt = 2 * (len(context.undo) - len(context.freeUndo))
+		if context.purgeAt < minPurgeAt {
+			context.purgeAt = minPurgeAt
 		}
 	}
 
-	return nil
+	if n := len(context.freeUndo); n > 0 {
+		u := context.freeUndo[n-1]
+		context.freeUndo = context.freeUndo[:n-1]
+		return u
+	}
+
+	u := new(record)
+	context.undo = append(context.undo, u)
+	u.undoNo = int64(len(context.undo))
+	return u
 }
 
-// AllocteRecord to allocate trx from pool.
-func (context *TrxContext) allocteRecord() *record {
-	for i := 0; i < len(context.trxIDs); i++ {
-		if context.dataPool[i].rowID == 0 {
-			return &context.dataPool[i]
-		}
+func (context *TrxContext) freeUndoRecord(u *record) {
+	context.deleteUndo(u)
+	*u = record{undoNo: u.undoNo}
+	context.freeUndo = append(context.freeUndo, u)
+}
+
+// allocteRecord to allocate record from pool and index it by rowID, it
+// is ordered once it is stored in the row tree.
+func (context *TrxContext) allocteRecord(rowID int64) *record {
+	var r *record
+	if n := len(context.freeData); n > 0 {
+		r = context.freeData[n-1]
+		context.freeData = context.freeData[:n-1]
+	} else {
+		r = new(record)
 	}
 
-	return nil
+	r.rowID = rowID
+	context.dataPool[rowID] = r
+	return r
 }
 
-func (context *TrxContext) findRecord(rowID int64) *record {
-	for i := 0; i < len(context.trxIDs); i++ {
-		if context.dataPool[i].rowID == rowID {
-			return &context.dataPool[i]
+func (context *TrxContext) freeRecord(r *record) {
+	context.deleteRow(r)
+	delete(context.dataPool, r.rowID)
+
+	*r = record{}
+	context.freeData = append(context.freeData, r)
+}
+
+// purgeLimit returns the commit timestamp up to which every committed
+// version is visible to all active and future read views.
+func (context *TrxContext) purgeLimit() int64 {
+	limit := context.commitCounter
+	for _, t := range context.trxIDs {
+		if t.status == uncommit && t.view.snapshotTS < limit {
+			limit = t.view.snapshotTS
 		}
 	}
+	if limit > context.retentionLimit() {
+		limit = context.retentionLimit()
+	}
 
-	return nil
+	return limit
+}
+
+// purgeDue returns whether as many writers committed since the last purge
+// as there are rows, which pay for a purge reading every row. Commits
+// purge the history out of the retention when no undo record is allocated.
+func (context *TrxContext) purgeDue() bool {
+	n := len(context.dataPool)
+	if n < minPurgeAt {
+		n = minPurgeAt
+	}
+	return len(context.committed) >= n
 }
 
-func (context *TrxContext) createReadView() *readView {
-	view := new(readView)
-	view.lowLimitID = 0
-	view.upLimitID = 0
+// Purge to free undo records no read view can see any more.
+func (context *TrxContext) Purge() {
+	context.mu.Lock()
+	defer context.mu.Unlock()
 
-	ids := make([]Trx, 0)
-	num := len(context.trxIDs)
-	for i := 0; i < num; i++ {
-		if context.trxIDs[i].status == uncommit {
-			ids = append(ids, context.trxIDs[i])
+	context.purge()
+}
+
+func (context *TrxContext) purge() {
+	limit := context.purgeLimit()
+	if limit > context.purgedLimit {
+		context.purgedLimit = limit
+	}
+	context.trimHistory(limit)
+	for _, r := range context.dataPool {
+		for p := r; p != nil; p = p.rollPtr {
+			if ts := context.commitTS(p); ts != 0 && ts <= limit {
+				u := p.rollPtr
+				if u == nil {
+					break
+				}
+				p.rollPtr = nil
+				if p == r {
+					context.storeRow(r)
+				} else {
+					context.storeUndo(p)
+				}
+				for u != nil {
+					next := u.rollPtr
+					context.freeUndoRecord(u)
+					u = next
+				}
+				break
+			}
+		}
+
+		// a delete every read view can see removes the row
+		if ts := context.commitTS(r); r.deleted && r.rollPtr == nil && ts != 0 && ts <= limit {
+			context.freeRecord(r)
 		}
 	}
 
-	view.trxIDs = ids
-	if len(view.trxIDs) > 0 {
-		view.lowLimitID = view.trxIDs[0].trxID
-		view.upLimitID = view.trxIDs[len(view.trxIDs)-1].trxID
+	// every version left has its commit timestamp filled in
+	context.committed = make(map[int64]int64)
+}
+
+func (context *TrxContext) findRecord(rowID int64) *record {
+	return context.dataPool[rowID]
+}
+
+func (context *TrxContext) createReadView(creatorID int64) *readView {
+	return &readView{snapshotTS: context.commitCounter, creatorID: creatorID}
+}
+
+// commitTS returns the commit timestamp of version r, 0 while its trx
+// has not committed. It may run under the read lock, so commitTS of the
+// record is accessed atomically.
+func (context *TrxContext) commitTS(r *record) int64 {
+	if ts := atomic.LoadInt64(&r.commitTS); ts != 0 {
+		return ts
+	}
+	ts := context.committed[r.trxID]
+	if ts == 0 && r.trxID <= context.frozenID {
+		ts = frozenTS
 	}
-	return view
+	if ts != 0 {
+		atomic.StoreInt64(&r.commitTS, ts)
+	}
+	return ts
 }
 
 // Begin to trx
 func (t *Trx) Begin(context *TrxContext) {
-	atomic.AddInt64(&context.trxCounter, 1)
-	t.trxID = context.trxCounter
-	t.status = uncommit
-	t.view = context.createReadView()
+	context.mu.Lock()
+	defer context.mu.Unlock()
 
-	fmt.Printf("begin trx %d\n", t.trxID)
+	trxID := atomic.AddInt64(&context.trxCounter, 1)
+	t.begin(context, trxID, context.createReadView(trxID))
+}
+
+func (t *Trx) begin(context *TrxContext, trxID int64, view *readView) {
+	t.trxID = trxID
+	t.status = uncommit
+	t.view = view
+	t.ctx = context
+	t.writes = nil
+	t.committing = false
+	t.savepoints = nil
+	t.readOnly = false
+	if t.retired {
+		// the caller begins a retired trx again
+		t.retired = false
+		context.trxIDs = append(context.trxIDs, t)
+	}
+	context.beginCounter++
+	t.id = context.beginCounter
+	t.startTimers(context)
 }
 
-// Commit to trx
-func (t *Trx) Commit() {
+// Commit to trx, when the context has a redo log the commit record is
+// forced to disk before the changes become visible to other trx. A commit
+// hook returning an error rolls the trx back and Commit returns the error.
+// A failed force rolls the trx back too and fails every later commit of
+// a writer, whether the trx survives a restart depends on whether the
+// commit record reached the disk.
+func (t *Trx) Commit() error {
+	if t.ctx == nil {
+		return ErrTrxNotActive
+	}
+
+	ctx := t.ctx
+	ctx.mu.RLock()
+	info := TxInfo{TrxID: t.trxID, Writes: len(t.writes)}
+	active := t.status == uncommit && !t.committing
+	hooks := ctx.hooks
+	ctx.mu.RUnlock()
+	if !active {
+		return ErrTrxNotActive
+	}
+	for _, fn := range hooks.commit {
+		if err := fn(info); err != nil {
+			t.Rollback()
+			return err
+		}
+	}
+
+	ctx.mu.Lock()
+	if t.status != uncommit || t.committing {
+		ctx.mu.Unlock()
+		return ErrTrxNotActive
+	}
+
+	var lsn uint64
+	if len(t.writes) > 0 {
+		lsn = ctx.log.append(logCommit, t.trxID, 0, encodeCommit(len(t.writes), time.Now()))
+	}
+	t.committing = true
+	ctx.mu.Unlock()
+
+	if err := ctx.log.force(lsn); err != nil {
+		ctx.mu.Lock()
+		info := t.undo(false)
+		hooks = ctx.hooks
+		ctx.mu.Unlock()
+
+		for _, fn := range hooks.rollback {
+			fn(info)
+		}
+		return err
+	}
+
+	ctx.mu.Lock()
+	if len(t.writes) > 0 {
+		ctx.commitCounter++
+		ctx.committed[t.trxID] = ctx.commitCounter
+		ctx.stampVersions(t.writes, t.trxID, ctx.commitCounter)
+		ctx.recordCommit(t.trxID, ctx.commitCounter, time.Now())
+		ctx.feed.publish(t.changes(ctx.commitCounter))
+		info.CommitTS = ctx.commitCounter
+		if ctx.purgeDue() {
+			ctx.purge()
+		}
+	}
+	info.Writes = len(t.writes)
+	t.writes = nil
 	t.status = commit
-	fmt.Printf("trx commit %d.\n", t.trxID)
+	ctx.locks.releaseAll(t.trxID)
+	ctx.releaseTrx(t)
+	if ctx.checkpointSize > 0 && ctx.log.written() >= ctx.checkpointSize {
+		// the commit is durable already, a failed checkpoint leaves the
+		// log to recover from and is tried again by the next commit
+		ctx.checkpoint()
+	}
+	hooks = ctx.hooks
+	ctx.mu.Unlock()
+
+	for _, fn := range hooks.afterCommit {
+		fn(info)
+	}
+	return nil
 }
 
-// Rollback to trx
+// Rollback to trx, the versions written by trx are restored from undo.
 func (t *Trx) Rollback() {
+	if t.ctx == nil {
+		return
+	}
+
+	t.rollback(t.id, false)
+}
+
+// rollback to roll trx back if it is still the trx begun as id, an
+// aborted trx is retired instead of released.
+func (t *Trx) rollback(id int64, aborted bool) {
+	ctx := t.ctx
+	ctx.mu.Lock()
+	if t.id != id || t.status != uncommit || t.committing {
+		ctx.mu.Unlock()
+		return
+	}
+
+	info := t.undo(aborted)
+	hooks := ctx.hooks
+	ctx.mu.Unlock()
+
+	for _, fn := range hooks.rollback {
+		fn(info)
+	}
+}
+
+// undo to restore the versions written by trx and end it, the caller
+// holds the lock of the context and runs the rollback hooks.
+func (t *Trx) undo(aborted bool) TxInfo {
+	ctx := t.ctx
+	for i := len(t.writes) - 1; i >= 0; i-- {
+		ctx.undoVersion(t.writes[i])
+	}
+	if len(t.writes) > 0 {
+		ctx.log.append(logAbort, t.trxID, 0, nil)
+	}
+	info := TxInfo{TrxID: t.trxID, Writes: len(t.writes)}
+	t.writes = nil
 	t.status = rollback
-	fmt.Printf("trx rollback %d.\n", t.trxID)
+	ctx.locks.releaseAll(t.trxID)
+	if aborted {
+		ctx.retireTrx(t)
+	} else {
+		ctx.releaseTrx(t)
+	}
+	return info
 }
 
-// Insert to insert record
-func (t *Trx) Insert(context *TrxContext, data string) {
-	r := context.allocteRecord()
-	r.data = []byte(data)
-	r.trxID = t.trxID
+// active returns whether trx was begun and has not finished
+func (t *Trx) active() bool {
+	if t.ctx == nil {
+		return false
+	}
 
-	atomic.AddInt64(&context.rowCounter, 1)
-	r.rowID = context.rowCounter
+	t.ctx.mu.RLock()
+	defer t.ctx.mu.RUnlock()
+	return t.status == uncommit && !t.committing
 }
 
-// Update to update record
-func (t *Trx) Update(ctx *TrxContext, rowid int64, data string) {
-	r := ctx.findRecord(rowid)
-	u := ctx.allocteUndo()
-	u.trxID = r.trxID
-	u.data = r.data
+// undoVersion restores the previous version of r, a row without previous
+// version was inserted and is removed.
+func (context *TrxContext) undoVersion(r *record) {
+	u := r.rollPtr
+	if u == nil {
+		context.freeRecord(r)
+		return
+	}
 
-	if r.rollPtr == nil {
-		r.rollPtr = u
-	} else {
-		u1 := r.rollPtr
-		r.rollPtr = u
-		u.rollPtr = u1
+	r.trxID = u.trxID
+	r.commitTS = u.commitTS
+	r.data = u.data
+	r.deleted = u.deleted
+	r.rollPtr = u.rollPtr
+	context.storeRow(r)
+	context.freeUndoRecord(u)
+}
+
+// Insert to insert record, returns the rowID of the new row
+func (t *Trx) Insert(context *TrxContext, data string) (int64, error) {
+	rowID, err := t.insert(context, data)
+	if err == nil {
+		context.rowHooks(t.trxID, ChangeInsert, rowID)
 	}
+	return rowID, err
+}
 
-	r.trxID = t.trxID
-	r.data = []byte(data)
+func (t *Trx) insert(context *TrxContext, data string) (int64, error) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return 0, ErrTrxNotActive
+	} else if t.readOnly {
+		return 0, ErrTrxReadOnly
+	}
+
+	t.touch()
+	rowID := atomic.AddInt64(&context.rowCounter, 1)
+	r := context.insertVersion(rowID, t.trxID, []byte(data))
+	context.log.append(logInsert, t.trxID, rowID, r.data)
+	t.writes = append(t.writes, r)
+	return rowID, nil
+}
+
+func (context *TrxContext) insertVersion(rowID int64, trxID int64, data []byte) *record {
+	r := context.allocteRecord(rowID)
+	r.data = data
+	r.trxID = trxID
+	context.storeRow(r)
+	return r
 }
 
-func (t *Trx) inView(tid int64) bool {
-	for i := 0; i < len(t.view.trxIDs); i++ {
-		if t.view.trxIDs[i].trxID == tid {
-			return true
+// writable returns the record of rowid if trx can change it, the newest
+// version of the row must be visible to trx, otherwise another trx
+// changed it first and ErrWriteConflict is returned.
+func (t *Trx) writable(ctx *TrxContext, rowid int64) (*record, error) {
+	if t.status != uncommit || t.committing {
+		return nil, ErrTrxNotActive
+	} else if t.readOnly {
+		return nil, ErrTrxReadOnly
+	}
+
+	t.touch()
+	r := ctx.findRecord(rowid)
+	if r == nil {
+		return nil, ErrRowNotFound
+	}
+	if !t.check(r) {
+		if v := t.visible(r); v == nil || v.deleted {
+			return nil, ErrRowNotFound
 		}
+		return nil, ErrWriteConflict
+	}
+	if r.deleted {
+		return nil, ErrRowNotFound
 	}
 
-	return false
+	return r, nil
 }
 
-func (t *Trx) check(tid int64) bool {
-	if t.trxID == tid {
-		return true
+// Update to update record
+func (t *Trx) Update(ctx *TrxContext, rowid int64, data string) error {
+	return t.update(ctx, rowid, []byte(data))
+}
+
+func (t *Trx) update(ctx *TrxContext, rowid int64, data []byte) error {
+	err := t.updateVersion(ctx, rowid, data)
+	if err == nil {
+		ctx.rowHooks(t.trxID, ChangeUpdate, rowid)
 	}
+	return err
+}
 
-	tmin := t.view.lowLimitID
-	tmax := t.view.upLimitID
+func (t *Trx) updateVersion(ctx *TrxContext, rowid int64, data []byte) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
 
-	if tid < tmin {
-		return true
-	} else if tid > tmax {
-		return false
-	} else if t.inView(tid) {
-		return false
+	r, err := t.writable(ctx, rowid)
+	if err != nil {
+		return err
 	}
 
-	return true
+	ctx.newVersion(r, t.trxID, data, false)
+	ctx.log.append(logUpdate, t.trxID, rowid, r.data)
+	t.writes = append(t.writes, r)
+	return nil
 }
 
-func (t *Trx) selectRollback(ctx *TrxContext, r *record) {
-	for p := r.rollPtr; p != nil; p = p.rollPtr {
-		if p == nil {
-			break
+// Delete to delete record, the row stays as a deleted version until purge
+func (t *Trx) Delete(ctx *TrxContext, rowid int64) error {
+	err := t.delete(ctx, rowid)
+	if err == nil {
+		ctx.rowHooks(t.trxID, ChangeDelete, rowid)
+	}
+	return err
+}
+
+func (t *Trx) delete(ctx *TrxContext, rowid int64) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	r, err := t.writable(ctx, rowid)
+	if err != nil {
+		return err
+	}
+
+	ctx.newVersion(r, t.trxID, nil, true)
+	ctx.log.append(logDelete, t.trxID, rowid, nil)
+	t.writes = append(t.writes, r)
+	return nil
+}
+
+// newVersion moves the newest version of r to undo and replaces it with data written by trxID
+func (context *TrxContext) newVersion(r *record, trxID int64, data []byte, deleted bool) {
+	u := context.allocteUndo()
+	u.rowID = r.rowID
+	u.trxID = r.trxID
+	u.commitTS = r.commitTS
+	u.data = r.data
+	u.deleted = r.deleted
+	u.rollPtr = r.rollPtr
+
+	r.rollPtr = u
+	r.trxID = trxID
+	r.commitTS = 0
+	r.data = data
+	r.deleted = deleted
+	context.storeUndo(u)
+	context.storeRow(r)
+}
+
+// check returns whether a version committed at ts is visible to the view.
+func (view *readView) check(ts int64) bool {
+	return ts != 0 && ts <= view.snapshotTS
+}
+
+func (t *Trx) check(r *record) bool {
+	return r.trxID == t.view.creatorID || t.view.check(t.ctx.commitTS(r))
+}
+
+// visible returns the version of r visible to the trx read view, walking
+// the undo chain when the newest version is not visible.
+func (t *Trx) visible(r *record) *record {
+	for p := r; p != nil; p = p.rollPtr {
+		if t.check(p) {
+			return p
 		}
+	}
+
+	return nil
+}
 
-		if t.check(p.trxID) {
-			fmt.Printf("[%s] ", string(p.data))
+func (t *Trx) toRow(r *record) (Row, bool) {
+	v := t.visible(r)
+	if v == nil || v.deleted {
+		return Row{}, false
+	}
+
+	return Row{RowID: r.rowID, TrxID: v.trxID, Data: v.data}, true
+}
+
+// Scan to walk the rows visible to trx until fn returns false,
+// fn must not write through ctx while the scan is running.
+func (t *Trx) Scan(ctx *TrxContext, fn func(Row) bool) error {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	if t.status != uncommit {
+		return ErrTrxNotActive
+	}
+
+	t.touch()
+	for c := ctx.rowTree.First(); c.Valid(); c.Next() {
+		row, ok := t.toRow(ctx.dataPool[int64(c.Key())])
+		if ok && !fn(row) {
 			break
 		}
 	}
+
+	return nil
 }
 
 // Select to query trx data
-func (t *Trx) Select(ctx *TrxContext) {
-	fmt.Printf("\n**********%d*********\n", t.trxID)
-	poolSize := len(ctx.dataPool)
-	for i := 0; i < poolSize; i++ {
-		if ctx.dataPool[i].rowID > 0 {
-			tid := ctx.dataPool[i].trxID
-			if t.check(tid) {
-				fmt.Printf("%d:[%s] ", ctx.dataPool[i].rowID, string(ctx.dataPool[i].data))
-			} else {
-				t.selectRollback(ctx, &ctx.dataPool[i])
-			}
-		}
+func (t *Trx) Select(ctx *TrxContext) ([]Row, error) {
+	rows := make([]Row, 0)
+	err := t.Scan(ctx, func(row Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Get to query the row with rowID visible to trx
+func (t *Trx) Get(ctx *TrxContext, rowID int64) (Row, bool, error) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	if t.status != uncommit {
+		return Row{}, false, ErrTrxNotActive
 	}
-	fmt.Printf("\n**********%d*********\n", t.trxID)
+	t.touch()
+
+	r := ctx.findRecord(rowID)
+	if r == nil {
+		return Row{}, false, nil
+	}
+
+	row, ok := t.toRow(r)
+	return row, ok, nil
 }
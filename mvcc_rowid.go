@@ -0,0 +1,136 @@
+package gosqlite
+
+// insertAt to insert a row with a chosen rowID, a row deleted before
+// trx began may be inserted again.
+func (t *Trx) insertAt(ctx *TrxContext, rowID int64, data []byte) error {
+	err := t.insertVersionAt(ctx, rowID, data)
+	if err == nil {
+		ctx.rowHooks(t.trxID, ChangeInsert, rowID)
+	}
+	return err
+}
+
+func (t *Trx) insertVersionAt(ctx *TrxContext, rowID int64, data []byte) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return ErrTrxNotActive
+	} else if t.readOnly {
+		return ErrTrxReadOnly
+	}
+
+	t.touch()
+	r := ctx.findRecord(rowID)
+	if r == nil {
+		r = ctx.insertVersion(rowID, t.trxID, data)
+		ctx.log.append(logInsert, t.trxID, rowID, data)
+		if rowID > ctx.rowCounter {
+			ctx.rowCounter = rowID
+		}
+	} else if !t.check(r) {
+		return ErrWriteConflict
+	} else if !r.deleted {
+		return ErrDuplicateRowID
+	} else {
+		ctx.newVersion(r, t.trxID, data, false)
+		ctx.log.append(logUpdate, t.trxID, rowID, data)
+	}
+	t.writes = append(t.writes, r)
+	return nil
+}
+
+// rowCursor walks the rows visible to trx in [from, to) over a cursor of
+// the row tree. The tree may change between rows, the cursor seeks the
+// row after the last one again then.
+type rowCursor struct {
+	trx     *Trx
+	ctx     *TrxContext
+	from    int64
+	to      int64
+	cursor  *Cursor
+	changes uint64
+}
+
+// cursor returns a cursor on the rows visible to trx in [from, to)
+func (t *Trx) cursor(ctx *TrxContext, from int64, to int64) *rowCursor {
+	return &rowCursor{trx: t, ctx: ctx, from: from, to: to}
+}
+
+// next returns the next row, ok is false at the end
+func (c *rowCursor) next() (Row, bool, error) {
+	ctx := c.ctx
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	if c.trx.status != uncommit {
+		return Row{}, false, ErrTrxNotActive
+	}
+
+	c.trx.touch()
+	if c.cursor == nil || c.changes != ctx.rowChanges {
+		c.cursor = ctx.rowTree.Seek(uint64(c.from))
+		c.changes = ctx.rowChanges
+	}
+	for ; c.cursor.Valid() && int64(c.cursor.Key()) < c.to; c.cursor.Next() {
+		rowID := int64(c.cursor.Key())
+		if row, ok := c.trx.toRow(ctx.dataPool[rowID]); ok {
+			c.from = rowID + 1
+			c.cursor.Next()
+			return row, true, nil
+		}
+	}
+	c.from = c.to
+	return Row{}, false, nil
+}
+
+// maxRowID returns the largest rowID in [from, to) of any version, even
+// one trx can not see, or from-1 when there is none.
+func (context *TrxContext) maxRowID(from int64, to int64) int64 {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	if key, ok := context.rowTree.lastBelow(uint64(to)); ok && int64(key) >= from {
+		return int64(key)
+	}
+	return from - 1
+}
+
+// versions returns the data of the versions of rowID from the newest,
+// nil for a deleted version, and whether trx can see the newest. trx may
+// be nil.
+func (context *TrxContext) versions(t *Trx, rowID int64) ([][]byte, bool) {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	r := context.findRecord(rowID)
+	if r == nil {
+		return nil, false
+	}
+	data := make([][]byte, 0)
+	for p := r; p != nil; p = p.rollPtr {
+		if p.deleted {
+			data = append(data, nil)
+		} else {
+			data = append(data, p.data)
+		}
+	}
+	return data, t != nil && t.check(r)
+}
+
+// eachVersion calls fn on the versions that are not deleted of the rows
+// in [from, to), head tells whether it is the newest. fn must not use
+// the context.
+func (context *TrxContext) eachVersion(from int64, to int64, fn func(rowID int64, data []byte, head bool)) {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	for c := context.rowTree.Seek(uint64(from)); c.Valid() && int64(c.Key()) < to; c.Next() {
+		r := context.dataPool[int64(c.Key())]
+		for p := r; p != nil; p = p.rollPtr {
+			if !p.deleted {
+				fn(r.rowID, p.data, p == r)
+			}
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package gosqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	storeOrder = 5
+
+	// row payload: trxID, roll pointer (undoNo of the previous version), deleted, commitTS, data
+	rowHeaderSize = 25
+	// undo payload: rowID, trxID, roll pointer, deleted, commitTS, data
+	undoHeaderSize = 33
+	// storeFormat is the format of the store file, the rows and undo
+	// records of format 0 are stored without commitTS and the history
+	// is stored from format 2
+	storeFormat = 2
+
+	// defaultCheckpointSize is the size of the redo log a commit
+	// checkpoints at unless SetCheckpointSize changes it
+	defaultCheckpointSize = 16 << 20
+)
+
+func encodeRow(r *record) []byte {
+	payload := make([]byte, rowHeaderSize+len(r.data))
+	binary.BigEndian.PutUint64(payload, uint64(r.trxID))
+	binary.BigEndian.PutUint64(payload[8:], uint64(rollNo(r)))
+	if r.deleted {
+		payload[16] = 1
+	}
+	binary.BigEndian.PutUint64(payload[17:], uint64(r.commitTS))
+	copy(payload[rowHeaderSize:], r.data)
+	return payload
+}
+
+func encodeUndo(u *record) []byte {
+	payload := make([]byte, undoHeaderSize+len(u.data))
+	binary.BigEndian.PutUint64(payload, uint64(u.rowID))
+	binary.BigEndian.PutUint64(payload[8:], uint64(u.trxID))
+	binary.BigEndian.PutUint64(payload[16:], uint64(rollNo(u)))
+	if u.deleted {
+		payload[24] = 1
+	}
+	binary.BigEndian.PutUint64(payload[25:], uint64(u.commitTS))
+	copy(payload[undoHeaderSize:], u.data)
+	return payload
+}
+
+func rollNo(r *record) int64 {
+	if r.rollPtr == nil {
+		return 0
+	}
+	return r.rollPtr.undoNo
+}
+
+// storeRow to write the newest version of r to the row tree, in memory
+// the tree only orders the rows.
+func (context *TrxContext) storeRow(r *record) {
+	payload := []byte{}
+	if context.fileName != "" {
+		payload = encodeRow(r)
+	}
+	context.rowTree.Insert(uint64(r.rowID), payload)
+	context.rowChanges++
+}
+
+// stampVersions to fill in the commit timestamp ts of the versions trx
+// trxID wrote, the older ones it replaced included, and store them again.
+func (context *TrxContext) stampVersions(writes []*record, trxID int64, ts int64) {
+	for _, r := range writes {
+		for p := r; p != nil && p.trxID == trxID && p.commitTS != ts; p = p.rollPtr {
+			atomic.StoreInt64(&p.commitTS, ts)
+			if p != r {
+				context.storeUndo(p)
+			} else if context.fileName != "" {
+				context.storeRow(r)
+			}
+		}
+	}
+}
+
+func (context *TrxContext) deleteRow(r *record) {
+	context.rowTree.Delete(uint64(r.rowID))
+	context.rowChanges++
+}
+
+func (context *TrxContext) storeUndo(u *record) {
+	if context.undoTree != nil {
+		context.undoTree.Insert(uint64(u.undoNo), encodeUndo(u))
+	}
+}
+
+func (context *TrxContext) deleteUndo(u *record) {
+	if context.undoTree != nil {
+		context.undoTree.Delete(uint64(u.undoNo))
+	}
+}
+
+// the store file is a header page followed by the pages of the row tree,
+// the undo tree, the index tree and the commit tree, it is replaced as a
+// whole at checkpoint.
+const storeMagic = 0x6773716c
+
+func (context *TrxContext) writeStore(ckptLSN uint64) error {
+	rows := context.rowTree.bytes()
+	undo := context.undoTree.bytes()
+
+	index := context.indexTree.bytes()
+	commits := context.commitTree().bytes()
+
+	data := make([]byte, pageSize, pageSize+len(rows)+len(undo)+len(index)+len(commits))
+	setInt32(data, 0, storeMagic)
+	setInt64(data, 4, ckptLSN)
+	setInt64(data, 12, uint64(context.trxCounter))
+	setInt64(data, 20, uint64(context.rowCounter))
+	setInt32(data, 28, uint32(len(rows)/pageSize))
+	setInt64(data, 32, context.changeLSN())
+	setInt32(data, 40, uint32(len(undo)/pageSize))
+	setInt32(data, 44, storeFormat)
+	setInt64(data, 48, uint64(context.commitCounter))
+	setInt64(data, 56, uint64(context.purgedLimit))
+	setInt64(data, 64, uint64(context.history.trxID))
+	setInt64(data, 72, uint64(context.history.at.UnixNano()))
+	setInt64(data, 80, uint64(context.history.ts))
+	setInt32(data, 88, uint32(len(commits)/pageSize))
+	data = append(data, rows...)
+	data = append(data, undo...)
+	data = append(data, index...)
+	data = append(data, commits...)
+	return writeFileSync(context.fileName, data)
+}
+
+// StoreInfo is the header of a store file with the sizes of its trees
+type StoreInfo struct {
+	PageSize      int
+	CheckpointLSN uint64
+	MaxTrxID      int64
+	RowCounter    int64
+	RowPages      int
+	UndoPages     int
+	IndexPages    int
+	CommitPages   int
+}
+
+// ReadStoreInfo returns the header of the store file fileName as of the
+// last checkpoint, the redo log is not read.
+func ReadStoreInfo(fileName string) (StoreInfo, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return StoreInfo{}, err
+	}
+	if len(data) < pageSize || len(data)%pageSize != 0 || getInt32(data, 0) != storeMagic {
+		return StoreInfo{}, errors.New("The store file is corrupt")
+	}
+	info := StoreInfo{
+		PageSize:      pageSize,
+		CheckpointLSN: getInt64(data, 4),
+		MaxTrxID:      int64(getInt64(data, 12)),
+		RowCounter:    int64(getInt64(data, 20)),
+		RowPages:      int(getInt32(data, 28)),
+		CommitPages:   int(getInt32(data, 88)),
+	}
+	info.UndoPages = len(data)/pageSize - 1 - info.RowPages - info.CommitPages
+	if undoPages := int(getInt32(data, 40)); undoPages > 0 {
+		info.IndexPages = info.UndoPages - undoPages
+		info.UndoPages = undoPages
+	}
+	if info.UndoPages < 0 || info.IndexPages < 0 {
+		return StoreInfo{}, errors.New("The store file is corrupt")
+	}
+	return info, nil
+}
+
+// readStore loads the row and undo trees, returns the checkpoint lsn
+func (context *TrxContext) readStore() (uint64, error) {
+	data, err := ioutil.ReadFile(context.fileName)
+	if os.IsNotExist(err) {
+		context.rowTree = CreateTree(storeOrder)
+		context.undoTree = CreateTree(storeOrder)
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	format := getInt32(data, 44)
+	if len(data) < pageSize || getInt32(data, 0) != storeMagic || format > storeFormat {
+		return 0, errors.New("The store file is corrupt")
+	}
+	// the index tree follows the undo tree, a file without it has no
+	// count of undo pages
+	rowsEnd := pageSize + int(getInt32(data, 28))*pageSize
+	indexEnd := len(data) - int(getInt32(data, 88))*pageSize
+	undoEnd := indexEnd
+	if undoPages := int(getInt32(data, 40)); undoPages > 0 {
+		undoEnd = rowsEnd + undoPages*pageSize
+	}
+	if rowsEnd > undoEnd || undoEnd > indexEnd {
+		return 0, errors.New("The store file is corrupt")
+	}
+
+	rowTree, err := loadTree(append([]byte(nil), data[pageSize:rowsEnd]...))
+	if err != nil {
+		return 0, err
+	}
+	undoTree, err := loadTree(append([]byte(nil), data[rowsEnd:undoEnd]...))
+	if err != nil {
+		return 0, err
+	}
+	if undoEnd < indexEnd {
+		indexTree, err := loadTree(append([]byte(nil), data[undoEnd:indexEnd]...))
+		if err != nil {
+			return 0, err
+		}
+		context.indexTree = indexTree
+	}
+	if err = context.load(rowTree, undoTree, format); err != nil {
+		return 0, err
+	}
+
+	context.rowTree = rowTree
+	context.undoTree = undoTree
+	context.maxTrxID(int64(getInt64(data, 12)))
+	if rowCounter := int64(getInt64(data, 20)); rowCounter > context.rowCounter {
+		context.rowCounter = rowCounter
+	}
+	if lsn := getInt64(data, 32); lsn > context.feed.first {
+		context.feed.first = lsn
+	}
+	if commitCounter := int64(getInt64(data, 48)); commitCounter > context.commitCounter {
+		context.commitCounter = commitCounter
+	}
+	if format < 2 {
+		context.resetHistory()
+	} else if err = context.loadHistory(data, indexEnd); err != nil {
+		return 0, err
+	}
+	return getInt64(data, 4), nil
+}
+
+// OpenTrxContext to open a trx context stored in fileName, changes since
+// the last checkpoint are recovered from the redo log fileName-wal.
+func OpenTrxContext(fileName string) (*TrxContext, error) {
+	context := CreateTrxContext()
+	context.fileName = fileName
+	context.checkpointSize = defaultCheckpointSize
+
+	ckptLSN, err := context.readStore()
+	if err != nil {
+		return nil, err
+	}
+	// the versions stored without commit timestamp were committed before
+	context.frozenID = context.trxCounter
+
+	log, records, err := openRedoLog(fileName + "-wal")
+	if err != nil {
+		return nil, err
+	}
+	if context.recover(records, ckptLSN) {
+		// the index entries of the rows recovered are not logged, the
+		// indexes are built again from the rows
+		context.indexTree = createKeyTree(indexOrder)
+	}
+	if log.lsn < ckptLSN {
+		log.lsn = ckptLSN
+	}
+	context.log = log
+
+	if err = context.checkpoint(); err != nil {
+		log.close()
+		return nil, err
+	}
+	return context, nil
+}
+
+// load rebuilds rows and undo chains from the trees of a store file in
+// format, the versions of format 0 have no commit timestamp.
+func (context *TrxContext) load(rowTree, undoTree *BPlusTree, format uint32) error {
+	rowHeader, undoHeader := rowHeaderSize, undoHeaderSize
+	if format == 0 {
+		rowHeader, undoHeader = rowHeaderSize-8, undoHeaderSize-8
+	}
+
+	rollPtrs := make(map[*record]int64)
+	for c := undoTree.First(); c.Valid(); c.Next() {
+		payload := c.Payload()
+		if len(payload) < undoHeader {
+			return errors.New("The undo record is corrupt")
+		}
+
+		undoNo := int64(c.Key())
+		for int64(len(context.undo)) < undoNo {
+			u := new(record)
+			context.undo = append(context.undo, u)
+			u.undoNo = int64(len(context.undo))
+			context.freeUndo = append(context.freeUndo, u)
+		}
+
+		u := context.undo[undoNo-1]
+		context.freeUndo = context.freeUndo[:len(context.freeUndo)-1]
+		u.rowID = int64(binary.BigEndian.Uint64(payload))
+		u.trxID = int64(binary.BigEndian.Uint64(payload[8:]))
+		u.deleted = payload[24] != 0
+		if format > 0 {
+			u.commitTS = int64(binary.BigEndian.Uint64(payload[25:]))
+		}
+		u.data = payload[undoHeader:]
+		rollPtrs[u] = int64(binary.BigEndian.Uint64(payload[16:]))
+		context.maxTrxID(u.trxID)
+	}
+
+	for c := rowTree.First(); c.Valid(); c.Next() {
+		payload := c.Payload()
+		if len(payload) < rowHeader {
+			return errors.New("The row record is corrupt")
+		}
+
+		r := context.allocteRecord(int64(c.Key()))
+		r.trxID = int64(binary.BigEndian.Uint64(payload))
+		r.deleted = payload[16] != 0
+		if format > 0 {
+			r.commitTS = int64(binary.BigEndian.Uint64(payload[17:]))
+		}
+		r.data = payload[rowHeader:]
+		rollPtrs[r] = int64(binary.BigEndian.Uint64(payload[8:]))
+		context.maxTrxID(r.trxID)
+		if r.rowID > context.rowCounter {
+			context.rowCounter = r.rowID
+		}
+	}
+
+	for r, undoNo := range rollPtrs {
+		if undoNo > int64(len(context.undo)) {
+			return errors.New("The roll pointer is out of range")
+		}
+		if undoNo > 0 {
+			r.rollPtr = context.undo[undoNo-1]
+		}
+	}
+
+	return nil
+}
+
+func (context *TrxContext) maxTrxID(trxID int64) {
+	if trxID > context.trxCounter {
+		context.trxCounter = trxID
+	}
+}
+
+// checkpoint to write the trees to the store file and restart the redo log,
+// the trx active at the checkpoint are recorded so recovery can undo them.
+func (context *TrxContext) checkpoint() error {
+	if context.fileName == "" {
+		return nil
+	}
+
+	lsn := context.log.currentLSN()
+	if err := context.log.force(lsn); err != nil {
+		return err
+	}
+	if err := context.writeStore(lsn); err != nil {
+		return err
+	}
+
+	active := make([]int64, 0)
+	for _, t := range context.trxIDs {
+		if t.status == uncommit && !t.committing && t.trxID != 0 {
+			active = append(active, t.trxID)
+		}
+	}
+	return context.log.reset(lsn, active)
+}
+
+// SetCheckpointSize to checkpoint at the commit which grows the redo log
+// to size bytes, 0 checkpoints at Flush and Close only.
+func (context *TrxContext) SetCheckpointSize(size int64) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.checkpointSize = size
+}
+
+// Flush to checkpoint the trx context
+func (context *TrxContext) Flush() error {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	return context.checkpoint()
+}
+
+// Close to checkpoint and close the trx context, the trx must have finished
+func (context *TrxContext) Close() error {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	if context.log == nil {
+		return nil
+	}
+
+	err := context.checkpoint()
+	context.feed.close()
+	if cerr := context.log.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
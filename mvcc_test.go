@@ -1,7 +1,13 @@
 package gosqlite_test
 
 import (
+	"fmt"
 	"gosqlite"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -45,3 +51,499 @@ func TestTrx2(t *testing.T) {
 	trx2.Commit()
 	trx3.Commit()
 }
+
+func rowData(rows []gosqlite.Row) []string {
+	data := make([]string, 0, len(rows))
+	for _, row := range rows {
+		data = append(data, string(row.Data))
+	}
+	return data
+}
+
+func TestTrxSelectUndo(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx1 := context.AllocteTrx()
+	trx1.Begin(context)
+	trx1.Insert(context, "trx1-data1")
+	trx1.Commit()
+
+	trx2 := context.AllocteTrx()
+	trx2.Begin(context)
+
+	trx3 := context.AllocteTrx()
+	trx3.Begin(context)
+	trx3.Update(context, 1, "trx3-data0")
+	trx3.Insert(context, "trx3-data1")
+
+	rows, err := trx2.Select(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rowData(rows); len(got) != 1 || got[0] != "trx1-data1" {
+		t.Fatalf("trx2 select %v", got)
+	}
+	if rows[0].RowID != 1 || rows[0].TrxID != 1 {
+		t.Fatalf("trx2 row %+v", rows[0])
+	}
+
+	rows, _ = trx3.Select(context)
+	if got := rowData(rows); len(got) != 2 || got[0] != "trx3-data0" || got[1] != "trx3-data1" {
+		t.Fatalf("trx3 select %v", got)
+	}
+
+	row, ok, err := trx2.Get(context, 1)
+	if err != nil || !ok || string(row.Data) != "trx1-data1" {
+		t.Fatalf("trx2 get %+v %v %v", row, ok, err)
+	}
+	if _, ok, _ = trx2.Get(context, 2); ok {
+		t.Fatal("trx2 sees uncommitted insert of trx3")
+	}
+
+	n := 0
+	trx3.Scan(context, func(row gosqlite.Row) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("scan did not stop, %d rows", n)
+	}
+
+	trx3.Rollback()
+	trx2.Commit()
+	if _, err = trx2.Select(context); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("select after commit %v", err)
+	}
+
+	trx4 := context.AllocteTrx()
+	trx4.Begin(context)
+	rows, _ = trx4.Select(context)
+	if got := rowData(rows); len(got) != 1 || got[0] != "trx1-data1" {
+		t.Fatalf("trx4 select after rollback %v", got)
+	}
+	trx4.Commit()
+}
+
+func TestTrxSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+
+	const rows = 16
+	context := gosqlite.CreateTrxContext()
+	init := context.AllocteTrx()
+	init.Begin(context)
+	for i := 0; i < rows; i++ {
+		init.Insert(context, "v0")
+	}
+	init.Commit()
+
+	want := make([]string, rows)
+	for i := range want {
+		want[i] = "v0"
+	}
+
+	reader := context.AllocteTrx()
+	reader.Begin(context)
+
+	for i := 1; i <= 1000000; i++ {
+		trx := context.AllocteTrx()
+		if trx == nil {
+			t.Fatalf("no trx slot after %d trx", i)
+		}
+		trx.Begin(context)
+		data := fmt.Sprintf("v%d", i)
+		trx.Update(context, int64(i%rows+1), data)
+		if i%10 == 0 {
+			trx.Rollback()
+		} else {
+			trx.Commit()
+			want[i%rows] = data
+		}
+
+		if i == 100000 {
+			got, _ := reader.Select(context)
+			for _, row := range got {
+				if string(row.Data) != "v0" {
+					t.Fatalf("reader sees %d:%s", row.RowID, row.Data)
+				}
+			}
+			reader.Commit()
+		}
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	got, _ := trx.Select(context)
+	if len(got) != rows {
+		t.Fatalf("select %d rows", len(got))
+	}
+	for _, row := range got {
+		if string(row.Data) != want[row.RowID-1] {
+			t.Fatalf("row %d is %s, want %s", row.RowID, row.Data, want[row.RowID-1])
+		}
+	}
+	trx.Commit()
+}
+
+const benchRows = 100000
+
+func benchContext(b *testing.B) *gosqlite.TrxContext {
+	context := gosqlite.CreateTrxContext()
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < benchRows; i++ {
+		trx.Insert(context, "data")
+	}
+	trx.Commit()
+	b.ResetTimer()
+	return context
+}
+
+func BenchmarkTrxGet(b *testing.B) {
+	context := benchContext(b)
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < b.N; i++ {
+		trx.Get(context, int64(i%benchRows+1))
+	}
+	trx.Commit()
+}
+
+// BenchmarkTrxGetScan is the baseline of BenchmarkTrxGet, it finds the
+// row by walking all rows as lookups did before rows were indexed.
+func BenchmarkTrxGetScan(b *testing.B) {
+	context := benchContext(b)
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < b.N; i++ {
+		rowID := int64(i%benchRows + 1)
+		found := 0
+		trx.Scan(context, func(row gosqlite.Row) bool {
+			if row.RowID == rowID {
+				found++
+			}
+			return true
+		})
+		if found != 1 {
+			b.Fatalf("row %d found %d times", rowID, found)
+		}
+	}
+	trx.Commit()
+}
+
+func BenchmarkTrxUpdate(b *testing.B) {
+	context := benchContext(b)
+	for i := 0; i < b.N; i++ {
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		trx.Update(context, int64(i%benchRows+1), "update")
+		trx.Commit()
+	}
+}
+
+func BenchmarkTrxSelect(b *testing.B) {
+	context := benchContext(b)
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < b.N; i++ {
+		trx.Select(context)
+	}
+	trx.Commit()
+}
+
+func TestTrxConcurrent(t *testing.T) {
+	const accounts = 8
+	const balance = 100
+	context := gosqlite.CreateTrxContext()
+	init := context.AllocteTrx()
+	init.Begin(context)
+	for i := 0; i < accounts; i++ {
+		init.Insert(context, strconv.Itoa(balance))
+	}
+	init.Commit()
+
+	sum := func(rows []gosqlite.Row) int {
+		total := 0
+		for _, row := range rows {
+			n, _ := strconv.Atoi(string(row.Data))
+			total += n
+		}
+		return total
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				from := int64((w+i)%accounts + 1)
+				to := int64((w+2*i+1)%accounts + 1)
+				if from == to {
+					continue
+				}
+
+				trx := context.AllocteTrx()
+				trx.Begin(context)
+				a, _, _ := trx.Get(context, from)
+				b, _, _ := trx.Get(context, to)
+				x, _ := strconv.Atoi(string(a.Data))
+				y, _ := strconv.Atoi(string(b.Data))
+				if err := trx.Update(context, from, strconv.Itoa(x-1)); err != nil {
+					trx.Rollback()
+					continue
+				}
+				if err := trx.Update(context, to, strconv.Itoa(y+1)); err != nil {
+					trx.Rollback()
+					continue
+				}
+				trx.Commit()
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				trx := context.AllocteTrx()
+				trx.Begin(context)
+				rows, _ := trx.Select(context)
+				again, _ := trx.Select(context)
+				trx.Commit()
+
+				if len(rows) != accounts || sum(rows) != accounts*balance {
+					errs <- fmt.Errorf("snapshot of %d rows sums to %d", len(rows), sum(rows))
+					return
+				}
+				for i := range rows {
+					if string(rows[i].Data) != string(again[i].Data) {
+						errs <- fmt.Errorf("row %d changed inside snapshot", rows[i].RowID)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	rows, _ := trx.Select(context)
+	trx.Commit()
+	if sum(rows) != accounts*balance {
+		t.Fatalf("total %d", sum(rows))
+	}
+}
+
+// modelTrx is the brute-force model of a trx: the rows committed before
+// it began plus its own writes.
+type modelTrx struct {
+	trx      *gosqlite.Trx
+	begin    int
+	snapshot map[int64]string
+}
+
+// modelVersion is a version of a row in the model, commitAt is 0 until
+// the writer commits.
+type modelVersion struct {
+	writer   *modelTrx
+	commitAt int
+}
+
+// a trx that begins first but commits last is seen in commit order
+func TestTrxCommitOrder(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx1 := beginTrx(context)
+	trx1.Insert(context, "a")
+	trx1.Commit()
+
+	trx2 := beginTrx(context)
+	trx2.Update(context, 1, "b")
+	trx3 := beginTrx(context)
+	trx3.Insert(context, "c")
+	trx3.Commit()
+
+	trx4 := beginTrx(context)
+	context.Purge()
+	trx2.Commit()
+	context.Purge()
+	trx5 := beginTrx(context)
+
+	rows, _ := trx4.Select(context)
+	if got := rowData(rows); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("trx4 sees %v", got)
+	}
+	rows, _ = trx5.Select(context)
+	if got := rowData(rows); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("trx5 sees %v", got)
+	}
+	if err := trx4.Update(context, 1, "d"); err != gosqlite.ErrWriteConflict {
+		t.Fatalf("update after later commit %v", err)
+	}
+}
+
+func TestTrxReadViewModel(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		checkReadViewModel(t, rand.New(rand.NewSource(seed)))
+	}
+}
+
+func checkReadViewModel(t *testing.T, rnd *rand.Rand) {
+	context := gosqlite.CreateTrxContext()
+	committed := make(map[int64]string)
+	versions := make(map[int64][]*modelVersion)
+	active := make([]*modelTrx, 0)
+	clock := 0
+	nextRowID := int64(1)
+
+	for step := 0; step < 300; step++ {
+		clock++
+		if len(active) == 0 || (len(active) < 6 && rnd.Intn(4) == 0) {
+			m := &modelTrx{trx: context.AllocteTrx(), begin: clock, snapshot: make(map[int64]string)}
+			m.trx.Begin(context)
+			for k, v := range committed {
+				m.snapshot[k] = v
+			}
+			active = append(active, m)
+			continue
+		}
+
+		i := rnd.Intn(len(active))
+		m := active[i]
+		switch op := rnd.Intn(10); {
+		case op < 3:
+			data := fmt.Sprintf("i%d", step)
+			rowID, _ := m.trx.Insert(context, data)
+			if rowID != nextRowID {
+				t.Fatalf("insert rowID %d, want %d", rowID, nextRowID)
+			}
+			nextRowID++
+			m.snapshot[rowID] = data
+			versions[rowID] = append(versions[rowID], &modelVersion{writer: m})
+		case op < 6:
+			rowID := rnd.Int63n(nextRowID) + 1
+			data := fmt.Sprintf("u%d", step)
+			var want error
+			if _, ok := m.snapshot[rowID]; !ok {
+				want = gosqlite.ErrRowNotFound
+			} else {
+				v := versions[rowID][len(versions[rowID])-1]
+				if v.writer != m && (v.commitAt == 0 || v.commitAt > m.begin) {
+					want = gosqlite.ErrWriteConflict
+				}
+			}
+			if err := m.trx.Update(context, rowID, data); err != want {
+				t.Fatalf("update %d: %v, want %v", rowID, err, want)
+			}
+			if want == nil {
+				m.snapshot[rowID] = data
+				versions[rowID] = append(versions[rowID], &modelVersion{writer: m})
+			}
+		case op < 8:
+			got, _ := m.trx.Select(context)
+			if len(got) != len(m.snapshot) {
+				t.Fatalf("select %d rows, want %d", len(got), len(m.snapshot))
+			}
+			for _, row := range got {
+				if m.snapshot[row.RowID] != string(row.Data) {
+					t.Fatalf("row %d is %s, want %s", row.RowID, row.Data, m.snapshot[row.RowID])
+				}
+			}
+		case op < 9:
+			m.trx.Commit()
+			for rowID, vs := range versions {
+				for _, v := range vs {
+					if v.writer == m {
+						v.commitAt = clock
+						committed[rowID] = m.snapshot[rowID]
+					}
+				}
+			}
+			active = append(active[:i], active[i+1:]...)
+		default:
+			m.trx.Rollback()
+			for rowID, vs := range versions {
+				for len(vs) > 0 && vs[len(vs)-1].writer == m {
+					vs = vs[:len(vs)-1]
+				}
+				versions[rowID] = vs
+			}
+			active = append(active[:i], active[i+1:]...)
+		}
+	}
+}
+
+func TestTrxContextReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < 200; i++ {
+		trx.Insert(context, strings.Repeat(strconv.Itoa(i), i))
+	}
+	trx.Commit()
+
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 7, "updated")
+	trx.Commit()
+
+	reader := context.AllocteTrx()
+	reader.Begin(context)
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 8, "updated-8")
+	trx.Commit()
+
+	rolled := context.AllocteTrx()
+	rolled.Begin(context)
+	rolled.Insert(context, "rolled back")
+	rolled.Update(context, 9, "rolled back")
+	rolled.Rollback()
+
+	if err = context.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	rows, _ := trx.Select(context)
+	if len(rows) != 200 {
+		t.Fatalf("select %d rows after reopen", len(rows))
+	}
+	for i, row := range rows {
+		want := strings.Repeat(strconv.Itoa(i), i)
+		switch row.RowID {
+		case 7:
+			want = "updated"
+		case 8:
+			want = "updated-8"
+		}
+		if string(row.Data) != want {
+			t.Fatalf("row %d is %q, want %q", row.RowID, row.Data, want)
+		}
+	}
+	if row, _, _ := trx.Get(context, 201); row.RowID != 0 {
+		t.Fatalf("rolled back insert is stored")
+	}
+	if rowID, _ := trx.Insert(context, "new"); rowID <= 200 {
+		t.Fatalf("rowID %d reused", rowID)
+	}
+	trx.Commit()
+}
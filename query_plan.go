@@ -0,0 +1,380 @@
+package gosqlite
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+const (
+	// defaultRows is the count of rows assumed of a table not analyzed
+	defaultRows = 1000000
+	// defaultEqRows is the count of rows assumed to share the value of the
+	// first column of an index not analyzed, one fewer for each next column
+	defaultEqRows = 10
+	// rangeFactor divides the rows read for each bound of a range
+	rangeFactor = 4
+	// termFactor divides the rows of a loop for each term checked on them
+	termFactor = 4
+	// maxJoinOrder is the most tables of a join whose orders are all tried
+	maxJoinOrder = 5
+)
+
+// the costs of reading a row in a scan, an entry of an index and a row by
+// its rowid
+const (
+	scanCost   = 2
+	entryCost  = 1
+	lookupCost = 4
+)
+
+var queryPlanColumns = []string{"id", "parent", "notused", "detail"}
+
+// planNote is a row of EXPLAIN QUERY PLAN, id is the address of the
+// instruction it describes unless an earlier note has it, parent is the
+// id of the note of the subquery it is part of, 0 if none.
+type planNote struct {
+	id     int
+	parent int
+	detail string
+}
+
+// estimatedRows returns the count of rows of the table
+func (info *tableInfo) estimatedRows() float64 {
+	if len(info.stat) > 0 {
+		return math.Max(float64(info.stat[0]), 1)
+	}
+	return defaultRows
+}
+
+// eqRows returns the count of rows of table with the same values in the
+// first n columns of idx
+func (idx *indexInfo) eqRows(table *tableInfo, n int) float64 {
+	rows := table.estimatedRows()
+	switch {
+	case n == 0:
+	case n < len(idx.stat):
+		rows = math.Min(rows, float64(idx.stat[n]))
+	case idx.unique && n == len(idx.columns):
+		rows = 1
+	default:
+		rows = math.Min(rows, math.Max(defaultEqRows-float64(n-1), 1))
+	}
+	return math.Max(rows, 1)
+}
+
+// accessPath is a way of reading the rows of a table: a seek of the
+// rowids equal to keys, a range of rowids, a range of an index or else a
+// scan. used is the count of terms it applies, covering is set when the
+// index has the columns the statement reads of the table. rows and cost
+// are the estimates of the rows read and of the work of reading them.
+type accessPath struct {
+	keys       []sql.Expr
+	lo, hi     sql.Expr
+	loOp, hiOp string
+	index      *indexRange
+	values     []sql.Expr
+	covering   bool
+	used       int
+	rows, cost float64
+}
+
+// accessPaths returns the ways of reading table with the terms, the scan
+// first. usable tells whether an expr can be evaluated before the read,
+// used tells which columns of s the statement reads, nil for all of them.
+// The rows are still read from the versions to check their visibility,
+// which costs nothing more for a covering index as the rows are in memory.
+func accessPaths(info *tableInfo, s *scope, terms []sql.Expr, usable func(sql.Expr) bool, used []bool) []*accessPath {
+	n := info.estimatedRows()
+	seek := math.Log2(n) + 1
+	paths := []*accessPath{{rows: n, cost: n * scanCost}}
+
+	rng := &accessPath{}
+	for _, e := range terms {
+		switch e := e.(type) {
+		case *sql.InExpr:
+			if e.Not || !isRowIDRef(e.X, s, info) {
+				continue
+			}
+			ok := true
+			for _, x := range e.List {
+				ok = ok && usable(x)
+			}
+			if ok {
+				k := float64(len(e.List))
+				paths = append(paths, &accessPath{keys: e.List, used: 1, rows: k, cost: k * (seek + lookupCost)})
+			}
+		case *sql.BetweenExpr:
+			if !e.Not && isRowIDRef(e.X, s, info) && usable(e.Low) && usable(e.High) && rng.lo == nil && rng.hi == nil {
+				rng.lo, rng.loOp, rng.hi, rng.hiOp = e.Low, ">=", e.High, "<="
+				rng.used++
+			}
+		case *sql.BinaryExpr:
+			x, y, op := e.X, e.Y, e.Op
+			if isRowIDRef(y, s, info) {
+				x, y, op = y, x, swapped[op]
+			}
+			if op == "" || !isRowIDRef(x, s, info) || !usable(y) {
+				continue
+			}
+			switch {
+			case op == "=":
+				paths = append(paths, &accessPath{keys: []sql.Expr{y}, used: 1, rows: 1, cost: seek + lookupCost})
+			case strings.HasPrefix(op, ">") && rng.lo == nil:
+				rng.lo, rng.loOp = y, op
+				rng.used++
+			case strings.HasPrefix(op, "<") && rng.hi == nil:
+				rng.hi, rng.hiOp = y, op
+				rng.used++
+			}
+		}
+	}
+	if rng.lo != nil || rng.hi != nil {
+		rng.rows = n / math.Pow(rangeFactor, float64(bounds(rng.loOp, rng.hiOp)))
+		rng.cost = seek + rng.rows*scanCost
+		paths = append(paths, rng)
+	}
+
+	for _, idx := range info.indexes {
+		r, values, k := matchIndex(idx, s, terms, usable)
+		if k == 0 {
+			continue
+		}
+		p := &accessPath{index: r, values: values, covering: covers(info, idx, used), used: k}
+		p.rows = idx.eqRows(info, r.eq) / math.Pow(rangeFactor, float64(bounds(r.loOp, r.hiOp)))
+		per := float64(entryCost)
+		if !p.covering {
+			per += lookupCost
+		}
+		p.cost = seek + p.rows*per
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// bounds returns the count of the bounds of a range
+func bounds(loOp, hiOp string) int {
+	n := 0
+	if loOp != "" {
+		n++
+	}
+	if hiOp != "" {
+		n++
+	}
+	return n
+}
+
+// covers returns whether idx has the columns of table that are used
+func covers(info *tableInfo, idx *indexInfo, used []bool) bool {
+	if used == nil {
+		return false
+	}
+	for i, u := range used {
+		if !u || i == 0 || i == info.rowidColumn+1 {
+			continue
+		}
+		found := false
+		for _, c := range idx.columns {
+			found = found || c == i-1
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cheapest returns the path of the least cost, the first of equal ones
+func cheapest(paths []*accessPath) *accessPath {
+	best := paths[0]
+	for _, p := range paths[1:] {
+		if p.cost < best.cost {
+			best = p
+		}
+	}
+	return best
+}
+
+// usedColumns returns which columns of s the statement reads, the
+// columns of its unresolved names are left out.
+func usedColumns(stmt *sql.SelectStmt, s *scope) []bool {
+	used := make([]bool, len(s.columns))
+	mark := func(e sql.Expr) {
+		walkExpr(e, func(e sql.Expr) {
+			if ref, ok := e.(*sql.ColumnRef); ok {
+				if i, err := s.resolve(ref); err == nil {
+					used[i] = true
+				}
+			}
+		})
+	}
+	for _, rc := range stmt.Columns {
+		if rc.Star {
+			for i, c := range s.columns {
+				used[i] = used[i] || !c.hidden && (rc.Table == "" || strings.EqualFold(rc.Table, c.table))
+			}
+		}
+		mark(rc.Expr)
+	}
+	var on func(src sql.Source)
+	on = func(src sql.Source) {
+		if j, ok := src.(*sql.Join); ok {
+			on(j.Left)
+			on(j.Right)
+			mark(j.On)
+		}
+	}
+	on(stmt.From)
+	mark(stmt.Where)
+	for _, e := range stmt.GroupBy {
+		mark(e)
+	}
+	mark(stmt.Having)
+	for _, term := range stmt.OrderBy {
+		mark(term.Expr)
+	}
+	return used
+}
+
+// pathDetail returns the detail of EXPLAIN QUERY PLAN for reading the
+// table called name by p
+func pathDetail(info *tableInfo, name string, p *accessPath) string {
+	switch {
+	case len(p.keys) > 0:
+		return fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (rowid=?)", name)
+	case p.index != nil:
+		terms := make([]string, 0)
+		columns := p.index.index.columns
+		for _, c := range columns[:p.index.eq] {
+			terms = append(terms, info.columns[c].name+"=?")
+		}
+		for _, op := range []string{p.index.loOp, p.index.hiOp} {
+			if op != "" {
+				terms = append(terms, info.columns[columns[p.index.eq]].name+op+"?")
+			}
+		}
+		using := "INDEX"
+		if p.covering {
+			using = "COVERING INDEX"
+		}
+		return fmt.Sprintf("SEARCH %s USING %s %s (%s)", name, using, p.index.index.name, strings.Join(terms, " AND "))
+	case p.lo != nil || p.hi != nil:
+		terms := make([]string, 0)
+		for _, op := range []string{p.loOp, p.hiOp} {
+			if op != "" {
+				terms = append(terms, "rowid"+op+"?")
+			}
+		}
+		return fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (%s)", name, strings.Join(terms, " AND "))
+	}
+	return "SCAN " + name
+}
+
+// queryPlan returns the rows EXPLAIN QUERY PLAN shows for the program
+func (p *program) queryPlan() [][]interface{} {
+	rows := make([][]interface{}, len(p.plan))
+	for i, note := range p.plan {
+		rows[i] = []interface{}{int64(note.id), int64(note.parent), int64(0), note.detail}
+	}
+	return rows
+}
+
+// explainPlan to add a row of EXPLAIN QUERY PLAN for the next instruction
+// and return its id
+func (g *codegen) explainPlan(detail string) int {
+	id := len(g.instrs)
+	if n := len(g.plan); n > 0 && g.plan[n-1].id >= id {
+		id = g.plan[n-1].id + 1
+	}
+	g.plan = append(g.plan, planNote{id: id, parent: g.parent, detail: detail})
+	return id
+}
+
+// loopPaths returns the paths reading level l after the outer tables,
+// the terms are those of l.
+func (g *codegen) loopPaths(lp *loops, l *level, outer *scope) []*accessPath {
+	terms := lp.terms
+	if l.left {
+		terms = conjuncts(l.on)
+	}
+	paths := make([]*accessPath, 0)
+	for _, p := range accessPaths(l.info, lp.tableScope(l), terms, outer.has, l.used) {
+		// the program seeks a single rowid
+		if len(p.keys) <= 1 {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// joinOrder to order the loops of lp by the least cost when the tables
+// are all joined inner and are at most maxJoinOrder, a loop reads the
+// rows by its cheapest path and a term checked on them keeps a part of
+// them. The ON terms are checked in the innermost loop when the order
+// changes.
+func (g *codegen) joinOrder(lp *loops) {
+	n := len(lp.levels)
+	if n < 2 || n > maxJoinOrder {
+		return
+	}
+	for _, l := range lp.levels {
+		if l.left {
+			return
+		}
+	}
+
+	best, bestCost := lp.levels, math.Inf(1)
+	order := make([]*level, 0, n)
+	var try func(outer *scope, rows, cost float64)
+	try = func(outer *scope, rows, cost float64) {
+		if cost >= bestCost {
+			return
+		}
+		if len(order) == n {
+			best, bestCost = append([]*level(nil), order...), cost
+			return
+		}
+	next:
+		for _, l := range lp.levels {
+			for _, o := range order {
+				if o == l {
+					continue next
+				}
+			}
+			p := cheapest(g.loopPaths(lp, l, outer))
+			inner := outer.concat(lp.tableScope(l))
+			checked := -p.used
+			for _, t := range lp.terms {
+				if inner.has(t) && !outer.has(t) {
+					checked++
+				}
+			}
+			out := p.rows / math.Pow(termFactor, math.Max(float64(checked), 0))
+			order = append(order, l)
+			try(inner, rows*math.Max(out, 1), cost+rows*p.cost)
+			order = order[:len(order)-1]
+		}
+	}
+	try(&scope{}, 1, 0)
+
+	changed := false
+	for i, l := range best {
+		changed = changed || l != lp.levels[i]
+	}
+	if !changed {
+		return
+	}
+	var on sql.Expr
+	for _, l := range best {
+		if l.on != nil && on == nil {
+			on = l.on
+		} else if l.on != nil {
+			on = &sql.BinaryExpr{Span: sql.Span{Start: l.on.Pos()}, Op: "AND", X: on, Y: l.on}
+		}
+		l.on = nil
+	}
+	best[n-1].on = on
+	lp.levels = best
+}
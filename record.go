@@ -0,0 +1,223 @@
+package gosqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrRecordCorrupt is returned when a record can not be decoded.
+var ErrRecordCorrupt = errors.New("record is corrupt")
+
+// a record is a header of varints, the header size and the serial type of
+// every column, followed by the column values as in SQLite:
+// 0 NULL, 1-6 big endian integers of 1, 2, 3, 4, 6 and 8 bytes,
+// 7 float64, 8 and 9 the integers 0 and 1, even N >= 12 a blob of
+// (N-12)/2 bytes and odd N >= 13 a text of (N-13)/2 bytes.
+var intSizes = [...]int{0, 1, 2, 3, 4, 6, 8}
+
+// putVarint to append v as a SQLite varint: big endian groups of 7 bits
+// with the high bit set on all but the last, the 9th byte holds 8 bits.
+func putVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := buf[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// getVarint returns the varint at the start of b and its length, 0 when b is too short
+func getVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+func varintLen(v uint64) int {
+	n := 1
+	for v > 0x7f && n < 9 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func intSerialType(v int64) uint64 {
+	switch {
+	case v == 0:
+		return 8
+	case v == 1:
+		return 9
+	case v >= -1<<7 && v < 1<<7:
+		return 1
+	case v >= -1<<15 && v < 1<<15:
+		return 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3
+	case v >= -1<<31 && v < 1<<31:
+		return 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5
+	}
+	return 6
+}
+
+// serialType returns the serial type of value, values are nil, int64,
+// float64, string and []byte.
+func serialType(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return intSerialType(v), nil
+	case float64:
+		return 7, nil
+	case string:
+		return uint64(len(v))*2 + 13, nil
+	case []byte:
+		return uint64(len(v))*2 + 12, nil
+	}
+	return 0, fmt.Errorf("unsupported value type %T", value)
+}
+
+// EncodeRecord to encode the column values of a row, values are nil,
+// int64, float64, string or []byte.
+func EncodeRecord(values []interface{}) ([]byte, error) {
+	types := make([]uint64, len(values))
+	headerSize := 0
+	for i, value := range values {
+		typ, err := serialType(value)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = typ
+		headerSize += varintLen(typ)
+	}
+	// the header size counts its own varint
+	size := headerSize + 1
+	for headerSize+varintLen(uint64(size)) != size {
+		size = headerSize + varintLen(uint64(size))
+	}
+	headerSize = size
+
+	b := putVarint(make([]byte, 0, headerSize+8*len(values)), uint64(headerSize))
+	for _, typ := range types {
+		b = putVarint(b, typ)
+	}
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64:
+			if types[i] < 7 {
+				var buf [8]byte
+				binary.BigEndian.PutUint64(buf[:], uint64(v))
+				b = append(b, buf[8-intSizes[types[i]]:]...)
+			}
+		case float64:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			b = append(b, buf[:]...)
+		case string:
+			b = append(b, v...)
+		case []byte:
+			b = append(b, v...)
+		}
+	}
+	return b, nil
+}
+
+// DecodeRecord to decode the column values of a record, text and blob
+// values are copied out of data.
+func DecodeRecord(data []byte) ([]interface{}, error) {
+	headerSize, n := getVarint(data)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(data)) {
+		return nil, ErrRecordCorrupt
+	}
+
+	values := make([]interface{}, 0)
+	header, body := data[n:headerSize], data[headerSize:]
+	for len(header) > 0 {
+		typ, n := getVarint(header)
+		if n == 0 {
+			return nil, ErrRecordCorrupt
+		}
+		header = header[n:]
+
+		size := 0
+		switch {
+		case typ < 7:
+			size = intSizes[typ]
+		case typ == 7:
+			size = 8
+		case typ >= 12:
+			size = int((typ - 12) / 2)
+		}
+		if typ == 10 || typ == 11 || size > len(body) {
+			return nil, ErrRecordCorrupt
+		}
+
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ < 7:
+			// sign extend the big endian integer
+			var x int64
+			if v[0]&0x80 != 0 {
+				x = -1
+			}
+			for _, c := range v {
+				x = x<<8 | int64(c)
+			}
+			values = append(values, x)
+		case typ == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case typ == 8 || typ == 9:
+			values = append(values, int64(typ-8))
+		case typ%2 == 0:
+			values = append(values, append([]byte{}, v...))
+		default:
+			values = append(values, string(v))
+		}
+	}
+	if len(body) != 0 {
+		return nil, ErrRecordCorrupt
+	}
+	return values, nil
+}
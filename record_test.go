@@ -0,0 +1,57 @@
+package gosqlite_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	values := []interface{}{nil, int64(0), int64(1), int64(-1), int64(127), int64(128), int64(-128),
+		int64(-129), int64(32767), int64(-32769), int64(1 << 23), int64(1<<31 - 1), int64(1 << 31),
+		int64(1 << 47), int64(-1 << 47), int64(math.MaxInt64), int64(math.MinInt64),
+		0.5, -1e300, "", "text", []byte{}, []byte{0, 1, 2}}
+
+	data, err := gosqlite.EncodeRecord(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gosqlite.DecodeRecord(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Fatalf("decoded %v", got)
+	}
+
+	// a header longer than 127 bytes takes a 2 byte header size
+	wide := make([]interface{}, 200)
+	for i := range wide {
+		wide[i] = int64(i)
+	}
+	data, _ = gosqlite.EncodeRecord(wide)
+	if got, err := gosqlite.DecodeRecord(data); err != nil || !reflect.DeepEqual(got, wide) {
+		t.Fatalf("wide record %v %v", got, err)
+	}
+}
+
+// the bytes match the SQLite record format
+func TestRecordFormat(t *testing.T) {
+	data, _ := gosqlite.EncodeRecord([]interface{}{nil, int64(1), "hi", int64(300), []byte{0xff}})
+	want := []byte{0x06, 0x00, 0x09, 0x11, 0x02, 0x0e, 'h', 'i', 0x01, 0x2c, 0xff}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("record % x, want % x", data, want)
+	}
+
+	if _, err := gosqlite.EncodeRecord([]interface{}{1}); err == nil {
+		t.Fatal("int is not a record value")
+	}
+	for _, corrupt := range [][]byte{nil, {0x05, 0x00}, {0x02, 0x11, 'h'}, {0x02, 0x0a}, {0x02, 0x01, 1, 2}} {
+		if _, err := gosqlite.DecodeRecord(corrupt); err != gosqlite.ErrRecordCorrupt {
+			t.Fatalf("decode % x: %v", corrupt, err)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package gosqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	logInsert     byte = 1
+	logUpdate     byte = 2
+	logDelete     byte = 3
+	logCommit     byte = 4
+	logAbort      byte = 5
+	logCheckpoint byte = 6
+	logUndo       byte = 7
+
+	// a log record is length, crc of the body, then the body:
+	// lsn, type, trxID, rowID and data
+	logHeaderSize = 8
+	logBodySize   = 25
+)
+
+// ErrLogClosed is returned when the redo log is used after Close.
+var ErrLogClosed = errors.New("redo log is closed")
+
+type logRecord struct {
+	lsn   uint64
+	typ   byte
+	trxID int64
+	rowID int64
+	data  []byte
+}
+
+// redoLog is an append only log of row changes, records are buffered
+// and written by force, concurrent commits share one write and fsync.
+type redoLog struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	fileName   string
+	file       *os.File
+	buf        []byte
+	lsn        uint64
+	flushedLSN uint64
+	// size is the length of the log file
+	size     int64
+	flushing bool
+	err      error
+}
+
+func encodeLogRecord(rec logRecord) []byte {
+	b := make([]byte, logHeaderSize+logBodySize+len(rec.data))
+	binary.BigEndian.PutUint32(b, uint32(logBodySize+len(rec.data)))
+	body := b[logHeaderSize:]
+	binary.BigEndian.PutUint64(body, rec.lsn)
+	body[8] = rec.typ
+	binary.BigEndian.PutUint64(body[9:], uint64(rec.trxID))
+	binary.BigEndian.PutUint64(body[17:], uint64(rec.rowID))
+	copy(body[logBodySize:], rec.data)
+	binary.BigEndian.PutUint32(b[4:], crc32.ChecksumIEEE(body))
+	return b
+}
+
+// decodeLogRecords returns the valid records of data and the length they
+// take, decoding stops at the first torn or corrupt record.
+func decodeLogRecords(data []byte) ([]logRecord, int) {
+	records := make([]logRecord, 0)
+	offset := 0
+	for len(data)-offset >= logHeaderSize+logBodySize {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < logBodySize || offset+logHeaderSize+size > len(data) {
+			break
+		}
+
+		body := data[offset+logHeaderSize : offset+logHeaderSize+size]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[offset+4:]) {
+			break
+		}
+
+		records = append(records, logRecord{
+			lsn:   binary.BigEndian.Uint64(body),
+			typ:   body[8],
+			trxID: int64(binary.BigEndian.Uint64(body[9:])),
+			rowID: int64(binary.BigEndian.Uint64(body[17:])),
+			data:  body[logBodySize:],
+		})
+		offset += logHeaderSize + size
+	}
+
+	return records, offset
+}
+
+// openRedoLog to open the log file and read its records, a torn tail
+// left by a crash is cut off.
+func openRedoLog(fileName string) (*redoLog, []logRecord, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	records, size := decodeLogRecords(data)
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = file.Truncate(int64(size)); err == nil {
+		_, err = file.Seek(int64(size), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	l := &redoLog{fileName: fileName, file: file, size: int64(size)}
+	l.cond = sync.NewCond(&l.mu)
+	if len(records) > 0 {
+		l.lsn = records[len(records)-1].lsn
+		l.flushedLSN = l.lsn
+	}
+	return l, records, nil
+}
+
+// append to add a record to the log buffer, returns its lsn
+func (l *redoLog) append(typ byte, trxID int64, rowID int64, data []byte) uint64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lsn++
+	l.buf = append(l.buf, encodeLogRecord(logRecord{l.lsn, typ, trxID, rowID, data})...)
+	return l.lsn
+}
+
+func (l *redoLog) currentLSN() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lsn
+}
+
+// force to make the log durable up to lsn, the first waiter writes and
+// syncs everything buffered so far while later commits wait for it.
+func (l *redoLog) force(lsn uint64) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.flushedLSN < lsn {
+		if l.err != nil {
+			return l.err
+		}
+		if l.flushing {
+			l.cond.Wait()
+			continue
+		}
+
+		buf, upTo := l.buf, l.lsn
+		l.buf = nil
+		l.flushing = true
+		l.mu.Unlock()
+
+		_, err := l.file.Write(buf)
+		if err == nil {
+			err = l.file.Sync()
+		}
+
+		l.mu.Lock()
+		l.flushing = false
+		if err != nil {
+			l.err = err
+		} else {
+			l.flushedLSN = upTo
+			l.size += int64(len(buf))
+		}
+		l.cond.Broadcast()
+	}
+
+	return nil
+}
+
+// reset to replace the log with a new one that starts with a checkpoint
+// record at lsn listing the trx still active.
+func (l *redoLog) reset(lsn uint64, active []int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+
+	data := make([]byte, 8*len(active))
+	for i, id := range active {
+		binary.BigEndian.PutUint64(data[8*i:], uint64(id))
+	}
+	rec := encodeLogRecord(logRecord{lsn: lsn, typ: logCheckpoint, data: data})
+	if err := writeFileSync(l.fileName, rec); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		l.err = err
+		return err
+	}
+
+	l.file.Close()
+	l.file = file
+	l.buf = nil
+	l.lsn = lsn
+	l.flushedLSN = lsn
+	l.size = int64(len(rec))
+	return nil
+}
+
+// written returns the length of the log file
+func (l *redoLog) written() int64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+func (l *redoLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.err = ErrLogClosed
+	return l.file.Close()
+}
+
+// writeFileSync replaces fileName with data through a synced temp file
+func writeFileSync(fileName string, data []byte) error {
+	tmp := fileName + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// encodeCommit returns the data of a commit record, the count of changes
+// the trx publishes to the feed and the time it commits at
+func encodeCommit(n int, at time.Time) []byte {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(n))
+	binary.BigEndian.PutUint64(data[8:], uint64(at.UnixNano()))
+	return data
+}
+
+func changeCount(rec logRecord) uint64 {
+	if len(rec.data) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(rec.data)
+}
+
+// commitTime returns the time of a commit record, the time it is read at
+// when the record has none
+func commitTime(rec logRecord) time.Time {
+	if len(rec.data) < 16 {
+		return time.Now()
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(rec.data[8:])))
+}
+
+func checkpointIDs(rec logRecord) []int64 {
+	ids := make([]int64, len(rec.data)/8)
+	for i := range ids {
+		ids[i] = int64(binary.BigEndian.Uint64(rec.data[8*i:]))
+	}
+	return ids
+}
+
+// recover replays the log on top of the state stored at checkpoint
+// ckptLSN: analysis finds the trx without commit or abort, changes after
+// the checkpoint are redone and the loser trx are undone from undo chains.
+// It returns whether a row was changed.
+func (context *TrxContext) recover(records []logRecord, ckptLSN uint64) bool {
+	// purge must not trim the undo chains of loser trx before they are undone
+	purgeAt := context.purgeAt
+	context.purgeAt = math.MaxInt32
+	defer func() { context.purgeAt = purgeAt }()
+
+	changed := false
+	active := make(map[int64]bool)
+	writes := make(map[int64][]*record)
+	for _, rec := range records {
+		context.maxTrxID(rec.trxID)
+		redo := rec.lsn > ckptLSN
+		if redo && rec.typ != logCheckpoint {
+			changed = true
+		}
+
+		switch rec.typ {
+		case logCheckpoint:
+			for _, id := range checkpointIDs(rec) {
+				active[id] = true
+			}
+		case logInsert:
+			active[rec.trxID] = true
+			if redo && context.findRecord(rec.rowID) == nil {
+				r := context.insertVersion(rec.rowID, rec.trxID, rec.data)
+				writes[rec.trxID] = append(writes[rec.trxID], r)
+			}
+			if rec.rowID > context.rowCounter {
+				context.rowCounter = rec.rowID
+			}
+		case logUpdate, logDelete:
+			active[rec.trxID] = true
+			if r := context.findRecord(rec.rowID); redo && r != nil {
+				context.newVersion(r, rec.trxID, rec.data, rec.typ == logDelete)
+				writes[rec.trxID] = append(writes[rec.trxID], r)
+			}
+		case logUndo:
+			if r := context.findRecord(rec.rowID); redo && r != nil && r.trxID == rec.trxID {
+				context.undoVersion(r)
+			}
+		case logCommit:
+			delete(active, rec.trxID)
+			if redo {
+				// the changes of the trx were published after the checkpoint
+				context.feed.first += changeCount(rec)
+				context.commitCounter++
+				context.committed[rec.trxID] = context.commitCounter
+				context.stampVersions(writes[rec.trxID], rec.trxID, context.commitCounter)
+				context.recordCommit(rec.trxID, context.commitCounter, commitTime(rec))
+			}
+			delete(writes, rec.trxID)
+		case logAbort:
+			delete(active, rec.trxID)
+			if redo {
+				context.undoTrx(map[int64]bool{rec.trxID: true})
+			}
+		}
+	}
+
+	context.undoTrx(active)
+	return changed || len(active) > 0
+}
+
+// undoTrx restores every row changed by the trx in ids to the version
+// before them.
+func (context *TrxContext) undoTrx(ids map[int64]bool) {
+	if len(ids) == 0 {
+		return
+	}
+
+	rows := make([]*record, 0)
+	for _, r := range context.dataPool {
+		rows = append(rows, r)
+	}
+	for _, r := range rows {
+		for r.rowID != 0 && ids[r.trxID] {
+			context.undoVersion(r)
+		}
+	}
+}
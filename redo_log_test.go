@@ -0,0 +1,345 @@
+package gosqlite_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"gosqlite"
+)
+
+func snapshot(t *testing.T, context *gosqlite.TrxContext) map[int64]string {
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	defer trx.Commit()
+
+	rows, err := trx.Select(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(map[int64]string)
+	for _, row := range rows {
+		data[row.RowID] = string(row.Data)
+	}
+	return data
+}
+
+func sameRows(a, b map[int64]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRedoRecovery(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	for i := 0; i < 10; i++ {
+		trx.Insert(context, "v"+strconv.Itoa(i))
+	}
+	if err = trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// active across the checkpoint and never committed
+	loser := context.AllocteTrx()
+	loser.Begin(context)
+	loser.Update(context, 1, "loser-1")
+	if err = context.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	loser.Update(context, 2, "loser-2")
+	loser.Insert(context, "loser-insert")
+
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 3, "winner-3")
+	trx.Delete(context, 4)
+	trx.Commit()
+
+	rolled := context.AllocteTrx()
+	rolled.Begin(context)
+	rolled.Update(context, 5, "rolled-5")
+	rolled.Rollback()
+
+	pending := context.AllocteTrx()
+	pending.Begin(context)
+	pending.Update(context, 6, "pending-6")
+
+	want := snapshot(t, context)
+
+	// crash: reopen without Close, buffered log records are lost
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := snapshot(t, context)
+	if !sameRows(got, want) {
+		t.Fatalf("recovered %v, want %v", got, want)
+	}
+	if _, ok := got[4]; ok {
+		t.Fatal("deleted row recovered")
+	}
+
+	// recovery is repeatable
+	context.Close()
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = snapshot(t, context); !sameRows(got, want) {
+		t.Fatalf("reopened %v, want %v", got, want)
+	}
+	context.Close()
+}
+
+func copyFile(t *testing.T, src, dst string, size int) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if size > len(data) {
+		size = len(data)
+	}
+	if err = ioutil.WriteFile(dst, data[:size], 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedoTornLog(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// states[k] is the data after k commits since the checkpoint
+	states := []map[int64]string{snapshot(t, context)}
+	for i := 0; i < 12; i++ {
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		if i%3 == 0 {
+			trx.Insert(context, "insert-"+strconv.Itoa(i))
+		} else {
+			trx.Update(context, int64(i/3+1), "update-"+strconv.Itoa(i))
+		}
+		if i%4 == 3 {
+			trx.Rollback()
+			continue
+		}
+		trx.Commit()
+		states = append(states, snapshot(t, context))
+	}
+
+	wal, err := ioutil.ReadFile(fileName + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := 0
+	for size := 0; size <= len(wal); size++ {
+		crash := filepath.Join(dir, "crash"+strconv.Itoa(size)+".db")
+		copyFile(t, fileName, crash, 1<<30)
+		copyFile(t, fileName+"-wal", crash+"-wal", size)
+
+		recovered, err := gosqlite.OpenTrxContext(crash)
+		if err != nil {
+			t.Fatalf("open at %d: %v", size, err)
+		}
+		got := snapshot(t, recovered)
+		recovered.Close()
+
+		k := last
+		for k < len(states) && !sameRows(got, states[k]) {
+			k++
+		}
+		if k == len(states) {
+			t.Fatalf("log cut at %d recovered %v, not a committed state", size, got)
+		}
+		last = k
+	}
+	if last != len(states)-1 {
+		t.Fatalf("full log recovered state %d of %d", last, len(states)-1)
+	}
+}
+
+func TestRedoGroupCommit(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				trx := context.AllocteTrx()
+				trx.Begin(context)
+				trx.Insert(context, "row")
+				if err := trx.Commit(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); len(got) != 160 {
+		t.Fatalf("recovered %d rows", len(got))
+	}
+	context.Close()
+}
+
+func TestRedoFailedForce(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	if err = trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 1, "b")
+	trx.Insert(context, "c")
+
+	// the log is closed under the trx, its commit record is never forced
+	context.Close()
+	if err = trx.Commit(); err != gosqlite.ErrLogClosed {
+		t.Fatalf("commit: %v", err)
+	}
+	want := map[int64]string{1: "a"}
+	if got := snapshot(t, context); !sameRows(got, want) {
+		t.Fatalf("rows %v after a failed commit, want %v", got, want)
+	}
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "d")
+	if err = trx.Commit(); err != gosqlite.ErrLogClosed {
+		t.Fatalf("later commit: %v", err)
+	}
+
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); !sameRows(got, want) {
+		t.Fatalf("recovered %v, want %v", got, want)
+	}
+	context.Close()
+}
+
+func TestRedoCheckpointSize(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context.SetCheckpointSize(4096)
+
+	want := make(map[int64]string)
+	for i := 1; i <= 200; i++ {
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		v := "row-" + strconv.Itoa(i)
+		trx.Insert(context, v)
+		if err = trx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		want[int64(i)] = v
+
+		info, err := os.Stat(fileName + "-wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() >= 4096 {
+			t.Fatalf("log of %d bytes after commit %d", info.Size(), i)
+		}
+	}
+
+	// crash: the rows are in the store and the log since the checkpoint
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); !sameRows(got, want) {
+		t.Fatalf("recovered %d rows, want %d", len(got), len(want))
+	}
+	context.Close()
+}
+
+func TestRedoCommitTS(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	var commitTS []int64
+	open := func() *gosqlite.TrxContext {
+		context, err := gosqlite.OpenTrxContext(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		context.OnAfterCommit(func(info gosqlite.TxInfo) {
+			commitTS = append(commitTS, info.CommitTS)
+		})
+		return context
+	}
+
+	context := open()
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	trx.Commit()
+	if err := context.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 1, "b")
+	trx.Commit()
+
+	// crash: the insert is stored with its commit timestamp, the update
+	// is redone and gets the next one
+	context = open()
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 1, "c")
+	trx.Commit()
+	if len(commitTS) != 3 || commitTS[0] >= commitTS[1] || commitTS[1]+1 != commitTS[2] {
+		t.Fatalf("commit timestamps %v", commitTS)
+	}
+	if got := snapshot(t, context); len(got) != 1 || got[1] != "c" {
+		t.Fatalf("rows %v", got)
+	}
+	context.Close()
+
+	context = open()
+	if got := snapshot(t, context); len(got) != 1 || got[1] != "c" {
+		t.Fatalf("reopened rows %v", got)
+	}
+	context.Close()
+}
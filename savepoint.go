@@ -0,0 +1,93 @@
+package gosqlite
+
+import "errors"
+
+// ErrSavepointNotFound is returned when a trx has no savepoint with the name.
+var ErrSavepointNotFound = errors.New("savepoint not found")
+
+// savepoint remembers how many versions the trx had written
+type savepoint struct {
+	name   string
+	writes int
+}
+
+// findSavepoint returns the index of the newest savepoint called name
+func (t *Trx) findSavepoint(name string) int {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i].name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Savepoint to mark the current position of trx, a savepoint with the
+// same name as an older one hides it until released.
+func (t *Trx) Savepoint(name string) error {
+	if t.ctx == nil {
+		return ErrTrxNotActive
+	}
+
+	t.ctx.mu.Lock()
+	defer t.ctx.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return ErrTrxNotActive
+	}
+
+	t.savepoints = append(t.savepoints, savepoint{name, len(t.writes)})
+	return nil
+}
+
+// RollbackTo to restore the versions trx wrote after savepoint name, the
+// savepoint stays and the savepoints after it are removed.
+func (t *Trx) RollbackTo(name string) error {
+	if t.ctx == nil {
+		return ErrTrxNotActive
+	}
+
+	t.ctx.mu.Lock()
+	defer t.ctx.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return ErrTrxNotActive
+	}
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return ErrSavepointNotFound
+	}
+
+	sp := t.savepoints[i]
+	for j := len(t.writes) - 1; j >= sp.writes; j-- {
+		r := t.writes[j]
+		t.ctx.log.append(logUndo, t.trxID, r.rowID, nil)
+		t.ctx.undoVersion(r)
+		t.writes[j] = nil
+	}
+	t.writes = t.writes[:sp.writes]
+	t.savepoints = t.savepoints[:i+1]
+	return nil
+}
+
+// Release to remove savepoint name and the savepoints after it, the
+// versions written since are kept.
+func (t *Trx) Release(name string) error {
+	if t.ctx == nil {
+		return ErrTrxNotActive
+	}
+
+	t.ctx.mu.Lock()
+	defer t.ctx.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return ErrTrxNotActive
+	}
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return ErrSavepointNotFound
+	}
+
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
@@ -0,0 +1,94 @@
+package gosqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestSavepoint(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	trx.Insert(context, "b")
+	trx.Commit()
+
+	trx = context.AllocteTrx()
+	trx.Begin(context)
+	trx.Update(context, 1, "a1")
+	trx.Savepoint("sp1")
+	trx.Update(context, 1, "a2")
+	trx.Update(context, 2, "b2")
+	trx.Insert(context, "c")
+	trx.Savepoint("sp2")
+	trx.Update(context, 2, "b3")
+
+	if err := trx.RollbackTo("sp1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); got[1] != "a" || got[2] != "b" {
+		t.Fatalf("other trx sees %v", got)
+	}
+	rows, _ := trx.Select(context)
+	if got := rowData(rows); len(got) != 2 || got[0] != "a1" || got[1] != "b" {
+		t.Fatalf("after rollback to sp1 %v", got)
+	}
+	if err := trx.RollbackTo("sp2"); err != gosqlite.ErrSavepointNotFound {
+		t.Fatalf("rollback to removed savepoint %v", err)
+	}
+
+	// row 2 is no longer written by trx, another trx can change it
+	other := context.AllocteTrx()
+	other.Begin(context)
+	if err := other.Update(context, 2, "other"); err != nil {
+		t.Fatal(err)
+	}
+	other.Commit()
+
+	// sp1 is kept after rollback to it
+	trx.Update(context, 1, "a3")
+	if err := trx.RollbackTo("sp1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trx.Release("sp1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trx.Release("sp1"); err != gosqlite.ErrSavepointNotFound {
+		t.Fatalf("release twice %v", err)
+	}
+	trx.Commit()
+
+	if got := snapshot(t, context); got[1] != "a1" || got[2] != "other" || len(got) != 2 {
+		t.Fatalf("after commit %v", got)
+	}
+}
+
+func TestSavepointRecovery(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	context, err := gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	trx.Savepoint("sp")
+	trx.Update(context, 1, "a1")
+	trx.Insert(context, "b")
+	trx.RollbackTo("sp")
+	trx.Insert(context, "c")
+	trx.Commit()
+	want := snapshot(t, context)
+
+	context, err = gosqlite.OpenTrxContext(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); !sameRows(got, want) {
+		t.Fatalf("recovered %v, want %v", got, want)
+	}
+	context.Close()
+}
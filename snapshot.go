@@ -0,0 +1,73 @@
+package gosqlite
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrSnapshotNotFound is returned when a snapshot is not exported or its trx finished.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// SnapshotID names a read view exported by a trx
+type SnapshotID int64
+
+// exportedSnapshot is valid until the exporting trx finishes
+type exportedSnapshot struct {
+	view *readView
+	trx  *Trx
+}
+
+// BeginReadOnly to begin a trx that only reads, it takes no trx id and
+// rejects writes with ErrTrxReadOnly.
+func (t *Trx) BeginReadOnly(context *TrxContext) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	t.begin(context, 0, context.createReadView(0))
+	t.readOnly = true
+}
+
+// BeginWithSnapshot to begin a trx that reads from the snapshot exported
+// as id, all trx begun with it see the same data.
+func (t *Trx) BeginWithSnapshot(context *TrxContext, id SnapshotID) error {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	s, ok := context.snapshots[id]
+	if !ok {
+		return ErrSnapshotNotFound
+	}
+
+	trxID := atomic.AddInt64(&context.trxCounter, 1)
+	t.begin(context, trxID, &readView{snapshotTS: s.view.snapshotTS, creatorID: trxID})
+	return nil
+}
+
+// ExportSnapshot to export the read view of trx, it can be imported by
+// BeginWithSnapshot until trx commits or rolls back.
+func (t *Trx) ExportSnapshot() (SnapshotID, error) {
+	if t.ctx == nil {
+		return 0, ErrTrxNotActive
+	}
+
+	t.ctx.mu.Lock()
+	defer t.ctx.mu.Unlock()
+
+	if t.status != uncommit || t.committing {
+		return 0, ErrTrxNotActive
+	}
+
+	t.ctx.snapshotCounter++
+	id := SnapshotID(t.ctx.snapshotCounter)
+	t.ctx.snapshots[id] = exportedSnapshot{t.view, t}
+	return id, nil
+}
+
+// dropSnapshots removes the snapshots exported by trx
+func (context *TrxContext) dropSnapshots(t *Trx) {
+	for id, s := range context.snapshots {
+		if s.trx == t {
+			delete(context.snapshots, id)
+		}
+	}
+}
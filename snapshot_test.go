@@ -0,0 +1,93 @@
+package gosqlite_test
+
+import (
+	"testing"
+
+	"gosqlite"
+)
+
+func TestBeginReadOnly(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	trx.Commit()
+
+	ro := context.AllocteTrx()
+	ro.BeginReadOnly(context)
+	if _, err := ro.Insert(context, "b"); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("insert %v", err)
+	}
+	if err := ro.Update(context, 1, "b"); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("update %v", err)
+	}
+	if err := ro.Delete(context, 1); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("delete %v", err)
+	}
+
+	// a read-only trx takes no trx id
+	trx = beginTrx(context)
+	trx.Update(context, 1, "b")
+	trx.Commit()
+	row, ok, _ := ro.Get(context, 1)
+	if !ok || string(row.Data) != "a" {
+		t.Fatalf("read-only trx sees %v", row)
+	}
+	if err := ro.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	trx = beginTrx(context)
+	if row, _, _ := trx.Get(context, 1); row.TrxID != 2 {
+		t.Fatalf("row written by trx %d, want 2", row.TrxID)
+	}
+	trx.Commit()
+}
+
+func TestExportSnapshot(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	trx.Commit()
+
+	exporter := beginTrx(context)
+	id, err := exporter.ExportSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trx = beginTrx(context)
+	trx.Update(context, 1, "b")
+	trx.Insert(context, "c")
+	trx.Commit()
+	context.Purge()
+
+	workers := make([]*gosqlite.Trx, 3)
+	for i := range workers {
+		workers[i] = context.AllocteTrx()
+		if err := workers[i].BeginWithSnapshot(context, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, w := range workers {
+		rows, _ := w.Select(context)
+		if got := rowData(rows); len(got) != 1 || got[0] != "a" {
+			t.Fatalf("worker %d sees %v", i, got)
+		}
+	}
+
+	// an imported snapshot does not see the row changed after it
+	if err := workers[0].Update(context, 1, "d"); err != gosqlite.ErrWriteConflict {
+		t.Fatalf("update %v", err)
+	}
+	for _, w := range workers {
+		w.Commit()
+	}
+
+	exporter.Commit()
+	if err := context.AllocteTrx().BeginWithSnapshot(context, id); err != gosqlite.ErrSnapshotNotFound {
+		t.Fatalf("import after exporter finished %v", err)
+	}
+	if _, err := exporter.ExportSnapshot(); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("export from finished trx %v", err)
+	}
+}
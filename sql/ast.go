@@ -0,0 +1,329 @@
+package sql
+
+// Node is a node of the syntax tree
+type Node interface {
+	Pos() Pos
+}
+
+// Stmt is a statement
+type Stmt interface {
+	Node
+	stmt()
+}
+
+// Expr is an expression
+type Expr interface {
+	Node
+	expr()
+}
+
+// Span is embedded in every node, Start is where the node begins
+type Span struct {
+	Start Pos
+}
+
+// Pos returns where the node begins
+func (s Span) Pos() Pos {
+	return s.Start
+}
+
+// CreateTableStmt is CREATE TABLE [IF NOT EXISTS] name (columns)
+type CreateTableStmt struct {
+	Span
+	IfNotExists bool
+	Name        string
+	Columns     []*ColumnDef
+}
+
+// ColumnDef is a column of CREATE TABLE with its constraints
+type ColumnDef struct {
+	Span
+	Name       string
+	Type       string
+	PrimaryKey bool
+	Desc       bool
+	NotNull    bool
+	Unique     bool
+	Default    Expr
+	Collate    string
+}
+
+// CreateIndexStmt is CREATE [UNIQUE] INDEX [IF NOT EXISTS] name ON table (columns)
+type CreateIndexStmt struct {
+	Span
+	Unique      bool
+	IfNotExists bool
+	Name        string
+	Table       string
+	Columns     []*IndexedColumn
+}
+
+// IndexedColumn is a column of an index
+type IndexedColumn struct {
+	Span
+	Name    string
+	Collate string
+	Desc    bool
+}
+
+// DropStmt is DROP TABLE or DROP INDEX
+type DropStmt struct {
+	Span
+	Index    bool
+	IfExists bool
+	Name     string
+}
+
+// InsertStmt is INSERT INTO table [(columns)] VALUES (row), ...
+type InsertStmt struct {
+	Span
+	Table   string
+	Columns []string
+	Rows    [][]Expr
+}
+
+// UpdateStmt is UPDATE table SET column = expr, ... [WHERE expr]
+type UpdateStmt struct {
+	Span
+	Table string
+	Set   []*Assignment
+	Where Expr
+}
+
+// Assignment is column = expr of UPDATE
+type Assignment struct {
+	Span
+	Column string
+	Value  Expr
+}
+
+// DeleteStmt is DELETE FROM table [WHERE expr]
+type DeleteStmt struct {
+	Span
+	Table string
+	Where Expr
+}
+
+// SelectStmt is a SELECT, From is nil without FROM
+type SelectStmt struct {
+	Span
+	Distinct bool
+	Columns  []*ResultColumn
+	From     Source
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	OrderBy  []*OrderingTerm
+	Limit    Expr
+	Offset   Expr
+}
+
+// ResultColumn is expr [AS alias], * or table.*
+type ResultColumn struct {
+	Span
+	Star  bool
+	Table string
+	Expr  Expr
+	Alias string
+}
+
+// OrderingTerm is expr [ASC | DESC] of ORDER BY
+type OrderingTerm struct {
+	Span
+	Expr Expr
+	Desc bool
+}
+
+// Source is a table or a join in FROM
+type Source interface {
+	Node
+	source()
+}
+
+// TableRef is a table [AS alias]
+type TableRef struct {
+	Span
+	Name  string
+	Alias string
+}
+
+// JoinKind is the kind of a join
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota
+	LeftJoin
+	CrossJoin
+)
+
+func (k JoinKind) String() string {
+	return [...]string{"INNER", "LEFT", "CROSS"}[k]
+}
+
+// Join is left JOIN right [ON expr], a comma join is a cross join
+type Join struct {
+	Span
+	Kind  JoinKind
+	Left  Source
+	Right Source
+	On    Expr
+}
+
+// BeginStmt is BEGIN [TRANSACTION]
+type BeginStmt struct {
+	Span
+}
+
+// CommitStmt is COMMIT [TRANSACTION]
+type CommitStmt struct {
+	Span
+}
+
+// RollbackStmt is ROLLBACK [TRANSACTION]
+type RollbackStmt struct {
+	Span
+}
+
+// ExplainStmt is EXPLAIN [QUERY PLAN] stmt, it returns the program or the
+// plan of stmt instead of running it.
+type ExplainStmt struct {
+	Span
+	QueryPlan bool
+	Stmt      Stmt
+}
+
+// AnalyzeStmt is ANALYZE [name], name is a table or an index
+type AnalyzeStmt struct {
+	Span
+	Name string
+}
+
+// Literal is a constant of kind Integer, Float, String, Blob or Null,
+// Value of a string or blob is unquoted.
+type Literal struct {
+	Span
+	Kind  Kind
+	Value string
+}
+
+// Param is a parameter bound when the statement runs, Name is ?, ?NNN,
+// :name or $name and Index counts from 1. A ? takes the index after the
+// largest one so far and the uses of a name share its index.
+type Param struct {
+	Span
+	Name  string
+	Index int
+}
+
+// ColumnRef is a column, optionally qualified by table
+type ColumnRef struct {
+	Span
+	Table string
+	Name  string
+}
+
+// UnaryExpr is op X, op is -, +, ~ or NOT
+type UnaryExpr struct {
+	Span
+	Op string
+	X  Expr
+}
+
+// BinaryExpr is X op Y, keyword operators like AND, LIKE and IS NOT are
+// upper case.
+type BinaryExpr struct {
+	Span
+	Op string
+	X  Expr
+	Y  Expr
+}
+
+// IsNullExpr is X IS [NOT] NULL, X ISNULL, X NOTNULL or X NOT NULL
+type IsNullExpr struct {
+	Span
+	X   Expr
+	Not bool
+}
+
+// InExpr is X [NOT] IN (list)
+type InExpr struct {
+	Span
+	X    Expr
+	Not  bool
+	List []Expr
+}
+
+// BetweenExpr is X [NOT] BETWEEN Low AND High
+type BetweenExpr struct {
+	Span
+	X    Expr
+	Not  bool
+	Low  Expr
+	High Expr
+}
+
+// CollateExpr is X COLLATE name
+type CollateExpr struct {
+	Span
+	X         Expr
+	Collation string
+}
+
+// CastExpr is CAST(X AS type)
+type CastExpr struct {
+	Span
+	X    Expr
+	Type string
+}
+
+// SubqueryExpr is (select), the value of the first column of the first
+// row of Select, NULL without rows.
+type SubqueryExpr struct {
+	Span
+	Select *SelectStmt
+}
+
+// ExistsExpr is EXISTS (select), whether Select returns a row
+type ExistsExpr struct {
+	Span
+	Select *SelectStmt
+}
+
+// FuncCall is name([DISTINCT] args) or name(*)
+type FuncCall struct {
+	Span
+	Name     string
+	Distinct bool
+	Star     bool
+	Args     []Expr
+}
+
+func (*CreateTableStmt) stmt() {}
+func (*CreateIndexStmt) stmt() {}
+func (*DropStmt) stmt()        {}
+func (*InsertStmt) stmt()      {}
+func (*UpdateStmt) stmt()      {}
+func (*DeleteStmt) stmt()      {}
+func (*SelectStmt) stmt()      {}
+func (*BeginStmt) stmt()       {}
+func (*CommitStmt) stmt()      {}
+func (*RollbackStmt) stmt()    {}
+func (*ExplainStmt) stmt()     {}
+func (*AnalyzeStmt) stmt()     {}
+
+func (*TableRef) source() {}
+func (*Join) source()     {}
+
+func (*Literal) expr()      {}
+func (*Param) expr()        {}
+func (*ColumnRef) expr()    {}
+func (*UnaryExpr) expr()    {}
+func (*BinaryExpr) expr()   {}
+func (*IsNullExpr) expr()   {}
+func (*InExpr) expr()       {}
+func (*BetweenExpr) expr()  {}
+func (*CollateExpr) expr()  {}
+func (*CastExpr) expr()     {}
+func (*SubqueryExpr) expr() {}
+func (*ExistsExpr) expr()   {}
+func (*FuncCall) expr()     {}
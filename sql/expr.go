@@ -0,0 +1,354 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// the binary operators by precedence, from the loosest
+var binaryLevels = [][]string{
+	{"OR"},
+	{"AND"},
+	nil, // NOT
+	{"=", "==", "!=", "<>", "IS", "IN", "LIKE", "BETWEEN"},
+	{"<", "<=", ">", ">="},
+	{"&", "|", "<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"||"},
+}
+
+const (
+	notLevel      = 2
+	equalityLevel = 3
+)
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+// opAt returns the operator of the next token if it is one of level
+func (p *parser) opAt(level int) (string, bool) {
+	t := p.peek()
+	if t.Kind != Operator && t.Kind != Keyword {
+		return "", false
+	}
+	for _, op := range binaryLevels[level] {
+		if t.Text == op {
+			return op, true
+		}
+	}
+	if level != equalityLevel || t.Kind != Keyword {
+		return "", false
+	}
+	// the postfix ISNULL, NOTNULL and NOT NULL
+	if t.Text == "ISNULL" || t.Text == "NOTNULL" {
+		return t.Text, true
+	}
+	// NOT IN, NOT LIKE and NOT BETWEEN
+	if t.Text == "NOT" {
+		next := p.peekAt(1)
+		if next.Kind == Keyword && (next.Text == "IN" || next.Text == "LIKE" || next.Text == "BETWEEN" || next.Text == "NULL") {
+			return "NOT", true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	if level == notLevel {
+		if t := p.peek(); t.Kind == Keyword && t.Text == "NOT" {
+			p.next()
+			x, err := p.binary(level)
+			if err != nil {
+				return nil, err
+			}
+			return &UnaryExpr{Span{t.Pos}, "NOT", x}, nil
+		}
+		return p.binary(level + 1)
+	}
+
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.opAt(level)
+		if !ok {
+			return x, nil
+		}
+		p.next()
+		if level == equalityLevel {
+			x, err = p.equality(x, op)
+		} else {
+			var y Expr
+			y, err = p.binary(level + 1)
+			x = &BinaryExpr{Span{x.Pos()}, normalize(op), x, y}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func normalize(op string) string {
+	switch op {
+	case "==":
+		return "="
+	case "<>":
+		return "!="
+	}
+	return op
+}
+
+// equality parses the right side of x op, op was read
+func (p *parser) equality(x Expr, op string) (Expr, error) {
+	not := false
+	if op == "NOT" {
+		not = true
+		op = p.next().Text
+	} else if op == "IS" {
+		not = p.acceptKeyword("NOT")
+		if p.acceptKeyword("NULL") {
+			return &IsNullExpr{Span{x.Pos()}, x, not}, nil
+		}
+	}
+
+	switch op {
+	case "ISNULL":
+		return &IsNullExpr{Span{x.Pos()}, x, false}, nil
+	case "NOTNULL":
+		return &IsNullExpr{Span{x.Pos()}, x, true}, nil
+	case "NULL":
+		return &IsNullExpr{Span{x.Pos()}, x, true}, nil
+	case "IN":
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		list, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		return &InExpr{Span{x.Pos()}, x, not, list}, p.expectOp(")")
+	case "BETWEEN":
+		low, err := p.binary(equalityLevel + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.binary(equalityLevel + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{Span{x.Pos()}, x, not, low, high}, nil
+	}
+
+	y, err := p.binary(equalityLevel + 1)
+	if err != nil {
+		return nil, err
+	}
+	if op == "IS" && not {
+		op = "IS NOT"
+	} else if not {
+		op = "NOT " + op
+	}
+	return &BinaryExpr{Span{x.Pos()}, normalize(op), x, y}, nil
+}
+
+func (p *parser) unary() (Expr, error) {
+	t := p.peek()
+	if t.Kind == Operator && (t.Text == "-" || t.Text == "+" || t.Text == "~") {
+		p.next()
+		if min := p.minInt(t); min != nil {
+			return min, nil
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Span{t.Pos}, t.Text, x}, nil
+	}
+	x, err := p.primary()
+	for err == nil && p.acceptKeyword("COLLATE") {
+		var name string
+		name, err = p.name("collation name")
+		x = &CollateExpr{Span{x.Pos()}, x, name}
+	}
+	return x, err
+}
+
+// minIntText is the least INTEGER without its sign, it is too big for an
+// INTEGER on its own.
+const minIntText = "9223372036854775808"
+
+// minInt returns the literal of the least INTEGER when op is a minus
+// followed by minIntText, nil otherwise.
+func (p *parser) minInt(op Token) *Literal {
+	if t := p.peek(); op.Text != "-" || t.Kind != Integer || t.Text != minIntText {
+		return nil
+	}
+	p.next()
+	return &Literal{Span{op.Pos}, Integer, "-" + minIntText}
+}
+
+func (p *parser) literal() *Literal {
+	t := p.next()
+	if t.Kind == Keyword {
+		return &Literal{Span{t.Pos}, Null, ""}
+	}
+	return &Literal{Span{t.Pos}, t.Kind, t.Text}
+}
+
+// param returns the parameter of the next token, numbering it
+func (p *parser) param() (Expr, error) {
+	t := p.next()
+	index := 0
+	switch {
+	case t.Text == "?":
+		index = len(p.params) + 1
+	case t.Text[0] == '?':
+		n, err := strconv.Atoi(t.Text[1:])
+		if err != nil || n < 1 || n > maxParams {
+			return nil, &Error{t.Pos, fmt.Sprintf("variable number must be between ?1 and ?%d", maxParams)}
+		}
+		index = n
+	default:
+		for i, name := range p.params {
+			if name == t.Text {
+				index = i + 1
+			}
+		}
+		if index == 0 {
+			index = len(p.params) + 1
+		}
+	}
+	if index > maxParams {
+		return nil, &Error{t.Pos, "too many SQL variables"}
+	}
+
+	for len(p.params) < index {
+		p.params = append(p.params, "")
+	}
+	if t.Text[0] != '?' {
+		p.params[index-1] = t.Text
+	} else if p.params[index-1] == "" {
+		p.params[index-1] = "?"
+	}
+	return &Param{Span{t.Pos}, t.Text, index}, nil
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.peek()
+	switch t.Kind {
+	case Integer, Float, String, Blob:
+		return p.literal(), nil
+	case Variable:
+		return p.param()
+	case Keyword:
+		if t.Text == "NULL" {
+			return p.literal(), nil
+		}
+		if t.Text == "CAST" {
+			return p.cast()
+		}
+		if t.Text == "EXISTS" {
+			p.next()
+			sub, err := p.subquery()
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpr{Span{t.Pos}, sub}, nil
+		}
+	case Operator:
+		if t.Text == "(" && p.peekAt(1).Kind == Keyword && p.peekAt(1).Text == "SELECT" {
+			sub, err := p.subquery()
+			if err != nil {
+				return nil, err
+			}
+			return &SubqueryExpr{Span{t.Pos}, sub}, nil
+		}
+		if t.Text == "(" {
+			p.next()
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectOp(")")
+		}
+	case Ident:
+		p.next()
+		if p.isOp("(") {
+			return p.call(t)
+		}
+		if p.acceptOp(".") {
+			name, err := p.name("column name")
+			return &ColumnRef{Span{t.Pos}, t.Text, name}, err
+		}
+		return &ColumnRef{Span{t.Pos}, "", t.Text}, nil
+	}
+	return nil, p.expected("expression")
+}
+
+// subquery parses (select), the parenthesis is next
+func (p *parser) subquery() (*SelectStmt, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("SELECT") {
+		return nil, p.expected("SELECT")
+	}
+	stmt, err := p.selectStmt()
+	if err != nil {
+		return nil, err
+	}
+	return stmt, p.expectOp(")")
+}
+
+// cast parses CAST(X AS type), CAST is next
+func (p *parser) cast() (Expr, error) {
+	cast := &CastExpr{Span: Span{p.next().Pos}}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	cast.X = x
+	if cast.Type, err = p.typeName(); err != nil {
+		return nil, err
+	}
+	if cast.Type == "" {
+		return nil, p.expected("type name")
+	}
+	return cast, p.expectOp(")")
+}
+
+// call parses the arguments of function name, ( is next
+func (p *parser) call(name Token) (Expr, error) {
+	p.next()
+	call := &FuncCall{Span: Span{name.Pos}, Name: name.Text}
+	if p.acceptOp("*") {
+		call.Star = true
+		return call, p.expectOp(")")
+	}
+	if p.acceptOp(")") {
+		return call, nil
+	}
+
+	call.Distinct = p.acceptKeyword("DISTINCT")
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	call.Args = args
+	return call, p.expectOp(")")
+}
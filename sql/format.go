@@ -0,0 +1,234 @@
+package sql
+
+import (
+	"strings"
+)
+
+// FormatExpr returns the SQL text of e, subexpressions are parenthesized
+// where the precedence needs it.
+func FormatExpr(e Expr) string {
+	var b strings.Builder
+	formatExpr(&b, e, 0)
+	return b.String()
+}
+
+// precedence returns the binary level of e, the unary ones bind tightest
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		op := strings.TrimPrefix(e.Op, "NOT ")
+		if op == "IS NOT" {
+			op = "IS"
+		}
+		for level, ops := range binaryLevels {
+			for _, o := range ops {
+				if o == op {
+					return level
+				}
+			}
+		}
+	case *UnaryExpr:
+		if e.Op == "NOT" {
+			return notLevel
+		}
+	case *IsNullExpr, *InExpr, *BetweenExpr:
+		return equalityLevel
+	default:
+		return len(binaryLevels) + 1
+	}
+	return len(binaryLevels)
+}
+
+func formatExpr(b *strings.Builder, e Expr, min int) {
+	if precedence(e) < min {
+		b.WriteString("(")
+		defer b.WriteString(")")
+	}
+
+	level := precedence(e)
+	switch e := e.(type) {
+	case *Literal:
+		switch e.Kind {
+		case Null:
+			b.WriteString("NULL")
+		case String, Blob:
+			b.WriteString(Token{Kind: e.Kind, Text: e.Value}.String())
+		default:
+			b.WriteString(e.Value)
+		}
+	case *Param:
+		b.WriteString(e.Name)
+	case *ColumnRef:
+		if e.Table != "" {
+			b.WriteString(QuoteIdent(e.Table) + ".")
+		}
+		b.WriteString(QuoteIdent(e.Name))
+	case *UnaryExpr:
+		var x strings.Builder
+		formatExpr(&x, e.X, level)
+		b.WriteString(e.Op)
+		// two minus signs in a row start a comment
+		if e.Op == "NOT" || strings.HasPrefix(x.String(), "-") {
+			b.WriteString(" ")
+		}
+		b.WriteString(x.String())
+	case *BinaryExpr:
+		formatExpr(b, e.X, level)
+		b.WriteString(" " + e.Op + " ")
+		formatExpr(b, e.Y, level+1)
+	case *IsNullExpr:
+		formatExpr(b, e.X, level+1)
+		if e.Not {
+			b.WriteString(" IS NOT NULL")
+		} else {
+			b.WriteString(" IS NULL")
+		}
+	case *InExpr:
+		formatExpr(b, e.X, level+1)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" IN (")
+		formatList(b, e.List)
+		b.WriteString(")")
+	case *BetweenExpr:
+		formatExpr(b, e.X, level+1)
+		if e.Not {
+			b.WriteString(" NOT")
+		}
+		b.WriteString(" BETWEEN ")
+		formatExpr(b, e.Low, level+1)
+		b.WriteString(" AND ")
+		formatExpr(b, e.High, level+1)
+	case *CollateExpr:
+		formatExpr(b, e.X, level)
+		b.WriteString(" COLLATE " + QuoteIdent(e.Collation))
+	case *CastExpr:
+		b.WriteString("CAST(")
+		formatExpr(b, e.X, 0)
+		b.WriteString(" AS " + e.Type + ")")
+	case *SubqueryExpr:
+		b.WriteString("(")
+		formatSelect(b, e.Select)
+		b.WriteString(")")
+	case *ExistsExpr:
+		b.WriteString("EXISTS (")
+		formatSelect(b, e.Select)
+		b.WriteString(")")
+	case *FuncCall:
+		b.WriteString(e.Name + "(")
+		if e.Star {
+			b.WriteString("*")
+		} else if e.Distinct {
+			b.WriteString("DISTINCT ")
+		}
+		formatList(b, e.Args)
+		b.WriteString(")")
+	}
+}
+
+// formatSelect writes the SQL text of the SELECT of a subquery
+func formatSelect(b *strings.Builder, stmt *SelectStmt) {
+	b.WriteString("SELECT ")
+	if stmt.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	for i, col := range stmt.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch {
+		case col.Star && col.Table != "":
+			b.WriteString(QuoteIdent(col.Table) + ".*")
+		case col.Star:
+			b.WriteString("*")
+		default:
+			formatExpr(b, col.Expr, 0)
+		}
+		if col.Alias != "" {
+			b.WriteString(" AS " + QuoteIdent(col.Alias))
+		}
+	}
+	if stmt.From != nil {
+		b.WriteString(" FROM ")
+		formatSource(b, stmt.From)
+	}
+	if stmt.Where != nil {
+		b.WriteString(" WHERE ")
+		formatExpr(b, stmt.Where, 0)
+	}
+	if len(stmt.GroupBy) > 0 {
+		b.WriteString(" GROUP BY ")
+		formatList(b, stmt.GroupBy)
+		if stmt.Having != nil {
+			b.WriteString(" HAVING ")
+			formatExpr(b, stmt.Having, 0)
+		}
+	}
+	for i, term := range stmt.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		formatExpr(b, term.Expr, 0)
+		if term.Desc {
+			b.WriteString(" DESC")
+		}
+	}
+	if stmt.Limit != nil {
+		b.WriteString(" LIMIT ")
+		formatExpr(b, stmt.Limit, 0)
+		if stmt.Offset != nil {
+			b.WriteString(" OFFSET ")
+			formatExpr(b, stmt.Offset, 0)
+		}
+	}
+}
+
+func formatSource(b *strings.Builder, src Source) {
+	switch src := src.(type) {
+	case *TableRef:
+		b.WriteString(QuoteIdent(src.Name))
+		if src.Alias != "" {
+			b.WriteString(" AS " + QuoteIdent(src.Alias))
+		}
+	case *Join:
+		formatSource(b, src.Left)
+		switch src.Kind {
+		case LeftJoin:
+			b.WriteString(" LEFT JOIN ")
+		case CrossJoin:
+			b.WriteString(" CROSS JOIN ")
+		default:
+			b.WriteString(" JOIN ")
+		}
+		formatSource(b, src.Right)
+		if src.On != nil {
+			b.WriteString(" ON ")
+			formatExpr(b, src.On, 0)
+		}
+	}
+}
+
+func formatList(b *strings.Builder, list []Expr) {
+	for i, e := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		formatExpr(b, e, 0)
+	}
+}
+
+// QuoteIdent returns name quoted as an identifier when it is not a plain
+// identifier
+func QuoteIdent(name string) string {
+	plain := name != "" && !keywords[strings.ToUpper(name)] && isIdentStart(name[0])
+	for i := 0; plain && i < len(name); i++ {
+		plain = isIdentStart(name[i]) || isDigit(name[i]) || name[i] == '$'
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,731 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the largest index of a parameter
+const maxParams = 32766
+
+// parser is a recursive descent parser over the tokens of one source,
+// params are the names of the parameters of the statement by index.
+type parser struct {
+	tokens []Token
+	i      int
+	params []string
+}
+
+// Parse to parse the statements of src separated by semicolons
+func Parse(src string) ([]Stmt, error) {
+	stmts, _, err := parse(src)
+	return stmts, err
+}
+
+// ParseStmt to parse src holding exactly one statement
+func ParseStmt(src string) (Stmt, error) {
+	stmt, _, err := ParseParams(src)
+	return stmt, err
+}
+
+// ParseParams to parse src holding exactly one statement, params are the
+// names of its parameters by index, "?" for a ? or ?NNN and empty for an
+// index no parameter uses.
+func ParseParams(src string) (Stmt, []string, error) {
+	stmts, params, err := parse(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stmts) != 1 {
+		return nil, nil, &Error{Pos{0, 1, 1}, fmt.Sprintf("expected one statement, found %d", len(stmts))}
+	}
+	return stmts[0], params[0], nil
+}
+
+// parse returns the statements of src and the parameters of each
+func parse(src string) ([]Stmt, [][]string, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	stmts, params := make([]Stmt, 0), make([][]string, 0)
+	for {
+		for p.acceptOp(";") {
+		}
+		if p.peek().Kind == EOF {
+			return stmts, params, nil
+		}
+
+		p.params = make([]string, 0)
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, nil, err
+		}
+		stmts, params = append(stmts, stmt), append(params, p.params)
+		if p.peek().Kind != EOF && !p.isOp(";") {
+			return nil, nil, p.expected("end of statement")
+		}
+	}
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+// peekAt returns the token n after the next one
+func (p *parser) peekAt(n int) Token {
+	if p.i+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.i+n]
+}
+
+func (p *parser) next() Token {
+	t := p.tokens[p.i]
+	if t.Kind != EOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(k string) bool {
+	t := p.peek()
+	return t.Kind == Keyword && t.Text == k
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.Kind == Operator && t.Text == op
+}
+
+func (p *parser) acceptKeyword(k string) bool {
+	if p.isKeyword(k) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// acceptWord to skip the next token if it is the identifier w in any case
+func (p *parser) acceptWord(w string) bool {
+	if t := p.peek(); t.Kind == Ident && strings.EqualFold(t.Text, w) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// expected returns the error for the next token when want was expected
+func (p *parser) expected(want string) error {
+	t := p.peek()
+	return &Error{t.Pos, fmt.Sprintf("expected %s, found %s", want, t)}
+}
+
+func (p *parser) expectKeyword(k string) error {
+	if !p.acceptKeyword(k) {
+		return p.expected(k)
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.expected(fmt.Sprintf("%q", op))
+	}
+	return nil
+}
+
+// name to read an identifier, what names it in the error
+func (p *parser) name(what string) (string, error) {
+	if p.peek().Kind != Ident {
+		return "", p.expected(what)
+	}
+	return p.next().Text, nil
+}
+
+func (p *parser) stmt() (Stmt, error) {
+	t := p.peek()
+	if t.Kind != Keyword {
+		return nil, p.expected("statement")
+	}
+
+	switch t.Text {
+	case "CREATE":
+		return p.create()
+	case "DROP":
+		return p.drop()
+	case "INSERT":
+		return p.insert()
+	case "UPDATE":
+		return p.update()
+	case "DELETE":
+		return p.delete()
+	case "SELECT":
+		return p.selectStmt()
+	case "BEGIN":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &BeginStmt{Span{t.Pos}}, nil
+	case "COMMIT":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &CommitStmt{Span{t.Pos}}, nil
+	case "ROLLBACK":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &RollbackStmt{Span{t.Pos}}, nil
+	case "ANALYZE":
+		p.next()
+		stmt := &AnalyzeStmt{Span: Span{t.Pos}}
+		if p.peek().Kind == Ident {
+			stmt.Name = p.next().Text
+		}
+		return stmt, nil
+	case "EXPLAIN":
+		p.next()
+		// QUERY and PLAN are names elsewhere
+		queryPlan := p.acceptWord("QUERY")
+		if queryPlan && !p.acceptWord("PLAN") {
+			return nil, p.expected("PLAN")
+		}
+		if p.isKeyword("EXPLAIN") {
+			return nil, p.expected("statement")
+		}
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		return &ExplainStmt{Span{t.Pos}, queryPlan, stmt}, nil
+	}
+	return nil, p.expected("statement")
+}
+
+func (p *parser) ifNotExists() (bool, error) {
+	if !p.acceptKeyword("IF") {
+		return false, nil
+	}
+	if err := p.expectKeyword("NOT"); err != nil {
+		return false, err
+	}
+	return true, p.expectKeyword("EXISTS")
+}
+
+func (p *parser) create() (Stmt, error) {
+	start := p.next().Pos
+	unique := p.acceptKeyword("UNIQUE")
+	if unique || p.isKeyword("INDEX") {
+		if err := p.expectKeyword("INDEX"); err != nil {
+			return nil, err
+		}
+		return p.createIndex(start, unique)
+	}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, p.expected("TABLE or INDEX")
+	}
+
+	stmt := &CreateTableStmt{Span: Span{start}}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Name, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+	if err = p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.columnDef()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return stmt, p.expectOp(")")
+}
+
+func (p *parser) columnDef() (*ColumnDef, error) {
+	col := &ColumnDef{Span: Span{p.peek().Pos}}
+	var err error
+	if col.Name, err = p.name("column name"); err != nil {
+		return nil, err
+	}
+	if col.Type, err = p.typeName(); err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.acceptKeyword("PRIMARY"):
+			if err = p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			col.PrimaryKey = true
+			if !p.acceptKeyword("ASC") {
+				col.Desc = p.acceptKeyword("DESC")
+			}
+		case p.acceptKeyword("NOT"):
+			if err = p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			col.NotNull = true
+		case p.acceptKeyword("NULL"):
+		case p.acceptKeyword("UNIQUE"):
+			col.Unique = true
+		case p.acceptKeyword("DEFAULT"):
+			if col.Default, err = p.defaultValue(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("COLLATE"):
+			if col.Collate, err = p.name("collation name"); err != nil {
+				return nil, err
+			}
+		default:
+			return col, nil
+		}
+	}
+}
+
+// typeName reads a type like INTEGER, VARCHAR(20) or UNSIGNED BIG INT
+func (p *parser) typeName() (string, error) {
+	words := make([]string, 0)
+	for p.peek().Kind == Ident {
+		words = append(words, p.next().Text)
+	}
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	typ := strings.Join(words, " ")
+	if !p.acceptOp("(") {
+		return typ, nil
+	}
+	sizes := make([]string, 0)
+	for {
+		sign := ""
+		if p.acceptOp("-") {
+			sign = "-"
+		} else {
+			p.acceptOp("+")
+		}
+		if t := p.peek(); t.Kind != Integer && t.Kind != Float {
+			return "", p.expected("type size")
+		}
+		sizes = append(sizes, sign+p.next().Text)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return typ + "(" + strings.Join(sizes, ",") + ")", p.expectOp(")")
+}
+
+// defaultValue reads a literal, a signed number or a parenthesized expr
+func (p *parser) defaultValue() (Expr, error) {
+	if p.isOp("(") {
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectOp(")")
+	}
+	if p.isOp("-") || p.isOp("+") {
+		op := p.next()
+		if t := p.peek(); t.Kind != Integer && t.Kind != Float {
+			return nil, p.expected("number")
+		}
+		if min := p.minInt(op); min != nil {
+			return min, nil
+		}
+		return &UnaryExpr{Span{op.Pos}, op.Text, p.literal()}, nil
+	}
+	switch p.peek().Kind {
+	case Integer, Float, String, Blob:
+		return p.literal(), nil
+	}
+	if p.isKeyword("NULL") {
+		return p.literal(), nil
+	}
+	return nil, p.expected("default value")
+}
+
+func (p *parser) createIndex(start Pos, unique bool) (Stmt, error) {
+	stmt := &CreateIndexStmt{Span: Span{start}, Unique: unique}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Name, err = p.name("index name"); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+	if err = p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		col := &IndexedColumn{Span: Span{p.peek().Pos}}
+		if col.Name, err = p.name("column name"); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("COLLATE") {
+			if col.Collate, err = p.name("collation name"); err != nil {
+				return nil, err
+			}
+		}
+		if !p.acceptKeyword("ASC") {
+			col.Desc = p.acceptKeyword("DESC")
+		}
+		stmt.Columns = append(stmt.Columns, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return stmt, p.expectOp(")")
+}
+
+func (p *parser) drop() (Stmt, error) {
+	stmt := &DropStmt{Span: Span{p.next().Pos}}
+	if p.acceptKeyword("INDEX") {
+		stmt.Index = true
+	} else if !p.acceptKeyword("TABLE") {
+		return nil, p.expected("TABLE or INDEX")
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfExists = true
+	}
+
+	var err error
+	stmt.Name, err = p.name("name")
+	return stmt, err
+}
+
+func (p *parser) insert() (Stmt, error) {
+	stmt := &InsertStmt{Span: Span{p.next().Pos}}
+	err := p.expectKeyword("INTO")
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+
+	if p.acceptOp("(") {
+		for {
+			col, err := p.name("column name")
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err = p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err = p.expectOp("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		if !p.acceptOp(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) where() (Expr, error) {
+	if !p.acceptKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.expr()
+}
+
+func (p *parser) update() (Stmt, error) {
+	stmt := &UpdateStmt{Span: Span{p.next().Pos}}
+	var err error
+	if stmt.Table, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		a := &Assignment{Span: Span{p.peek().Pos}}
+		if a.Column, err = p.name("column name"); err != nil {
+			return nil, err
+		}
+		if err = p.expectOp("="); err != nil {
+			return nil, err
+		}
+		if a.Value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, a)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	stmt.Where, err = p.where()
+	return stmt, err
+}
+
+func (p *parser) delete() (Stmt, error) {
+	stmt := &DeleteStmt{Span: Span{p.next().Pos}}
+	err := p.expectKeyword("FROM")
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+
+	stmt.Where, err = p.where()
+	return stmt, err
+}
+
+func (p *parser) selectStmt() (*SelectStmt, error) {
+	stmt := &SelectStmt{Span: Span{p.next().Pos}}
+	if !p.acceptKeyword("ALL") {
+		stmt.Distinct = p.acceptKeyword("DISTINCT")
+	}
+
+	var err error
+	for {
+		col, err := p.resultColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("FROM") {
+		if stmt.From, err = p.source(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("GROUP") {
+		if err = p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("HAVING") {
+			if stmt.Having, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err = p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			term := &OrderingTerm{Span: Span{p.peek().Pos}}
+			if term.Expr, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if !p.acceptKeyword("ASC") {
+				term.Desc = p.acceptKeyword("DESC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, term)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.Limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		// LIMIT offset, count
+		if p.acceptOp(",") {
+			stmt.Offset = stmt.Limit
+			if stmt.Limit, err = p.expr(); err != nil {
+				return nil, err
+			}
+		} else if p.acceptKeyword("OFFSET") {
+			if stmt.Offset, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) resultColumn() (*ResultColumn, error) {
+	col := &ResultColumn{Span: Span{p.peek().Pos}}
+	if p.acceptOp("*") {
+		col.Star = true
+		return col, nil
+	}
+	if p.peek().Kind == Ident && p.peekAt(1).Text == "." && p.peekAt(2).Text == "*" && p.peekAt(2).Kind == Operator {
+		col.Table = p.next().Text
+		p.i += 2
+		col.Star = true
+		return col, nil
+	}
+
+	var err error
+	if col.Expr, err = p.expr(); err != nil {
+		return nil, err
+	}
+	col.Alias, err = p.alias()
+	return col, err
+}
+
+// alias reads [AS] name
+func (p *parser) alias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.name("alias")
+	}
+	if p.peek().Kind == Ident {
+		return p.next().Text, nil
+	}
+	return "", nil
+}
+
+func (p *parser) tableRef() (Source, error) {
+	ref := &TableRef{Span: Span{p.peek().Pos}}
+	var err error
+	if ref.Name, err = p.name("table name"); err != nil {
+		return nil, err
+	}
+	ref.Alias, err = p.alias()
+	return ref, err
+}
+
+// source reads the tables of FROM, joins associate to the left
+func (p *parser) source() (Source, error) {
+	left, err := p.tableRef()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		join := &Join{Span: Span{left.Pos()}, Left: left}
+		switch {
+		case p.acceptOp(","):
+			join.Kind = CrossJoin
+		case p.acceptKeyword("CROSS"):
+			join.Kind = CrossJoin
+			err = p.expectKeyword("JOIN")
+		case p.acceptKeyword("LEFT"):
+			join.Kind = LeftJoin
+			p.acceptKeyword("OUTER")
+			err = p.expectKeyword("JOIN")
+		case p.acceptKeyword("INNER"):
+			err = p.expectKeyword("JOIN")
+		case p.acceptKeyword("JOIN"):
+		default:
+			return left, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if join.Right, err = p.tableRef(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("ON") {
+			if join.On, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		left = join
+	}
+}
+
+func (p *parser) exprList() ([]Expr, error) {
+	list := make([]Expr, 0)
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.acceptOp(",") {
+			return list, nil
+		}
+	}
+}
+
+// Split to split src into the text of its statements, the semicolons
+// and the comments between them are dropped.
+func Split(src string) ([]string, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]string, 0)
+	start := -1
+	for _, t := range tokens {
+		if t.Kind != EOF && (t.Kind != Operator || t.Text != ";") {
+			if start < 0 {
+				start = t.Pos.Offset
+			}
+			continue
+		}
+		if start >= 0 {
+			texts = append(texts, strings.TrimSpace(src[start:t.Pos.Offset]))
+			start = -1
+		}
+	}
+	return texts, nil
+}
+
+// Complete returns whether src ends with a semicolon which ends a
+// statement, a semicolon in a string, an identifier or a comment that is
+// not terminated does not. Text with other errors is complete so running
+// it reports them.
+func Complete(src string) bool {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		e, ok := err.(*Error)
+		return !ok || !strings.HasPrefix(e.Msg, "unterminated")
+	}
+	n := len(tokens)
+	return n > 1 && tokens[n-2].Kind == Operator && tokens[n-2].Text == ";"
+}
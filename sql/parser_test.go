@@ -0,0 +1,233 @@
+package sql_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gosqlite/sql"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// dump writes node as an indented tree, the scalar fields that are set
+// follow the node type and position, the child nodes are indented below.
+func dump(b *strings.Builder, indent string, node interface{}) {
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	fmt.Fprintf(b, "%s %s", v.Type().Name(), node.(sql.Node).Pos())
+
+	children := make([]int, 0)
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		switch {
+		case name == "Span" || f.IsZero():
+		case f.Kind() == reflect.String:
+			fmt.Fprintf(b, " %s=%q", name, f.String())
+		case f.Kind() == reflect.Bool:
+			fmt.Fprintf(b, " %s", name)
+		case f.Type() == reflect.TypeOf(sql.Kind(0)) || f.Type() == reflect.TypeOf(sql.JoinKind(0)):
+			fmt.Fprintf(b, " %s=%s", name, f.Interface())
+		case f.Kind() == reflect.Int:
+			fmt.Fprintf(b, " %s=%d", name, f.Int())
+		default:
+			children = append(children, i)
+		}
+	}
+	b.WriteString("\n")
+
+	for _, i := range children {
+		fmt.Fprintf(b, "%s  %s:", indent, v.Type().Field(i).Name)
+		dumpValue(b, indent+"  ", v.Field(i))
+	}
+}
+
+func dumpValue(b *strings.Builder, indent string, f reflect.Value) {
+	switch f.Kind() {
+	case reflect.Slice:
+		b.WriteString("\n")
+		for j := 0; j < f.Len(); j++ {
+			fmt.Fprintf(b, "%s  -", indent)
+			dumpValue(b, indent+"  ", f.Index(j))
+		}
+	case reflect.String:
+		fmt.Fprintf(b, " %q\n", f.String())
+	default:
+		b.WriteString(" ")
+		dump(b, indent, f.Interface())
+	}
+}
+
+func golden(t *testing.T, name string, got string) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from %s:\n%s", name, path, got)
+	}
+}
+
+func TestParseGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".sql")
+		if name == "errors" {
+			continue
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stmts, err := sql.Parse(string(src))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		var b strings.Builder
+		for _, stmt := range stmts {
+			dump(&b, "", stmt)
+		}
+		golden(t, name, b.String())
+	}
+}
+
+// every line of errors.sql is a statement with a syntax error
+func TestParseErrors(t *testing.T) {
+	src, err := ioutil.ReadFile(filepath.Join("testdata", "errors.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(string(src)), "\n") {
+		_, err := sql.Parse(line)
+		if err == nil {
+			t.Errorf("no error for %s", line)
+			continue
+		}
+		if _, ok := err.(*sql.Error); !ok {
+			t.Errorf("%s: %T is not a syntax error", line, err)
+		}
+		fmt.Fprintf(&b, "%s\n  %v\n", line, err)
+	}
+	golden(t, "errors", b.String())
+}
+
+func TestTokenize(t *testing.T) {
+	tokens, err := sql.Tokenize("select \"a b\", x'0F', 1.5e3 -- comment\n/* c */ FROM [t]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []sql.Token{
+		{Kind: sql.Keyword, Text: "SELECT", Pos: sql.Pos{Offset: 0, Line: 1, Column: 1}},
+		{Kind: sql.Ident, Text: "a b", Pos: sql.Pos{Offset: 7, Line: 1, Column: 8}},
+		{Kind: sql.Operator, Text: ",", Pos: sql.Pos{Offset: 12, Line: 1, Column: 13}},
+		{Kind: sql.Blob, Text: "0F", Pos: sql.Pos{Offset: 14, Line: 1, Column: 15}},
+		{Kind: sql.Operator, Text: ",", Pos: sql.Pos{Offset: 19, Line: 1, Column: 20}},
+		{Kind: sql.Float, Text: "1.5e3", Pos: sql.Pos{Offset: 21, Line: 1, Column: 22}},
+		{Kind: sql.Keyword, Text: "FROM", Pos: sql.Pos{Offset: 46, Line: 2, Column: 9}},
+		{Kind: sql.Ident, Text: "t", Pos: sql.Pos{Offset: 51, Line: 2, Column: 14}},
+		{Kind: sql.EOF, Pos: sql.Pos{Offset: 54, Line: 2, Column: 17}},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Fatalf("tokens %v", tokens)
+	}
+}
+
+// formatted expressions parse back to the same tree
+func TestFormatExpr(t *testing.T) {
+	src, err := ioutil.ReadFile(filepath.Join("testdata", "expr.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts, err := sql.Parse(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions := regexp.MustCompile(` \d+:\d+`)
+	for _, stmt := range stmts {
+		for _, col := range stmt.(*sql.SelectStmt).Columns {
+			text := sql.FormatExpr(col.Expr)
+			again, err := sql.ParseStmt("SELECT " + text)
+			if err != nil {
+				t.Fatalf("%s: %v", text, err)
+			}
+
+			var want, got strings.Builder
+			dump(&want, "", col.Expr)
+			dump(&got, "", again.(*sql.SelectStmt).Columns[0].Expr)
+			if positions.ReplaceAllString(got.String(), "") != positions.ReplaceAllString(want.String(), "") {
+				t.Fatalf("%s parses to\n%s\nwant\n%s", text, got.String(), want.String())
+			}
+		}
+	}
+
+	if got := sql.FormatExpr(&sql.ColumnRef{Table: "my table", Name: "select"}); got != `"my table"."select"` {
+		t.Fatalf("quoted names %s", got)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	_, params, err := sql.ParseParams("SELECT ?, :a, ?5, $b, :a, ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"?", ":a", "", "", "?", "$b", "?"}; !reflect.DeepEqual(params, want) {
+		t.Fatalf("params %q", params)
+	}
+	if _, params, _ = sql.ParseParams("SELECT 1"); len(params) != 0 {
+		t.Fatalf("params %q", params)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	texts, err := sql.Split("SELECT ';'; ;\n INSERT INTO t VALUES (1) ; -- done\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] != "SELECT ';'" || texts[1] != "INSERT INTO t VALUES (1)" {
+		t.Fatalf("split %q", texts)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for src, want := range map[string]bool{
+		"":                   false,
+		"SELECT 1":           false,
+		"SELECT 1;":          true,
+		"SELECT 1; -- done":  true,
+		"SELECT 1; SELECT 2": false,
+		"SELECT ';":          false,
+		"SELECT 1 /* ; */":   false,
+		"SELECT 1 /* ;":      false,
+		"SELECT \"a;":        false,
+		"SELECT 'a;\nb';":    true,
+		"SELECT 1a;":         true,
+	} {
+		if got := sql.Complete(src); got != want {
+			t.Errorf("Complete(%q) = %v", src, got)
+		}
+	}
+}
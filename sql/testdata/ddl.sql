@@ -0,0 +1,16 @@
+CREATE TABLE users (
+  id INTEGER PRIMARY KEY,
+  name VARCHAR(40) NOT NULL UNIQUE,
+  score REAL DEFAULT -1.5,
+  note TEXT DEFAULT 'n/a' NULL,
+  created DEFAULT (1 + 2)
+);
+create table if not exists "order" (a, [b c] unsigned big int, `d` decimal(10, 2));
+CREATE UNIQUE INDEX users_name ON users (name DESC, id);
+CREATE INDEX IF NOT EXISTS i ON t (a);
+CREATE TABLE c (a TEXT COLLATE NOCASE, b COLLATE rtrim NOT NULL);
+CREATE INDEX ci ON c (a COLLATE binary DESC, b);
+DROP TABLE users;
+DROP INDEX IF EXISTS users_name;
+BEGIN; COMMIT TRANSACTION; begin transaction; ROLLBACK;
+ANALYZE; analyze users;
@@ -0,0 +1,9 @@
+INSERT INTO users VALUES (1, 'it''s', NULL, X'00ff');
+INSERT INTO users (id, name) VALUES (2, 'b'), (3, 'c');
+UPDATE users SET name = 'x', score = score * 2 WHERE id = 1;
+UPDATE users SET score = 0;
+DELETE FROM users WHERE name LIKE 'a%' OR score IS NULL;
+DELETE FROM users;
+EXPLAIN DELETE FROM users WHERE id = 1;
+INSERT INTO users (id, name, email) VALUES (?, :name, ?5), ($email, :name, ?);
+EXPLAIN QUERY PLAN SELECT * FROM users WHERE id = 1;
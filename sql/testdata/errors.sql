@@ -0,0 +1,39 @@
+SELECT
+SELECT * FROM
+SELECT a FROM t WHERE
+SELECT a, FROM t
+SELECT (1 + 2 FROM t
+SELECT 'unterminated
+SELECT 1 2
+SELECT a FROM t ORDER a
+SELECT 12abc
+SELECT X'0G'
+SELECT @a
+CREATE VIEW v
+CREATE TABLE t
+CREATE TABLE t (a INTEGER PRIMARY)
+CREATE TABLE select (a)
+CREATE INDEX i ON t a
+DROP t
+INSERT users VALUES (1)
+INSERT INTO users (a VALUES (1)
+INSERT INTO users VALUES 1
+UPDATE users name = 1
+UPDATE users SET name 1
+DELETE users
+a b c
+SELECT a FROM t /* open
+SELECT a BETWEEN 1 OR 2
+SELECT a IN 1
+SELECT a COLLATE 1
+SELECT CAST(a)
+SELECT CAST(a AS)
+EXPLAIN EXPLAIN SELECT 1
+EXPLAIN QUERY SELECT 1
+SELECT ?0
+SELECT ?40000
+SELECT :
+SELECT EXISTS 1
+SELECT EXISTS (1)
+SELECT (SELECT 1
+SELECT a NOT
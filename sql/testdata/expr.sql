@@ -0,0 +1,11 @@
+SELECT 1 + 2 * 3 - 4 / 5 % 6;
+SELECT -a || 'x' || ~b, +0x1F, .5, 1e-3;
+SELECT a = 1 OR b <> 2 AND c == 3 OR d != 4;
+SELECT NOT a < b, (a + b) * c, a & b | c << 1 >> 2;
+SELECT a IS NULL, a IS NOT NULL, a IS b, a IS NOT b;
+SELECT a IN (1, 2), a NOT IN ('x'), a BETWEEN 1 AND 2 AND c, a NOT BETWEEN b + 1 AND c;
+SELECT a LIKE 'x%', a NOT LIKE b;
+SELECT a COLLATE nocase = b, -a COLLATE "my coll", (a || b) COLLATE rtrim, CAST(a AS INTEGER), CAST('1' AS varchar(10)) || b;
+SELECT -9223372036854775808, -(-9223372036854775808), - -a, -9223372036854775807, 9223372036854775808;
+SELECT a ISNULL, a NOTNULL, a NOT NULL, a + 1 NOT NULL = 1, NOT a NOTNULL;
+SELECT (SELECT max(b) FROM t WHERE c > 1), EXISTS (SELECT * FROM t AS x, u LEFT JOIN v ON x.a = v.a), NOT EXISTS (SELECT DISTINCT t.* FROM t GROUP BY a HAVING count(*) > 1 ORDER BY 1 DESC LIMIT 2 OFFSET 1), (SELECT 1) + 1;
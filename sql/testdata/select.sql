@@ -0,0 +1,5 @@
+SELECT 1;
+SELECT * FROM users;
+SELECT DISTINCT u.name AS n, score s, u.* FROM users AS u WHERE id >= 10 AND NOT deleted ORDER BY n DESC, 2 LIMIT 10 OFFSET 5;
+SELECT count(*), max(score), count(DISTINCT name), now() FROM users GROUP BY team HAVING count(*) > 1 LIMIT 5, 10;
+SELECT a.x, b.y FROM a JOIN b ON a.id = b.id LEFT OUTER JOIN c ON c.id = b.id, d CROSS JOIN e INNER JOIN f ON 1;
@@ -0,0 +1,311 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind is the kind of a token
+type Kind int
+
+const (
+	EOF Kind = iota
+	Ident
+	Keyword
+	Integer
+	Float
+	String
+	Blob
+	Operator
+	// Null is the kind of the NULL literal, NULL is lexed as a keyword
+	Null
+	// Variable is a parameter, ?, ?NNN, :name or $name
+	Variable
+)
+
+var kindNames = [...]string{"end of input", "identifier", "keyword", "integer", "float", "string", "blob", "operator", "null", "variable"}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Pos is a position in the source, Line and Column start at 1
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Token is a token of the source, Text of a keyword is upper case and
+// Text of a quoted identifier or a string is unquoted.
+type Token struct {
+	Kind Kind
+	Text string
+	Pos  Pos
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case EOF:
+		return t.Kind.String()
+	case String:
+		return "'" + strings.Replace(t.Text, "'", "''", -1) + "'"
+	case Blob:
+		return "X'" + t.Text + "'"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+var keywords = make(map[string]bool)
+
+func init() {
+	for _, k := range strings.Fields(`ALL ANALYZE AND AS ASC BEGIN BETWEEN BY CAST COLLATE COMMIT CREATE CROSS
+		DEFAULT DELETE DESC DISTINCT DROP EXISTS EXPLAIN FROM GROUP HAVING IF IN INDEX
+		INNER INSERT INTO IS ISNULL JOIN KEY LEFT LIKE LIMIT NOT NOTNULL NULL OFFSET ON OR
+		ORDER OUTER PRIMARY ROLLBACK SELECT SET TABLE TRANSACTION UNIQUE UPDATE
+		VALUES WHERE`) {
+		keywords[k] = true
+	}
+}
+
+// Error is a syntax error at Pos
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// lexer splits the source into tokens
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{l.off, l.line, l.col}
+}
+
+func (l *lexer) peekByte(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.off < len(l.src); i++ {
+		if l.src[l.off] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.off++
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// skip to skip white space and comments
+func (l *lexer) skip() error {
+	for l.off < len(l.src) {
+		switch c := l.src[l.off]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			l.advance(1)
+		case c == '-' && l.peekByte(1) == '-':
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance(1)
+			}
+		case c == '/' && l.peekByte(1) == '*':
+			start := l.pos()
+			end := strings.Index(l.src[l.off+2:], "*/")
+			if end < 0 {
+				return &Error{start, "unterminated comment"}
+			}
+			l.advance(end + 4)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// quoted reads the text up to the closing quote q, a doubled quote
+// stands for itself.
+func (l *lexer) quoted(q byte, what string) (string, error) {
+	start := l.pos()
+	l.advance(1)
+	var b strings.Builder
+	for {
+		if l.off >= len(l.src) {
+			return "", &Error{start, "unterminated " + what}
+		}
+		c := l.src[l.off]
+		l.advance(1)
+		if c == q {
+			if l.peekByte(0) != q {
+				return b.String(), nil
+			}
+			l.advance(1)
+		}
+		b.WriteByte(c)
+	}
+}
+
+func (l *lexer) next() (Token, error) {
+	if err := l.skip(); err != nil {
+		return Token{}, err
+	}
+
+	start := l.pos()
+	if l.off >= len(l.src) {
+		return Token{EOF, "", start}, nil
+	}
+
+	c := l.src[l.off]
+	switch {
+	case (c == 'x' || c == 'X') && l.peekByte(1) == '\'':
+		l.advance(1)
+		text, err := l.quoted('\'', "blob")
+		if err != nil {
+			return Token{}, err
+		}
+		for i := 0; i < len(text); i++ {
+			if !isHex(text[i]) {
+				return Token{}, &Error{start, "malformed blob literal"}
+			}
+		}
+		if len(text)%2 != 0 {
+			return Token{}, &Error{start, "malformed blob literal"}
+		}
+		return Token{Blob, text, start}, nil
+	case isIdentStart(c):
+		end := l.off
+		for end < len(l.src) && (isIdentStart(l.src[end]) || isDigit(l.src[end]) || l.src[end] == '$') {
+			end++
+		}
+		text := l.src[l.off:end]
+		l.advance(end - l.off)
+		if upper := strings.ToUpper(text); keywords[upper] {
+			return Token{Keyword, upper, start}, nil
+		}
+		return Token{Ident, text, start}, nil
+	case c == '"' || c == '`':
+		text, err := l.quoted(c, "identifier")
+		return Token{Ident, text, start}, err
+	case c == '[':
+		end := strings.IndexByte(l.src[l.off:], ']')
+		if end < 0 {
+			return Token{}, &Error{start, "unterminated identifier"}
+		}
+		text := l.src[l.off+1 : l.off+end]
+		l.advance(end + 1)
+		return Token{Ident, text, start}, nil
+	case c == '\'':
+		text, err := l.quoted(c, "string")
+		return Token{String, text, start}, err
+	case isDigit(c) || c == '.' && isDigit(l.peekByte(1)):
+		return l.number(start)
+	case c == '?' || (c == ':' || c == '$') && isIdentStart(l.peekByte(1)):
+		end := l.off + 1
+		for end < len(l.src) && (c == '?' && isDigit(l.src[end]) ||
+			c != '?' && (isIdentStart(l.src[end]) || isDigit(l.src[end]) || l.src[end] == '$')) {
+			end++
+		}
+		text := l.src[l.off:end]
+		l.advance(end - l.off)
+		return Token{Variable, text, start}, nil
+	}
+
+	for _, op := range []string{"||", "<=", ">=", "==", "!=", "<>", "<<", ">>"} {
+		if strings.HasPrefix(l.src[l.off:], op) {
+			l.advance(2)
+			return Token{Operator, op, start}, nil
+		}
+	}
+	if strings.IndexByte("(),;.*+-/%=<>&|~", c) >= 0 {
+		l.advance(1)
+		return Token{Operator, string(c), start}, nil
+	}
+	return Token{}, &Error{start, fmt.Sprintf("unexpected character %q", c)}
+}
+
+func (l *lexer) number(start Pos) (Token, error) {
+	end := l.off
+	kind := Integer
+	if l.src[end] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		end += 2
+		for end < len(l.src) && isHex(l.src[end]) {
+			end++
+		}
+		if end == l.off+2 {
+			return Token{}, &Error{start, "malformed hex literal"}
+		}
+	} else {
+		for end < len(l.src) && isDigit(l.src[end]) {
+			end++
+		}
+		if end < len(l.src) && l.src[end] == '.' {
+			kind = Float
+			end++
+			for end < len(l.src) && isDigit(l.src[end]) {
+				end++
+			}
+		}
+		if end < len(l.src) && (l.src[end] == 'e' || l.src[end] == 'E') {
+			kind = Float
+			end++
+			if end < len(l.src) && (l.src[end] == '+' || l.src[end] == '-') {
+				end++
+			}
+			if end >= len(l.src) || !isDigit(l.src[end]) {
+				return Token{}, &Error{start, "malformed number"}
+			}
+			for end < len(l.src) && isDigit(l.src[end]) {
+				end++
+			}
+		}
+	}
+	if end < len(l.src) && isIdentStart(l.src[end]) {
+		return Token{}, &Error{start, "malformed number"}
+	}
+
+	text := l.src[l.off:end]
+	l.advance(end - l.off)
+	return Token{kind, text, start}, nil
+}
+
+// Tokenize to split src into tokens, the last one is EOF
+func Tokenize(src string) ([]Token, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+	tokens := make([]Token, 0)
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.Kind == EOF {
+			return tokens, nil
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package gosqlite
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"gosqlite/sql"
+)
+
+// the number of stmts a conn caches by their text
+const defaultStmtCacheSize = 16
+
+var (
+	// ErrStmtClosed is returned when a closed stmt runs.
+	ErrStmtClosed = errors.New("statement is closed")
+	// ErrStmtBusy is returned when a stmt runs while its rows are open.
+	ErrStmtBusy = errors.New("statement has open rows")
+)
+
+// NamedArg is an argument bound to the parameter :Name or $Name
+type NamedArg struct {
+	Name  string
+	Value interface{}
+}
+
+// Named returns the argument v of the parameter name
+func Named(name string, v interface{}) NamedArg {
+	return NamedArg{name, v}
+}
+
+// Stmt is a statement prepared on a conn, it keeps its plan for the next
+// runs while the schema is not changed. A stmt is used by the goroutine
+// of its conn only.
+type Stmt struct {
+	conn *Conn
+	*prepared
+	closed bool
+}
+
+// prepared is the parsed statement and the plan a stmt runs, it goes to
+// the cache of the conn when its stmt is closed and a later Prepare of
+// the same text takes it in a new stmt.
+type prepared struct {
+	text   string
+	stmt   sql.Stmt
+	params []string
+	plan   *plan
+	busy   bool
+}
+
+// Prepare to prepare the statement text, the statement cached by the conn
+// is reused. The stmt must be closed, which returns it to the cache.
+func (c *Conn) Prepare(text string) (*Stmt, error) {
+	if p := c.cache.get(text); p != nil {
+		return &Stmt{conn: c, prepared: p}, nil
+	}
+
+	stmt, params, err := sql.ParseParams(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{conn: c, prepared: &prepared{text: text, stmt: stmt, params: params}}, nil
+}
+
+// SetStmtCacheSize to cache the last n stmts closed, 0 disables the cache
+func (c *Conn) SetStmtCacheSize(n int) {
+	c.cache.resize(n)
+}
+
+// CachedStmts returns the number of closed stmts the conn caches
+func (c *Conn) CachedStmts() int {
+	return c.cache.order.Len()
+}
+
+// NumParams returns the number of parameters, the largest index
+func (s *Stmt) NumParams() int {
+	return len(s.params)
+}
+
+// ParamName returns the name of the parameter i counting from 1, empty
+// for a ? parameter.
+func (s *Stmt) ParamName(i int) string {
+	if i < 1 || i > len(s.params) || s.params[i-1] == "?" {
+		return ""
+	}
+	return s.params[i-1]
+}
+
+// Close to close the stmt, it goes back to the cache of the conn once
+// its rows are closed.
+func (s *Stmt) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if !s.busy {
+		s.conn.cache.put(s.prepared)
+	}
+	return nil
+}
+
+// Exec to run the stmt with args, returns the result of the changes
+func (s *Stmt) Exec(args ...interface{}) (Result, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+
+// ExecContext to run the stmt with args until ctx is done
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (Result, error) {
+	rows, err := s.QueryContext(ctx, args...)
+	if err != nil {
+		return Result{}, err
+	}
+	for rows.Next() {
+	}
+	if err = rows.Close(); err != nil {
+		return Result{}, err
+	}
+	return rows.result, nil
+}
+
+// Query to run the stmt with args, the rows must be closed before the
+// stmt runs again.
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext to run the stmt with args, the rows end with the error of
+// ctx once it is done. DML and DDL are run to the end.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	if s.closed {
+		return nil, ErrStmtClosed
+	} else if s.busy {
+		return nil, ErrStmtBusy
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	params, err := s.bind(args)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.conn
+	switch s.stmt.(type) {
+	case *sql.BeginStmt:
+		return emptyRows(), c.Begin(context.Background(), false)
+	case *sql.CommitStmt:
+		return emptyRows(), c.Commit()
+	case *sql.RollbackStmt:
+		return emptyRows(), c.Rollback()
+	}
+
+	query := false
+	switch s.stmt.(type) {
+	case *sql.SelectStmt, *sql.ExplainStmt:
+		query = true
+	}
+	trx, done := c.begin(ctx, query)
+	p, err := s.planIn(trx, params)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+
+	s.busy = true
+	rows := &Rows{ctx: ctx, columns: p.columns, types: p.types, op: p.op, done: func(err error) error {
+		s.busy = false
+		if s.closed {
+			c.cache.put(s.prepared)
+		}
+		return done(err)
+	}}
+	if rows.types == nil {
+		rows.types = make([]string, len(p.columns))
+	}
+	if err = p.op.open(); err != nil {
+		rows.err = err
+		rows.Close()
+		return nil, err
+	}
+	if query {
+		return rows, nil
+	}
+
+	// changes are made when the single result row is read
+	rows.Next()
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	if p.result != nil {
+		rows.result = *p.result
+		if rows.result.LastInsertID != 0 {
+			c.lastInsertID = rows.result.LastInsertID
+		}
+	}
+	rows.result.LastInsertID = c.lastInsertID
+	return rows, nil
+}
+
+// planIn returns the plan of the stmt to run in trx with params, the
+// plan of the last run is reused unless the schema or the engine changed.
+// The schema is read again only when the schema cookie changed or trx
+// may not see the latest schema.
+func (s *Stmt) planIn(trx *Trx, params []interface{}) (*plan, error) {
+	db := s.conn.db
+	cookie := db.schemaCookie(trx)
+	if p := s.plan; p != nil && p.vm == s.conn.vm {
+		reuse := cookie >= 0 && p.cookie == cookie
+		if !reuse {
+			tables, err := db.schema(trx)
+			if err != nil {
+				return nil, err
+			}
+			reuse = p.reuse(tables, s.conn.vm)
+		}
+		if reuse {
+			p.cookie = cookie
+			p.env.trx, p.env.params = trx, params
+			if p.result != nil {
+				*p.result = Result{}
+			}
+			return p, nil
+		}
+	}
+
+	s.plan = nil
+	p, err := db.prepare(trx, s.stmt, s.text, s.conn.vm)
+	if err != nil {
+		return nil, err
+	}
+	p.cookie = cookie
+	p.env.params = params
+	s.plan = p
+	return p, nil
+}
+
+// bind returns the values of the parameters, a plain arg is bound to the
+// index of its position and a NamedArg by its name. Every parameter must
+// be bound, an index no parameter uses is NULL.
+func (s *Stmt) bind(args []interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(s.params))
+	bound := make([]bool, len(s.params))
+	for i, arg := range args {
+		if named, ok := arg.(NamedArg); ok {
+			if i = s.paramIndex(named.Name); i < 0 {
+				return nil, fmt.Errorf("no such parameter: %s", named.Name)
+			}
+			arg = named.Value
+		}
+		if i >= len(values) {
+			return nil, fmt.Errorf("%d arguments for %d parameters", len(args), len(values))
+		}
+
+		v, err := bindValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d: %v", i+1, err)
+		}
+		values[i], bound[i] = v, true
+	}
+	for i, ok := range bound {
+		if !ok && s.params[i] != "" {
+			return nil, fmt.Errorf("%d arguments for %d parameters", len(args), len(values))
+		}
+	}
+	return values, nil
+}
+
+// paramIndex returns the index of the parameter name, which may leave
+// out the : or $, -1 if none.
+func (s *Stmt) paramIndex(name string) int {
+	for i, param := range s.params {
+		if len(param) > 1 && (param == name || param[1:] == name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// bindValue returns v as a value of a column, NULL, int64, float64,
+// string or []byte. The other integers, float32 and bool are converted.
+func bindValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, int64, float64, string, []byte:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows", v)
+		}
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows", v)
+		}
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// stmtCache keeps the statements of the closed stmts of a conn by their
+// text, the least recently closed is dropped first.
+type stmtCache struct {
+	size  int
+	order *list.List
+	stmts map[string]*list.Element
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, order: list.New(), stmts: make(map[string]*list.Element)}
+}
+
+// get takes the statement of text out of the cache, nil if none
+func (c *stmtCache) get(text string) *prepared {
+	e, ok := c.stmts[text]
+	if !ok {
+		return nil
+	}
+	c.order.Remove(e)
+	delete(c.stmts, text)
+	return e.Value.(*prepared)
+}
+
+// put to cache s unless a statement of its text is cached
+func (c *stmtCache) put(s *prepared) {
+	if _, ok := c.stmts[s.text]; ok || c.size <= 0 {
+		return
+	}
+	c.stmts[s.text] = c.order.PushFront(s)
+	c.resize(c.size)
+}
+
+// resize to drop the least recently closed stmts beyond size
+func (c *stmtCache) resize(size int) {
+	c.size = size
+	for c.order.Len() > 0 && c.order.Len() > size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.stmts, e.Value.(*prepared).text)
+	}
+}
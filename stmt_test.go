@@ -0,0 +1,259 @@
+package gosqlite_test
+
+import (
+	"strings"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestStmtParams(t *testing.T) {
+	for _, vm := range []bool{false, true} {
+		conn := gosqlite.CreateDB().Conn()
+		conn.UseVM(vm)
+		if _, err := conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score REAL)"); err != nil {
+			t.Fatal(err)
+		}
+
+		ins, err := conn.Prepare("INSERT INTO t VALUES (?, :name, ?3)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins.NumParams() != 3 || ins.ParamName(2) != ":name" || ins.ParamName(1) != "" {
+			t.Fatalf("params %d %q", ins.NumParams(), ins.ParamName(2))
+		}
+		for i := 1; i <= 100; i++ {
+			res, err := ins.Exec(i, gosqlite.Named("name", "n"+strings.Repeat("x", i%3)), float32(i)/2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.RowsAffected != 1 || res.LastInsertID != int64(i) {
+				t.Fatalf("result %+v", res)
+			}
+		}
+		ins.Close()
+
+		rows, err := queryRows(conn, "SELECT count(*), sum(score) FROM t WHERE name = 'nx'")
+		if err != nil || len(rows) != 1 || rows[0] != "34 858.5" {
+			t.Fatalf("vm %v: %v %v", vm, rows, err)
+		}
+
+		sel, err := conn.Prepare("SELECT id FROM t WHERE id BETWEEN $lo AND $lo + ? ORDER BY id DESC LIMIT ?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := sel.Query(gosqlite.Named("$lo", 10), 5, uint8(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]interface{}, 0)
+		for r.Next() {
+			ids = append(ids, r.Values()[0])
+		}
+		if err = r.Close(); err != nil || len(ids) != 2 || ids[0] != int64(15) || ids[1] != int64(14) {
+			t.Fatalf("vm %v: %v %v", vm, ids, err)
+		}
+
+		for _, args := range [][]interface{}{
+			{1, 2},
+			{1, 2, 3, 4},
+			{1, 2, struct{}{}},
+			{gosqlite.Named("hi", 1), 2, 3},
+			{uint64(1 << 63), 2, 3},
+		} {
+			if _, err = sel.Exec(args...); err == nil {
+				t.Fatalf("vm %v: %v is bound", vm, args)
+			}
+		}
+		sel.Close()
+	}
+}
+
+func TestStmtReplan(t *testing.T) {
+	conn := gosqlite.CreateDB().Conn()
+	if _, err := conn.Exec("CREATE TABLE t (a); INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := conn.Prepare("SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i, want := range []string{"a", "a", "b,c", "b,c"} {
+		switch i {
+		case 2:
+			if _, err = conn.Exec("DROP TABLE t; CREATE TABLE t (b, c); INSERT INTO t VALUES (2, 3)"); err != nil {
+				t.Fatal(err)
+			}
+		case 3:
+			conn.UseVM(true)
+		}
+		rows, err := s.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(rows.Columns(), ","); got != want {
+			t.Fatalf("run %d: columns %s, want %s", i, got, want)
+		}
+		if !rows.Next() || rows.Close() != nil {
+			t.Fatalf("run %d: %v", i, rows.Err())
+		}
+	}
+
+	if _, err = conn.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Query(); err == nil || err.Error() != "no such table: t" {
+		t.Fatalf("dropped table: %v", err)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	conn := gosqlite.CreateDB().Conn()
+	a, err := conn.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := a.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Query(); err != gosqlite.ErrStmtBusy {
+		t.Fatalf("run with open rows: %v", err)
+	}
+
+	// a busy stmt is not shared and goes to the cache with its rows closed
+	if other, _ := conn.Prepare("SELECT 1"); other == a || conn.CachedStmts() != 0 {
+		t.Fatal("busy stmt is prepared again")
+	}
+	a.Close()
+	if _, err = a.Query(); err != gosqlite.ErrStmtClosed {
+		t.Fatalf("run after close: %v", err)
+	}
+	if conn.CachedStmts() != 0 {
+		t.Fatal("stmt with open rows is cached")
+	}
+	rows.Close()
+	if conn.CachedStmts() != 1 {
+		t.Fatal("closed stmt is not cached")
+	}
+
+	// the cached stmt is handed out in a new stmt, the closed one stays closed
+	again, _ := conn.Prepare("SELECT 1")
+	if again == a || conn.CachedStmts() != 0 {
+		t.Fatal("closed stmt is prepared again")
+	}
+	if _, err = a.Query(); err != gosqlite.ErrStmtClosed {
+		t.Fatalf("run closed stmt after prepare: %v", err)
+	}
+	a.Close()
+	if rows, err = again.Query(); err != nil || !rows.Next() || rows.Values()[0] != int64(1) {
+		t.Fatalf("run prepared stmt: %v", err)
+	}
+	rows.Close()
+	if conn.CachedStmts() != 0 {
+		t.Fatal("closing a stale stmt caches it")
+	}
+
+	conn.SetStmtCacheSize(1)
+	again.Close()
+	b, _ := conn.Prepare("SELECT 2")
+	b.Close()
+	if conn.CachedStmts() != 1 {
+		t.Fatalf("cached %d stmts", conn.CachedStmts())
+	}
+	if s, _ := conn.Prepare("SELECT 2"); conn.CachedStmts() != 0 {
+		t.Fatal("last stmt is not cached")
+	} else {
+		s.Close()
+	}
+	conn.Prepare("SELECT 1")
+	if conn.CachedStmts() != 1 {
+		t.Fatal("least recent stmt is not dropped")
+	}
+}
+
+func TestStmtParamGaps(t *testing.T) {
+	conn := gosqlite.CreateDB().Conn()
+	s, err := conn.Prepare("SELECT ?3, ?1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.NumParams() != 3 || s.ParamName(2) != "" || s.ParamName(3) != "" {
+		t.Fatalf("params %d", s.NumParams())
+	}
+	rows, err := s.Query(1, nil, 3)
+	if err != nil || !rows.Next() || rows.Values()[0] != int64(3) || rows.Values()[1] != int64(1) {
+		t.Fatalf("run with args for the gap: %v", err)
+	}
+	rows.Close()
+
+	// an index no parameter uses is NULL, a used one must be bound
+	if rows, err = s.Query(gosqlite.Named("?1", 1)); err == nil {
+		t.Fatal("?3 is bound")
+	}
+	if rows, err = s.Query(1); err == nil {
+		t.Fatal("?3 is bound")
+	}
+	s2, err := conn.Prepare("SELECT ?2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if rows, err = s2.Query(nil, 2); err != nil || !rows.Next() || rows.Values()[0] != int64(2) {
+		t.Fatalf("run with a gap: %v", err)
+	}
+	rows.Close()
+}
+
+func TestStmtSchemaCookie(t *testing.T) {
+	db := gosqlite.CreateDB()
+	c1, c2 := db.Conn(), db.Conn()
+	if _, err := c1.Exec("CREATE TABLE t (a); INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := c1.Prepare("SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	columns := func() string {
+		rows, err := s.Query()
+		if err != nil {
+			return err.Error()
+		}
+		defer rows.Close()
+		return strings.Join(rows.Columns(), ",")
+	}
+	if got := columns(); got != "a" {
+		t.Fatalf("columns %s", got)
+	}
+
+	// an uncommitted change of c2 is not seen by c1, the committed one is
+	if _, err = c2.Exec("BEGIN; DROP TABLE t; CREATE TABLE t (b, c)"); err != nil {
+		t.Fatal(err)
+	}
+	if got := columns(); got != "a" {
+		t.Fatalf("columns before commit %s", got)
+	}
+	if _, err = c1.Exec("BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c2.Exec("COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := columns(); got != "a" {
+		t.Fatalf("columns in older trx %s", got)
+	}
+	if _, err = c1.Exec("COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := columns(); got != "b,c" {
+		t.Fatalf("columns after commit %s", got)
+	}
+	if got := columns(); got != "b,c" {
+		t.Fatalf("columns of the reused plan %s", got)
+	}
+}
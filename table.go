@@ -0,0 +1,129 @@
+package gosqlite
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrDuplicateRowID is returned when a row with the rowid exists.
+var ErrDuplicateRowID = errors.New("rowid is not unique")
+
+// Table stores rows as records keyed by rowid in a b+ tree
+type Table struct {
+	tree     *BPlusTree
+	maxRowID int64
+}
+
+// rowKey maps a rowid to a tree key, negative rowids sort first
+func rowKey(rowID int64) uint64 {
+	return uint64(rowID) ^ 1<<63
+}
+
+func keyRowID(key uint64) int64 {
+	return int64(key ^ 1<<63)
+}
+
+// CreateTable to create a table on tree, new rowids follow the largest one in tree
+func CreateTable(tree *BPlusTree) *Table {
+	t := &Table{tree: tree}
+	for c := tree.First(); c.Valid(); c.Next() {
+		t.maxRowID = keyRowID(c.Key())
+	}
+	return t
+}
+
+// Tree returns the b+ tree of the table
+func (t *Table) Tree() *BPlusTree {
+	return t.tree
+}
+
+// Insert to insert a row, returns its rowid which is one more than the largest
+func (t *Table) Insert(values []interface{}) (int64, error) {
+	if t.maxRowID == math.MaxInt64 {
+		return 0, errors.New("rowid overflow")
+	}
+
+	rowID := t.maxRowID + 1
+	return rowID, t.InsertWithRowID(rowID, values)
+}
+
+// InsertWithRowID to insert a row with rowID
+func (t *Table) InsertWithRowID(rowID int64, values []interface{}) error {
+	if t.tree.Get(rowKey(rowID)) != nil {
+		return ErrDuplicateRowID
+	}
+	return t.put(rowID, values)
+}
+
+// Update to replace the values of row rowID
+func (t *Table) Update(rowID int64, values []interface{}) error {
+	if t.tree.Get(rowKey(rowID)) == nil {
+		return ErrRowNotFound
+	}
+	return t.put(rowID, values)
+}
+
+func (t *Table) put(rowID int64, values []interface{}) error {
+	payload, err := EncodeRecord(values)
+	if err != nil {
+		return err
+	}
+
+	t.tree.Insert(rowKey(rowID), payload)
+	if rowID > t.maxRowID {
+		t.maxRowID = rowID
+	}
+	return nil
+}
+
+// Get to get the values of row rowID
+func (t *Table) Get(rowID int64) ([]interface{}, bool, error) {
+	payload := t.tree.Get(rowKey(rowID))
+	if payload == nil {
+		return nil, false, nil
+	}
+
+	values, err := DecodeRecord(payload)
+	return values, err == nil, err
+}
+
+// Delete to delete row rowID, returns whether it existed
+func (t *Table) Delete(rowID int64) bool {
+	return t.tree.Delete(rowKey(rowID))
+}
+
+// TableCursor walks the rows of a table in rowid order, it is invalid
+// after the table is changed.
+type TableCursor struct {
+	c *Cursor
+}
+
+// Scan returns a cursor on the first row
+func (t *Table) Scan() *TableCursor {
+	return &TableCursor{t.tree.First()}
+}
+
+// SeekRowID returns a cursor on the first row with rowid not less than rowID
+func (t *Table) SeekRowID(rowID int64) *TableCursor {
+	return &TableCursor{t.tree.Seek(rowKey(rowID))}
+}
+
+// Valid returns whether the cursor is on a row
+func (c *TableCursor) Valid() bool {
+	return c.c.Valid()
+}
+
+// Next to move the cursor to the next row
+func (c *TableCursor) Next() {
+	c.c.Next()
+}
+
+// RowID returns the rowid under the cursor
+func (c *TableCursor) RowID() int64 {
+	return keyRowID(c.c.Key())
+}
+
+// Values returns the values of the row under the cursor
+func (c *TableCursor) Values() ([]interface{}, error) {
+	return DecodeRecord(c.c.Payload())
+}
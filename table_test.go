@@ -0,0 +1,70 @@
+package gosqlite_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gosqlite"
+)
+
+func TestTable(t *testing.T) {
+	table := gosqlite.CreateTable(gosqlite.CreateTree(5))
+	for i := 0; i < 100; i++ {
+		rowID, err := table.Insert([]interface{}{int64(i), "name", nil})
+		if err != nil || rowID != int64(i+1) {
+			t.Fatalf("insert %d: %d %v", i, rowID, err)
+		}
+	}
+	if err := table.InsertWithRowID(-5, []interface{}{"negative"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.InsertWithRowID(7, []interface{}{}); err != gosqlite.ErrDuplicateRowID {
+		t.Fatalf("duplicate rowid %v", err)
+	}
+	if err := table.Update(7, []interface{}{int64(70), 7.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Update(1000, []interface{}{}); err != gosqlite.ErrRowNotFound {
+		t.Fatalf("update missing row %v", err)
+	}
+	if !table.Delete(8) || table.Delete(8) {
+		t.Fatal("delete row 8")
+	}
+
+	values, ok, err := table.Get(7)
+	if !ok || err != nil || !reflect.DeepEqual(values, []interface{}{int64(70), 7.5}) {
+		t.Fatalf("get 7: %v %v %v", values, ok, err)
+	}
+	if _, ok, _ := table.Get(8); ok {
+		t.Fatal("deleted row 8 is found")
+	}
+
+	rowIDs := make([]int64, 0)
+	for c := table.Scan(); c.Valid(); c.Next() {
+		if _, err := c.Values(); err != nil {
+			t.Fatal(err)
+		}
+		rowIDs = append(rowIDs, c.RowID())
+	}
+	if len(rowIDs) != 100 || rowIDs[0] != -5 || rowIDs[1] != 1 || rowIDs[8] != 9 || rowIDs[99] != 100 {
+		t.Fatalf("scan %v", rowIDs)
+	}
+	if c := table.SeekRowID(8); !c.Valid() || c.RowID() != 9 {
+		t.Fatal("seek 8")
+	}
+
+	// rowids continue after the largest one of a stored table
+	fileName := filepath.Join(t.TempDir(), "table.db")
+	if err := table.Tree().Write(fileName); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gosqlite.OpenBtree(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table = gosqlite.CreateTable(tree)
+	if rowID, _ := table.Insert([]interface{}{"next"}); rowID != 101 {
+		t.Fatalf("rowid after reopen %d", rowID)
+	}
+}
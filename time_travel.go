@@ -0,0 +1,154 @@
+package gosqlite
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrHistoryUnavailable is returned when the versions of a past point were purged.
+var ErrHistoryUnavailable = errors.New("history is not available")
+
+// commitInfo is a point in history: trx trxID committed at time at with
+// commit timestamp ts.
+type commitInfo struct {
+	trxID int64
+	at    time.Time
+	ts    int64
+}
+
+func (context *TrxContext) recordCommit(trxID int64, ts int64, at time.Time) {
+	context.commits = append(context.commits, commitInfo{trxID, at, ts})
+}
+
+// commitTree returns the points of history keyed by commit timestamp, a
+// payload is the trx id and the time.
+func (context *TrxContext) commitTree() *BPlusTree {
+	tree := CreateTree(storeOrder)
+	for _, c := range context.commits {
+		payload := make([]byte, 16)
+		setInt64(payload, 0, uint64(c.trxID))
+		setInt64(payload, 8, uint64(c.at.UnixNano()))
+		tree.Insert(uint64(c.ts), payload)
+	}
+	return tree
+}
+
+// loadHistory to restore the history from the header page of the store
+// file data and the commit tree starting at offset.
+func (context *TrxContext) loadHistory(data []byte, offset int) error {
+	context.purgedLimit = int64(getInt64(data, 56))
+	context.history = commitInfo{
+		trxID: int64(getInt64(data, 64)),
+		at:    time.Unix(0, int64(getInt64(data, 72))),
+		ts:    int64(getInt64(data, 80)),
+	}
+	context.commits = nil
+	if offset == len(data) {
+		return nil
+	}
+
+	tree, err := loadTree(append([]byte(nil), data[offset:]...))
+	if err != nil {
+		return err
+	}
+	for c := tree.First(); c.Valid(); c.Next() {
+		payload := c.Payload()
+		if len(payload) < 16 {
+			return errors.New("The commit record is corrupt")
+		}
+		at := time.Unix(0, int64(getInt64(payload, 8)))
+		context.recordCommit(int64(getInt64(payload, 0)), int64(c.Key()), at)
+	}
+	return nil
+}
+
+// resetHistory to start history at the current state
+func (context *TrxContext) resetHistory() {
+	context.commits = nil
+	context.history = commitInfo{at: time.Now(), ts: context.commitCounter}
+	context.purgedLimit = context.commitCounter
+}
+
+// retentionLimit returns the commit timestamp purge keeps the versions
+// of, so the points within the retention can still be read.
+func (context *TrxContext) retentionLimit() int64 {
+	if context.retention <= 0 {
+		return math.MaxInt64
+	}
+
+	since := time.Now().Add(-context.retention)
+	for i := len(context.commits) - 1; i >= 0; i-- {
+		if !context.commits[i].at.After(since) {
+			return context.commits[i].ts
+		}
+	}
+	return context.history.ts
+}
+
+// trimHistory drops the points whose versions were purged, the last one
+// dropped becomes the start of history.
+func (context *TrxContext) trimHistory(limit int64) {
+	n := 0
+	for n < len(context.commits) && context.commits[n].ts < limit {
+		n++
+	}
+	if n > 0 {
+		context.history = context.commits[n-1]
+		context.commits = append(context.commits[:0], context.commits[n:]...)
+	}
+}
+
+// readAsOf starts a read-only trx on the snapshot of point
+func (context *TrxContext) readAsOf(point commitInfo) (*Trx, error) {
+	if point.ts < context.purgedLimit {
+		return nil, ErrHistoryUnavailable
+	}
+
+	t := context.allocteTrx()
+	t.begin(context, 0, &readView{snapshotTS: point.ts})
+	t.readOnly = true
+	return t, nil
+}
+
+// ReadAsOf to begin a read-only trx that sees the data right after trx
+// trxID committed.
+func (context *TrxContext) ReadAsOf(trxID int64) (*Trx, error) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	for i := len(context.commits) - 1; i >= 0; i-- {
+		if context.commits[i].trxID == trxID {
+			return context.readAsOf(context.commits[i])
+		}
+	}
+	if trxID != 0 && trxID == context.history.trxID {
+		return context.readAsOf(context.history)
+	}
+	return nil, ErrHistoryUnavailable
+}
+
+// ReadAsOfTime to begin a read-only trx that sees the data as of time at.
+func (context *TrxContext) ReadAsOfTime(at time.Time) (*Trx, error) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	if at.Before(context.history.at) {
+		return nil, ErrHistoryUnavailable
+	}
+	for i := len(context.commits) - 1; i >= 0; i-- {
+		if !context.commits[i].at.After(at) {
+			return context.readAsOf(context.commits[i])
+		}
+	}
+	return context.readAsOf(context.history)
+}
+
+// SetHistoryRetention to keep the versions needed to read as of any time
+// within retention, purge does not remove them.
+func (context *TrxContext) SetHistoryRetention(retention time.Duration) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.retention = retention
+}
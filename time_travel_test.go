@@ -0,0 +1,230 @@
+package gosqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+func readAsOf(t *testing.T, trx *gosqlite.Trx, err error, context *gosqlite.TrxContext) []string {
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := trx.Select(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trx.Commit()
+	return rowData(rows)
+}
+
+func TestReadAsOf(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetHistoryRetention(time.Hour)
+
+	trx1 := context.AllocteTrx()
+	trx1.Begin(context)
+	trx1.Insert(context, "a")
+	trx1.Insert(context, "b")
+	trx1.Commit()
+	at := time.Now()
+
+	// trx2 begins before trx3 but commits after it
+	trx2 := context.AllocteTrx()
+	trx2.Begin(context)
+	trx2.Update(context, 1, "a2")
+	trx3 := context.AllocteTrx()
+	trx3.Begin(context)
+	trx3.Delete(context, 2)
+	trx3.Commit()
+	active := context.AllocteTrx()
+	active.Begin(context)
+	active.Insert(context, "c")
+	trx2.Commit()
+	context.Purge()
+
+	asOf := func(trxID int64) []string {
+		trx, err := context.ReadAsOf(trxID)
+		return readAsOf(t, trx, err, context)
+	}
+	if got := asOf(1); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("as of 1 %v", got)
+	}
+	if got := asOf(3); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("as of 3 %v", got)
+	}
+	if got := asOf(2); len(got) != 1 || got[0] != "a2" {
+		t.Fatalf("as of 2 %v", got)
+	}
+	trx, err := context.ReadAsOfTime(at)
+	if got := readAsOf(t, trx, err, context); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("as of time %v", got)
+	}
+	if _, err := context.ReadAsOfTime(at.Add(-time.Hour)); err != gosqlite.ErrHistoryUnavailable {
+		t.Fatalf("before history %v", err)
+	}
+	if _, err := context.ReadAsOf(4); err != gosqlite.ErrHistoryUnavailable {
+		t.Fatalf("as of uncommitted trx %v", err)
+	}
+	active.Rollback()
+}
+
+func TestReadAsOfReadOnly(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	trx.Commit()
+
+	asOf, err := context.ReadAsOf(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asOf.Insert(context, "b"); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("insert %v", err)
+	}
+	if err := asOf.Update(context, 1, "b"); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("update %v", err)
+	}
+	if err := asOf.Delete(context, 1); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("delete %v", err)
+	}
+	if err := asOf.LockShared(1); err != gosqlite.ErrTrxReadOnly {
+		t.Fatalf("lock %v", err)
+	}
+	if err := asOf.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetHistoryRetention(time.Hour)
+	for i, data := range []string{"a", "b", "c"} {
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		if i == 0 {
+			trx.Insert(context, data)
+		} else {
+			trx.Update(context, 1, data)
+		}
+		trx.Commit()
+	}
+
+	// an open as-of trx keeps its versions when retention ends
+	held, err := context.ReadAsOf(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context.Purge()
+	trx, err := context.ReadAsOf(1)
+	if got := readAsOf(t, trx, err, context); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("within retention %v", got)
+	}
+
+	context.SetHistoryRetention(0)
+	context.Purge()
+	if _, err := context.ReadAsOf(1); err != gosqlite.ErrHistoryUnavailable {
+		t.Fatalf("purged history %v", err)
+	}
+	if got := readAsOf(t, held, nil, context); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("held as of 2 %v", got)
+	}
+
+	context.Purge()
+	if _, err := context.ReadAsOf(2); err != gosqlite.ErrHistoryUnavailable {
+		t.Fatalf("purged history %v", err)
+	}
+	trx, err = context.ReadAsOf(3)
+	if got := readAsOf(t, trx, err, context); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("latest %v", got)
+	}
+}
+
+func TestHistoryPurgedByCommits(t *testing.T) {
+	for _, retention := range []time.Duration{0, time.Hour} {
+		context := gosqlite.CreateTrxContext()
+		context.SetHistoryRetention(retention)
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		trx.Insert(context, "a")
+		trx.Commit()
+
+		// inserts allocate no undo records, the commits purge
+		for i := 0; i < 2000; i++ {
+			trx := context.AllocteTrx()
+			trx.Begin(context)
+			trx.Insert(context, "b")
+			trx.Commit()
+		}
+		trx, err := context.ReadAsOf(1)
+		if retention == 0 {
+			if err != gosqlite.ErrHistoryUnavailable {
+				t.Fatalf("history kept without retention: %v", err)
+			}
+			continue
+		}
+		if got := readAsOf(t, trx, err, context); len(got) != 1 || got[0] != "a" {
+			t.Fatalf("within retention %v", got)
+		}
+	}
+}
+
+func TestReadAsOfReopened(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trx.db")
+	open := func() *gosqlite.TrxContext {
+		context, err := gosqlite.OpenTrxContext(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		context.SetHistoryRetention(time.Hour)
+		return context
+	}
+	update := func(context *gosqlite.TrxContext, data string) {
+		trx := context.AllocteTrx()
+		trx.Begin(context)
+		trx.Update(context, 1, data)
+		if err := trx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	asOf := func(context *gosqlite.TrxContext, at time.Time) []string {
+		trx, err := context.ReadAsOfTime(at)
+		return readAsOf(t, trx, err, context)
+	}
+
+	context := open()
+	trx := context.AllocteTrx()
+	trx.Begin(context)
+	trx.Insert(context, "a")
+	trx.Commit()
+	atA := time.Now()
+	update(context, "b")
+	atB := time.Now()
+	if err := context.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	context = open()
+	update(context, "c")
+	// crash: the last update is recovered from the redo log
+	context = open()
+	for _, want := range []struct {
+		at   time.Time
+		data string
+	}{{atA, "a"}, {atB, "b"}, {time.Now(), "c"}} {
+		if got := asOf(context, want.at); len(got) != 1 || got[0] != want.data {
+			t.Fatalf("as of %v: %v, want %s", want.at, got, want.data)
+		}
+	}
+	trx, err := context.ReadAsOf(1)
+	if got := readAsOf(t, trx, err, context); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("as of trx 1 %v", got)
+	}
+	if _, err := context.ReadAsOfTime(atA.Add(-time.Hour)); err != gosqlite.ErrHistoryUnavailable {
+		t.Fatalf("before history %v", err)
+	}
+	context.Close()
+}
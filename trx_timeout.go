@@ -0,0 +1,177 @@
+package gosqlite
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrTrxTimeout is the reason a trx is aborted after its max duration or idle timeout.
+	ErrTrxTimeout = errors.New("trx timed out")
+	// ErrTrxKilled is the reason a trx is aborted by Kill.
+	ErrTrxKilled = errors.New("trx was killed")
+	// ErrTrxNotFound is returned by Kill when no active trx has the id.
+	ErrTrxNotFound = errors.New("trx not found")
+)
+
+// TrxStatus describes an active trx, ID identifies it for Kill since
+// read-only trx have no TrxID.
+type TrxStatus struct {
+	ID       int64
+	TrxID    int64
+	ReadOnly bool
+	Started  time.Time
+	Age      time.Duration
+	Rows     int
+}
+
+// SetTrxTimeout to abort the trx begun from now on that run longer than
+// maxDuration or stay idle longer than idle, 0 disables a timeout.
+func (context *TrxContext) SetTrxTimeout(maxDuration, idle time.Duration) {
+	context.mu.Lock()
+	defer context.mu.Unlock()
+
+	context.maxDuration = maxDuration
+	context.idleTimeout = idle
+}
+
+// BeginContext to begin a trx that is rolled back when ctx is done
+func (t *Trx) BeginContext(ctx context.Context, context *TrxContext) {
+	t.Begin(context)
+	t.watch(ctx)
+}
+
+// BeginReadOnlyContext to begin a read-only trx that ends when ctx is done
+func (t *Trx) BeginReadOnlyContext(ctx context.Context, context *TrxContext) {
+	t.BeginReadOnly(context)
+	t.watch(ctx)
+}
+
+// watch to abort trx with the error of ctx once it is done
+func (t *Trx) watch(ctx context.Context) {
+	t.ctx.mu.Lock()
+	defer t.ctx.mu.Unlock()
+
+	id := t.id
+	stop := context.AfterFunc(ctx, func() { t.abort(id, ctx.Err()) })
+	t.stops = append(t.stops, stop)
+}
+
+// startTimers to arm the timeouts of the context for trx, called at begin
+func (t *Trx) startTimers(context *TrxContext) {
+	now := time.Now()
+	t.started = now
+	t.idle = context.idleTimeout
+	if context.maxDuration <= 0 && context.idleTimeout <= 0 {
+		return
+	}
+
+	id := t.id
+	if context.maxDuration > 0 {
+		timer := time.AfterFunc(context.maxDuration, func() { t.abort(id, ErrTrxTimeout) })
+		t.stops = append(t.stops, timer.Stop)
+	}
+	if t.idle > 0 {
+		atomic.StoreInt64(&t.lastUsed, now.UnixNano())
+		var timer *time.Timer
+		timer = time.AfterFunc(t.idle, func() {
+			// the timer fires again when trx was used since it was armed
+			context.mu.Lock()
+			if t.id == id && t.status == uncommit {
+				last := time.Unix(0, atomic.LoadInt64(&t.lastUsed))
+				if left := t.idle - time.Since(last); left > 0 {
+					timer.Reset(left)
+					context.mu.Unlock()
+					return
+				}
+			}
+			context.mu.Unlock()
+			t.abort(id, ErrTrxTimeout)
+		})
+		t.stops = append(t.stops, timer.Stop)
+	}
+}
+
+// stopTimers to stop the timeouts and the watch of trx, called when it finishes
+func (t *Trx) stopTimers() {
+	for _, stop := range t.stops {
+		stop()
+	}
+	t.stops = nil
+}
+
+// touch to record that trx is used for the idle timeout
+func (t *Trx) touch() {
+	if t.idle > 0 {
+		atomic.StoreInt64(&t.lastUsed, time.Now().UnixNano())
+	}
+}
+
+// abort to roll trx back if it is still the trx begun as id, a lock wait
+// of trx returns reason. The caller still holds trx, so its slot is
+// retired rather than reused by a later trx.
+func (t *Trx) abort(id int64, reason error) {
+	ctx := t.ctx
+	if ctx == nil {
+		return
+	}
+
+	ctx.mu.RLock()
+	active := t.id == id && t.status == uncommit && !t.committing
+	trxID := t.trxID
+	ctx.mu.RUnlock()
+	if !active {
+		return
+	}
+
+	if trxID != 0 {
+		ctx.locks.cancel(trxID, reason)
+	}
+	t.rollback(id, true)
+}
+
+// ActiveTrx to list the active trx, the oldest first
+func (context *TrxContext) ActiveTrx() []TrxStatus {
+	context.mu.RLock()
+	defer context.mu.RUnlock()
+
+	now := time.Now()
+	list := make([]TrxStatus, 0)
+	for _, t := range context.trxIDs {
+		if t.status != uncommit || t.committing {
+			continue
+		}
+		list = append(list, TrxStatus{
+			ID:       t.id,
+			TrxID:    t.trxID,
+			ReadOnly: t.readOnly,
+			Started:  t.started,
+			Age:      now.Sub(t.started),
+			Rows:     len(t.writes),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Kill to roll back the active trx with id, a lock wait of it returns ErrTrxKilled.
+func (context *TrxContext) Kill(id int64) error {
+	context.mu.RLock()
+	var found *Trx
+	for _, t := range context.trxIDs {
+		if t.id == id && t.status == uncommit && !t.committing {
+			found = t
+			break
+		}
+	}
+	context.mu.RUnlock()
+	if found == nil {
+		return ErrTrxNotFound
+	}
+
+	found.abort(id, ErrTrxKilled)
+	return nil
+}
@@ -0,0 +1,143 @@
+package gosqlite_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gosqlite"
+)
+
+// waitFinished waits until the context has no active trx
+func waitFinished(t *testing.T, context *gosqlite.TrxContext) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(context.ActiveTrx()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("active trx %+v", context.ActiveTrx())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBeginContext(t *testing.T) {
+	trxContext := gosqlite.CreateTrxContext()
+	ctx, cancel := context.WithCancel(context.Background())
+	trx := trxContext.AllocteTrx()
+	trx.BeginContext(ctx, trxContext)
+	trx.Insert(trxContext, "a")
+
+	cancel()
+	waitFinished(t, trxContext)
+	if _, err := trx.Insert(trxContext, "b"); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("insert after cancel %v", err)
+	}
+	if got := snapshot(t, trxContext); len(got) != 0 {
+		t.Fatalf("cancelled trx left %v", got)
+	}
+}
+
+func TestBeginContextLockWait(t *testing.T) {
+	trxContext := gosqlite.CreateTrxContext()
+	holder := beginTrx(trxContext)
+	holder.Insert(trxContext, "a")
+	holder.LockExclusive(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	trx := trxContext.AllocteTrx()
+	trx.BeginContext(ctx, trxContext)
+	if err := trx.LockShared(1); err != context.DeadlineExceeded {
+		t.Fatalf("lock wait %v", err)
+	}
+	holder.Commit()
+	waitFinished(t, trxContext)
+}
+
+func TestTrxTimeout(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetTrxTimeout(50*time.Millisecond, 0)
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	waitFinished(t, context)
+	if got := snapshot(t, context); len(got) != 0 {
+		t.Fatalf("timed out trx left %v", got)
+	}
+
+	context.SetTrxTimeout(0, 100*time.Millisecond)
+	trx = beginTrx(context)
+	for i := 0; i < 10; i++ {
+		time.Sleep(25 * time.Millisecond)
+		if _, _, err := trx.Get(context, 1); err != nil {
+			t.Fatalf("used trx timed out %v", err)
+		}
+	}
+	waitFinished(t, context)
+}
+
+func TestTrxTimeoutStaleHandle(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	context.SetTrxTimeout(20*time.Millisecond, 0)
+	stale := beginTrx(context)
+	stale.Insert(context, "a")
+	waitFinished(t, context)
+
+	context.SetTrxTimeout(0, 0)
+	trx := beginTrx(context)
+	if trx == stale {
+		t.Fatal("aborted trx slot reused")
+	}
+	if _, err := trx.Insert(context, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stale.Insert(context, "c"); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("insert on stale trx %v", err)
+	}
+	if _, _, err := stale.Get(context, 1); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("get on stale trx %v", err)
+	}
+	if err := stale.Savepoint("s"); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("savepoint on stale trx %v", err)
+	}
+	if err := stale.Commit(); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("commit on stale trx %v", err)
+	}
+	stale.Rollback()
+	if len(context.ActiveTrx()) != 1 {
+		t.Fatalf("active trx %+v", context.ActiveTrx())
+	}
+	if err := trx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, context); len(got) != 1 || got[2] != "b" {
+		t.Fatalf("rows %v", got)
+	}
+}
+
+func TestKillTrx(t *testing.T) {
+	context := gosqlite.CreateTrxContext()
+	trx := beginTrx(context)
+	trx.Insert(context, "a")
+	trx.Insert(context, "b")
+	ro := context.AllocteTrx()
+	ro.BeginReadOnly(context)
+
+	list := context.ActiveTrx()
+	if len(list) != 2 || list[0].TrxID != 1 || list[0].Rows != 2 || !list[1].ReadOnly || list[1].Age < 0 {
+		t.Fatalf("active trx %+v", list)
+	}
+	if err := context.Kill(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := context.Kill(list[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := context.Kill(list[0].ID); err != gosqlite.ErrTrxNotFound {
+		t.Fatalf("kill twice %v", err)
+	}
+	if len(context.ActiveTrx()) != 0 {
+		t.Fatalf("active trx after kill %+v", context.ActiveTrx())
+	}
+	if err := trx.Commit(); err != gosqlite.ErrTrxNotActive {
+		t.Fatalf("commit after kill %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package gosqlite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gosqlite/sql"
+)
+
+// affinity is the type a column prefers for its values, the codes are
+// those of SQLite. An expression other than a column or a CAST has none.
+type affinity byte
+
+const (
+	affinityNone    affinity = 0
+	affinityBlob    affinity = 'A'
+	affinityText    affinity = 'B'
+	affinityNumeric affinity = 'C'
+	affinityInteger affinity = 'D'
+	affinityReal    affinity = 'E'
+)
+
+// typeAffinity returns the affinity of a declared type by the rules of
+// SQLite, the first that matches: INT, then CHAR, CLOB or TEXT, then BLOB
+// or no type, then REAL, FLOA or DOUB, else NUMERIC.
+func typeAffinity(typ string) affinity {
+	typ = strings.ToUpper(typ)
+	contains := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(typ, w) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case contains("INT"):
+		return affinityInteger
+	case contains("CHAR", "CLOB", "TEXT"):
+		return affinityText
+	case typ == "" || contains("BLOB"):
+		return affinityBlob
+	case contains("REAL", "FLOA", "DOUB"):
+		return affinityReal
+	}
+	return affinityNumeric
+}
+
+func (a affinity) numeric() bool {
+	return a >= affinityNumeric
+}
+
+// apply returns v converted by the affinity: text that is a number to
+// the number for a numeric one, a real without a fraction to an integer
+// for INTEGER and NUMERIC, an integer to a real for REAL and a number to
+// text for TEXT. Other values and blobs are kept.
+func (a affinity) apply(v interface{}) interface{} {
+	switch a {
+	case affinityText:
+		switch v.(type) {
+		case int64, float64:
+			return toText(v)
+		}
+	case affinityNumeric, affinityInteger, affinityReal:
+		if s, ok := v.(string); ok {
+			n, ok := parseNumber(s)
+			if !ok {
+				return v
+			}
+			v = n
+		}
+		switch n := v.(type) {
+		case int64:
+			if a == affinityReal {
+				return float64(n)
+			}
+		case float64:
+			if a != affinityReal && n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n)
+			}
+		}
+	}
+	return v
+}
+
+// parseNumber returns the number text is when the whole text is one,
+// spaces around it aside.
+func parseNumber(text string) (interface{}, bool) {
+	s := strings.TrimSpace(text)
+	if s == "" || strings.ContainsAny(s, "xXnN_") {
+		// no hex, inf, nan or digit separators
+		return nil, false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// compareAffinity returns the affinity applied to both sides of a
+// comparison of values of affinities x and y: NUMERIC when a side is
+// numeric, else that of the side which has one when the other has none.
+func compareAffinity(x, y affinity) affinity {
+	switch {
+	case x != affinityNone && y != affinityNone:
+		if x.numeric() || y.numeric() {
+			return affinityNumeric
+		}
+		return affinityBlob
+	case x == affinityNone:
+		return y
+	}
+	return x
+}
+
+// exprAffinity returns the affinity of e in s
+func exprAffinity(e sql.Expr, s *scope) affinity {
+	switch e := e.(type) {
+	case *sql.ColumnRef:
+		if i, err := s.resolve(e); err == nil {
+			return s.columns[i].aff
+		}
+	case *sql.CastExpr:
+		return typeAffinity(e.Type)
+	case *sql.CollateExpr:
+		return exprAffinity(e.X, s)
+	}
+	return affinityNone
+}
+
+// collation orders text by the bytes of the keys of the text, key nil is
+// the text itself.
+type collation struct {
+	name string
+	key  func(s string) string
+}
+
+var (
+	binaryCollation = &collation{name: "BINARY"}
+	nocaseCollation = &collation{name: "NOCASE", key: func(s string) string {
+		b := []byte(s)
+		for i, c := range b {
+			b[i] = lowerASCII(c)
+		}
+		return string(b)
+	}}
+	rtrimCollation = &collation{name: "RTRIM", key: func(s string) string {
+		return strings.TrimRight(s, " ")
+	}}
+
+	collations = struct {
+		sync.RWMutex
+		byName map[string]*collation
+	}{byName: map[string]*collation{"binary": binaryCollation, "nocase": nocaseCollation, "rtrim": rtrimCollation}}
+)
+
+// RegisterCollation to add the collation name for COLLATE, it orders
+// text by the bytes of key(text) and text with equal keys are equal, so
+// the collation orders indexes, GROUP BY and DISTINCT too. A collation
+// can not be replaced as indexes are ordered by it.
+func RegisterCollation(name string, key func(s string) string) error {
+	if name == "" || key == nil {
+		return fmt.Errorf("collation %q needs a name and a key", name)
+	}
+	collations.Lock()
+	defer collations.Unlock()
+	if collations.byName[strings.ToLower(name)] != nil {
+		return fmt.Errorf("collation %s already exists", name)
+	}
+	collations.byName[strings.ToLower(name)] = &collation{name: name, key: key}
+	return nil
+}
+
+// lookupCollation returns the collation name, BINARY for an empty name
+func lookupCollation(name string) (*collation, error) {
+	if name == "" {
+		return binaryCollation, nil
+	}
+	collations.RLock()
+	defer collations.RUnlock()
+	if c := collations.byName[strings.ToLower(name)]; c != nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("no such collation sequence: %s", name)
+}
+
+// text returns the key of s, nil is BINARY
+func (c *collation) text(s string) string {
+	if c == nil || c.key == nil {
+		return s
+	}
+	return c.key(s)
+}
+
+// compare returns -1, 0 or 1 comparing a and b like compareValues with
+// text compared by the collation
+func (c *collation) compare(a, b interface{}) int {
+	if c != nil && c.key != nil {
+		if x, ok := a.(string); ok {
+			if y, ok := b.(string); ok {
+				return strings.Compare(c.key(x), c.key(y))
+			}
+		}
+	}
+	return compareValues(a, b)
+}
+
+// collate returns values with the text replaced by its key in colls, a
+// nil colls or collation is BINARY
+func collate(values []interface{}, colls []*collation) []interface{} {
+	if colls == nil {
+		return values
+	}
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok && i < len(colls) {
+			v = colls[i].text(s)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// exprCollation returns the collation of e in s, that of COLLATE or of a
+// column and nil for none. explicit tells it is of COLLATE.
+func exprCollation(e sql.Expr, s *scope) (coll *collation, explicit bool, err error) {
+	switch e := e.(type) {
+	case *sql.CollateExpr:
+		coll, err = lookupCollation(e.Collation)
+		return coll, true, err
+	case *sql.ColumnRef:
+		if i, err := s.resolve(e); err == nil {
+			return s.columns[i].coll, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// comparisonCollation returns the collation comparing x and y: that of a
+// COLLATE, the left first, else of a column, the left first, else BINARY.
+func comparisonCollation(x, y sql.Expr, s *scope) (*collation, error) {
+	cx, ex, err := exprCollation(x, s)
+	if err != nil {
+		return nil, err
+	}
+	cy, ey, err := exprCollation(y, s)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ex:
+		return cx, nil
+	case ey:
+		return cy, nil
+	case cx != nil:
+		return cx, nil
+	case cy != nil:
+		return cy, nil
+	}
+	return binaryCollation, nil
+}
+
+// castValue returns CAST(v AS typ), NULL stays NULL
+func castValue(v interface{}, typ string) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch typeAffinity(typ) {
+	case affinityText:
+		return toText(v)
+	case affinityBlob:
+		if s, ok := toText(v).(string); ok {
+			return []byte(s)
+		}
+		return v
+	case affinityInteger:
+		return toInt(v)
+	case affinityReal:
+		return toFloat(toNumeric(v))
+	}
+	n := toNumeric(v)
+	if f, ok := n.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return int64(f)
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package gosqlite_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"gosqlite"
+)
+
+var registerDigits sync.Once
+
+// digits orders text by its digits alone
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func TestRegisterCollation(t *testing.T) {
+	registerDigits.Do(func() {
+		if err := gosqlite.RegisterCollation("digits", digits); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := gosqlite.RegisterCollation("DIGITS", digits); err == nil {
+		t.Fatal("registered digits twice")
+	}
+	if err := gosqlite.RegisterCollation("nocase", digits); err == nil {
+		t.Fatal("replaced nocase")
+	}
+	if err := gosqlite.RegisterCollation("none", nil); err == nil {
+		t.Fatal("registered a collation without a key")
+	}
+
+	for _, vm := range []bool{false, true} {
+		conn := gosqlite.CreateDB().Conn()
+		conn.UseVM(vm)
+		if _, err := conn.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT COLLATE digits)" +
+			"; INSERT INTO t (v) VALUES ('b2'), ('a3'), ('c1'), ('x-1')"); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := queryRows(conn, "SELECT v FROM t ORDER BY v, id")
+		if err != nil || fmt.Sprint(rows) != "[c1 x-1 b2 a3]" {
+			t.Fatalf("vm %v: %v %v", vm, rows, err)
+		}
+		rows, err = queryRows(conn, "SELECT id FROM t WHERE v = '#1' ORDER BY id")
+		if err != nil || fmt.Sprint(rows) != "[3 4]" {
+			t.Fatalf("vm %v: %v %v", vm, rows, err)
+		}
+
+		// the index is ordered and searched by the collation too
+		if _, err := conn.Exec("CREATE UNIQUE INDEX t_v ON t (v)"); err == nil || err.Error() != "UNIQUE constraint failed: t.v" {
+			t.Fatalf("vm %v: unique index on equal keys: %v", vm, err)
+		}
+		if _, err := conn.Exec("DELETE FROM t WHERE id = 4; CREATE UNIQUE INDEX t_v ON t (v)"); err != nil {
+			t.Fatal(err)
+		}
+		rows, err = queryRows(conn, "EXPLAIN QUERY PLAN SELECT id FROM t WHERE v = 'z2'")
+		if err != nil || fmt.Sprint(rows) != "[2 0 0 SEARCH t USING COVERING INDEX t_v (v=?)]" {
+			t.Fatalf("vm %v: %v %v", vm, rows, err)
+		}
+		rows, err = queryRows(conn, "SELECT id FROM t WHERE v = 'z2'")
+		if err != nil || fmt.Sprint(rows) != "[1]" {
+			t.Fatalf("vm %v: %v %v", vm, rows, err)
+		}
+		if _, err := conn.Exec("INSERT INTO t (v) VALUES ('3')"); err == nil || err.Error() != "UNIQUE constraint failed: t.v" {
+			t.Fatalf("vm %v: insert of an equal key: %v", vm, err)
+		}
+	}
+}
@@ -0,0 +1,742 @@
+package gosqlite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// opcode is an instruction of the bytecode VM, the registers and cursors
+// an instruction uses are in P1 to P3, see the comments of the opcodes.
+type opcode uint8
+
+const (
+	opInit       opcode = iota // jump to P2
+	opGoto                     // jump to P2
+	opGosub                    // r[P1] = return address, jump to P2
+	opReturn                   // jump to the address in r[P1]
+	opHalt                     // end the program
+	opHaltIfNull               // fail with P4 when r[P1] is NULL
+
+	opInteger  // r[P2] = P1
+	opInt64    // r[P2] = P4
+	opReal     // r[P2] = P4
+	opString8  // r[P2] = P4
+	opBlob     // r[P2] = P4
+	opVariable // r[P2] = parameter P1
+	opNull     // r[P2..P3] = NULL
+	opSCopy    // r[P2] = r[P1]
+	opCopy     // r[P2..P2+P3] = r[P1..P1+P3]
+
+	opOpenRead      // open cursor P1 on the table P4 with id P2 to read
+	opOpenWrite     // open cursor P1 on the table P4 with id P2 to write
+	opOpenEphemeral // open cursor P1 on an empty set of keys, text compared by the collations P4
+	opSorterOpen    // open cursor P1 on a sorter ordered by its first P2 columns, by the keys P4
+	opRewind        // move cursor P1 to its first row, jump to P2 when empty
+	opNext          // move cursor P1 to the next row, jump to P2 unless at the end
+	opSeekRowid     // move cursor P1 to the row with rowid r[P3], jump to P2 if there is none
+	opSeekGE        // move cursor P1 to the first row with rowid >= r[P3], jump to P2 if there is none
+	opSeekGT        // move cursor P1 to the first row with rowid > r[P3], jump to P2 if there is none
+	opIdxScan       // move cursor P1 to the first row in the index range P4 of the values r[P3..], jump to P2 if there is none
+	opNullRow       // make cursor P1 point at a row of NULLs
+	opColumn        // r[P3] = column P2 of cursor P1
+	opRowid         // r[P2] = rowid of cursor P1
+	opResultRow     // output r[P1..P1+P2-1]
+	opMakeRecord    // r[P3] = record of r[P1..P1+P2-1] converted by the affinities P4
+	opNewRowid      // r[P2] = a new rowid of the table of cursor P1
+	opInsert        // write record r[P2] as row r[P3] of cursor P1, P5 flags
+	opDelete        // delete the row of cursor P1, P5 flags
+	opMustBeInt     // fail unless r[P1] is an integer
+
+	opRowSetAdd    // add rowid r[P2] to the rowset r[P1]
+	opRowSetRead   // r[P3] = next rowid of rowset r[P1], jump to P2 when empty
+	opSorterInsert // add r[P2..P2+P3-1] to the sorter P1
+	opSorterSort   // sort sorter P1 and move to its first row, jump to P2 when empty
+	opSorterNext   // move sorter P1 to the next row, jump to P2 unless at the end
+	opFound        // jump to P2 if the key r[P3..P3+P4-1] is in cursor P1
+	opIdxInsert    // add the key r[P2..P2+P3-1] to cursor P1
+	opIf           // jump to P2 if r[P1] is true
+	opIfNot        // jump to P2 unless r[P1] is true
+	opIfPos        // if r[P1] > 0 then r[P1] -= P3 and jump to P2
+	opDecrJumpZero // r[P1]--, jump to P2 when it is 0
+	opNotNull      // jump to P2 if r[P1] is not NULL
+	opCompare      // compare r[P1..P1+P3-1] with r[P2..P2+P3-1], text by the collations P4
+	opJump         // jump to P1, P2 or P3 as the last compare was <, = or >
+	opAdd          // r[P3] = r[P1] + r[P2], the binary ops down to opOr alike
+	opSubtract     //
+	opMultiply     //
+	opDivide       //
+	opRemainder    //
+	opConcat       //
+	opBitAnd       //
+	opBitOr        //
+	opShiftLeft    //
+	opShiftRight   //
+	opEq           // the comparisons down to opIsNot convert by the affinity P5, text by the collation P4
+	opNe           //
+	opLt           //
+	opLe           //
+	opGt           //
+	opGe           //
+	opIs           //
+	opIsNot        //
+	opLike         //
+	opNotLike      //
+	opAnd          //
+	opOr           //
+	opNot          // r[P2] = NOT r[P1], the unary ops down to opPositive alike
+	opBitNot       //
+	opNegative     //
+	opPositive     //
+	opCast         // r[P1] = CAST(r[P1] AS P4)
+	opCollSeq      // the next opIn or opNotIn compares text by the collation P4
+	opIn           // r[P3] = r[P1] IN r[P2..P2+P4-1] converted by the affinity P5
+	opNotIn        // r[P3] = r[P1] NOT IN r[P2..P2+P4-1] converted by the affinity P5
+	opFunction     // r[P3] = function P4 of r[P1..P1+P2-1]
+	opAggStep      // add r[P1] to the aggregate P4 in r[P2]
+	opAggFinal     // r[P1] = result of the aggregate P4 in r[P1]
+)
+
+var opcodeNames = [...]string{
+	"Init", "Goto", "Gosub", "Return", "Halt", "HaltIfNull",
+	"Integer", "Int64", "Real", "String8", "Blob", "Variable", "Null", "SCopy", "Copy",
+	"OpenRead", "OpenWrite", "OpenEphemeral", "SorterOpen", "Rewind", "Next", "SeekRowid", "SeekGE",
+	"SeekGT", "IdxScan", "NullRow", "Column", "Rowid", "ResultRow", "MakeRecord", "NewRowid", "Insert", "Delete",
+	"MustBeInt",
+	"RowSetAdd", "RowSetRead", "SorterInsert", "SorterSort", "SorterNext", "Found", "IdxInsert",
+	"If", "IfNot", "IfPos", "DecrJumpZero", "NotNull", "Compare", "Jump",
+	"Add", "Subtract", "Multiply", "Divide", "Remainder", "Concat", "BitAnd", "BitOr", "ShiftLeft",
+	"ShiftRight", "Eq", "Ne", "Lt", "Le", "Gt", "Ge", "Is", "IsNot", "Like", "NotLike", "And", "Or",
+	"Not", "BitNot", "Negative", "Positive", "Cast", "CollSeq", "In", "NotIn", "Function", "AggStep", "AggFinal",
+}
+
+func (op opcode) String() string {
+	return opcodeNames[op]
+}
+
+// the SQL operators of the binary and unary opcodes
+var (
+	binaryOpcodes = map[string]opcode{
+		"+": opAdd, "-": opSubtract, "*": opMultiply, "/": opDivide, "%": opRemainder, "||": opConcat,
+		"&": opBitAnd, "|": opBitOr, "<<": opShiftLeft, ">>": opShiftRight,
+		"=": opEq, "!=": opNe, "<": opLt, "<=": opLe, ">": opGt, ">=": opGe,
+		"IS": opIs, "IS NOT": opIsNot, "LIKE": opLike, "NOT LIKE": opNotLike, "AND": opAnd, "OR": opOr,
+	}
+	unaryOpcodes = map[string]opcode{"NOT": opNot, "~": opBitNot, "-": opNegative, "+": opPositive}
+
+	binaryFuncs = make(map[opcode]func(a, b interface{}) (interface{}, error))
+	unaryOps    = make(map[opcode]string)
+	compareOps  = make(map[opcode]string)
+)
+
+func init() {
+	for op, code := range binaryOpcodes {
+		binaryFuncs[code] = binaryOp(op)
+		if compareTests[op] != nil {
+			compareOps[code] = op
+		}
+	}
+	for _, code := range []opcode{opAnd, opOr} {
+		and := code == opAnd
+		binaryFuncs[code] = func(a, b interface{}) (interface{}, error) {
+			return logicValue(and, a, b), nil
+		}
+	}
+	for op, code := range unaryOpcodes {
+		unaryOps[code] = op
+	}
+}
+
+// flags of opInsert and opDelete
+const (
+	// the row is counted in RowsAffected
+	opflagCount = 1 << iota
+	// the rowid is the last insert id
+	opflagLastRowID
+	// the row exists and is replaced
+	opflagReplace
+)
+
+// instr is an instruction of a program
+type instr struct {
+	op      opcode
+	p1      int
+	p2      int
+	p3      int
+	p4      interface{}
+	p5      int
+	comment string
+}
+
+// program is a statement compiled for the VM
+type program struct {
+	instrs   []instr
+	nMem     int
+	nCursor  int
+	columns  []string
+	types    []string
+	counting bool
+	plan     []planNote
+}
+
+// formatP4 returns P4 as EXPLAIN shows it
+func formatP4(p4 interface{}) interface{} {
+	switch p4 := p4.(type) {
+	case nil, int64, float64, string:
+		return p4
+	case int:
+		return int64(p4)
+	case []byte:
+		return fmt.Sprintf("x'%x'", p4)
+	case *tableInfo:
+		return p4.name
+	case *indexRange:
+		return p4.index.name
+	case *aggregate:
+		arg := "1"
+		if p4.star {
+			arg = "*"
+		} else if p4.distinct {
+			arg = "DISTINCT 1"
+		}
+		return p4.name + "(" + arg + ")"
+	case *collation:
+		return "(" + p4.name + ")"
+	case []*collation:
+		names := make([]string, len(p4))
+		for i, coll := range p4 {
+			names[i] = coll.name
+		}
+		return "k(" + strings.Join(names, ",") + ")"
+	case []sortKey:
+		keys := make([]string, len(p4))
+		for i, k := range p4 {
+			keys[i] = "ASC"
+			if k.desc {
+				keys[i] = "DESC"
+			}
+			if k.coll != nil && k.coll != binaryCollation {
+				keys[i] = k.coll.name + " " + keys[i]
+			}
+		}
+		return "k(" + strings.Join(keys, ",") + ")"
+	}
+	return fmt.Sprint(p4)
+}
+
+// explain returns the rows EXPLAIN shows for the program
+func (p *program) explain() [][]interface{} {
+	rows := make([][]interface{}, len(p.instrs))
+	for addr, in := range p.instrs {
+		var comment interface{}
+		if in.comment != "" {
+			comment = in.comment
+		}
+		rows[addr] = []interface{}{int64(addr), in.op.String(), int64(in.p1), int64(in.p2), int64(in.p3),
+			formatP4(in.p4), int64(in.p5), comment}
+	}
+	return rows
+}
+
+// String returns the listing of the program
+func (p *program) String() string {
+	var b strings.Builder
+	for _, row := range p.explain() {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			if v == nil {
+				v = ""
+			}
+			fields[i] = fmt.Sprint(v)
+		}
+		b.WriteString(strings.Join(fields, "\t"))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+var explainColumns = []string{"addr", "opcode", "p1", "p2", "p3", "p4", "p5", "comment"}
+
+// vmCursor is a cursor of a running program, a table cursor moves over
+// the rows of a table visible to the trx, in the order of an index once
+// index is set.
+type vmCursor struct {
+	table   *tableInfo
+	rows    *rowCursor
+	index   *indexCursor
+	row     []interface{}
+	nullRow bool
+
+	keys   []sortKey
+	sorter [][]interface{}
+	pos    int
+
+	set   map[string]bool
+	colls []*collation
+}
+
+// vm runs a program in a trx, it is the operator of the plan so next runs
+// the program until it outputs a row or halts.
+type vm struct {
+	env     *execEnv
+	prog    *program
+	result  *Result
+	pc      int
+	mem     []interface{}
+	cursors []*vmCursor
+	cmp     int
+	coll    *collation
+	halted  bool
+}
+
+func (v *vm) open() error {
+	v.pc = 0
+	v.halted = false
+	v.coll = nil
+	v.mem = make([]interface{}, v.prog.nMem+1)
+	v.cursors = make([]*vmCursor, v.prog.nCursor)
+	return nil
+}
+
+func (v *vm) close() {
+	v.mem = nil
+	v.cursors = nil
+}
+
+// seekFrom moves a table cursor to the first row with rowid >= rowid
+func (v *vm) seekFrom(c *vmCursor, rowid int64) (bool, error) {
+	c.nullRow = false
+	c.row, c.rows = nil, nil
+	if rowid > maxTableRowID {
+		return false, nil
+	}
+	if rowid < 1 {
+		rowid = 1
+	}
+
+	c.rows = v.env.trx.cursor(v.env.db.ctx, c.table.rowID(rowid), c.table.rowID(maxTableRowID)+1)
+	return v.nextRow(c)
+}
+
+// nextRow moves a table cursor to the next row of its rows
+func (v *vm) nextRow(c *vmCursor) (bool, error) {
+	row, ok, err := c.rows.next()
+	if err != nil || !ok {
+		c.row, c.rows = nil, nil
+		return false, err
+	}
+	c.row, err = decodeTableRow(c.table, row.RowID-c.table.id<<tableShift, row.Data)
+	return err == nil, err
+}
+
+// seekBound returns the first rowid r[P3] allows, ok is false for no rowid
+func seekBound(value interface{}, strict bool) (int64, bool) {
+	value = affinityNumeric.apply(value)
+	if typeRank(value) != 1 {
+		return 0, false
+	}
+	f := toFloat(value)
+	if n, isInt := value.(int64); isInt && strict {
+		if n == math.MaxInt64 {
+			return 0, false
+		}
+		return n + 1, true
+	} else if isInt {
+		return n, true
+	}
+	if strict {
+		f = math.Floor(f) + 1
+	} else {
+		f = math.Ceil(f)
+	}
+	if f > maxTableRowID {
+		return 0, false
+	} else if f < 1 {
+		return 1, true
+	}
+	return int64(f), true
+}
+
+// mustBeInt returns v as an integer, integral reals and text of an
+// integer are converted
+func mustBeInt(v interface{}) (int64, error) {
+	switch n := affinityNumeric.apply(v).(type) {
+	case int64:
+		return n, nil
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), nil
+		}
+	}
+	return 0, errors.New("datatype mismatch")
+}
+
+func (v *vm) next() ([]interface{}, bool, error) {
+	if v.halted {
+		return nil, false, nil
+	}
+	row, err := v.run()
+	if err != nil || row == nil {
+		v.halted = true
+		return nil, false, err
+	}
+	return row, true, nil
+}
+
+// run executes the program until it outputs a row or halts
+func (v *vm) run() ([]interface{}, error) {
+	env, mem := v.env, v.mem
+	for {
+		in := &v.prog.instrs[v.pc]
+		v.pc++
+
+		switch in.op {
+		case opInit, opGoto:
+			v.pc = in.p2
+		case opGosub:
+			mem[in.p1] = int64(v.pc)
+			v.pc = in.p2
+		case opReturn:
+			v.pc = int(mem[in.p1].(int64))
+		case opHalt:
+			return nil, nil
+		case opHaltIfNull:
+			if mem[in.p1] == nil {
+				return nil, errors.New(in.p4.(string))
+			}
+
+		case opInteger:
+			mem[in.p2] = int64(in.p1)
+		case opInt64, opReal, opString8, opBlob:
+			mem[in.p2] = in.p4
+		case opVariable:
+			mem[in.p2] = v.env.params[in.p1-1]
+		case opNull:
+			last := in.p3
+			if last < in.p2 {
+				last = in.p2
+			}
+			for i := in.p2; i <= last; i++ {
+				mem[i] = nil
+			}
+		case opSCopy:
+			mem[in.p2] = mem[in.p1]
+		case opCopy:
+			copy(mem[in.p2:in.p2+in.p3+1], mem[in.p1:in.p1+in.p3+1])
+
+		case opOpenRead, opOpenWrite:
+			v.cursors[in.p1] = &vmCursor{table: in.p4.(*tableInfo)}
+		case opOpenEphemeral:
+			colls, _ := in.p4.([]*collation)
+			v.cursors[in.p1] = &vmCursor{set: make(map[string]bool), colls: colls}
+		case opSorterOpen:
+			v.cursors[in.p1] = &vmCursor{keys: in.p4.([]sortKey)}
+		case opRewind:
+			ok, err := v.seekFrom(v.cursors[in.p1], 1)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				v.pc = in.p2
+			}
+		case opNext:
+			c := v.cursors[in.p1]
+			if c.row == nil {
+				break
+			}
+			var ok bool
+			var err error
+			switch {
+			case c.index != nil:
+				c.row, ok, err = c.index.next()
+			case c.rows != nil:
+				ok, err = v.nextRow(c)
+			default:
+				ok, err = v.seekFrom(c, c.row[0].(int64)+1)
+			}
+			if err != nil {
+				return nil, err
+			} else if ok {
+				v.pc = in.p2
+			}
+		case opSeekRowid:
+			c := v.cursors[in.p1]
+			c.row, c.rows, c.nullRow = nil, nil, false
+			rowid, ok := toRowID(mem[in.p3])
+			if ok {
+				row, found, err := env.trx.Get(env.db.ctx, c.table.rowID(rowid))
+				if err != nil {
+					return nil, err
+				}
+				if ok = found; ok {
+					if c.row, err = decodeTableRow(c.table, rowid, row.Data); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if !ok {
+				v.pc = in.p2
+			}
+		case opSeekGE, opSeekGT:
+			c := v.cursors[in.p1]
+			rowid, ok := seekBound(mem[in.p3], in.op == opSeekGT)
+			if ok {
+				var err error
+				if ok, err = v.seekFrom(c, rowid); err != nil {
+					return nil, err
+				}
+			} else {
+				c.row, c.rows, c.nullRow = nil, nil, false
+			}
+			if !ok {
+				v.pc = in.p2
+			}
+		case opIdxScan:
+			c := v.cursors[in.p1]
+			rng := in.p4.(*indexRange)
+			n := rng.eq
+			if rng.loOp != "" {
+				n++
+			}
+			if rng.hiOp != "" {
+				n++
+			}
+			c.index = env.seekIndex(c.table, rng, mem[in.p3:in.p3+n])
+			c.nullRow = false
+			var ok bool
+			var err error
+			if c.row, ok, err = c.index.next(); err != nil {
+				return nil, err
+			} else if !ok {
+				v.pc = in.p2
+			}
+		case opNullRow:
+			c := v.cursors[in.p1]
+			c.row, c.nullRow = nil, true
+		case opColumn:
+			c := v.cursors[in.p1]
+			switch {
+			case c.row == nil:
+				mem[in.p3] = nil
+			case c.table != nil:
+				mem[in.p3] = c.row[in.p2+1]
+			default:
+				mem[in.p3] = c.row[in.p2]
+			}
+		case opRowid:
+			if c := v.cursors[in.p1]; c.row != nil {
+				mem[in.p2] = c.row[0]
+			} else {
+				mem[in.p2] = nil
+			}
+		case opResultRow:
+			return append([]interface{}(nil), mem[in.p1:in.p1+in.p2]...), nil
+		case opMakeRecord:
+			values := mem[in.p1 : in.p1+in.p2]
+			if affs, ok := in.p4.(string); ok {
+				values = make([]interface{}, in.p2)
+				for i := range values {
+					values[i] = affinity(affs[i]).apply(mem[in.p1+i])
+				}
+			}
+			data, err := EncodeRecord(values)
+			if err != nil {
+				return nil, err
+			}
+			mem[in.p3] = data
+		case opNewRowid:
+			rowid, err := env.newRowID(v.cursors[in.p1].table)
+			if err != nil {
+				return nil, err
+			}
+			mem[in.p2] = rowid
+		case opInsert:
+			c := v.cursors[in.p1]
+			rowid := mem[in.p3].(int64)
+			var err error
+			if in.p5&opflagReplace != 0 {
+				err = env.writeRow(c.table, rowid, mem[in.p2].([]byte), ChangeUpdate)
+			} else {
+				err = env.insertRecord(c.table, rowid, mem[in.p2].([]byte))
+			}
+			if err != nil {
+				return nil, err
+			}
+			v.count(in.p5, rowid)
+		case opDelete:
+			c := v.cursors[in.p1]
+			if err := env.writeRow(c.table, c.row[0].(int64), nil, ChangeDelete); err != nil {
+				return nil, err
+			}
+			v.count(in.p5, 0)
+		case opMustBeInt:
+			n, err := mustBeInt(mem[in.p1])
+			if err != nil {
+				return nil, err
+			}
+			mem[in.p1] = n
+
+		case opRowSetAdd:
+			set, _ := mem[in.p1].([]int64)
+			mem[in.p1] = append(set, mem[in.p2].(int64))
+		case opRowSetRead:
+			set, _ := mem[in.p1].([]int64)
+			if len(set) == 0 {
+				v.pc = in.p2
+				break
+			}
+			mem[in.p3] = set[0]
+			mem[in.p1] = set[1:]
+		case opSorterInsert:
+			c := v.cursors[in.p1]
+			c.sorter = append(c.sorter, append([]interface{}(nil), mem[in.p2:in.p2+in.p3]...))
+		case opSorterSort:
+			c := v.cursors[in.p1]
+			sort.SliceStable(c.sorter, func(i, j int) bool {
+				return compareRows(c.sorter[i], c.sorter[j], c.keys) < 0
+			})
+			c.pos, c.row = 0, nil
+			if len(c.sorter) == 0 {
+				v.pc = in.p2
+			} else {
+				c.row = c.sorter[0]
+			}
+		case opSorterNext:
+			c := v.cursors[in.p1]
+			c.pos++
+			c.row = nil
+			if c.pos < len(c.sorter) {
+				c.row = c.sorter[c.pos]
+				v.pc = in.p2
+			}
+		case opFound:
+			c := v.cursors[in.p1]
+			key, err := groupKey(collate(mem[in.p3:in.p3+in.p4.(int)], c.colls))
+			if err != nil {
+				return nil, err
+			}
+			if c.set[key] {
+				v.pc = in.p2
+			}
+		case opIdxInsert:
+			c := v.cursors[in.p1]
+			key, err := groupKey(collate(mem[in.p2:in.p2+in.p3], c.colls))
+			if err != nil {
+				return nil, err
+			}
+			c.set[key] = true
+
+		case opIf, opIfNot:
+			if truth(mem[in.p1]) == (in.op == opIf) {
+				v.pc = in.p2
+			}
+		case opIfPos:
+			if n, _ := mem[in.p1].(int64); n > 0 {
+				mem[in.p1] = n - int64(in.p3)
+				v.pc = in.p2
+			}
+		case opDecrJumpZero:
+			n := mem[in.p1].(int64) - 1
+			mem[in.p1] = n
+			if n == 0 {
+				v.pc = in.p2
+			}
+		case opNotNull:
+			if mem[in.p1] != nil {
+				v.pc = in.p2
+			}
+		case opCompare:
+			colls, _ := in.p4.([]*collation)
+			v.cmp = 0
+			for i := 0; i < in.p3 && v.cmp == 0; i++ {
+				var coll *collation
+				if colls != nil {
+					coll = colls[i]
+				}
+				v.cmp = coll.compare(mem[in.p1+i], mem[in.p2+i])
+			}
+		case opJump:
+			switch {
+			case v.cmp < 0:
+				v.pc = in.p1
+			case v.cmp == 0:
+				v.pc = in.p2
+			default:
+				v.pc = in.p3
+			}
+
+		case opEq, opNe, opLt, opLe, opGt, opGe, opIs, opIsNot:
+			coll, _ := in.p4.(*collation)
+			mem[in.p3] = compareValue(compareOps[in.op], mem[in.p1], mem[in.p2], affinity(in.p5), coll)
+		case opCast:
+			mem[in.p1] = castValue(mem[in.p1], in.p4.(string))
+		case opCollSeq:
+			v.coll = in.p4.(*collation)
+		case opIn, opNotIn:
+			mem[in.p3] = inValue(mem[in.p1], mem[in.p2:in.p2+in.p4.(int)], in.op == opNotIn, affinity(in.p5), v.coll)
+			v.coll = nil
+		case opFunction:
+			fn := scalarFuncs[strings.ToLower(in.p4.(string))]
+			value, err := fn.call(append([]interface{}(nil), mem[in.p1:in.p1+in.p2]...))
+			if err != nil {
+				return nil, err
+			}
+			mem[in.p3] = value
+		case opAggStep:
+			agg := in.p4.(*aggregate)
+			s, _ := mem[in.p2].(*aggState)
+			if s == nil {
+				s = newAggState(agg)
+				mem[in.p2] = s
+			}
+			if agg.star {
+				s.count++
+			} else if err := s.step(mem[in.p1]); err != nil {
+				return nil, err
+			}
+		case opAggFinal:
+			s, _ := mem[in.p1].(*aggState)
+			if s == nil {
+				s = newAggState(in.p4.(*aggregate))
+			}
+			mem[in.p1] = s.result()
+
+		default:
+			if fn := binaryFuncs[in.op]; fn != nil {
+				value, err := fn(mem[in.p1], mem[in.p2])
+				if err != nil {
+					return nil, err
+				}
+				mem[in.p3] = value
+			} else if op, ok := unaryOps[in.op]; ok {
+				mem[in.p2] = unaryValue(op, mem[in.p1])
+			} else {
+				return nil, fmt.Errorf("bad opcode %s at %d", in.op, v.pc-1)
+			}
+		}
+	}
+}
+
+// count records a row changed by opInsert or opDelete
+func (v *vm) count(flags int, rowid int64) {
+	if flags&opflagCount != 0 {
+		v.result.RowsAffected++
+	}
+	if flags&opflagLastRowID != 0 {
+		v.result.LastInsertID = rowid
+	}
+}
+
+// compareRows compares two rows by keys
+func compareRows(a, b []interface{}, keys []sortKey) int {
+	for _, k := range keys {
+		if c := k.coll.compare(a[k.column], b[k.column]); c != 0 {
+			if k.desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
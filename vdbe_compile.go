@@ -0,0 +1,1204 @@
+package gosqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"gosqlite/sql"
+)
+
+// codegen compiles a statement into a program, jumps to labels made by
+// newLabel are resolved when the program is finished.
+type codegen struct {
+	b       *planner
+	instrs  []instr
+	labels  []int
+	nMem    int
+	nCursor int
+	plan    []planNote
+	// parent is the id of the plan note of the subquery being compiled
+	parent int
+}
+
+// compile to compile stmt into a program of the VM
+func (b *planner) compile(stmt sql.Stmt, text string) (*program, error) {
+	g := &codegen{b: b}
+	g.emit(opInit, 0, 1, 0)
+
+	columns, types := []string{}, []string{}
+	var err error
+	switch stmt := stmt.(type) {
+	case *sql.SelectStmt:
+		columns, types, err = g.selectStmt(stmt)
+	case *sql.InsertStmt:
+		err = g.insertStmt(stmt)
+	case *sql.UpdateStmt:
+		err = g.updateStmt(stmt)
+	case *sql.DeleteStmt:
+		err = g.deleteStmt(stmt)
+	case *sql.CreateTableStmt:
+		err = g.createTable(stmt, text)
+	case *sql.DropStmt:
+		if stmt.Index {
+			err = g.dropIndex(stmt)
+		} else {
+			err = g.dropTable(stmt)
+		}
+	case *sql.CreateIndexStmt:
+		err = g.createIndex(stmt, text)
+	default:
+		return nil, fmt.Errorf("unsupported statement %T", stmt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	g.emit(opHalt, 0, 0, 0)
+	prog := g.finish(columns)
+	prog.types = types
+	return prog, nil
+}
+
+func (g *codegen) emit(op opcode, p1, p2, p3 int) *instr {
+	g.instrs = append(g.instrs, instr{op: op, p1: p1, p2: p2, p3: p3})
+	return &g.instrs[len(g.instrs)-1]
+}
+
+func (g *codegen) emit4(op opcode, p1, p2, p3 int, p4 interface{}) *instr {
+	in := g.emit(op, p1, p2, p3)
+	in.p4 = p4
+	return in
+}
+
+// reg allocates n registers, returns the first
+func (g *codegen) reg(n int) int {
+	first := g.nMem + 1
+	g.nMem += n
+	return first
+}
+
+func (g *codegen) cursor() int {
+	g.nCursor++
+	return g.nCursor - 1
+}
+
+// newLabel returns a label to jump to, it is negative until resolved
+func (g *codegen) newLabel() int {
+	g.labels = append(g.labels, -1)
+	return -len(g.labels)
+}
+
+// resolve to place label at the next instruction
+func (g *codegen) resolve(label int) {
+	g.labels[-label-1] = len(g.instrs)
+}
+
+func (g *codegen) finish(columns []string) *program {
+	addr := func(p int) int {
+		if p < 0 {
+			return g.labels[-p-1]
+		}
+		return p
+	}
+	for i := range g.instrs {
+		in := &g.instrs[i]
+		in.p2 = addr(in.p2)
+		if in.op == opJump {
+			in.p1, in.p3 = addr(in.p1), addr(in.p3)
+		}
+	}
+	return &program{instrs: g.instrs, nMem: g.nMem, nCursor: g.nCursor, columns: columns, plan: g.plan}
+}
+
+// exprEnv tells the codegen how to load the columns of the scope of an
+// expression, aggs are the registers of the aggregate results.
+type exprEnv struct {
+	scope  *scope
+	column func(i int, dest int)
+	aggs   map[*sql.FuncCall]int
+}
+
+var constEnv = &exprEnv{scope: &scope{}}
+
+// loadValue loads a constant into dest
+func (g *codegen) loadValue(v interface{}, dest int) {
+	switch v := v.(type) {
+	case nil:
+		g.emit(opNull, 0, dest, 0)
+	case int64:
+		if v == int64(int32(v)) {
+			g.emit(opInteger, int(v), dest, 0)
+		} else {
+			g.emit4(opInt64, 0, dest, 0, v)
+		}
+	case float64:
+		g.emit4(opReal, 0, dest, 0, v)
+	case string:
+		g.emit4(opString8, 0, dest, 0, v)
+	case []byte:
+		g.emit4(opBlob, 0, dest, 0, v)
+	}
+}
+
+// expr emits the code storing the value of e into dest
+func (g *codegen) expr(e sql.Expr, env *exprEnv, dest int) error {
+	switch e := e.(type) {
+	case *sql.Literal:
+		v, err := literalValue(e)
+		if err != nil {
+			return err
+		}
+		g.loadValue(v, dest)
+	case *sql.Param:
+		g.emit(opVariable, e.Index, dest, 0)
+	case *sql.ColumnRef:
+		i, err := env.scope.resolve(e)
+		if err != nil {
+			return err
+		}
+		env.column(i, dest)
+	case *sql.UnaryExpr:
+		if err := g.expr(e.X, env, dest); err != nil {
+			return err
+		}
+		g.emit(unaryOpcodes[e.Op], dest, dest, 0)
+	case *sql.BinaryExpr:
+		op, ok := binaryOpcodes[e.Op]
+		if !ok {
+			return fmt.Errorf("unsupported operator %s", e.Op)
+		}
+		x := g.reg(2)
+		if err := g.expr(e.X, env, x); err != nil {
+			return err
+		}
+		if err := g.expr(e.Y, env, x+1); err != nil {
+			return err
+		}
+		in := g.emit(op, x, x+1, dest)
+		if compareTests[e.Op] != nil {
+			aff, coll, err := comparison(e.X, e.Y, env.scope)
+			if err != nil {
+				return err
+			}
+			in.p4, in.p5 = collationP4(coll), int(aff)
+		}
+	case *sql.IsNullExpr:
+		x := g.reg(2)
+		if err := g.expr(e.X, env, x); err != nil {
+			return err
+		}
+		g.emit(opNull, 0, x+1, 0)
+		op := opIs
+		if e.Not {
+			op = opIsNot
+		}
+		g.emit(op, x, x+1, dest)
+	case *sql.InExpr:
+		x := g.reg(1 + len(e.List))
+		if err := g.expr(e.X, env, x); err != nil {
+			return err
+		}
+		for i, item := range e.List {
+			if err := g.expr(item, env, x+1+i); err != nil {
+				return err
+			}
+		}
+		aff, coll, err := inComparison(e, env.scope)
+		if err != nil {
+			return err
+		}
+		if p4 := collationP4(coll); p4 != nil {
+			g.emit4(opCollSeq, 0, 0, 0, p4)
+		}
+		op := opIn
+		if e.Not {
+			op = opNotIn
+		}
+		g.emit4(op, x, x+1, dest, len(e.List)).p5 = int(aff)
+	case *sql.BetweenExpr:
+		return g.expr(betweenExpr(e), env, dest)
+	case *sql.CollateExpr:
+		if _, err := lookupCollation(e.Collation); err != nil {
+			return err
+		}
+		return g.expr(e.X, env, dest)
+	case *sql.CastExpr:
+		if err := g.expr(e.X, env, dest); err != nil {
+			return err
+		}
+		g.emit4(opCast, dest, 0, 0, e.Type)
+	case *sql.FuncCall:
+		if isAggregate(e) {
+			r, ok := env.aggs[e]
+			if !ok {
+				return fmt.Errorf("misuse of aggregate: %s()", e.Name)
+			}
+			g.emit(opSCopy, r, dest, 0)
+			return nil
+		}
+		if _, err := lookupFunc(e); err != nil {
+			return err
+		}
+		args := g.reg(len(e.Args))
+		for i, arg := range e.Args {
+			if err := g.expr(arg, env, args+i); err != nil {
+				return err
+			}
+		}
+		g.emit4(opFunction, args, len(e.Args), dest, e.Name)
+	case *sql.SubqueryExpr:
+		return g.subquery(e.Select, false, dest)
+	case *sql.ExistsExpr:
+		return g.subquery(e.Select, true, dest)
+	default:
+		return fmt.Errorf("unsupported expression %T", e)
+	}
+	return nil
+}
+
+// subquery emits a subquery which runs to its first row each time it is
+// reached, dest is set to whether it returns a row when exists is set,
+// else to the single column of the row or NULL. The subquery reads its
+// own tables only, a column of the outer query is not found.
+func (g *codegen) subquery(stmt *sql.SelectStmt, exists bool, dest int) error {
+	parent := g.parent
+	defer func() { g.parent = parent }()
+	g.parent = g.explainPlan("SCALAR SUBQUERY")
+	if exists {
+		g.emit(opInteger, 0, dest, 0)
+	} else {
+		g.emit(opNull, 0, dest, 0)
+	}
+	_, _, err := g.selectInto(stmt, &output{into: dest, exists: exists})
+	return err
+}
+
+// collationP4 returns coll as P4 of a comparison, nil for BINARY
+func collationP4(coll *collation) interface{} {
+	if coll == nil || coll == binaryCollation {
+		return nil
+	}
+	return coll
+}
+
+// keyColls returns the collations of keys as P4, nil when all are BINARY
+func keyColls(colls []*collation) interface{} {
+	for _, coll := range colls {
+		if collationP4(coll) != nil {
+			return colls
+		}
+	}
+	return nil
+}
+
+// cond emits a jump to label unless e is true
+func (g *codegen) cond(e sql.Expr, env *exprEnv, label int) error {
+	r := g.reg(1)
+	if err := g.expr(e, env, r); err != nil {
+		return err
+	}
+	g.emit(opIfNot, r, label, 0)
+	return nil
+}
+
+// level is a table of FROM read by a loop of the program by its access
+// path, used tells which columns the statement reads, nil for all.
+type level struct {
+	info   *tableInfo
+	alias  string
+	cursor int
+	left   bool
+	on     sql.Expr
+	offset int
+	used   []bool
+
+	accessPath
+	body, next, end int
+	inner, match    int
+}
+
+// levels returns the tables of a FROM source in join order
+func (g *codegen) levels(src sql.Source, levels []*level) ([]*level, error) {
+	switch src := src.(type) {
+	case nil:
+		return levels, nil
+	case *sql.TableRef:
+		info, err := g.b.table(src.Name)
+		if err != nil {
+			return nil, err
+		}
+		return append(levels, &level{info: info, alias: src.Alias}), nil
+	case *sql.Join:
+		levels, err := g.levels(src.Left, levels)
+		if err != nil {
+			return nil, err
+		}
+		right, ok := src.Right.(*sql.TableRef)
+		if !ok {
+			return nil, fmt.Errorf("unsupported join of %T", src.Right)
+		}
+		if levels, err = g.levels(right, levels); err != nil {
+			return nil, err
+		}
+		l := levels[len(levels)-1]
+		l.left, l.on = src.Kind == sql.LeftJoin, src.On
+		return levels, nil
+	}
+	return nil, fmt.Errorf("unsupported source %T", src)
+}
+
+// loops reads the rows of the levels with nested loops, the body emitted
+// between openLoops and closeLoops runs for every joined row. levels are
+// in the order of the loops and the scope in the order of FROM, terms are
+// those of WHERE and of the ON of inner joins.
+type loops struct {
+	levels []*level
+	scope  *scope
+	env    *exprEnv
+	terms  []sql.Expr
+	// next is where the body continues with the next row
+	next int
+}
+
+// tableScope returns the scope of the rows of level l
+func (lp *loops) tableScope(l *level) *scope {
+	return &scope{columns: lp.scope.columns[l.offset : l.offset+1+len(l.info.columns)]}
+}
+
+// openLoops opens the cursors of the tables and emits the loop heads in
+// the order of the least cost, a table is read by its cheapest path.
+func (g *codegen) openLoops(levels []*level, where sql.Expr, write bool) (*loops, error) {
+	lp := &loops{levels: levels, scope: &scope{}, terms: conjuncts(where)}
+	for _, l := range levels {
+		l.offset = len(lp.scope.columns)
+		lp.scope = lp.scope.concat(tableScope(l.info, l.alias))
+		if !l.left {
+			lp.terms = append(lp.terms, conjuncts(l.on)...)
+		}
+	}
+	lp.env = &exprEnv{scope: lp.scope, column: func(i int, dest int) {
+		for _, l := range levels {
+			if j := i - l.offset; j >= 0 && j <= len(l.info.columns) {
+				name := lp.scope.columns[i].table + "."
+				if j == 0 {
+					g.emit(opRowid, l.cursor, dest, 0).comment = name + "rowid"
+				} else {
+					g.emit(opColumn, l.cursor, j-1, dest).comment = name + l.info.columns[j-1].name
+				}
+				return
+			}
+		}
+	}}
+
+	op := opOpenRead
+	if write {
+		op = opOpenWrite
+	}
+	for _, l := range levels {
+		l.cursor = g.cursor()
+		g.emit4(op, l.cursor, int(l.info.id), 0, l.info)
+	}
+
+	g.joinOrder(lp)
+	outer := &scope{}
+	for _, l := range lp.levels {
+		l.accessPath = *cheapest(g.loopPaths(lp, l, outer))
+		outer = outer.concat(lp.tableScope(l))
+		l.body, l.next, l.end, l.inner = g.newLabel(), g.newLabel(), g.newLabel(), g.newLabel()
+		if l.left {
+			l.match = g.reg(1)
+			g.emit(opInteger, 0, l.match, 0)
+		}
+
+		var hi int
+		if l.hi != nil {
+			hi = g.reg(1)
+			if err := g.expr(l.hi, lp.env, hi); err != nil {
+				return nil, err
+			}
+		}
+		name := l.alias
+		if name == "" {
+			name = l.info.name
+		}
+		g.explainPlan(pathDetail(l.info, name, &l.accessPath))
+		switch {
+		case len(l.keys) > 0:
+			r := g.reg(1)
+			if err := g.expr(l.keys[0], lp.env, r); err != nil {
+				return nil, err
+			}
+			g.emit(opSeekRowid, l.cursor, l.end, r)
+		case l.index != nil:
+			r := g.reg(len(l.values))
+			for i, e := range l.values {
+				if err := g.expr(e, lp.env, r+i); err != nil {
+					return nil, err
+				}
+			}
+			g.emit4(opIdxScan, l.cursor, l.end, r, l.index)
+		case l.lo != nil:
+			r := g.reg(1)
+			if err := g.expr(l.lo, lp.env, r); err != nil {
+				return nil, err
+			}
+			seek := opSeekGE
+			if l.loOp == ">" {
+				seek = opSeekGT
+			}
+			g.emit(seek, l.cursor, l.end, r)
+		default:
+			g.emit(opRewind, l.cursor, l.end, 0)
+		}
+
+		g.resolve(l.body)
+		if l.hi != nil {
+			r := g.reg(2)
+			g.emit(opRowid, l.cursor, r, 0)
+			past := opGt
+			if l.hiOp == "<" {
+				past = opGe
+			}
+			g.emit(past, r, hi, r+1).p5 = int(affinityNumeric)
+			g.emit(opIf, r+1, l.end, 0)
+		}
+		if l.on != nil {
+			if err := g.cond(l.on, lp.env, l.next); err != nil {
+				return nil, err
+			}
+		}
+		if l.left {
+			g.emit(opInteger, 1, l.match, 0)
+		}
+		g.resolve(l.inner)
+	}
+
+	if len(levels) > 0 {
+		lp.next = lp.levels[len(levels)-1].next
+	} else {
+		lp.next = g.newLabel()
+	}
+	return lp, nil
+}
+
+// closeLoops emits the loop tails, a LEFT JOIN table without a matching
+// row runs the inner loops once more with a row of NULLs.
+func (g *codegen) closeLoops(lp *loops) {
+	if len(lp.levels) == 0 {
+		g.resolve(lp.next)
+		return
+	}
+	for i := len(lp.levels) - 1; i >= 0; i-- {
+		l := lp.levels[i]
+		g.resolve(l.next)
+		if len(l.keys) == 0 {
+			g.emit(opNext, l.cursor, l.body, 0)
+		}
+		g.resolve(l.end)
+		if l.left {
+			done := g.newLabel()
+			g.emit(opIf, l.match, done, 0)
+			g.emit(opNullRow, l.cursor, 0, 0)
+			g.emit(opInteger, 1, l.match, 0)
+			g.emit(opGoto, 0, l.inner, 0)
+			g.resolve(done)
+		}
+	}
+}
+
+// resultItem is a result column, a column of the scope for *
+type resultItem struct {
+	expr   sql.Expr
+	column int
+}
+
+// output emits the rows of a SELECT after WHERE and grouping: DISTINCT,
+// ORDER BY through a sorter, OFFSET and LIMIT.
+type output struct {
+	items    []resultItem
+	keys     []*sql.OrderingTerm
+	keyItems []int
+	distinct int
+	sorter   int
+	limit    int
+	offset   int
+	halt     int
+	// into is the register a subquery stores its first row in, 0 for a
+	// query whose rows are the result, exists stores 1 instead.
+	into   int
+	exists bool
+}
+
+// row emits the code computing and passing on a result row, skip is
+// where to continue when the row is left out.
+func (g *codegen) row(o *output, env *exprEnv, skip int) error {
+	nKeys := len(o.keys)
+	block := g.reg(nKeys + len(o.items))
+	res := block + nKeys
+	for i, item := range o.items {
+		if item.expr == nil {
+			env.column(item.column, res+i)
+		} else if err := g.expr(item.expr, env, res+i); err != nil {
+			return err
+		}
+	}
+	for k, term := range o.keys {
+		if i := o.keyItems[k]; i >= 0 {
+			g.emit(opSCopy, res+i, block+k, 0)
+		} else if err := g.expr(term.Expr, env, block+k); err != nil {
+			return err
+		}
+	}
+
+	if o.distinct >= 0 {
+		g.explainPlan("USE TEMP B-TREE FOR DISTINCT")
+		g.emit4(opFound, o.distinct, skip, res, len(o.items))
+		g.emit(opIdxInsert, o.distinct, res, len(o.items))
+	}
+	if o.sorter >= 0 {
+		g.emit(opSorterInsert, o.sorter, block, nKeys+len(o.items))
+		return nil
+	}
+	g.result(o, res, skip)
+	return nil
+}
+
+// result emits OFFSET, the output of the row in res and LIMIT
+func (g *codegen) result(o *output, res int, skip int) {
+	if o.offset > 0 {
+		g.emit(opIfPos, o.offset, skip, 1)
+	}
+	if o.into > 0 {
+		if o.exists {
+			g.emit(opInteger, 1, o.into, 0)
+		} else {
+			g.emit(opSCopy, res, o.into, 0)
+		}
+		g.emit(opGoto, 0, o.halt, 0)
+		return
+	}
+	g.emit(opResultRow, res, len(o.items), 0)
+	if o.limit > 0 {
+		g.emit(opDecrJumpZero, o.limit, o.halt, 0)
+	}
+}
+
+func (g *codegen) selectStmt(stmt *sql.SelectStmt) ([]string, []string, error) {
+	return g.selectInto(stmt, &output{})
+}
+
+// selectInto emits a SELECT whose rows go to o, the result rows unless
+// o is the output of a subquery
+func (g *codegen) selectInto(stmt *sql.SelectStmt, o *output) ([]string, []string, error) {
+	levels, err := g.levels(stmt.From, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grouped := len(stmt.GroupBy) > 0 || hasAggregate(stmt.Having)
+	for _, rc := range stmt.Columns {
+		grouped = grouped || hasAggregate(rc.Expr)
+	}
+	for _, term := range stmt.OrderBy {
+		grouped = grouped || hasAggregate(term.Expr)
+	}
+
+	o.distinct, o.sorter, o.halt, o.keys = -1, -1, g.newLabel(), stmt.OrderBy
+	columns, types := make([]string, 0), make([]string, 0)
+	s := &scope{}
+	for _, l := range levels {
+		s = s.concat(tableScope(l.info, l.alias))
+	}
+	used := usedColumns(stmt, s)
+	for _, l := range levels {
+		l.used, used = used[:1+len(l.info.columns)], used[1+len(l.info.columns):]
+	}
+	for _, rc := range stmt.Columns {
+		if !rc.Star {
+			o.items = append(o.items, resultItem{expr: rc.Expr})
+			columns = append(columns, columnName(rc))
+			types = append(types, columnType(s, rc))
+			continue
+		}
+
+		n := len(o.items)
+		for i, col := range s.columns {
+			if !col.hidden && (rc.Table == "" || strings.EqualFold(rc.Table, col.table)) {
+				o.items = append(o.items, resultItem{column: i})
+				columns = append(columns, col.name)
+				types = append(types, col.typ)
+			}
+		}
+		if n == len(o.items) {
+			if rc.Table != "" {
+				return nil, nil, fmt.Errorf("no such table: %s", rc.Table)
+			}
+			return nil, nil, fmt.Errorf("no tables specified")
+		}
+	}
+	if o.into > 0 && !o.exists && len(o.items) != 1 {
+		return nil, nil, fmt.Errorf("sub-select returns %d columns - expected 1", len(o.items))
+	}
+	colls := make([]*collation, len(o.items))
+	for i, item := range o.items {
+		if item.expr == nil {
+			colls[i] = s.columns[item.column].coll
+		} else if colls[i], _, err = exprCollation(item.expr, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	keys := make([]sortKey, len(o.keys))
+	for k, term := range stmt.OrderBy {
+		i, err := orderColumn(stmt, term.Expr, len(o.items))
+		if err != nil {
+			return nil, nil, err
+		}
+		o.keyItems = append(o.keyItems, i)
+		keys[k] = sortKey{column: k, desc: term.Desc}
+		if keys[k].coll, err = orderCollation(term.Expr, s, colls, i); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if stmt.Limit != nil {
+		o.limit = g.reg(1)
+		if err = g.expr(stmt.Limit, constEnv, o.limit); err != nil {
+			return nil, nil, err
+		}
+		g.emit(opMustBeInt, o.limit, 0, 0)
+		g.emit(opIfNot, o.limit, o.halt, 0)
+		if stmt.Offset != nil {
+			o.offset = g.reg(1)
+			if err = g.expr(stmt.Offset, constEnv, o.offset); err != nil {
+				return nil, nil, err
+			}
+			g.emit(opMustBeInt, o.offset, 0, 0)
+		}
+	}
+	if len(o.keys) > 0 {
+		o.sorter = g.cursor()
+		g.emit4(opSorterOpen, o.sorter, len(o.keys), 0, keys)
+	}
+	if stmt.Distinct {
+		o.distinct = g.cursor()
+		g.emit4(opOpenEphemeral, o.distinct, len(o.items), 0, keyColls(colls))
+	}
+
+	if grouped {
+		err = g.groupedSelect(stmt, levels, s, o)
+	} else {
+		var lp *loops
+		if lp, err = g.openLoops(levels, stmt.Where, false); err != nil {
+			return nil, nil, err
+		}
+		if stmt.Where != nil {
+			if err = g.cond(stmt.Where, lp.env, lp.next); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = g.row(o, lp.env, lp.next); err != nil {
+			return nil, nil, err
+		}
+		g.closeLoops(lp)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if o.sorter >= 0 {
+		loop, next := g.newLabel(), g.newLabel()
+		g.explainPlan("USE TEMP B-TREE FOR ORDER BY")
+		g.emit(opSorterSort, o.sorter, o.halt, 0)
+		g.resolve(loop)
+		res := g.reg(len(o.items))
+		for i := range o.items {
+			g.emit(opColumn, o.sorter, len(o.keys)+i, res+i)
+		}
+		g.result(o, res, next)
+		g.resolve(next)
+		g.emit(opSorterNext, o.sorter, loop, 0)
+	}
+	g.resolve(o.halt)
+	return columns, types, nil
+}
+
+// groupedSelect emits an aggregate query, the rows are sorted by the
+// GROUP BY keys and a group ends when the keys change. The columns of the
+// last row of a group are kept in registers for the bare columns. s is
+// the scope of the rows of FROM.
+func (g *codegen) groupedSelect(stmt *sql.SelectStmt, levels []*level, s *scope, o *output) error {
+	nk := len(stmt.GroupBy)
+	sorter := -1
+	keys, colls := make([]sortKey, nk), make([]*collation, nk)
+	for k, e := range stmt.GroupBy {
+		var err error
+		if colls[k], _, err = exprCollation(e, s); err != nil {
+			return err
+		}
+		keys[k] = sortKey{column: k, coll: colls[k]}
+	}
+	if nk > 0 {
+		sorter = g.cursor()
+		g.emit4(opSorterOpen, sorter, nk, 0, keys)
+	}
+
+	lp, err := g.openLoops(levels, stmt.Where, false)
+	if err != nil {
+		return err
+	}
+	width := len(lp.scope.columns)
+	row := g.reg(width)
+	rowEnv := &exprEnv{scope: lp.scope, column: func(i int, dest int) {
+		g.emit(opSCopy, row+i, dest, 0)
+	}}
+	if stmt.Where != nil {
+		if err = g.cond(stmt.Where, lp.env, lp.next); err != nil {
+			return err
+		}
+	}
+
+	// the aggregates of the result, HAVING and ORDER BY
+	calls := make([]*sql.FuncCall, 0)
+	collect := func(e sql.Expr) {
+		walkExpr(e, func(e sql.Expr) {
+			if f, ok := e.(*sql.FuncCall); ok && isAggregate(f) {
+				calls = append(calls, f)
+			}
+		})
+	}
+	for _, item := range o.items {
+		collect(item.expr)
+	}
+	collect(stmt.Having)
+	for _, term := range stmt.OrderBy {
+		collect(term.Expr)
+	}
+	aggs := make([]*aggregate, len(calls))
+	for i, call := range calls {
+		if aggs[i], err = newAggregate(call, lp.scope); err != nil {
+			return err
+		}
+	}
+	acc := g.reg(len(calls))
+	resultEnv := &exprEnv{scope: lp.scope, column: rowEnv.column, aggs: make(map[*sql.FuncCall]int)}
+	for i, call := range calls {
+		resultEnv.aggs[call] = acc + i
+	}
+	step := func() error {
+		for i, call := range calls {
+			arg := 0
+			if !call.Star {
+				arg = g.reg(1)
+				if err := g.expr(call.Args[0], rowEnv, arg); err != nil {
+					return err
+				}
+			}
+			g.emit4(opAggStep, arg, acc+i, 0, aggs[i])
+		}
+		return nil
+	}
+	final := func(skip int) error {
+		for i := range calls {
+			g.emit4(opAggFinal, acc+i, 0, 0, aggs[i])
+		}
+		if stmt.Having != nil {
+			if err := g.cond(stmt.Having, resultEnv, skip); err != nil {
+				return err
+			}
+		}
+		return g.row(o, resultEnv, skip)
+	}
+
+	if nk == 0 {
+		for i := 0; i < width; i++ {
+			lp.env.column(i, row+i)
+		}
+		if err = step(); err != nil {
+			return err
+		}
+		g.closeLoops(lp)
+		return final(o.halt)
+	}
+
+	block := g.reg(nk + width)
+	for k, e := range stmt.GroupBy {
+		if err = g.expr(e, lp.env, block+k); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < width; i++ {
+		lp.env.column(i, block+nk+i)
+	}
+	g.emit(opSorterInsert, sorter, block, nk+width)
+	g.closeLoops(lp)
+
+	cur, prev, has, ret := g.reg(nk), g.reg(nk), g.reg(1), g.reg(1)
+	loop, reset, same, out, finish, done := g.newLabel(), g.newLabel(), g.newLabel(), g.newLabel(), g.newLabel(), g.newLabel()
+	g.emit(opInteger, 0, has, 0)
+	g.explainPlan("USE TEMP B-TREE FOR GROUP BY")
+	g.emit(opSorterSort, sorter, finish, 0)
+	g.resolve(loop)
+	for k := 0; k < nk; k++ {
+		g.emit(opColumn, sorter, k, cur+k)
+	}
+	g.emit(opIfNot, has, reset, 0)
+	g.emit4(opCompare, prev, cur, nk, keyColls(colls))
+	next := g.newLabel()
+	g.emit(opJump, next, same, next)
+	g.resolve(next)
+	g.emit(opGosub, ret, out, 0)
+	g.resolve(reset)
+	g.emit(opCopy, cur, prev, nk-1)
+	if len(calls) > 0 {
+		g.emit(opNull, 0, acc, acc+len(calls)-1)
+	}
+	g.emit(opInteger, 1, has, 0)
+	g.resolve(same)
+	for i := 0; i < width; i++ {
+		g.emit(opColumn, sorter, nk+i, row+i)
+	}
+	if err = step(); err != nil {
+		return err
+	}
+	g.emit(opSorterNext, sorter, loop, 0)
+	g.emit(opIfNot, has, finish, 0)
+	g.emit(opGosub, ret, out, 0)
+	g.emit(opGoto, 0, finish, 0)
+
+	g.resolve(out)
+	if err = final(done); err != nil {
+		return err
+	}
+	g.resolve(done)
+	g.emit(opReturn, ret, 0, 0)
+	g.resolve(finish)
+	return nil
+}
+
+// record emits the checks of the columns in block of a row of table and
+// the record of them into rec, the INTEGER PRIMARY KEY column is the
+// rowid and stored as NULL.
+func (g *codegen) record(info *tableInfo, block int, rec int) {
+	for i, c := range info.columns {
+		if c.notNull && i != info.rowidColumn {
+			g.emit4(opHaltIfNull, block+i, 0, 0, constraintError("NOT NULL", info, c.name).Error())
+		}
+	}
+	if info.rowidColumn >= 0 {
+		g.emit(opNull, 0, block+info.rowidColumn, 0)
+	}
+	g.emit4(opMakeRecord, block, len(info.columns), rec, affinities(info))
+}
+
+// affinities returns the affinities of the columns of table as P4 of
+// opMakeRecord
+func affinities(info *tableInfo) string {
+	affs := make([]byte, len(info.columns))
+	for i, c := range info.columns {
+		affs[i] = byte(c.aff)
+	}
+	return string(affs)
+}
+
+func (g *codegen) insertStmt(stmt *sql.InsertStmt) error {
+	// the plan of the operators checks the statement
+	p, err := g.b.insertPlan(stmt)
+	if err != nil {
+		return err
+	}
+	info := p.op.(*insertOp).table
+	columns := make(map[int]int)
+	for i, name := range stmt.Columns {
+		columns[info.column(name)] = i
+	}
+
+	cursor := g.cursor()
+	g.emit4(opOpenWrite, cursor, int(info.id), 0, info)
+	for _, values := range stmt.Rows {
+		block, rowid, rec := g.reg(len(info.columns)), g.reg(1), g.reg(1)
+		for i, c := range info.columns {
+			e := c.dflt
+			if len(stmt.Columns) == 0 {
+				e = values[i]
+			} else if j, ok := columns[i]; ok {
+				e = values[j]
+			}
+			if e == nil {
+				e = &sql.Literal{Kind: sql.Null}
+			}
+			if err = g.expr(e, constEnv, block+i); err != nil {
+				return err
+			}
+		}
+
+		if k := info.rowidColumn; k >= 0 {
+			have, ready := g.newLabel(), g.newLabel()
+			g.emit(opNotNull, block+k, have, 0)
+			g.emit(opNewRowid, cursor, rowid, 0)
+			g.emit(opGoto, 0, ready, 0)
+			g.resolve(have)
+			g.emit(opSCopy, block+k, rowid, 0)
+			g.emit(opMustBeInt, rowid, 0, 0)
+			g.resolve(ready)
+		} else {
+			g.emit(opNewRowid, cursor, rowid, 0)
+		}
+		g.record(info, block, rec)
+		g.emit(opInsert, cursor, rec, rowid).p5 = opflagCount | opflagLastRowID
+	}
+	return nil
+}
+
+// targetRows emits the loop collecting the rowids of the rows of table
+// where is true for into a rowset, returns the rowset register and the
+// write cursor.
+func (g *codegen) targetRows(info *tableInfo, where sql.Expr) (int, int, error) {
+	rowset := g.reg(1)
+	g.emit(opNull, 0, rowset, 0)
+	lp, err := g.openLoops([]*level{{info: info}}, where, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if where != nil {
+		if err = g.cond(where, lp.env, lp.next); err != nil {
+			return 0, 0, err
+		}
+	}
+	r := g.reg(1)
+	cursor := lp.levels[0].cursor
+	g.emit(opRowid, cursor, r, 0)
+	g.emit(opRowSetAdd, rowset, r, 0)
+	g.closeLoops(lp)
+	return rowset, cursor, nil
+}
+
+func (g *codegen) updateStmt(stmt *sql.UpdateStmt) error {
+	// the plan of the operators checks the statement
+	p, err := g.b.updatePlan(stmt)
+	if err != nil {
+		return err
+	}
+	info := p.op.(*updateOp).table
+	rowset, cursor, err := g.targetRows(info, stmt.Where)
+	if err != nil {
+		return err
+	}
+
+	loop, done := g.newLabel(), g.newLabel()
+	rowid := g.reg(1)
+	g.resolve(loop)
+	g.emit(opRowSetRead, rowset, done, rowid)
+	g.emit(opSeekRowid, cursor, loop, rowid)
+
+	set := make(map[int]sql.Expr)
+	for _, a := range stmt.Set {
+		set[info.column(a.Column)] = a.Value
+	}
+	env := &exprEnv{scope: tableScope(info, ""), column: func(i int, dest int) {
+		if i == 0 {
+			g.emit(opRowid, cursor, dest, 0)
+		} else {
+			g.emit(opColumn, cursor, i-1, dest).comment = info.name + "." + info.columns[i-1].name
+		}
+	}}
+	block, newRowid, rec := g.reg(len(info.columns)), g.reg(1), g.reg(1)
+	for i := range info.columns {
+		if e, ok := set[i]; ok {
+			if err = g.expr(e, env, block+i); err != nil {
+				return err
+			}
+		} else {
+			env.column(i+1, block+i)
+		}
+	}
+
+	k := info.rowidColumn
+	if k >= 0 {
+		g.emit(opSCopy, block+k, newRowid, 0)
+		g.emit(opMustBeInt, newRowid, 0, 0)
+	}
+	g.record(info, block, rec)
+	if k >= 0 {
+		same, r := g.newLabel(), g.reg(1)
+		g.emit(opEq, newRowid, rowid, r)
+		g.emit(opIf, r, same, 0)
+		g.emit(opDelete, cursor, 0, 0)
+		g.emit(opInsert, cursor, rec, newRowid).p5 = opflagCount
+		g.emit(opGoto, 0, loop, 0)
+		g.resolve(same)
+	}
+	g.emit(opInsert, cursor, rec, rowid).p5 = opflagCount | opflagReplace
+	g.emit(opGoto, 0, loop, 0)
+	g.resolve(done)
+	return nil
+}
+
+func (g *codegen) deleteStmt(stmt *sql.DeleteStmt) error {
+	// the plan of the operators checks the statement
+	p, err := g.b.deletePlan(stmt)
+	if err != nil {
+		return err
+	}
+	rowset, cursor, err := g.targetRows(p.op.(*deleteOp).table, stmt.Where)
+	if err != nil {
+		return err
+	}
+
+	loop, done := g.newLabel(), g.newLabel()
+	rowid := g.reg(1)
+	g.resolve(loop)
+	g.emit(opRowSetRead, rowset, done, rowid)
+	g.emit(opSeekRowid, cursor, loop, rowid)
+	g.emit(opDelete, cursor, 0, 0).p5 = opflagCount
+	g.emit(opGoto, 0, loop, 0)
+	g.resolve(done)
+	return nil
+}
+
+// createTable emits the insert of the table into sqlite_master
+func (g *codegen) createTable(stmt *sql.CreateTableStmt, text string) error {
+	if _, err := g.b.createTable(stmt, text); err != nil {
+		return err
+	}
+	if g.b.tables[strings.ToLower(stmt.Name)] != nil {
+		return nil
+	}
+
+	cursor := g.cursor()
+	g.emit4(opOpenWrite, cursor, int(masterTable.id), 0, masterTable)
+	g.insertMaster(cursor, "table", stmt.Name, stmt.Name, text)
+	info, _ := newTableInfo(0, text, stmt)
+	for n := range info.uniqueColumns() {
+		g.insertMaster(cursor, "index", autoIndexName(stmt.Name, n+1), stmt.Name, nil)
+	}
+	return nil
+}
+
+// insertMaster emits the insert of a row into sqlite_master open on cursor
+func (g *codegen) insertMaster(cursor int, typ, name, table string, text interface{}) {
+	id, block, rec := g.reg(1), g.reg(5), g.reg(1)
+	g.emit(opNewRowid, cursor, id, 0)
+	g.loadValue(typ, block)
+	g.loadValue(name, block+1)
+	g.loadValue(table, block+2)
+	g.emit(opSCopy, id, block+3, 0)
+	g.loadValue(text, block+4)
+	g.emit4(opMakeRecord, block, 5, rec, affinities(masterTable))
+	g.emit(opInsert, cursor, rec, id)
+}
+
+// createIndex emits the check of the rows of a UNIQUE index and the
+// insert of the index into sqlite_master
+func (g *codegen) createIndex(stmt *sql.CreateIndexStmt, text string) error {
+	info, idx, err := g.b.checkCreateIndex(stmt, text)
+	if err != nil || idx == nil {
+		return err
+	}
+
+	if idx.unique {
+		cursor, seen := g.cursor(), g.cursor()
+		g.emit4(opOpenRead, cursor, int(info.id), 0, info)
+		g.emit4(opOpenEphemeral, seen, 0, 0, keyColls(idx.colls))
+		loop, next, done := g.newLabel(), g.newLabel(), g.newLabel()
+		g.emit(opRewind, cursor, done, 0)
+		g.resolve(loop)
+		key := g.reg(len(idx.columns))
+		for i, c := range idx.columns {
+			g.emit(opColumn, cursor, c, key+i).comment = info.name + "." + info.columns[c].name
+		}
+		for i := range idx.columns {
+			notNull := g.newLabel()
+			g.emit(opNotNull, key+i, notNull, 0)
+			g.emit(opGoto, 0, next, 0)
+			g.resolve(notNull)
+		}
+		unique, r := g.newLabel(), g.reg(1)
+		g.emit4(opFound, seen, unique, key, len(idx.columns))
+		g.emit(opIdxInsert, seen, key, len(idx.columns))
+		g.emit(opGoto, 0, next, 0)
+		g.resolve(unique)
+		g.emit(opNull, 0, r, 0)
+		g.emit4(opHaltIfNull, r, 0, 0, uniqueError(info, idx).Error())
+		g.resolve(next)
+		g.emit(opNext, cursor, loop, 0)
+		g.resolve(done)
+	}
+
+	cursor := g.cursor()
+	g.emit4(opOpenWrite, cursor, int(masterTable.id), 0, masterTable)
+	g.insertMaster(cursor, "index", stmt.Name, info.name, text)
+	return nil
+}
+
+// dropIndex emits the delete of the index in sqlite_master
+func (g *codegen) dropIndex(stmt *sql.DropStmt) error {
+	idx, err := g.b.checkDropIndex(stmt)
+	if err != nil || idx == nil {
+		return err
+	}
+
+	g.clearStats(1, idx.name)
+	cursor := g.cursor()
+	g.emit4(opOpenWrite, cursor, int(masterTable.id), 0, masterTable)
+	g.deleteMaster(cursor, idx.id)
+	return nil
+}
+
+// clearStats emits the delete of the rows of sqlite_stat1 with name in
+// column, 0 for a table and 1 for an index
+func (g *codegen) clearStats(column int, name string) {
+	stat := g.b.tables[statName]
+	if stat == nil {
+		return
+	}
+	cursor, r := g.cursor(), g.reg(3)
+	g.emit4(opOpenWrite, cursor, int(stat.id), 0, stat)
+	g.loadValue(name, r)
+	loop, next, done := g.newLabel(), g.newLabel(), g.newLabel()
+	g.emit(opRewind, cursor, done, 0)
+	g.resolve(loop)
+	g.emit(opColumn, cursor, column, r+1)
+	g.emit(opEq, r+1, r, r+2)
+	g.emit(opIfNot, r+2, next, 0)
+	g.emit(opDelete, cursor, 0, 0)
+	g.resolve(next)
+	g.emit(opNext, cursor, loop, 0)
+	g.resolve(done)
+}
+
+// deleteMaster emits the delete of row id of sqlite_master open on cursor
+func (g *codegen) deleteMaster(cursor int, id int64) {
+	r, missing := g.reg(1), g.newLabel()
+	g.loadValue(id, r)
+	g.emit(opSeekRowid, cursor, missing, r)
+	g.emit(opDelete, cursor, 0, 0)
+	g.resolve(missing)
+}
+
+// dropTable emits the delete of the rows of the table and of the table in sqlite_master
+func (g *codegen) dropTable(stmt *sql.DropStmt) error {
+	if _, err := g.b.dropTable(stmt); err != nil {
+		return err
+	}
+	info := g.b.tables[strings.ToLower(stmt.Name)]
+	if info == nil {
+		return nil
+	}
+
+	cursor, master := g.cursor(), g.cursor()
+	g.emit4(opOpenWrite, cursor, int(info.id), 0, info)
+	g.emit4(opOpenWrite, master, int(masterTable.id), 0, masterTable)
+	loop, done := g.newLabel(), g.newLabel()
+	g.emit(opRewind, cursor, done, 0)
+	g.resolve(loop)
+	g.emit(opDelete, cursor, 0, 0)
+	g.emit(opNext, cursor, loop, 0)
+	g.resolve(done)
+	for _, idx := range info.indexes {
+		g.deleteMaster(master, idx.id)
+	}
+	if info.name != statName {
+		g.clearStats(0, info.name)
+	}
+	g.deleteMaster(master, info.id)
+	return nil
+}